@@ -266,6 +266,22 @@ type DetectionBoxInput struct {
 	Edited      bool                   `json:"edited,omitempty"`
 	Smoothed    bool                   `json:"smoothed,omitempty"`
 	Meta        map[string]interface{} `json:"meta,omitempty"`
+	// Keypoints is the pose of the subject in a "pose" run, in the same
+	// coordinate space as the box.
+	Keypoints []DetectionKeypointInput `json:"keypoints,omitempty"`
+}
+
+// DetectionKeypointInput is one keypoint of a pose. It is identified by Index
+// or by Name, looked up in the box's category Keypoints; when both are sent
+// they must agree. Visibility defaults to visible; a keypoint that is not
+// labelled needs no coordinates.
+type DetectionKeypointInput struct {
+	Index      *int     `json:"index,omitempty"`
+	Name       string   `json:"name,omitempty"`
+	X          *float64 `json:"x,omitempty"`
+	Y          *float64 `json:"y,omitempty"`
+	Visibility *int     `json:"visibility,omitempty"` // 0 not labelled | 1 occluded | 2 visible
+	Score      float64  `json:"score,omitempty"`
 }
 
 // PostDetectionsResponse echoes what was stored plus any per-box rejections.
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// Detections travel to and from annotation tools in three formats: COCO JSON
+// with the video extension (ExportCOCO, ImportCOCO), MOTChallenge CSV
+// (ExportMOT, ImportMOT) and CVAT for video 1.1 XML (ExportCVAT, ImportCVAT).
+// Exports write pixel coordinates against the oriented frame; imports take the
+// recording's DetectionMedia from the caller, as the files only know the
+// oriented frame, and produce a PostDetectionsRequest in pixel space with
+// Source.Kind "import", which NormalizeDetections then stores like any other
+// run.
+//
+// All three formats number tracks with integers. A track whose id is already
+// a non-negative integer keeps it; otherwise every track of the export is
+// numbered from 1 in order. COCO and CVAT files then carry the original id in
+// an "original_track_id" attribute, which their importers restore, so
+// Marker.Detections still find their tracks. MOT has no room for it: keep
+// AnnotationExport.TrackIds and pass it to RestoreTrackIds after ImportMOT.
+// Frames are 0-based except in MOT, which counts from 1.
+
+// annotationTrackIdAttribute names the attribute that carries a renumbered
+// track's original id.
+const annotationTrackIdAttribute = "original_track_id"
+
+// ErrAnnotationInvalid is returned when an export has nothing to place boxes
+// against, or an import cannot be parsed.
+var ErrAnnotationInvalid = errors.New("invalid annotation file")
+
+// AnnotationExport is what the exporters write: the tracks of a DetectionRun
+// or FaceRedaction with the frame they are drawn on.
+type AnnotationExport struct {
+	// Name identifies the video in the exported file.
+	Name string
+	// Media gives the frame boxes are scaled to: Width x Height turned by
+	// Rotation, as NormalizeDetections orients it. Fps and FrameCount are
+	// written where the format has room for them.
+	Media      models.DetectionMedia
+	Categories []models.DetectionCategory
+	Tracks     []models.FaceRedactionTrack
+}
+
+// AnnotationExportFromRun prepares a detection run for export.
+func AnnotationExportFromRun(run models.DetectionRun) AnnotationExport {
+	return AnnotationExport{Name: run.Key, Media: run.Media, Categories: run.Categories, Tracks: run.Tracks}
+}
+
+// AnnotationExportFromRedaction prepares a redaction for export. Redactions
+// carry no frame size or taxonomy, so media describes the recording and
+// categories are made up from the track labels.
+func AnnotationExportFromRedaction(redaction models.FaceRedaction, name string, media models.DetectionMedia) AnnotationExport {
+	return AnnotationExport{Name: name, Media: media, Tracks: redaction.Tracks}
+}
+
+// frameSize is the oriented frame the export's boxes are drawn on.
+func (e AnnotationExport) frameSize() (int, int) {
+	return models.RotatedSize(e.Media.Width, e.Media.Height, e.Media.Rotation)
+}
+
+// annotationBox is one exported box, in pixels.
+type annotationBox struct {
+	frame      int64
+	x, y, w, h float64
+	confidence float64
+	smoothed   bool
+}
+
+// annotationTrack is one exported track. originalId is the track's id when
+// it had to be renumbered, and empty otherwise.
+type annotationTrack struct {
+	id         int
+	originalId string
+	category   models.DetectionCategory
+	boxes      []annotationBox
+}
+
+// annotationTracks resolves the export's tracks to numbered tracks of pixel
+// boxes, skipping deleted frames and empty tracks, together with the category
+// table they refer to. Categories missing from the export are added after the
+// highest known id, named after the track label, or "object" without one.
+func (e AnnotationExport) annotationTracks() ([]annotationTrack, []models.DetectionCategory, error) {
+	if e.Media.Width <= 0 || e.Media.Height <= 0 {
+		return nil, nil, fmt.Errorf("%w: media width and height are required", ErrAnnotationInvalid)
+	}
+	w, h := e.frameSize()
+	width, height := float64(w), float64(h)
+
+	categories := append([]models.DetectionCategory(nil), e.Categories...)
+	byId := map[int]int{}
+	byName := map[string]int{}
+	next := 0
+	for i, category := range categories {
+		byId[category.Id] = i
+		if _, ok := byName[category.Name]; !ok {
+			byName[category.Name] = i
+		}
+		if category.Id >= next {
+			next = category.Id + 1
+		}
+	}
+	category := func(classId *int, label string) models.DetectionCategory {
+		if classId != nil {
+			if i, ok := byId[*classId]; ok {
+				return categories[i]
+			}
+		}
+		if label == "" {
+			label = "object"
+		}
+		if i, ok := byName[label]; ok {
+			return categories[i]
+		}
+		id := next
+		if classId != nil {
+			if _, taken := byId[*classId]; !taken {
+				id = *classId
+			}
+		}
+		if id >= next {
+			next = id + 1
+		}
+		categories = append(categories, models.DetectionCategory{Id: id, Name: label})
+		byId[id], byName[label] = len(categories)-1, len(categories)-1
+		return categories[len(categories)-1]
+	}
+
+	ids := annotationTrackIds(e.Tracks)
+	tracks := make([]annotationTrack, 0, len(e.Tracks))
+	for i, track := range e.Tracks {
+		if len(track.FrameCoordinates) == 0 {
+			track = track.Clone()
+			track.FrameCoordinatesFromTraject()
+		}
+		frames := make([]int64, 0, len(track.FrameCoordinates))
+		for frame := range track.FrameCoordinates {
+			if !track.IsDeleted(frame) {
+				frames = append(frames, frame)
+			}
+		}
+		if len(frames) == 0 {
+			continue
+		}
+		sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+
+		first := track.FrameCoordinates[frames[0]]
+		classId, label := track.ClassId, track.Classified
+		if classId == nil {
+			classId = first.ClassId
+		}
+		if label == "" {
+			label = first.Label
+		}
+		exported := annotationTrack{id: ids[i], category: category(classId, label), boxes: make([]annotationBox, len(frames))}
+		if strconv.Itoa(ids[i]) != track.Id {
+			exported.originalId = track.Id
+		}
+		for j, frame := range frames {
+			box := track.FrameCoordinates[frame]
+			confidence := box.Confidence
+			if confidence == 0 {
+				confidence = track.Confidence
+			}
+			exported.boxes[j] = annotationBox{
+				frame:      frame,
+				x:          box.X1 * width,
+				y:          box.Y1 * height,
+				w:          (box.X2 - box.X1) * width,
+				h:          (box.Y2 - box.Y1) * height,
+				confidence: confidence,
+				smoothed:   box.Smoothed,
+			}
+		}
+		tracks = append(tracks, exported)
+	}
+	return tracks, categories, nil
+}
+
+// annotationTrackIds keeps the tracks' ids when all are distinct non-negative
+// integers and numbers them from 1 otherwise.
+func annotationTrackIds(tracks []models.FaceRedactionTrack) []int {
+	ids := make([]int, len(tracks))
+	seen := map[int]bool{}
+	for i, track := range tracks {
+		id, err := strconv.Atoi(track.Id)
+		if err != nil || id < 0 || seen[id] {
+			for j := range ids {
+				ids[j] = j + 1
+			}
+			return ids
+		}
+		seen[id] = true
+		ids[i] = id
+	}
+	return ids
+}
+
+// TrackIds maps the numbers the export gives renumbered tracks back to their
+// ids. It is empty when every track keeps its id.
+func (e AnnotationExport) TrackIds() map[int]string {
+	ids := map[int]string{}
+	for i, id := range annotationTrackIds(e.Tracks) {
+		if strconv.Itoa(id) != e.Tracks[i].Id {
+			ids[id] = e.Tracks[i].Id
+		}
+	}
+	return ids
+}
+
+// RestoreTrackIds gives imported tracks back the ids an export renumbered,
+// as mapped by AnnotationExport.TrackIds.
+func RestoreTrackIds(tracks []DetectionTrackInput, ids map[int]string) {
+	for i, track := range tracks {
+		number, err := strconv.Atoi(string(track.Id))
+		if err != nil {
+			continue
+		}
+		if id, ok := ids[number]; ok {
+			tracks[i].Id = FlexibleString(id)
+		}
+	}
+}
+
+// annotationMedia is the media of an import: the recording's media, whose
+// oriented frame must be the width x height the file was drawn on, with the
+// file's fps and frame count filling in what media leaves out. Without a
+// recording size the file's frame is taken as unrotated.
+func annotationMedia(media models.DetectionMedia, width, height int, fps float64, frameCount int64) (models.DetectionMedia, error) {
+	if media.Width <= 0 || media.Height <= 0 {
+		media.Width, media.Height, media.Rotation = width, height, 0
+	} else if w, h := models.RotatedSize(media.Width, media.Height, media.Rotation); (width != 0 || height != 0) && (width != w || height != h) {
+		return models.DetectionMedia{}, fmt.Errorf("%w: file frame is %dx%d, the recording's is %dx%d", ErrAnnotationInvalid, width, height, w, h)
+	}
+	if media.Fps == 0 {
+		media.Fps = fps
+	}
+	if media.FrameCount == 0 {
+		media.FrameCount = frameCount
+	}
+	return media, nil
+}
+
+// annotationRequest starts an import: a pixel-space request from source,
+// marked as an import.
+func annotationRequest(source models.DetectionSource, media models.DetectionMedia) PostDetectionsRequest {
+	source.Kind = models.DetectionSourceImport
+	return PostDetectionsRequest{
+		Source:          source,
+		CoordinateSpace: models.DetectionCoordinatesPixel,
+		Media:           media,
+		Tracks:          []DetectionTrackInput{},
+	}
+}
+
+// pixelBox is a DetectionBoxInput in the preferred {x, y, w, h} form.
+func pixelBox(frame int64, x, y, w, h float64) DetectionBoxInput {
+	return DetectionBoxInput{Frame: frame, X: &x, Y: &y, W: &w, H: &h}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// COCO video files follow the CocoVID layout: videos, with one image per
+// annotated frame (frame_id), and annotations whose instance_id ties the boxes
+// of a track together. bbox is [x, y, width, height] in pixels. Free-form
+// attributes, as CVAT writes them, carry a renumbered track's original id.
+
+type cocoFile struct {
+	Videos      []cocoVideo      `json:"videos"`
+	Images      []cocoImage      `json:"images"`
+	Categories  []cocoCategory   `json:"categories"`
+	Annotations []cocoAnnotation `json:"annotations"`
+}
+
+type cocoVideo struct {
+	Id     int     `json:"id"`
+	Name   string  `json:"name"`
+	Width  int     `json:"width,omitempty"`
+	Height int     `json:"height,omitempty"`
+	Fps    float64 `json:"fps,omitempty"`
+	Length int64   `json:"length,omitempty"`
+}
+
+type cocoImage struct {
+	Id       int    `json:"id"`
+	VideoId  int    `json:"video_id"`
+	FrameId  int64  `json:"frame_id"`
+	FileName string `json:"file_name"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+type cocoCategory struct {
+	Id            int    `json:"id"`
+	Name          string `json:"name"`
+	Supercategory string `json:"supercategory,omitempty"`
+}
+
+type cocoAnnotation struct {
+	Id         int            `json:"id"`
+	ImageId    int            `json:"image_id"`
+	VideoId    int            `json:"video_id"`
+	CategoryId int            `json:"category_id"`
+	InstanceId *int           `json:"instance_id,omitempty"`
+	TrackId    *int           `json:"track_id,omitempty"` // written by some tools instead of instance_id
+	Bbox       []float64      `json:"bbox"`
+	Area       float64        `json:"area"`
+	IsCrowd    int            `json:"iscrowd"`
+	Score      float64        `json:"score,omitempty"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// ExportCOCO writes the export as a COCO video file holding one video.
+// Category aliases are written as supercategories.
+func ExportCOCO(export AnnotationExport) ([]byte, error) {
+	tracks, categories, err := export.annotationTracks()
+	if err != nil {
+		return nil, err
+	}
+	width, height := export.frameSize()
+	file := cocoFile{
+		Videos: []cocoVideo{{
+			Id:     1,
+			Name:   export.Name,
+			Width:  width,
+			Height: height,
+			Fps:    export.Media.Fps,
+			Length: export.Media.FrameCount,
+		}},
+		Images:      []cocoImage{},
+		Categories:  make([]cocoCategory, len(categories)),
+		Annotations: []cocoAnnotation{},
+	}
+	for i, category := range categories {
+		file.Categories[i] = cocoCategory{Id: category.Id, Name: category.Name, Supercategory: category.Alias}
+	}
+
+	images := map[int64]int{}
+	for _, track := range tracks {
+		for _, box := range track.boxes {
+			images[box.frame] = 0
+		}
+	}
+	frames := make([]int64, 0, len(images))
+	for frame := range images {
+		frames = append(frames, frame)
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+	for i, frame := range frames {
+		images[frame] = i + 1
+		file.Images = append(file.Images, cocoImage{
+			Id:       i + 1,
+			VideoId:  1,
+			FrameId:  frame,
+			FileName: path.Join(export.Name, fmt.Sprintf("%06d.jpg", frame)),
+			Width:    width,
+			Height:   height,
+		})
+	}
+
+	for _, track := range tracks {
+		instance := track.id
+		var attributes map[string]any
+		if track.originalId != "" {
+			attributes = map[string]any{annotationTrackIdAttribute: track.originalId}
+		}
+		for _, box := range track.boxes {
+			file.Annotations = append(file.Annotations, cocoAnnotation{
+				Id:         len(file.Annotations) + 1,
+				ImageId:    images[box.frame],
+				VideoId:    1,
+				CategoryId: track.category.Id,
+				InstanceId: &instance,
+				Bbox:       []float64{box.x, box.y, box.w, box.h},
+				Area:       box.w * box.h,
+				Score:      box.confidence,
+				Attributes: attributes,
+			})
+		}
+	}
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// ImportCOCO reads a COCO video file holding a single video into a detection
+// request. Annotations without an instance_id (or track_id) each become a
+// track of their own; an original_track_id attribute names the track instead.
+// media is the recording's; the file's video size must be its oriented frame.
+func ImportCOCO(data []byte, media models.DetectionMedia, source models.DetectionSource) (PostDetectionsRequest, error) {
+	var file cocoFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return PostDetectionsRequest{}, fmt.Errorf("%w: %v", ErrAnnotationInvalid, err)
+	}
+	if len(file.Videos) > 1 {
+		return PostDetectionsRequest{}, fmt.Errorf("%w: file holds %d videos; import them one at a time", ErrAnnotationInvalid, len(file.Videos))
+	}
+
+	var video cocoVideo
+	if len(file.Videos) == 1 {
+		video = file.Videos[0]
+	}
+	images := make(map[int]cocoImage, len(file.Images))
+	for _, image := range file.Images {
+		images[image.Id] = image
+		if video.Width == 0 && video.Height == 0 {
+			video.Width, video.Height = image.Width, image.Height
+		}
+	}
+	media, err := annotationMedia(media, video.Width, video.Height, video.Fps, video.Length)
+	if err != nil {
+		return PostDetectionsRequest{}, err
+	}
+
+	req := annotationRequest(source, media)
+	req.MediaKey = video.Name
+	names := make(map[int]string, len(file.Categories))
+	for _, category := range file.Categories {
+		req.Categories = append(req.Categories, models.DetectionCategory{Id: category.Id, Name: category.Name, Alias: category.Supercategory})
+		names[category.Id] = category.Name
+	}
+
+	byInstance := map[string]int{}
+	for _, annotation := range file.Annotations {
+		image, ok := images[annotation.ImageId]
+		if !ok {
+			return PostDetectionsRequest{}, fmt.Errorf("%w: annotation %d refers to unknown image %d", ErrAnnotationInvalid, annotation.Id, annotation.ImageId)
+		}
+		if len(annotation.Bbox) != 4 {
+			return PostDetectionsRequest{}, fmt.Errorf("%w: annotation %d has a bbox of %d values", ErrAnnotationInvalid, annotation.Id, len(annotation.Bbox))
+		}
+		instance := annotation.InstanceId
+		if instance == nil {
+			instance = annotation.TrackId
+		}
+		id := "annotation-" + strconv.Itoa(annotation.Id)
+		if instance != nil {
+			id = strconv.Itoa(*instance)
+		}
+		if original, ok := annotation.Attributes[annotationTrackIdAttribute].(string); ok && original != "" {
+			id = original
+		}
+
+		i, ok := byInstance[id]
+		if !ok {
+			classId := annotation.CategoryId
+			i = len(req.Tracks)
+			byInstance[id] = i
+			req.Tracks = append(req.Tracks, DetectionTrackInput{Id: FlexibleString(id), Label: names[classId], ClassId: &classId})
+		}
+		box := pixelBox(image.FrameId, annotation.Bbox[0], annotation.Bbox[1], annotation.Bbox[2], annotation.Bbox[3])
+		box.Confidence = annotation.Score
+		if classId := annotation.CategoryId; classId != *req.Tracks[i].ClassId {
+			box.ClassId = &classId
+		}
+		req.Tracks[i].Boxes = append(req.Tracks[i].Boxes, box)
+	}
+	return req, nil
+}
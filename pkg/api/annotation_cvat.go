@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// CVAT for video 1.1 files hold tracks of boxes given by their corners in
+// pixels. A track is drawn from its first box until a box marked outside; a
+// box that is not a keyframe was interpolated by CVAT. A renumbered track's
+// original id is a text attribute of its boxes, declared on every label.
+
+type cvatAnnotations struct {
+	XMLName xml.Name    `xml:"annotations"`
+	Version string      `xml:"version"`
+	Meta    cvatMeta    `xml:"meta"`
+	Tracks  []cvatTrack `xml:"track"`
+}
+
+type cvatMeta struct {
+	Task cvatTask `xml:"task"`
+}
+
+type cvatTask struct {
+	Name         string      `xml:"name"`
+	Size         int64       `xml:"size"`
+	Mode         string      `xml:"mode"`
+	OriginalSize cvatSize    `xml:"original_size"`
+	Labels       []cvatLabel `xml:"labels>label"`
+}
+
+type cvatSize struct {
+	Width  int `xml:"width"`
+	Height int `xml:"height"`
+}
+
+type cvatLabel struct {
+	Name       string               `xml:"name"`
+	Attributes []cvatLabelAttribute `xml:"attributes>attribute"`
+}
+
+type cvatLabelAttribute struct {
+	Name         string `xml:"name"`
+	Mutable      string `xml:"mutable"`
+	InputType    string `xml:"input_type"`
+	DefaultValue string `xml:"default_value"`
+	Values       string `xml:"values"`
+}
+
+type cvatTrack struct {
+	Id     int       `xml:"id,attr"`
+	Label  string    `xml:"label,attr"`
+	Source string    `xml:"source,attr,omitempty"`
+	Boxes  []cvatBox `xml:"box"`
+}
+
+type cvatBox struct {
+	Frame    int64   `xml:"frame,attr"`
+	Outside  int     `xml:"outside,attr"`
+	Occluded int     `xml:"occluded,attr"`
+	Keyframe int     `xml:"keyframe,attr"`
+	Xtl      float64 `xml:"xtl,attr"`
+	Ytl      float64 `xml:"ytl,attr"`
+	Xbr      float64 `xml:"xbr,attr"`
+	Ybr      float64 `xml:"ybr,attr"`
+	ZOrder   int     `xml:"z_order,attr"`
+
+	Attributes []cvatAttribute `xml:"attribute"`
+}
+
+type cvatAttribute struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+// ExportCVAT writes the export as a CVAT for video 1.1 file. Each box is a
+// keyframe unless it was Smoothed; where a track skips frames, or ends before
+// the recording does, an outside box stops CVAT interpolating across the gap.
+// CVAT has no confidences, so they are not written.
+func ExportCVAT(export AnnotationExport) ([]byte, error) {
+	tracks, categories, err := export.annotationTracks()
+	if err != nil {
+		return nil, err
+	}
+	width, height := export.frameSize()
+	file := cvatAnnotations{
+		Version: "1.1",
+		Meta: cvatMeta{Task: cvatTask{
+			Name:         export.Name,
+			Size:         export.Media.FrameCount,
+			Mode:         "interpolation",
+			OriginalSize: cvatSize{Width: width, Height: height},
+			Labels:       make([]cvatLabel, len(categories)),
+		}},
+		Tracks: make([]cvatTrack, len(tracks)),
+	}
+	renumbered := false
+	for _, track := range tracks {
+		renumbered = renumbered || track.originalId != ""
+	}
+	for i, category := range categories {
+		label := cvatLabel{Name: category.Name}
+		if renumbered {
+			label.Attributes = []cvatLabelAttribute{{Name: annotationTrackIdAttribute, Mutable: "False", InputType: "text"}}
+		}
+		file.Meta.Task.Labels[i] = label
+	}
+
+	for i, track := range tracks {
+		exported := cvatTrack{Id: track.id, Label: track.category.Name, Source: "manual"}
+		var attributes []cvatAttribute
+		if renumbered {
+			// Every shape of a label carries its declared attributes, so a
+			// track that kept its number names itself.
+			original := track.originalId
+			if original == "" {
+				original = strconv.Itoa(track.id)
+			}
+			attributes = []cvatAttribute{{Name: annotationTrackIdAttribute, Value: original}}
+		}
+		for j, box := range track.boxes {
+			corners := cvatBox{Frame: box.frame, Keyframe: 1, Xtl: box.x, Ytl: box.y, Xbr: box.x + box.w, Ybr: box.y + box.h, Attributes: attributes}
+			if box.smoothed {
+				corners.Keyframe = 0
+			}
+			exported.Boxes = append(exported.Boxes, corners)
+
+			last := j == len(track.boxes)-1
+			if !last && track.boxes[j+1].frame > box.frame+1 ||
+				last && (export.Media.FrameCount == 0 || box.frame+1 < export.Media.FrameCount) {
+				corners.Frame, corners.Outside, corners.Keyframe = box.frame+1, 1, 1
+				exported.Boxes = append(exported.Boxes, corners)
+			}
+		}
+		file.Tracks[i] = exported
+	}
+
+	data, err := xml.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// ImportCVAT reads a CVAT for video 1.1 file into a detection request. Boxes
+// marked outside end a stretch of the track and are not imported; boxes that
+// are not keyframes are marked Smoothed, and an original_track_id attribute
+// names the track in place of its number. Labels take the id of the category in
+// categories with their name, so a corrected run keeps the taxonomy of the
+// run it was exported from; labels without one are numbered after the highest
+// known id, in the order the file declares them. media is the recording's;
+// the task's original size must be its oriented frame.
+func ImportCVAT(data []byte, media models.DetectionMedia, categories []models.DetectionCategory, source models.DetectionSource) (PostDetectionsRequest, error) {
+	var file cvatAnnotations
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return PostDetectionsRequest{}, fmt.Errorf("%w: %v", ErrAnnotationInvalid, err)
+	}
+	task := file.Meta.Task
+	media, err := annotationMedia(media, task.OriginalSize.Width, task.OriginalSize.Height, 0, task.Size)
+	if err != nil {
+		return PostDetectionsRequest{}, err
+	}
+	req := annotationRequest(source, media)
+	req.MediaKey = task.Name
+	req.Categories = append([]models.DetectionCategory(nil), categories...)
+
+	classIds := map[string]int{}
+	next := 0
+	for _, category := range categories {
+		if _, ok := classIds[category.Name]; !ok {
+			classIds[category.Name] = category.Id
+		}
+		next = max(next, category.Id+1)
+	}
+	category := func(name string) int {
+		id, ok := classIds[name]
+		if !ok {
+			id = next
+			next++
+			classIds[name] = id
+			req.Categories = append(req.Categories, models.DetectionCategory{Id: id, Name: name})
+		}
+		return id
+	}
+	for _, label := range task.Labels {
+		category(label.Name)
+	}
+
+	for _, track := range file.Tracks {
+		classId := category(track.Label)
+		input := DetectionTrackInput{Id: FlexibleString(strconv.Itoa(track.Id)), Label: track.Label, ClassId: &classId}
+		for _, box := range track.Boxes {
+			for _, attribute := range box.Attributes {
+				if attribute.Name == annotationTrackIdAttribute && attribute.Value != "" {
+					input.Id = FlexibleString(attribute.Value)
+				}
+			}
+			if box.Outside == 1 {
+				continue
+			}
+			x1, y1, x2, y2 := box.Xtl, box.Ytl, box.Xbr, box.Ybr
+			input.Boxes = append(input.Boxes, DetectionBoxInput{
+				Frame:    box.Frame,
+				X1:       &x1,
+				Y1:       &y1,
+				X2:       &x2,
+				Y2:       &y2,
+				Smoothed: box.Keyframe == 0,
+			})
+		}
+		req.Tracks = append(req.Tracks, input)
+	}
+	return req, nil
+}
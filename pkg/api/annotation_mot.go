@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// MOTChallenge files have one box per row:
+//
+//	frame, id, bb_left, bb_top, bb_width, bb_height, conf, x, y, z
+//
+// with 1-based frames and pixel boxes. Results files set the world x, y, z to
+// -1; ground-truth files use nine columns instead, with the class id eighth.
+
+// ExportMOT writes the export as a MOTChallenge results file. The format has
+// no room for classes, so they are not written.
+func ExportMOT(export AnnotationExport) ([]byte, error) {
+	tracks, _, err := export.annotationTracks()
+	if err != nil {
+		return nil, err
+	}
+	type row struct {
+		frame int64
+		track int
+		box   annotationBox
+	}
+	var boxes []row
+	for _, track := range tracks {
+		for _, box := range track.boxes {
+			boxes = append(boxes, row{box.frame + 1, track.id, box})
+		}
+	}
+	// MOT files are read frame by frame, so rows are ordered by frame first.
+	sort.SliceStable(boxes, func(i, j int) bool {
+		if boxes[i].frame != boxes[j].frame {
+			return boxes[i].frame < boxes[j].frame
+		}
+		return boxes[i].track < boxes[j].track
+	})
+	rows := make([][]string, len(boxes))
+	for i, r := range boxes {
+		confidence := "-1"
+		if r.box.confidence > 0 {
+			confidence = formatFloat(r.box.confidence)
+		}
+		rows[i] = []string{
+			strconv.FormatInt(r.frame, 10),
+			strconv.Itoa(r.track),
+			formatFloat(r.box.x), formatFloat(r.box.y), formatFloat(r.box.w), formatFloat(r.box.h),
+			confidence, "-1", "-1", "-1",
+		}
+	}
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ImportMOT reads a MOTChallenge results or ground-truth file into a detection
+// request. MOT files do not record the frame size, so media supplies it. The
+// class column of a ground-truth file becomes the tracks' class ids, named
+// from categories.
+func ImportMOT(data []byte, media models.DetectionMedia, categories []models.DetectionCategory, source models.DetectionSource) (PostDetectionsRequest, error) {
+	req := annotationRequest(source, media)
+	req.Categories = categories
+	names := make(map[int]string, len(categories))
+	for _, category := range categories {
+		names[category.Id] = category.Name
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	byId := map[string]int{}
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return PostDetectionsRequest{}, fmt.Errorf("%w: %v", ErrAnnotationInvalid, err)
+		}
+		if len(row) == 1 && strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+		if len(row) < 6 {
+			return PostDetectionsRequest{}, fmt.Errorf("%w: line %d has %d columns, want at least 6", ErrAnnotationInvalid, line, len(row))
+		}
+		values := make([]float64, len(row))
+		for i, field := range row {
+			if values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+				return PostDetectionsRequest{}, fmt.Errorf("%w: line %d column %d: %v", ErrAnnotationInvalid, line, i+1, err)
+			}
+		}
+
+		id := strconv.FormatInt(int64(values[1]), 10)
+		i, ok := byId[id]
+		if !ok {
+			i = len(req.Tracks)
+			byId[id] = i
+			track := DetectionTrackInput{Id: FlexibleString(id)}
+			if len(row) == 9 {
+				classId := int(values[7])
+				track.ClassId = &classId
+				track.Label = names[classId]
+			}
+			req.Tracks = append(req.Tracks, track)
+		}
+		box := pixelBox(int64(values[0])-1, values[2], values[3], values[4], values[5])
+		// Ground truth uses the seventh column as a 0/1 "consider" flag rather
+		// than a score.
+		if len(row) >= 7 && len(row) != 9 && values[6] > 0 {
+			box.Confidence = values[6]
+		}
+		req.Tracks[i].Boxes = append(req.Tracks[i].Boxes, box)
+	}
+	return req, nil
+}
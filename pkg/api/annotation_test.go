@@ -0,0 +1,302 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+func annotatedRun() models.DetectionRun {
+	person, car := 0, 2
+	track := func(id string, classId *int, label string, boxes map[int64]models.TrackBox) models.FaceRedactionTrack {
+		t := models.FaceRedactionTrack{Id: id, ClassId: classId, Classified: label, FrameCoordinates: boxes}
+		t.TrajectFromFrameCoordinates()
+		return t
+	}
+	walker := track("3", &person, "person", map[int64]models.TrackBox{
+		0: {X1: 0.1, Y1: 0.2, X2: 0.3, Y2: 0.6, Confidence: 0.9},
+		1: {X1: 0.15, Y1: 0.2, X2: 0.35, Y2: 0.6, Confidence: 0.8, Smoothed: true},
+		4: {X1: 0.3, Y1: 0.25, X2: 0.5, Y2: 0.65, Confidence: 0.7},
+		5: {X1: 0.9, Y1: 0.9, X2: 1, Y2: 1, Confidence: 0.7},
+	})
+	walker.DeletedFrames = []int64{5}
+	return models.DetectionRun{
+		Key:        "cam/1.mp4",
+		Media:      models.DetectionMedia{Width: 200, Height: 100, Fps: 10, FrameCount: 50},
+		Categories: []models.DetectionCategory{{Id: 0, Name: "person"}, {Id: 2, Name: "car"}},
+		Tracks: []models.FaceRedactionTrack{
+			walker,
+			track("8", &car, "car", map[int64]models.TrackBox{
+				2: {X1: 0.5, Y1: 0.5, X2: 0.9, Y2: 0.8, Confidence: 0.6},
+				3: {X1: 0.5, Y1: 0.5, X2: 0.9, Y2: 0.8, Confidence: 0.6},
+			}),
+		},
+	}
+}
+
+func TestAnnotationRoundTrip(t *testing.T) {
+	source := models.DetectionSource{Kind: "model", Name: "cvat", RunId: "corrected"}
+	original := annotatedRun()
+	export := AnnotationExportFromRun(original)
+
+	tests := []struct {
+		format        string
+		export        func(AnnotationExport) ([]byte, error)
+		parse         func([]byte) (PostDetectionsRequest, error)
+		classes       bool
+		confidences   bool
+		smoothedKnown bool
+	}{
+		{"coco", ExportCOCO, func(data []byte) (PostDetectionsRequest, error) { return ImportCOCO(data, original.Media, source) }, true, true, false},
+		{"mot", ExportMOT, func(data []byte) (PostDetectionsRequest, error) {
+			// MOT files do not name their video; the caller does.
+			req, err := ImportMOT(data, original.Media, nil, source)
+			req.MediaKey = original.Key
+			return req, err
+		}, false, true, false},
+		{"cvat", ExportCVAT, func(data []byte) (PostDetectionsRequest, error) {
+			return ImportCVAT(data, original.Media, original.Categories, source)
+		}, true, false, true},
+	}
+	for _, tt := range tests {
+		data, err := tt.export(export)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		req, err := tt.parse(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if req.Source.Kind != models.DetectionSourceImport || req.Source.RunId != "corrected" || req.CoordinateSpace != models.DetectionCoordinatesPixel {
+			t.Errorf("%s: request = %+v", tt.format, req)
+		}
+		run, response, err := NormalizeDetections(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if len(response.Rejected) != 0 || len(run.Tracks) != 2 {
+			t.Fatalf("%s: rejected %+v, tracks %+v", tt.format, response.Rejected, run.Tracks)
+		}
+
+		for i, want := range original.Tracks {
+			got := run.Tracks[i]
+			if got.Id != want.Id {
+				t.Errorf("%s: track %d id = %q, want %q", tt.format, i, got.Id, want.Id)
+			}
+			if wantFrames := want.Frames[:len(want.Frames)-len(want.DeletedFrames)]; !reflect.DeepEqual(got.Frames, wantFrames) {
+				t.Errorf("%s: track %s frames = %v, want %v", tt.format, got.Id, got.Frames, wantFrames)
+			}
+			if tt.classes && (got.Classified != want.Classified || got.ClassId == nil || *got.ClassId != *want.ClassId) {
+				t.Errorf("%s: track %s class = %v %q, want %d %q", tt.format, got.Id, got.ClassId, got.Classified, *want.ClassId, want.Classified)
+			}
+			for _, frame := range got.Frames {
+				gotBox, wantBox := got.FrameCoordinates[frame], want.FrameCoordinates[frame]
+				if !closeBox(gotBox, wantBox) {
+					t.Errorf("%s: track %s frame %d = %+v, want %+v", tt.format, got.Id, frame, gotBox, wantBox)
+				}
+				if tt.confidences && gotBox.Confidence != wantBox.Confidence {
+					t.Errorf("%s: track %s frame %d confidence = %v", tt.format, got.Id, frame, gotBox.Confidence)
+				}
+				if tt.smoothedKnown && gotBox.Smoothed != wantBox.Smoothed {
+					t.Errorf("%s: track %s frame %d smoothed = %v", tt.format, got.Id, frame, gotBox.Smoothed)
+				}
+			}
+		}
+	}
+}
+
+func TestAnnotationRoundTripKeepsTrackIds(t *testing.T) {
+	// Markers link to detections by track id, so ids the formats cannot hold
+	// must come back from the import.
+	run := annotatedRun()
+	run.Tracks[0].Id, run.Tracks[1].Id = "track-3", "track-8"
+	export := AnnotationExportFromRun(run)
+	source := models.DetectionSource{RunId: "r"}
+
+	tests := []struct {
+		format string
+		export func(AnnotationExport) ([]byte, error)
+		parse  func([]byte) (PostDetectionsRequest, error)
+	}{
+		{"coco", ExportCOCO, func(data []byte) (PostDetectionsRequest, error) { return ImportCOCO(data, run.Media, source) }},
+		{"mot", ExportMOT, func(data []byte) (PostDetectionsRequest, error) {
+			req, err := ImportMOT(data, run.Media, nil, source)
+			RestoreTrackIds(req.Tracks, export.TrackIds())
+			return req, err
+		}},
+		{"cvat", ExportCVAT, func(data []byte) (PostDetectionsRequest, error) {
+			return ImportCVAT(data, run.Media, run.Categories, source)
+		}},
+	}
+	for _, tt := range tests {
+		data, err := tt.export(export)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		req, err := tt.parse(data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if len(req.Tracks) != 2 || req.Tracks[0].Id != "track-3" || req.Tracks[1].Id != "track-8" {
+			t.Errorf("%s: tracks = %+v", tt.format, req.Tracks)
+		}
+	}
+}
+
+func TestAnnotationExportRotatedMedia(t *testing.T) {
+	// Stored boxes are against the oriented frame: 100x200 for a 200x100
+	// recording displayed turned 90 degrees.
+	run := annotatedRun()
+	run.Media.Rotation = 90
+	data, err := ExportCOCO(AnnotationExportFromRun(run))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file cocoFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if video := file.Videos[0]; video.Width != 100 || video.Height != 200 {
+		t.Errorf("video = %+v, want 100x200", video)
+	}
+	if bbox := file.Annotations[0].Bbox; math.Abs(bbox[0]-10) > 1e-9 || math.Abs(bbox[1]-40) > 1e-9 || math.Abs(bbox[2]-20) > 1e-9 || math.Abs(bbox[3]-80) > 1e-9 {
+		t.Errorf("bbox = %v, want [10 40 20 80]", bbox)
+	}
+
+	// Imports keep the recording's encoded size and rotation.
+	source := models.DetectionSource{RunId: "r"}
+	for _, format := range []string{"coco", "cvat"} {
+		var req PostDetectionsRequest
+		if format == "coco" {
+			req, err = ImportCOCO(data, run.Media, source)
+		} else {
+			var cvat []byte
+			if cvat, err = ExportCVAT(AnnotationExportFromRun(run)); err != nil {
+				t.Fatal(err)
+			}
+			req, err = ImportCVAT(cvat, run.Media, run.Categories, source)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if req.Media != run.Media {
+			t.Errorf("%s: media = %+v, want %+v", format, req.Media, run.Media)
+		}
+		imported, _, err := NormalizeDetections(req)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if got, want := imported.Tracks[0].FrameCoordinates[0], run.Tracks[0].FrameCoordinates[0]; !closeBox(got, want) {
+			t.Errorf("%s: round trip = %+v, want %+v", format, got, want)
+		}
+	}
+
+	// A file drawn on another frame cannot be placed on the recording.
+	unrotated := run.Media
+	unrotated.Rotation = 0
+	if _, err := ImportCOCO(data, unrotated, source); !errors.Is(err, ErrAnnotationInvalid) {
+		t.Errorf("mismatched frame: err = %v, want ErrAnnotationInvalid", err)
+	}
+}
+
+func TestImportCVATKeepsCategoryIds(t *testing.T) {
+	data, err := ExportCVAT(AnnotationExportFromRun(annotatedRun()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := []models.DetectionCategory{{Id: 5, Name: "car"}, {Id: 9, Name: "bicycle"}}
+	req, err := ImportCVAT(data, models.DetectionMedia{}, existing, models.DetectionSource{RunId: "r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.DetectionCategory{{Id: 5, Name: "car"}, {Id: 9, Name: "bicycle"}, {Id: 10, Name: "person"}}
+	if !reflect.DeepEqual(req.Categories, want) {
+		t.Errorf("categories = %+v, want %+v", req.Categories, want)
+	}
+	if *req.Tracks[0].ClassId != 10 || *req.Tracks[1].ClassId != 5 {
+		t.Errorf("class ids = %d, %d, want 10, 5", *req.Tracks[0].ClassId, *req.Tracks[1].ClassId)
+	}
+}
+
+func TestExportCVATMarksGaps(t *testing.T) {
+	data, err := ExportCVAT(AnnotationExportFromRun(annotatedRun()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xml := string(data)
+	// Track 3 skips frames 2-3 and ends at 4; track 8 ends at 3.
+	for _, frame := range []string{`frame="2" outside="1"`, `frame="5" outside="1"`, `frame="4" outside="1"`} {
+		if !strings.Contains(xml, frame) {
+			t.Errorf("missing outside box %s in\n%s", frame, xml)
+		}
+	}
+	if !strings.Contains(xml, `frame="1" outside="0" occluded="0" keyframe="0"`) {
+		t.Errorf("smoothed box not written as an interpolated frame:\n%s", xml)
+	}
+}
+
+func TestExportRedactionNumbersTracks(t *testing.T) {
+	redaction := models.FaceRedaction{Tracks: []models.FaceRedactionTrack{
+		{Id: "face-a", Classified: "face", FrameCoordinates: map[int64]models.TrackBox{0: {X2: 0.5, Y2: 0.5}}},
+		{Id: "face-b", FrameCoordinates: map[int64]models.TrackBox{0: {X1: 0.5, Y1: 0.5, X2: 1, Y2: 1}}},
+	}}
+	export := AnnotationExportFromRedaction(redaction, "clip.mp4", models.DetectionMedia{Width: 100, Height: 100})
+	data, err := ExportCOCO(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file cocoFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := file.Annotations[0], file.Annotations[1]; *a.InstanceId != 1 || *b.InstanceId != 2 {
+		t.Errorf("instance ids = %d, %d, want 1, 2", *a.InstanceId, *b.InstanceId)
+	}
+	if want := map[int]string{1: "face-a", 2: "face-b"}; !reflect.DeepEqual(export.TrackIds(), want) {
+		t.Errorf("track ids = %v, want %v", export.TrackIds(), want)
+	}
+	req, err := ImportCOCO(data, models.DetectionMedia{}, models.DetectionSource{RunId: "r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.DetectionCategory{{Id: 0, Name: "face"}, {Id: 1, Name: "object"}}
+	if !reflect.DeepEqual(req.Categories, want) {
+		t.Errorf("categories = %+v, want %+v", req.Categories, want)
+	}
+	if len(req.Tracks) != 2 || req.Tracks[0].Id != "face-a" || req.Tracks[1].Id != "face-b" || req.MediaKey != "clip.mp4" {
+		t.Errorf("tracks = %+v", req.Tracks)
+	}
+
+	export.Media = models.DetectionMedia{}
+	if _, err := ExportMOT(export); !errors.Is(err, ErrAnnotationInvalid) {
+		t.Errorf("err = %v, want ErrAnnotationInvalid", err)
+	}
+}
+
+func TestImportAnnotationErrors(t *testing.T) {
+	source := models.DetectionSource{RunId: "r"}
+	if _, err := ImportCOCO([]byte(`{"videos": [{"id": 1}, {"id": 2}]}`), models.DetectionMedia{}, source); !errors.Is(err, ErrAnnotationInvalid) {
+		t.Errorf("coco: err = %v", err)
+	}
+	if _, err := ImportMOT([]byte("1,1,0,0,10\n"), models.DetectionMedia{}, nil, source); !errors.Is(err, ErrAnnotationInvalid) {
+		t.Errorf("mot: err = %v", err)
+	}
+	if _, err := ImportCVAT([]byte("<annotations><track"), models.DetectionMedia{}, nil, source); !errors.Is(err, ErrAnnotationInvalid) {
+		t.Errorf("cvat: err = %v", err)
+	}
+
+	// Ground-truth MOT rows carry the class in the eighth column.
+	req, err := ImportMOT([]byte("1,4,10,10,20,20,1,2,0.5\n"), models.DetectionMedia{Width: 100, Height: 100},
+		[]models.DetectionCategory{{Id: 2, Name: "car"}}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track := req.Tracks[0]; *track.ClassId != 2 || track.Label != "car" || track.Boxes[0].Frame != 0 || track.Boxes[0].Confidence != 0 {
+		t.Errorf("track = %+v", track)
+	}
+}
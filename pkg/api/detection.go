@@ -0,0 +1,397 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// NormalizeDetections is the server's write-path normalisation of a
+// PostDetectionsRequest, as a pure function: producers can run it locally to
+// see exactly which boxes the server will store, reject or adjust.
+
+// ErrDetectionsInvalid is returned when the request as a whole cannot be
+// normalised; individual bad boxes are rejected instead.
+var ErrDetectionsInvalid = errors.New("detection request is invalid")
+
+// Reasons on DetectionRejection.
+const (
+	// DetectionRejectMissingGeometry: neither a complete {x, y, w, h} nor a
+	// complete {x1, y1, x2, y2} was sent.
+	DetectionRejectMissingGeometry = "missing_geometry"
+	// DetectionRejectNonFinite: a coordinate is NaN or infinite.
+	DetectionRejectNonFinite = "non_finite"
+	// DetectionRejectDegenerate: the box has no width or height.
+	DetectionRejectDegenerate = "degenerate"
+	// DetectionRejectOutOfRange: the box lies outside the frame by more than
+	// the clamping tolerance.
+	DetectionRejectOutOfRange = "out_of_range"
+	// DetectionRejectInvalidFrame: the frame is negative or beyond the media's
+	// frame count.
+	DetectionRejectInvalidFrame = "invalid_frame"
+	// DetectionRejectDuplicateFrame: the track already has a box at this frame.
+	DetectionRejectDuplicateFrame = "duplicate_frame"
+	// DetectionRejectInvalidKeypoint: a keypoint of the box has no index or
+	// name its category knows, repeats an index, has a visibility other than
+	// 0, 1 or 2, or is labelled without coordinates.
+	DetectionRejectInvalidKeypoint = "invalid_keypoint"
+)
+
+// detectionClampTolerance is how far, as a fraction of the frame, a box may
+// spill over the frame edge and still be accepted (clamped to the frame).
+// Detectors routinely overshoot by a pixel or two.
+const detectionClampTolerance = 0.01
+
+// NormalizeDetections converts a request into the DetectionRun the server
+// stores, with the response it would answer. Boxes become normalised TrackBoxes
+// against the oriented frame; the request's coordinate space and box form are
+// kept as provenance. A box that cannot be stored is rejected with a reason,
+// and a track left without boxes is dropped.
+//
+// The request must name its recording by MediaKey or AnalysisId; when it sets
+// both, MediaKey wins and AnalysisId is ignored with a warning. A delegated
+// ingest result, which names no recording, goes through
+// NormalizeDelegatedDetections instead.
+//
+// Pixel coordinates are divided by the size of the frame the boxes are drawn
+// on: Media.Width/Height when Source.RotationApplied is false, since boxes are
+// then against the unrotated (encoded) frame, and otherwise that size turned
+// by Media.Rotation. Without a media size they are divided by
+// Source.InputWidth/InputHeight as given. Boxes against the unrotated frame
+// are then turned by Media.Rotation degrees clockwise; the stored run has
+// RotationApplied set.
+//
+// Keypoints go through the same conversion, clamping and rotation as their
+// box and are stored in the track's FrameKeypoints. A bad keypoint rejects its
+// box, as a bad corner would.
+func NormalizeDetections(req PostDetectionsRequest) (models.DetectionRun, PostDetectionsResponse, error) {
+	return normalizeDetections(req, true)
+}
+
+// NormalizeDelegatedDetections normalises a PostDetectionsRequest returned by
+// a delegated-ingest workflow stage. The engine targets the recording from the
+// WorkflowRun envelope, so MediaKey and AnalysisId are not required and the
+// run's Key is left for the engine to set; everything else is checked as in
+// NormalizeDetections.
+func NormalizeDelegatedDetections(req PostDetectionsRequest) (models.DetectionRun, PostDetectionsResponse, error) {
+	req.MediaKey, req.AnalysisId = "", ""
+	return normalizeDetections(req, false)
+}
+
+func normalizeDetections(req PostDetectionsRequest, recording bool) (models.DetectionRun, PostDetectionsResponse, error) {
+	response := PostDetectionsResponse{RunId: req.Source.RunId, Rejected: []DetectionRejection{}, Warnings: []string{}}
+	if strings.TrimSpace(req.Source.RunId) == "" {
+		return models.DetectionRun{}, response, fmt.Errorf("%w: source.runId is required", ErrDetectionsInvalid)
+	}
+	if recording {
+		mediaKey, analysisId := strings.TrimSpace(req.MediaKey) != "", strings.TrimSpace(req.AnalysisId) != ""
+		if !mediaKey && !analysisId {
+			return models.DetectionRun{}, response, fmt.Errorf("%w: mediaKey or analysisId is required", ErrDetectionsInvalid)
+		}
+		if mediaKey && analysisId {
+			response.Warnings = append(response.Warnings, fmt.Sprintf("analysisId %q ignored; mediaKey %q wins", req.AnalysisId, req.MediaKey))
+		}
+	}
+	rotationApplied := req.Source.RotationApplied == nil || *req.Source.RotationApplied
+
+	var width, height float64
+	switch req.CoordinateSpace {
+	case models.DetectionCoordinatesNormalized:
+		width, height = 1, 1
+	case models.DetectionCoordinatesPixel:
+		w, h := req.Media.Width, req.Media.Height
+		if rotationApplied {
+			w, h = models.RotatedSize(w, h, req.Media.Rotation)
+		}
+		width, height = float64(w), float64(h)
+		if width <= 0 || height <= 0 {
+			width, height = float64(req.Source.InputWidth), float64(req.Source.InputHeight)
+		}
+		if width <= 0 || height <= 0 {
+			return models.DetectionRun{}, response, fmt.Errorf("%w: pixel coordinates need media width and height", ErrDetectionsInvalid)
+		}
+	default:
+		return models.DetectionRun{}, response, fmt.Errorf("%w: coordinateSpace %q is not %q or %q", ErrDetectionsInvalid,
+			req.CoordinateSpace, models.DetectionCoordinatesPixel, models.DetectionCoordinatesNormalized)
+	}
+
+	rotation := 0
+	source := req.Source
+	if !rotationApplied {
+		rotation = ((req.Media.Rotation % 360) + 360) % 360
+		if rotation%90 != 0 {
+			return models.DetectionRun{}, response, fmt.Errorf("%w: rotation %d is not a multiple of 90", ErrDetectionsInvalid, req.Media.Rotation)
+		}
+		applied := true
+		source.RotationApplied = &applied
+		if rotation != 0 {
+			response.Warnings = append(response.Warnings, fmt.Sprintf("boxes rotated by %d degrees to the oriented frame", rotation))
+		}
+	}
+
+	task := req.Task
+	if task == "" {
+		task = models.DetectionTask
+	}
+	run := models.DetectionRun{
+		Key:                     req.MediaKey,
+		Task:                    task,
+		Source:                  source,
+		SchemaVersion:           req.SchemaVersion,
+		Media:                   req.Media,
+		Categories:              req.Categories,
+		Tracks:                  []models.FaceRedactionTrack{},
+		OriginalCoordinateSpace: req.CoordinateSpace,
+	}
+
+	categories := make(map[int]string, len(req.Categories))
+	poses := map[int]models.DetectionCategory{}
+	for _, category := range req.Categories {
+		if err := category.Validate(); err != nil {
+			return models.DetectionRun{}, response, fmt.Errorf("%w: %w", ErrDetectionsInvalid, err)
+		}
+		categories[category.Id] = category.Name
+		poses[category.Id] = category
+	}
+
+	forms := map[string]bool{}
+	clamped, clampedKeypoints := 0, 0
+	seenIds := map[string]int{}
+	for i, input := range req.Tracks {
+		id := strings.TrimSpace(input.Id.String())
+		if id == "" {
+			id = strconv.Itoa(i)
+			response.Warnings = append(response.Warnings, fmt.Sprintf("track %d has no id; using %q", i, id))
+		}
+		if n := seenIds[id]; n > 0 {
+			renamed := fmt.Sprintf("%s-%d", id, n+1)
+			response.Warnings = append(response.Warnings, fmt.Sprintf("duplicate track id %q renamed to %q", id, renamed))
+			seenIds[id] = n + 1
+			id = renamed
+		}
+		seenIds[id]++
+
+		label := input.Label
+		if label == "" && input.ClassId != nil {
+			label = categories[*input.ClassId]
+		}
+		track := models.FaceRedactionTrack{
+			Id:               id,
+			Classified:       label,
+			FrameCoordinates: map[int64]models.TrackBox{},
+			DeletedFrames:    input.DeletedFrames,
+			Confidence:       input.Confidence,
+			ClassId:          input.ClassId,
+			Shape:            input.Shape,
+		}
+		if input.Color != "" {
+			track.ColorString = []string{input.Color}
+		}
+
+		for _, box := range input.Boxes {
+			reject := func(reason string) {
+				response.Rejected = append(response.Rejected, DetectionRejection{TrackId: id, Frame: box.Frame, Reason: reason})
+			}
+			if box.Frame < 0 || req.Media.FrameCount > 0 && box.Frame >= req.Media.FrameCount {
+				reject(DetectionRejectInvalidFrame)
+				continue
+			}
+			if _, exists := track.FrameCoordinates[box.Frame]; exists {
+				reject(DetectionRejectDuplicateFrame)
+				continue
+			}
+			x1, y1, x2, y2, form, ok := detectionCorners(box)
+			if !ok {
+				reject(DetectionRejectMissingGeometry)
+				continue
+			}
+			if !finite(x1, y1, x2, y2) {
+				reject(DetectionRejectNonFinite)
+				continue
+			}
+			x1, x2 = x1/width, x2/width
+			y1, y2 = y1/height, y2/height
+			if x2 <= x1 || y2 <= y1 {
+				reject(DetectionRejectDegenerate)
+				continue
+			}
+			if x1 < -detectionClampTolerance || y1 < -detectionClampTolerance || x2 > 1+detectionClampTolerance || y2 > 1+detectionClampTolerance {
+				reject(DetectionRejectOutOfRange)
+				continue
+			}
+
+			classId := box.ClassId
+			if classId == nil {
+				classId = input.ClassId
+			}
+			var pose models.DetectionCategory
+			if classId != nil {
+				pose = poses[*classId]
+			}
+			keypoints, keypointsClamped, reason := normalizeKeypoints(box.Keypoints, pose, width, height, rotation)
+			if reason != "" {
+				reject(reason)
+				continue
+			}
+			clampedKeypoints += keypointsClamped
+
+			if x1 < 0 || y1 < 0 || x2 > 1 || y2 > 1 {
+				clamped++
+				x1, y1, x2, y2 = math.Max(x1, 0), math.Max(y1, 0), math.Min(x2, 1), math.Min(y2, 1)
+			}
+			forms[form] = true
+
+			boxLabel := box.Label
+			if boxLabel == "" && classId != nil {
+				boxLabel = categories[*classId]
+			}
+			if boxLabel == "" {
+				boxLabel = label
+			}
+			track.FrameCoordinates[box.Frame] = models.TrackBox{
+				X1: x1, Y1: y1, X2: x2, Y2: y2,
+				TrackId:    id,
+				Smoothed:   box.Smoothed,
+				Edited:     box.Edited,
+				Confidence: box.Confidence,
+				ClassId:    classId,
+				Label:      boxLabel,
+			}.Rotate(rotation)
+			if len(keypoints) > 0 {
+				if track.FrameKeypoints == nil {
+					track.FrameKeypoints = map[int64][]models.TrackKeypoint{}
+				}
+				track.FrameKeypoints[box.Frame] = keypoints
+			}
+		}
+
+		if len(track.FrameCoordinates) == 0 {
+			if len(input.Boxes) > 0 {
+				response.Warnings = append(response.Warnings, fmt.Sprintf("track %q dropped: every box was rejected", id))
+			}
+			continue
+		}
+		frames := make([]int64, 0, len(track.FrameCoordinates))
+		for frame := range track.FrameCoordinates {
+			frames = append(frames, frame)
+		}
+		sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+		track.Frames = frames
+		track.Traject = make([][]float64, len(frames))
+		for j, frame := range frames {
+			b := track.FrameCoordinates[frame]
+			track.Traject[j] = []float64{b.X1, b.Y1, b.X2, b.Y2, float64(frame)}
+		}
+		run.Tracks = append(run.Tracks, track)
+		response.TracksStored++
+		response.BoxesStored += len(frames)
+	}
+
+	switch {
+	case len(forms) > 1:
+		run.OriginalBoxForm = models.DetectionBoxFormMixed
+	case forms[models.DetectionBoxFormXYXY]:
+		run.OriginalBoxForm = models.DetectionBoxFormXYXY
+	case forms[models.DetectionBoxFormXYWH]:
+		run.OriginalBoxForm = models.DetectionBoxFormXYWH
+	}
+	if clamped > 0 {
+		response.Warnings = append(response.Warnings, fmt.Sprintf("%d boxes clamped to the frame", clamped))
+	}
+	if clampedKeypoints > 0 {
+		response.Warnings = append(response.Warnings, fmt.Sprintf("%d keypoints clamped to the frame", clampedKeypoints))
+	}
+	return run, response, nil
+}
+
+// detectionCorners returns a box's corners in the request's coordinate space
+// and the form it was sent in. The preferred {x, y, w, h} wins when a box
+// carries both complete forms.
+func detectionCorners(box DetectionBoxInput) (x1, y1, x2, y2 float64, form string, ok bool) {
+	if box.X != nil && box.Y != nil && box.W != nil && box.H != nil {
+		return *box.X, *box.Y, *box.X + *box.W, *box.Y + *box.H, models.DetectionBoxFormXYWH, true
+	}
+	if box.X1 != nil && box.Y1 != nil && box.X2 != nil && box.Y2 != nil {
+		return *box.X1, *box.Y1, *box.X2, *box.Y2, models.DetectionBoxFormXYXY, true
+	}
+	return 0, 0, 0, 0, "", false
+}
+
+// normalizeKeypoints converts a box's keypoints like its corners: into the
+// normalised, oriented frame, clamped when they spill over the edge by no more
+// than the tolerance. It returns the keypoints ordered by index, how many were
+// clamped, and the rejection reason of the box when one is unusable.
+func normalizeKeypoints(inputs []DetectionKeypointInput, category models.DetectionCategory, width, height float64, rotation int) ([]models.TrackKeypoint, int, string) {
+	if len(inputs) == 0 {
+		return nil, 0, ""
+	}
+	keypoints := make([]models.TrackKeypoint, 0, len(inputs))
+	seen := map[int]bool{}
+	clamped := 0
+	for _, input := range inputs {
+		index := -1
+		if input.Index != nil {
+			index = *input.Index
+		}
+		if input.Name != "" && len(category.Keypoints) > 0 {
+			named, ok := category.KeypointIndex(input.Name)
+			if !ok || input.Index != nil && named != index {
+				return nil, 0, DetectionRejectInvalidKeypoint
+			}
+			index = named
+		}
+		if index < 0 || len(category.Keypoints) > 0 && index >= len(category.Keypoints) || seen[index] {
+			return nil, 0, DetectionRejectInvalidKeypoint
+		}
+		seen[index] = true
+
+		keypoint := models.TrackKeypoint{Index: index, Name: input.Name, Visibility: models.KeypointVisible, Score: input.Score}
+		if len(category.Keypoints) > 0 {
+			keypoint.Name = category.Keypoints[index]
+		}
+		if input.Visibility != nil {
+			keypoint.Visibility = *input.Visibility
+		}
+		switch keypoint.Visibility {
+		case models.KeypointNotLabeled:
+			keypoints = append(keypoints, keypoint)
+			continue
+		case models.KeypointOccluded, models.KeypointVisible:
+		default:
+			return nil, 0, DetectionRejectInvalidKeypoint
+		}
+		if input.X == nil || input.Y == nil {
+			return nil, 0, DetectionRejectInvalidKeypoint
+		}
+		if !finite(*input.X, *input.Y) {
+			return nil, 0, DetectionRejectNonFinite
+		}
+		p := models.Point{X: *input.X, Y: *input.Y}.ToNormalized(width, height)
+		if p.X < -detectionClampTolerance || p.Y < -detectionClampTolerance || p.X > 1+detectionClampTolerance || p.Y > 1+detectionClampTolerance {
+			return nil, 0, DetectionRejectOutOfRange
+		}
+		if p.X < 0 || p.Y < 0 || p.X > 1 || p.Y > 1 {
+			clamped++
+			p = models.Point{X: math.Min(math.Max(p.X, 0), 1), Y: math.Min(math.Max(p.Y, 0), 1)}
+		}
+		p = p.Rotate(rotation)
+		keypoint.X, keypoint.Y = p.X, p.Y
+		keypoints = append(keypoints, keypoint)
+	}
+	sort.Slice(keypoints, func(i, j int) bool { return keypoints[i].Index < keypoints[j].Index })
+	return keypoints, clamped, ""
+}
+
+func finite(values ...float64) bool {
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+func decodeDetections(t *testing.T, body string) PostDetectionsRequest {
+	t.Helper()
+	var req PostDetectionsRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestNormalizeDetectionsPixel(t *testing.T) {
+	req := decodeDetections(t, `{
+		"mediaKey": "cam/1.mp4",
+		"source": {"kind": "model", "name": "yolo", "runId": "run-1"},
+		"coordinateSpace": "pixel",
+		"media": {"width": 200, "height": 100, "frameCount": 100},
+		"categories": [{"id": 0, "name": "person"}, {"id": 2, "name": "car"}],
+		"tracks": [
+			{"id": 7, "classId": 0, "confidence": 0.8, "boxes": [
+				{"frame": 3, "x": 20, "y": 10, "w": 40, "h": 50, "confidence": 0.9},
+				{"frame": 1, "x1": 0, "y1": 0, "x2": 100, "y2": 100, "classId": 2, "edited": true},
+				{"frame": 1, "x": 0, "y": 0, "w": 10, "h": 10},
+				{"frame": 4, "x": 10, "y": 10, "w": 0, "h": 10},
+				{"frame": 5, "x": 190, "y": 10, "w": 11, "h": 10},
+				{"frame": 6, "x": 190, "y": 10, "w": 40, "h": 10},
+				{"frame": 100, "x": 0, "y": 0, "w": 10, "h": 10},
+				{"frame": 8, "x": 1}
+			]},
+			{"id": "empty", "boxes": [{"frame": 0, "x1": 5, "y1": 5, "x2": 1, "y2": 10}]}
+		]
+	}`)
+
+	run, response, err := NormalizeDetections(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Key != "cam/1.mp4" || run.Task != models.DetectionTask || run.OriginalCoordinateSpace != "pixel" || run.OriginalBoxForm != "mixed" {
+		t.Errorf("run = %+v", run)
+	}
+	if len(run.Tracks) != 1 {
+		t.Fatalf("tracks = %+v", run.Tracks)
+	}
+	track := run.Tracks[0]
+	if track.Id != "7" || track.Classified != "person" || !reflect.DeepEqual(track.Frames, []int64{1, 3, 5}) {
+		t.Errorf("track = %+v", track)
+	}
+
+	want := models.TrackBox{X1: 0.1, Y1: 0.1, X2: 0.3, Y2: 0.6, TrackId: "7", Confidence: 0.9, ClassId: track.ClassId, Label: "person"}
+	if got := track.FrameCoordinates[3]; !closeBox(got, want) || got.Label != want.Label || got.Confidence != want.Confidence {
+		t.Errorf("frame 3 = %+v, want %+v", got, want)
+	}
+	if got := track.FrameCoordinates[1]; !got.Edited || got.Label != "car" || *got.ClassId != 2 || got.X2 != 0.5 || got.Y2 != 1 {
+		t.Errorf("frame 1 = %+v", got)
+	}
+	// Spilling 1 pixel of 200 past the edge is clamped, not rejected.
+	if got := track.FrameCoordinates[5]; got.X2 != 1 {
+		t.Errorf("frame 5 = %+v, want clamped to 1", got)
+	}
+	if len(track.Traject) != 3 || track.Traject[1][4] != 3 {
+		t.Errorf("traject = %v", track.Traject)
+	}
+
+	wantRejected := []DetectionRejection{
+		{TrackId: "7", Frame: 1, Reason: DetectionRejectDuplicateFrame},
+		{TrackId: "7", Frame: 4, Reason: DetectionRejectDegenerate},
+		{TrackId: "7", Frame: 6, Reason: DetectionRejectOutOfRange},
+		{TrackId: "7", Frame: 100, Reason: DetectionRejectInvalidFrame},
+		{TrackId: "7", Frame: 8, Reason: DetectionRejectMissingGeometry},
+		{TrackId: "empty", Frame: 0, Reason: DetectionRejectDegenerate},
+	}
+	if !reflect.DeepEqual(response.Rejected, wantRejected) {
+		t.Errorf("rejected = %+v, want %+v", response.Rejected, wantRejected)
+	}
+	if response.RunId != "run-1" || response.TracksStored != 1 || response.BoxesStored != 3 || len(response.Warnings) != 2 {
+		t.Errorf("response = %+v", response)
+	}
+}
+
+func TestNormalizeDetectionsRotation(t *testing.T) {
+	notApplied := false
+	x, y, w, h := 0.1, 0.2, 0.3, 0.1
+	tests := []struct {
+		rotation int
+		want     models.TrackBox
+	}{
+		{0, models.TrackBox{X1: 0.1, Y1: 0.2, X2: 0.4, Y2: 0.3}},
+		{90, models.TrackBox{X1: 0.7, Y1: 0.1, X2: 0.8, Y2: 0.4}},
+		{180, models.TrackBox{X1: 0.6, Y1: 0.7, X2: 0.9, Y2: 0.8}},
+		{-90, models.TrackBox{X1: 0.2, Y1: 0.6, X2: 0.3, Y2: 0.9}},
+	}
+	for _, tt := range tests {
+		req := PostDetectionsRequest{
+			MediaKey:        "cam/1.mp4",
+			Source:          models.DetectionSource{RunId: "r", RotationApplied: &notApplied},
+			CoordinateSpace: models.DetectionCoordinatesNormalized,
+			Media:           models.DetectionMedia{Rotation: tt.rotation},
+			Tracks:          []DetectionTrackInput{{Id: "a", Boxes: []DetectionBoxInput{{Frame: 0, X: &x, Y: &y, W: &w, H: &h}}}},
+		}
+		run, _, err := NormalizeDetections(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := run.Tracks[0].FrameCoordinates[0]; !closeBox(got, tt.want) {
+			t.Errorf("rotation %d: box = %+v, want %+v", tt.rotation, got, tt.want)
+		}
+		if run.Source.RotationApplied == nil || !*run.Source.RotationApplied || *req.Source.RotationApplied {
+			t.Errorf("rotation %d: rotationApplied not recorded on the run only", tt.rotation)
+		}
+	}
+}
+
+func TestNormalizeDetectionsPixelRotatedMedia(t *testing.T) {
+	// A portrait phone recording: encoded 200x100, displayed turned 90
+	// degrees, so boxes drawn on the displayed frame are against 100x200.
+	notApplied := false
+	tests := []struct {
+		name       string
+		applied    *bool
+		x, y, w, h float64
+		want       models.TrackBox
+	}{
+		{"oriented frame", nil, 10, 20, 50, 100, models.TrackBox{X1: 0.1, Y1: 0.1, X2: 0.6, Y2: 0.6}},
+		{"encoded frame", &notApplied, 20, 10, 100, 50, models.TrackBox{X1: 0.4, Y1: 0.1, X2: 0.9, Y2: 0.6}},
+	}
+	for _, tt := range tests {
+		x, y, w, h := tt.x, tt.y, tt.w, tt.h
+		req := PostDetectionsRequest{
+			MediaKey:        "cam/1.mp4",
+			Source:          models.DetectionSource{RunId: "r", RotationApplied: tt.applied},
+			CoordinateSpace: models.DetectionCoordinatesPixel,
+			Media:           models.DetectionMedia{Width: 200, Height: 100, Rotation: 90},
+			Tracks:          []DetectionTrackInput{{Id: "a", Boxes: []DetectionBoxInput{{Frame: 0, X: &x, Y: &y, W: &w, H: &h}}}},
+		}
+		run, response, err := NormalizeDetections(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(run.Tracks) != 1 {
+			t.Fatalf("%s: rejected %+v", tt.name, response.Rejected)
+		}
+		if got := run.Tracks[0].FrameCoordinates[0]; !closeBox(got, tt.want) {
+			t.Errorf("%s: box = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDetectionsInvalidRequests(t *testing.T) {
+	notApplied := false
+	for name, req := range map[string]PostDetectionsRequest{
+		"no run id":     {MediaKey: "k", CoordinateSpace: "normalized"},
+		"no recording":  {Source: models.DetectionSource{RunId: "r"}, CoordinateSpace: "normalized"},
+		"unknown space": {MediaKey: "k", Source: models.DetectionSource{RunId: "r"}, CoordinateSpace: "percent"},
+		"pixel no size": {MediaKey: "k", Source: models.DetectionSource{RunId: "r"}, CoordinateSpace: "pixel"},
+		"odd rotation":  {MediaKey: "k", Source: models.DetectionSource{RunId: "r", RotationApplied: &notApplied}, CoordinateSpace: "normalized", Media: models.DetectionMedia{Rotation: 45}},
+	} {
+		if _, _, err := NormalizeDetections(req); !errors.Is(err, ErrDetectionsInvalid) {
+			t.Errorf("%s: err = %v, want ErrDetectionsInvalid", name, err)
+		}
+	}
+}
+
+func TestNormalizeDetectionsRecordingReference(t *testing.T) {
+	x, y, w, h := 0.1, 0.1, 0.2, 0.2
+	req := PostDetectionsRequest{
+		MediaKey:        "cam/1.mp4",
+		AnalysisId:      "65a1f0c2e4b0a1b2c3d4e5f6",
+		Source:          models.DetectionSource{RunId: "r"},
+		CoordinateSpace: models.DetectionCoordinatesNormalized,
+		Tracks:          []DetectionTrackInput{{Id: "a", Boxes: []DetectionBoxInput{{Frame: 0, X: &x, Y: &y, W: &w, H: &h}}}},
+	}
+	run, response, err := NormalizeDetections(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Key != "cam/1.mp4" || len(response.Warnings) != 1 {
+		t.Errorf("both references: key %q, warnings %v", run.Key, response.Warnings)
+	}
+
+	// A delegated-ingest result names no recording: the engine targets it
+	// from the workflow run.
+	req.MediaKey, req.AnalysisId = "", ""
+	if _, _, err := NormalizeDetections(req); !errors.Is(err, ErrDetectionsInvalid) {
+		t.Errorf("no reference: err = %v, want ErrDetectionsInvalid", err)
+	}
+	run, response, err = NormalizeDelegatedDetections(req)
+	if err != nil || run.Key != "" || response.BoxesStored != 1 {
+		t.Errorf("delegated = (%+v, %+v, %v)", run, response, err)
+	}
+	req.Source.RunId = ""
+	if _, _, err := NormalizeDelegatedDetections(req); !errors.Is(err, ErrDetectionsInvalid) {
+		t.Errorf("delegated without run id: err = %v, want ErrDetectionsInvalid", err)
+	}
+}
+
+func TestNormalizeDetectionsTrackIds(t *testing.T) {
+	req := decodeDetections(t, `{
+		"analysisId": "65a1f0c2e4b0a1b2c3d4e5f6",
+		"source": {"runId": "r"},
+		"coordinateSpace": "normalized",
+		"tracks": [
+			{"id": 12, "boxes": [{"frame": 0, "x": 0, "y": 0, "w": 0.5, "h": 0.5}]},
+			{"id": "12", "boxes": [{"frame": 0, "x": 0, "y": 0, "w": 0.5, "h": 0.5}]},
+			{"boxes": [{"frame": 0, "x": 0, "y": 0, "w": 0.5, "h": 0.5}]}
+		]
+	}`)
+	run, response, err := NormalizeDetections(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, track := range run.Tracks {
+		ids = append(ids, track.Id)
+		if track.FrameCoordinates[0].TrackId != track.Id {
+			t.Errorf("box track id = %q, track %q", track.FrameCoordinates[0].TrackId, track.Id)
+		}
+	}
+	if want := []string{"12", "12-2", "2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ids = %v, want %v", ids, want)
+	}
+	if run.OriginalBoxForm != models.DetectionBoxFormXYWH || len(response.Warnings) != 2 {
+		t.Errorf("form = %q, warnings = %v", run.OriginalBoxForm, response.Warnings)
+	}
+}
+
+func TestNormalizeDetectionsPose(t *testing.T) {
+	req := decodeDetections(t, `{
+		"task": "pose",
+		"mediaKey": "cam/1.mp4",
+		"source": {"runId": "r", "rotationApplied": false},
+		"coordinateSpace": "pixel",
+		"media": {"width": 200, "height": 100, "rotation": 90},
+		"categories": [{"id": 0, "name": "person", "keypoints": ["nose", "left_eye", "right_eye"], "skeleton": [[0, 1], [0, 2]]}],
+		"tracks": [{"id": 1, "classId": 0, "boxes": [
+			{"frame": 0, "x": 20, "y": 10, "w": 100, "h": 80, "keypoints": [
+				{"index": 1, "x": 201, "y": 10, "visibility": 1, "score": 0.4},
+				{"name": "nose", "x": 40, "y": 20, "score": 0.9},
+				{"name": "right_eye", "visibility": 0}
+			]},
+			{"frame": 1, "x": 20, "y": 10, "w": 100, "h": 80, "keypoints": [{"name": "tail", "x": 1, "y": 1}]},
+			{"frame": 2, "x": 20, "y": 10, "w": 100, "h": 80, "keypoints": [{"index": 0, "name": "left_eye", "x": 1, "y": 1}]},
+			{"frame": 3, "x": 20, "y": 10, "w": 100, "h": 80, "keypoints": [{"index": 0, "x": 1, "y": 1}, {"name": "nose", "x": 1, "y": 1}]},
+			{"frame": 4, "x": 20, "y": 10, "w": 100, "h": 80, "keypoints": [{"index": 0, "x": 1, "y": 1, "visibility": 3}]},
+			{"frame": 5, "x": 20, "y": 10, "w": 100, "h": 80, "keypoints": [{"index": 0, "y": 1}]},
+			{"frame": 6, "x": 20, "y": 10, "w": 100, "h": 80, "keypoints": [{"index": 0, "x": 250, "y": 1}]},
+			{"frame": 7, "x": 20, "y": 10, "w": 100, "h": 80}
+		]}]
+	}`)
+	run, response, err := NormalizeDetections(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Task != models.PoseTask || len(run.Tracks) != 1 {
+		t.Fatalf("run = %+v", run)
+	}
+	track := run.Tracks[0]
+	if !reflect.DeepEqual(track.Frames, []int64{0, 7}) || len(track.FrameKeypoints) != 1 {
+		t.Errorf("frames = %v, keypoints = %+v", track.Frames, track.FrameKeypoints)
+	}
+	wantReasons := map[int64]string{
+		1: DetectionRejectInvalidKeypoint, 2: DetectionRejectInvalidKeypoint, 3: DetectionRejectInvalidKeypoint,
+		4: DetectionRejectInvalidKeypoint, 5: DetectionRejectInvalidKeypoint, 6: DetectionRejectOutOfRange,
+	}
+	for _, rejection := range response.Rejected {
+		if wantReasons[rejection.Frame] != rejection.Reason {
+			t.Errorf("frame %d rejected for %q, want %q", rejection.Frame, rejection.Reason, wantReasons[rejection.Frame])
+		}
+	}
+	if len(response.Rejected) != len(wantReasons) {
+		t.Errorf("rejected = %+v", response.Rejected)
+	}
+
+	// Keypoints are normalised, clamped and turned with their box: (0.2, 0.2)
+	// becomes (0.8, 0.2), and the clamped (1, 0.1) becomes (0.9, 1).
+	want := []models.TrackKeypoint{
+		{Index: 0, Name: "nose", X: 0.8, Y: 0.2, Visibility: models.KeypointVisible, Score: 0.9},
+		{Index: 1, Name: "left_eye", X: 0.9, Y: 1, Visibility: models.KeypointOccluded, Score: 0.4},
+		{Index: 2, Name: "right_eye", Visibility: models.KeypointNotLabeled},
+	}
+	got := track.FrameKeypoints[0]
+	if len(got) != len(want) {
+		t.Fatalf("keypoints = %+v", got)
+	}
+	for i := range want {
+		if got[i].Index != want[i].Index || got[i].Name != want[i].Name || got[i].Visibility != want[i].Visibility ||
+			got[i].Score != want[i].Score || math.Abs(got[i].X-want[i].X) > 1e-9 || math.Abs(got[i].Y-want[i].Y) > 1e-9 {
+			t.Errorf("keypoint %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if response.Warnings[len(response.Warnings)-1] != "1 keypoints clamped to the frame" {
+		t.Errorf("warnings = %v", response.Warnings)
+	}
+
+	req.Categories[0].Skeleton = [][2]int{{0, 3}}
+	if _, _, err := NormalizeDetections(req); !errors.Is(err, ErrDetectionsInvalid) || !errors.Is(err, models.ErrPoseInvalid) {
+		t.Errorf("skeleton out of range: err = %v", err)
+	}
+}
+
+func closeBox(a, b models.TrackBox) bool {
+	const eps = 1e-9
+	return math.Abs(a.X1-b.X1) < eps && math.Abs(a.Y1-b.Y1) < eps && math.Abs(a.X2-b.X2) < eps && math.Abs(a.Y2-b.Y2) < eps
+}
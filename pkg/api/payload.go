@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// Block envelope for WorkflowRun.Payload — the self-describing body a
+// delegated-ingest stage worker hands back to the engine. The envelope is an
+// ordered list of typed blocks; each block names its own type, so the shared
+// ingest core routes every block to the handler for that type without peeking
+// at the worker's operation:
+//
+//	{
+//	  "blocks": [
+//	    {"type": "detection", "body": { ...PostDetectionsRequest... }},
+//	    {"type": "marker",    "body": { ...Marker... }},
+//	    {"type": "marker",    "body": { ...Marker... }}
+//	  ]
+//	}
+//
+// The helpers below are the one implementation of that envelope: a worker
+// builds it with NewPayloadBlock/EncodePayload, the ingest core reads it with
+// DecodePayload, and the engine mirrors it into Results[operation] with
+// GroupPayloadResults. Block types are resolved through a registry so a new
+// kind is a RegisterPayloadBlockKind call rather than another hand-rolled
+// switch in every consumer.
+
+// PayloadBlockType names the kind of a block inside a PayloadEnvelope. It is
+// the registry key the ingest core routes on.
+type PayloadBlockType string
+
+const (
+	// PayloadBlockDetection carries a PostDetectionsRequest.
+	PayloadBlockDetection PayloadBlockType = "detection"
+	// PayloadBlockMarker carries a models.Marker.
+	PayloadBlockMarker PayloadBlockType = "marker"
+)
+
+// PayloadDecodeMode controls how DecodePayload treats a block whose type is not
+// registered.
+//
+//	strict  — the whole envelope is rejected (ErrPayloadUnknownBlock). This is
+//	          what the ingest core uses, so a typo never silently drops data.
+//	lenient — the unknown block is kept with its raw body and Known=false, so a
+//	          consumer that only reads some kinds (or an older deployment that
+//	          predates a new kind) can still process the rest.
+type PayloadDecodeMode string
+
+const (
+	PayloadDecodeStrict  PayloadDecodeMode = "strict"
+	PayloadDecodeLenient PayloadDecodeMode = "lenient"
+)
+
+var (
+	// ErrPayloadMalformed is returned when the envelope or one of its blocks is
+	// not valid JSON of the expected shape.
+	ErrPayloadMalformed = errors.New("payload envelope is malformed")
+	// ErrPayloadUnknownBlock is returned for a block type that is not registered
+	// (strict decoding, or encoding a block of an unregistered type).
+	ErrPayloadUnknownBlock = errors.New("payload block type is not registered")
+	// ErrPayloadBodyMismatch is returned when a block body does not have the Go
+	// type registered for its block type.
+	ErrPayloadBodyMismatch = errors.New("payload block body does not match its type")
+)
+
+// PayloadBlock is one typed block on the wire. Body is kept raw so the envelope
+// can be relayed or persisted without knowing every kind.
+type PayloadBlock struct {
+	Type PayloadBlockType `json:"type"`
+	Body json.RawMessage  `json:"body"`
+}
+
+// PayloadEnvelope is the wire shape of WorkflowRun.Payload.
+type PayloadEnvelope struct {
+	Blocks []PayloadBlock `json:"blocks"`
+}
+
+// DecodedPayloadBlock is a block routed to its typed body. For a registered
+// type Body is a pointer to the registered body type (e.g.
+// *PostDetectionsRequest); for an unknown type accepted in lenient mode Body is
+// the raw json.RawMessage and Known is false. Raw always holds the block's body
+// as it was on the wire.
+type DecodedPayloadBlock struct {
+	Type  PayloadBlockType
+	Body  any
+	Raw   json.RawMessage
+	Known bool
+}
+
+// payloadBlockKind is one registered block type.
+type payloadBlockKind struct {
+	resultsKey string
+	newBody    func() any
+}
+
+var payloadRegistry = struct {
+	sync.RWMutex
+	kinds map[PayloadBlockType]payloadBlockKind
+}{
+	kinds: map[PayloadBlockType]payloadBlockKind{
+		PayloadBlockDetection: {resultsKey: "detections", newBody: func() any { return &PostDetectionsRequest{} }},
+		PayloadBlockMarker:    {resultsKey: "markers", newBody: func() any { return &models.Marker{} }},
+	},
+}
+
+// RegisterPayloadBlockKind adds (or replaces) a block type in the registry. It
+// is meant to be called from an init function by the package that owns the
+// body type. resultsKey is the key the grouped blocks are mirrored under in
+// Results[operation] (e.g. "detections" → results.<op>.detections) and
+// defaults to the type name; newBody returns a pointer to a zero body the block
+// decodes into.
+func RegisterPayloadBlockKind(blockType PayloadBlockType, resultsKey string, newBody func() any) error {
+	if blockType == "" {
+		return fmt.Errorf("%w: block type is empty", ErrPayloadMalformed)
+	}
+	if newBody == nil || reflect.TypeOf(newBody()).Kind() != reflect.Pointer {
+		return fmt.Errorf("%w: block type %q needs a constructor returning a pointer", ErrPayloadMalformed, blockType)
+	}
+	if resultsKey == "" {
+		resultsKey = string(blockType)
+	}
+	payloadRegistry.Lock()
+	defer payloadRegistry.Unlock()
+	payloadRegistry.kinds[blockType] = payloadBlockKind{resultsKey: resultsKey, newBody: newBody}
+	return nil
+}
+
+// PayloadResultsKey returns the Results key a registered block type is grouped
+// under, and whether the type is registered.
+func PayloadResultsKey(blockType PayloadBlockType) (string, bool) {
+	kind, ok := lookupPayloadBlockKind(blockType)
+	return kind.resultsKey, ok
+}
+
+func lookupPayloadBlockKind(blockType PayloadBlockType) (payloadBlockKind, bool) {
+	payloadRegistry.RLock()
+	defer payloadRegistry.RUnlock()
+	kind, ok := payloadRegistry.kinds[blockType]
+	return kind, ok
+}
+
+// NewPayloadBlock encodes a typed body as a block of the given type. The body
+// must be the registered body type for blockType, either by value or pointer.
+func NewPayloadBlock(blockType PayloadBlockType, body any) (PayloadBlock, error) {
+	kind, ok := lookupPayloadBlockKind(blockType)
+	if !ok {
+		return PayloadBlock{}, fmt.Errorf("%w: %q", ErrPayloadUnknownBlock, blockType)
+	}
+	want := reflect.TypeOf(kind.newBody()).Elem()
+	got := reflect.TypeOf(body)
+	if got != nil && got.Kind() == reflect.Pointer {
+		got = got.Elem()
+	}
+	if got != want {
+		return PayloadBlock{}, fmt.Errorf("%w: %q expects %s, got %v", ErrPayloadBodyMismatch, blockType, want, got)
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return PayloadBlock{}, fmt.Errorf("%w: %q: %v", ErrPayloadMalformed, blockType, err)
+	}
+	return PayloadBlock{Type: blockType, Body: raw}, nil
+}
+
+// EncodePayload serialises blocks into the raw envelope assigned to
+// WorkflowRun.Payload.
+func EncodePayload(blocks ...PayloadBlock) (json.RawMessage, error) {
+	if blocks == nil {
+		blocks = []PayloadBlock{}
+	}
+	return json.Marshal(PayloadEnvelope{Blocks: blocks})
+}
+
+// DecodePayload parses a raw envelope and routes every block to its typed body,
+// preserving block order. An empty payload decodes to no blocks.
+func DecodePayload(raw json.RawMessage, mode PayloadDecodeMode) ([]DecodedPayloadBlock, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	var envelope PayloadEnvelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPayloadMalformed, err)
+	}
+
+	decoded := make([]DecodedPayloadBlock, 0, len(envelope.Blocks))
+	for i, block := range envelope.Blocks {
+		if block.Type == "" {
+			return nil, fmt.Errorf("%w: block %d has no type", ErrPayloadMalformed, i)
+		}
+		kind, ok := lookupPayloadBlockKind(block.Type)
+		if !ok {
+			if mode != PayloadDecodeLenient {
+				return nil, fmt.Errorf("%w: block %d has type %q", ErrPayloadUnknownBlock, i, block.Type)
+			}
+			decoded = append(decoded, DecodedPayloadBlock{Type: block.Type, Body: block.Body, Raw: block.Body})
+			continue
+		}
+		body := kind.newBody()
+		if err := json.Unmarshal(block.Body, body); err != nil {
+			return nil, fmt.Errorf("%w: block %d (%q): %v", ErrPayloadMalformed, i, block.Type, err)
+		}
+		decoded = append(decoded, DecodedPayloadBlock{Type: block.Type, Body: body, Raw: block.Body, Known: true})
+	}
+	return decoded, nil
+}
+
+// GroupPayloadResults groups decoded blocks by type into the per-type arrays
+// the engine mirrors into Results[operation] (e.g. {"detections": [...],
+// "markers": [...]}). Each entry is the block's body decoded into generic JSON
+// values (map[string]any, []any, float64, ...) — the same shape a run document
+// has after a round trip through the queue — so stage conditions such as
+// results.<op>.markers.*.name evaluate against it directly. Registered kinds
+// are grouped under their ResultsKey; unknown kinds kept in lenient mode are
+// grouped under their raw type.
+func GroupPayloadResults(blocks []DecodedPayloadBlock) (map[string]any, error) {
+	grouped := map[string]any{}
+	for _, block := range blocks {
+		key := string(block.Type)
+		if resultsKey, ok := PayloadResultsKey(block.Type); ok && block.Known {
+			key = resultsKey
+		}
+		raw := block.Raw
+		if len(raw) == 0 {
+			encoded, err := json.Marshal(block.Body)
+			if err != nil {
+				return nil, fmt.Errorf("%w: block %q: %v", ErrPayloadMalformed, block.Type, err)
+			}
+			raw = encoded
+		}
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%w: block %q: %v", ErrPayloadMalformed, block.Type, err)
+		}
+		list, _ := grouped[key].([]any)
+		grouped[key] = append(list, value)
+	}
+	return grouped, nil
+}
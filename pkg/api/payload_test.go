@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+func TestPayloadRoundTripRoutesBlocksToTypedBodies(t *testing.T) {
+	detection, err := NewPayloadBlock(PayloadBlockDetection, PostDetectionsRequest{
+		CoordinateSpace: "normalized",
+		Source:          models.DetectionSource{Kind: "model", RunId: "run-1"},
+		Tracks:          []DetectionTrackInput{{Id: "7"}},
+	})
+	if err != nil {
+		t.Fatalf("detection block: %v", err)
+	}
+	marker, err := NewPayloadBlock(PayloadBlockMarker, &models.Marker{Name: "2-HCP-007"})
+	if err != nil {
+		t.Fatalf("marker block: %v", err)
+	}
+	raw, err := EncodePayload(detection, marker)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	blocks, err := DecodePayload(raw, PayloadDecodeStrict)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("decoded %d blocks, want 2", len(blocks))
+	}
+	req, ok := blocks[0].Body.(*PostDetectionsRequest)
+	if !ok {
+		t.Fatalf("block 0 body = %T, want *PostDetectionsRequest", blocks[0].Body)
+	}
+	if req.Source.RunId != "run-1" || len(req.Tracks) != 1 || req.Tracks[0].Id != "7" {
+		t.Errorf("detection body did not survive the round trip: %+v", req)
+	}
+	m, ok := blocks[1].Body.(*models.Marker)
+	if !ok {
+		t.Fatalf("block 1 body = %T, want *models.Marker", blocks[1].Body)
+	}
+	if m.Name != "2-HCP-007" {
+		t.Errorf("marker name = %q, want 2-HCP-007", m.Name)
+	}
+}
+
+func TestNewPayloadBlockRejectsMismatchedBody(t *testing.T) {
+	if _, err := NewPayloadBlock(PayloadBlockMarker, PostDetectionsRequest{}); !errors.Is(err, ErrPayloadBodyMismatch) {
+		t.Errorf("err = %v, want ErrPayloadBodyMismatch", err)
+	}
+	if _, err := NewPayloadBlock("heatmap", map[string]any{}); !errors.Is(err, ErrPayloadUnknownBlock) {
+		t.Errorf("err = %v, want ErrPayloadUnknownBlock", err)
+	}
+}
+
+func TestDecodePayloadUnknownBlockModes(t *testing.T) {
+	raw := json.RawMessage(`{"blocks":[{"type":"marker","body":{"name":"a"}},{"type":"future","body":{"x":1}}]}`)
+
+	if _, err := DecodePayload(raw, PayloadDecodeStrict); !errors.Is(err, ErrPayloadUnknownBlock) {
+		t.Fatalf("strict err = %v, want ErrPayloadUnknownBlock", err)
+	}
+
+	blocks, err := DecodePayload(raw, PayloadDecodeLenient)
+	if err != nil {
+		t.Fatalf("lenient decode: %v", err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("lenient decoded %d blocks, want 2", len(blocks))
+	}
+	if blocks[1].Known {
+		t.Error("unknown block reported as known")
+	}
+	if body, ok := blocks[1].Body.(json.RawMessage); !ok || string(body) != `{"x":1}` {
+		t.Errorf("unknown block body = %v, want the raw JSON", blocks[1].Body)
+	}
+}
+
+func TestDecodePayloadRejectsMalformedEnvelopes(t *testing.T) {
+	for name, raw := range map[string]string{
+		"not json":      `{`,
+		"missing type":  `{"blocks":[{"body":{}}]}`,
+		"body mismatch": `{"blocks":[{"type":"marker","body":[1,2]}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DecodePayload(json.RawMessage(raw), PayloadDecodeLenient); !errors.Is(err, ErrPayloadMalformed) {
+				t.Errorf("err = %v, want ErrPayloadMalformed", err)
+			}
+		})
+	}
+
+	blocks, err := DecodePayload(nil, PayloadDecodeStrict)
+	if err != nil || blocks != nil {
+		t.Errorf("empty payload = (%v, %v), want no blocks and no error", blocks, err)
+	}
+}
+
+// The grouped results are what stage conditions read, so they must be generic
+// JSON values the condition evaluator can walk with a "*" fan-out.
+func TestGroupPayloadResultsFeedsConditionEvaluation(t *testing.T) {
+	raw := json.RawMessage(`{"blocks":[
+		{"type":"detection","body":{"coordinateSpace":"pixel","source":{"kind":"model","name":"n","version":"1","runId":"r"},"tracks":[]}},
+		{"type":"marker","body":{"name":"plate-a"}},
+		{"type":"marker","body":{"name":"plate-b"}},
+		{"type":"future","body":{"x":1}}
+	]}`)
+	blocks, err := DecodePayload(raw, PayloadDecodeLenient)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	grouped, err := GroupPayloadResults(blocks)
+	if err != nil {
+		t.Fatalf("group: %v", err)
+	}
+	if got := len(grouped["detections"].([]any)); got != 1 {
+		t.Errorf("detections = %d, want 1", got)
+	}
+	if got := len(grouped["markers"].([]any)); got != 2 {
+		t.Errorf("markers = %d, want 2", got)
+	}
+	if got := len(grouped["future"].([]any)); got != 1 {
+		t.Errorf("unknown kind grouped under its type = %d, want 1", got)
+	}
+
+	root := map[string]any{"results": map[string]any{"anpr": grouped}}
+	cond := &models.StageCondition{Path: "results.anpr.markers.*.name", Op: models.ConditionOpEq, Value: "plate-b"}
+	if !models.EvaluateCondition(cond, root) {
+		t.Error("condition over grouped markers did not match")
+	}
+}
+
+func TestRegisterPayloadBlockKind(t *testing.T) {
+	type heatmapBody struct {
+		Cells []int `json:"cells"`
+	}
+	if err := RegisterPayloadBlockKind("heatmap-test", "", func() any { return &heatmapBody{} }); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if key, ok := PayloadResultsKey("heatmap-test"); !ok || key != "heatmap-test" {
+		t.Errorf("results key = (%q, %v), want the type name", key, ok)
+	}
+	block, err := NewPayloadBlock("heatmap-test", heatmapBody{Cells: []int{1, 2}})
+	if err != nil {
+		t.Fatalf("new block: %v", err)
+	}
+	raw, _ := EncodePayload(block)
+	blocks, err := DecodePayload(raw, PayloadDecodeStrict)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body := blocks[0].Body.(*heatmapBody); len(body.Cells) != 2 {
+		t.Errorf("registered body = %+v", body)
+	}
+
+	if err := RegisterPayloadBlockKind("bad", "", func() any { return heatmapBody{} }); !errors.Is(err, ErrPayloadMalformed) {
+		t.Errorf("non-pointer constructor err = %v, want ErrPayloadMalformed", err)
+	}
+}
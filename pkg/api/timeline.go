@@ -64,6 +64,9 @@ func (ms TimelineStatus) Translate(lang string) string {
 type GetTimelineMediaRequest struct {
 	Filter          MediaFilter `json:"filter" bson:"filter"`
 	DisableGrouping *bool       `json:"disableGrouping,omitempty" bson:"disableGrouping,omitempty"`
+	// Bucketing groups the media with models.BucketMedia; nil keeps the
+	// default grouping.
+	Bucketing *models.TimelineBucketOptions `json:"bucketing,omitempty" bson:"bucketing,omitempty"`
 }
 type GetTimelineMediaResponse struct {
 	Timelines []models.MediaTimeline `json:"timelines" bson:"timelines"`
@@ -81,6 +84,9 @@ type GetTimelineMediaSuccessResponse struct {
 type GetTimelineMarkersRequest struct {
 	Filter          MarkerFilter `json:"filter" bson:"filter"`
 	DisableGrouping *bool        `json:"disableGrouping,omitempty" bson:"disableGrouping,omitempty"`
+	// Bucketing groups the markers with models.BucketMarkers; nil keeps the
+	// default grouping.
+	Bucketing *models.TimelineBucketOptions `json:"bucketing,omitempty" bson:"bucketing,omitempty"`
 }
 
 type GetTimelineMarkersResponse struct {
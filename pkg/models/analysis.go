@@ -161,6 +161,11 @@ type FaceRedactionTrack struct {
 	Confidence float64 `json:"confidence,omitempty" bson:"confidence,omitempty"`
 	ClassId    *int    `json:"classId,omitempty" bson:"classId,omitempty"`
 	Shape      string  `json:"shape,omitempty" bson:"shape,omitempty"`
+	// FrameKeypoints holds the pose of the subject in a "pose" run, by the
+	// same frames as FrameCoordinates and ordered by Index. It sits beside the
+	// boxes rather than on them so TrackBox stays comparable and box-only
+	// readers are unaffected.
+	FrameKeypoints map[int64][]TrackKeypoint `json:"frameKeypoints,omitempty" bson:"frameKeypoints,omitempty"`
 }
 
 type TrackBox struct {
@@ -178,6 +183,19 @@ type TrackBox struct {
 	Label      string  `json:"label,omitempty" bson:"label,omitempty"`
 }
 
+// TrackKeypoint is one keypoint of a pose. X and Y are normalised against the
+// frame like the box corners, not relative to the box.
+type TrackKeypoint struct {
+	// Index is the keypoint's position in its category's Keypoints; Name is
+	// that entry, kept so a run stays readable without its categories.
+	Index      int     `json:"index" bson:"index"`
+	Name       string  `json:"name,omitempty" bson:"name,omitempty"`
+	X          float64 `json:"x" bson:"x"`
+	Y          float64 `json:"y" bson:"y"`
+	Visibility int     `json:"visibility" bson:"visibility"` // KeypointNotLabeled | KeypointOccluded | KeypointVisible
+	Score      float64 `json:"score,omitempty" bson:"score,omitempty"`
+}
+
 type FaceRedaction struct {
 	Id     primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
 	Tracks []FaceRedactionTrack `json:"tracks" bson:"tracks"`
@@ -235,12 +253,36 @@ type DetectionRun struct {
 	// expire a run on the same retention clock as its recording rather than by
 	// the (possibly much later) post time.
 	RecordingTimestamp int64 `json:"recordingTimestamp,omitempty" bson:"recordingTimestamp,omitempty"`
+	// View records the selection a derived run was made with (see
+	// DetectionRun.ApplyView); nil for a run as the producer posted it.
+	View *DetectionView `json:"view,omitempty" bson:"view,omitempty"`
 }
 
 // DetectionTask is the default value of DetectionRun.Task when a producer does
 // not send one.
 const DetectionTask = "detection"
 
+// PoseTask is the DetectionRun.Task of a run whose boxes carry keypoints.
+const PoseTask = "pose"
+
+// Values of DetectionRun.OriginalCoordinateSpace and OriginalBoxForm.
+const (
+	DetectionCoordinatesPixel      = "pixel"
+	DetectionCoordinatesNormalized = "normalized"
+
+	DetectionBoxFormXYWH  = "xywh"
+	DetectionBoxFormXYXY  = "xyxy"
+	DetectionBoxFormMixed = "mixed"
+)
+
+// Values of DetectionSource.Kind.
+const (
+	DetectionSourcePipeline = "pipeline"
+	DetectionSourceModel    = "model"
+	// DetectionSourceImport marks a run imported from an annotation tool.
+	DetectionSourceImport = "import"
+)
+
 // DetectionSource identifies the producer of a detection run. RunId is the
 // natural key the upsert matches on within an analysis.
 type DetectionSource struct {
@@ -258,6 +300,9 @@ type DetectionSource struct {
 }
 
 // DetectionMedia describes the media the detections were produced against.
+// Width and Height are the encoded frame size, as a decoder reports it, before
+// Rotation (clockwise degrees of display rotation) is applied; the oriented
+// frame is RotatedSize(Width, Height, Rotation).
 type DetectionMedia struct {
 	Width      int     `json:"width,omitempty" bson:"width,omitempty"`
 	Height     int     `json:"height,omitempty" bson:"height,omitempty"`
@@ -271,6 +316,11 @@ type DetectionCategory struct {
 	Id    int    `json:"id" bson:"id"`
 	Name  string `json:"name" bson:"name"`
 	Alias string `json:"alias,omitempty" bson:"alias,omitempty"`
+	// Keypoints names the keypoints of a pose category by index, e.g.
+	// "nose", "left_eye", ...; Skeleton lists the pairs of keypoint indexes
+	// (0-based, unlike COCO's) joined by a limb.
+	Keypoints []string `json:"keypoints,omitempty" bson:"keypoints,omitempty"`
+	Skeleton  [][2]int `json:"skeleton,omitempty" bson:"skeleton,omitempty"`
 }
 
 // RedactionMode describes the visual technique applied by the
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Counting turns object trajectories into CountingRecords: a crossing of a
+// counting line is a CountingIn or CountingOut record, and a stay inside a
+// counting region is a CountingRegion record whose Duration is the dwell time.
+// Lines and regions are drawn at their own Width x Height and are rescaled to
+// the frame each trajectory was tracked in.
+
+// Values of Region.Direction on a counting line. A line counts crossings in
+// both directions unless its direction names one.
+const (
+	RegionDirectionBoth = "both"
+	RegionDirectionIn   = "in"
+	RegionDirectionOut  = "out"
+)
+
+// ErrCountingInvalidOptions is returned for a negative minimum dwell or frame
+// rate, or a line or region with too few points.
+var ErrCountingInvalidOptions = errors.New("invalid counting options")
+
+// CountingPoint is an object's position at a moment of the recording.
+type CountingPoint struct {
+	X float64 `json:"x" bson:"x"`
+	Y float64 `json:"y" bson:"y"`
+	// Time is in seconds from the start of the recording.
+	Time float64 `json:"time" bson:"time"`
+}
+
+// CountingTrajectory is the path of one tracked object, in pixels of a
+// Width x Height frame, with its points in time order.
+type CountingTrajectory struct {
+	ObjectId   string          `json:"objectId" bson:"objectId"`
+	ObjectName string          `json:"objectName" bson:"objectName"`
+	Width      float64         `json:"width" bson:"width"`
+	Height     float64         `json:"height" bson:"height"`
+	Points     []CountingPoint `json:"points" bson:"points"`
+}
+
+// CountingOptions are the lines and regions to count against, and the
+// recording the trajectories come from.
+type CountingOptions struct {
+	Lines   []Region `json:"lines,omitempty" bson:"lines,omitempty"`
+	Regions []Region `json:"regions,omitempty" bson:"regions,omitempty"`
+	// StartTimestamp is the recording start in epoch seconds; records are
+	// stamped with it plus the point's Time.
+	StartTimestamp int64 `json:"startTimestamp" bson:"startTimestamp"`
+	// DeviceId, AlertId and AlertName are copied onto every record. A line or
+	// region's own Device wins over DeviceId.
+	DeviceId  string `json:"deviceId,omitempty" bson:"deviceId,omitempty"`
+	AlertId   string `json:"alertId,omitempty" bson:"alertId,omitempty"`
+	AlertName string `json:"alertName,omitempty" bson:"alertName,omitempty"`
+	// MinDwell is the shortest stay in a region, in seconds, that is recorded.
+	MinDwell float64 `json:"minDwell,omitempty" bson:"minDwell,omitempty"`
+}
+
+// CountingTrajectoryFromClassify reads a classified object's path. The points
+// are TrajectCentroids ([x, y] rows) when present and otherwise the centres of
+// the Traject boxes ([x1, y1, x2, y2, frame] rows). A point's frame is taken
+// from Frames, then from the fifth value of its Traject row, then from its
+// position; frames are turned into seconds with fps.
+func CountingTrajectoryFromClassify(details ClassifyDetails, fps float64) (CountingTrajectory, error) {
+	if fps <= 0 || math.IsNaN(fps) || math.IsInf(fps, 0) {
+		return CountingTrajectory{}, fmt.Errorf("%w: fps %v", ErrCountingInvalidOptions, fps)
+	}
+	trajectory := CountingTrajectory{
+		ObjectId:   details.Id,
+		ObjectName: details.Classified,
+		Width:      details.FrameWidth,
+		Height:     details.FrameHeight,
+		Points:     []CountingPoint{},
+	}
+	frame := func(i int, row []float64) float64 {
+		if len(details.Frames) > i {
+			return float64(details.Frames[i])
+		}
+		if len(row) >= 5 {
+			return row[4]
+		}
+		return float64(i)
+	}
+	if len(details.TrajectCentroids) > 0 {
+		for i, row := range details.TrajectCentroids {
+			if len(row) >= 2 {
+				trajectory.Points = append(trajectory.Points, CountingPoint{X: row[0], Y: row[1], Time: frame(i, nil) / fps})
+			}
+		}
+	} else {
+		for i, row := range details.Traject {
+			if len(row) >= 4 {
+				trajectory.Points = append(trajectory.Points, CountingPoint{X: (row[0] + row[2]) / 2, Y: (row[1] + row[3]) / 2, Time: frame(i, row) / fps})
+			}
+		}
+	}
+	sort.SliceStable(trajectory.Points, func(i, j int) bool { return trajectory.Points[i].Time < trajectory.Points[j].Time })
+	return trajectory, nil
+}
+
+// CountTrajectories returns the line crossings and region stays of the
+// trajectories, ordered by timestamp.
+//
+// A line is the polyline through its RegionPoints. Crossing it to the right,
+// as seen walking from its first point to its last, counts in; crossing to
+// the left counts out. With y pointing down, that makes crossing downwards
+// over a line drawn left to right an in. A trajectory segment counts at most
+// once per line, and a point exactly on the line belongs to its left.
+//
+// A region is the polygon through its RegionPoints. A stay starts at the
+// first point inside it and ends at the first point outside it again, or at
+// the last point of a trajectory that ends inside.
+func CountTrajectories(trajectories []CountingTrajectory, options CountingOptions) ([]CountingRecord, error) {
+	if options.MinDwell < 0 {
+		return nil, fmt.Errorf("%w: minDwell %v", ErrCountingInvalidOptions, options.MinDwell)
+	}
+	for _, line := range options.Lines {
+		if len(line.RegionPoints) < 2 {
+			return nil, fmt.Errorf("%w: line %q has %d points", ErrCountingInvalidOptions, line.Id, len(line.RegionPoints))
+		}
+	}
+	for _, region := range options.Regions {
+		if len(region.RegionPoints) < 3 {
+			return nil, fmt.Errorf("%w: region %q has %d points", ErrCountingInvalidOptions, region.Id, len(region.RegionPoints))
+		}
+	}
+
+	type timed struct {
+		time   float64
+		record CountingRecord
+	}
+	var events []timed
+	for _, trajectory := range trajectories {
+		record := func(kind string, region Region, time float64) CountingRecord {
+			device := region.Device
+			if device == "" {
+				device = options.DeviceId
+			}
+			return CountingRecord{
+				Type:       kind,
+				SegmentId:  region.Id,
+				AlertId:    options.AlertId,
+				AlertName:  options.AlertName,
+				Timestamp:  strconv.FormatInt(options.StartTimestamp+int64(math.Floor(time)), 10),
+				DeviceId:   device,
+				ObjectName: trajectory.ObjectName,
+				Count:      1,
+			}
+		}
+
+		for _, line := range options.Lines {
+			points := line.Scaled(trajectory.Width, trajectory.Height)
+			for i := 1; i < len(trajectory.Points); i++ {
+				from, to := trajectory.Points[i-1], trajectory.Points[i]
+				kind, at, ok := lineCrossing(points, from, to)
+				if !ok || line.Direction == RegionDirectionIn && kind != CountingIn || line.Direction == RegionDirectionOut && kind != CountingOut {
+					continue
+				}
+				// The crossing happens between the two points, at the moment the
+				// path meets the line.
+				time := from.Time + (to.Time-from.Time)*at
+				events = append(events, timed{time, record(kind, line, time)})
+			}
+		}
+
+		for _, region := range options.Regions {
+			polygon := region.Scaled(trajectory.Width, trajectory.Height)
+			enter := -1
+			for i := 0; i <= len(trajectory.Points); i++ {
+				inside := i < len(trajectory.Points) && PointInPolygon(polygon, Point{X: trajectory.Points[i].X, Y: trajectory.Points[i].Y})
+				switch {
+				case inside && enter < 0:
+					enter = i
+				case !inside && enter >= 0:
+					exit := trajectory.Points[len(trajectory.Points)-1].Time
+					if i < len(trajectory.Points) {
+						exit = trajectory.Points[i].Time
+					}
+					start := trajectory.Points[enter].Time
+					if dwell := exit - start; dwell >= options.MinDwell {
+						stay := record(CountingRegion, region, start)
+						stay.Duration = dwell
+						events = append(events, timed{start, stay})
+					}
+					enter = -1
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time < events[j].time })
+	records := make([]CountingRecord, len(events))
+	for i, event := range events {
+		records[i] = event.record
+	}
+	return records, nil
+}
+
+// lineCrossing reports whether the step from one point to the next crosses
+// the polyline, in which direction, and at what fraction of the step.
+func lineCrossing(line []Point, from, to CountingPoint) (string, float64, bool) {
+	p, q := Point{X: from.X, Y: from.Y}, Point{X: to.X, Y: to.Y}
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		cp, cq := SideOfLine(a, b, p), SideOfLine(a, b, q)
+		if (cp > 0) == (cq > 0) || !SegmentsIntersect(a, b, p, q) {
+			continue
+		}
+		at := cp / (cp - cq)
+		if cq > 0 {
+			return CountingIn, at, true
+		}
+		return CountingOut, at, true
+	}
+	return "", 0, false
+}
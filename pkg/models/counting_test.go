@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCountTrajectories(t *testing.T) {
+	// Drawn at 1280x720; the trajectories are tracked at 640x360.
+	line := Region{Id: "door", Width: 1280, Height: 720, RegionPoints: []Point{{X: 0, Y: 360}, {X: 1280, Y: 360}}}
+	square := Region{Id: "desk", Device: "cam-2", Width: 1280, Height: 720, RegionPoints: []Point{{X: 0, Y: 0}, {X: 640, Y: 0}, {X: 640, Y: 360}, {X: 0, Y: 360}}}
+
+	walker := CountingTrajectory{ObjectId: "1", ObjectName: "person", Width: 640, Height: 360, Points: []CountingPoint{
+		{X: 500, Y: 100, Time: 0},
+		{X: 500, Y: 150, Time: 1},
+		// Crosses y=180 downwards three tenths of the way to the next point.
+		{X: 500, Y: 250, Time: 2},
+		{X: 500, Y: 300, Time: 3},
+		{X: 500, Y: 100, Time: 4},
+	}}
+	loiterer := CountingTrajectory{ObjectId: "2", ObjectName: "person", Width: 640, Height: 360, Points: []CountingPoint{
+		{X: 400, Y: 100, Time: 10},
+		{X: 200, Y: 100, Time: 11},
+		{X: 210, Y: 100, Time: 15},
+		{X: 400, Y: 100, Time: 16},
+		// A stay cut short by the end of the trajectory.
+		{X: 100, Y: 50, Time: 20},
+		{X: 100, Y: 60, Time: 20.5},
+	}}
+
+	options := CountingOptions{
+		Lines:          []Region{line},
+		Regions:        []Region{square},
+		StartTimestamp: 1000,
+		DeviceId:       "cam-1",
+		AlertId:        "alert",
+		MinDwell:       1,
+	}
+	records, err := CountTrajectories([]CountingTrajectory{loiterer, walker}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CountingRecord{
+		{Type: CountingIn, SegmentId: "door", AlertId: "alert", Timestamp: "1001", DeviceId: "cam-1", ObjectName: "person", Count: 1},
+		{Type: CountingOut, SegmentId: "door", AlertId: "alert", Timestamp: "1003", DeviceId: "cam-1", ObjectName: "person", Count: 1},
+		{Type: CountingRegion, SegmentId: "desk", AlertId: "alert", Timestamp: "1011", DeviceId: "cam-2", ObjectName: "person", Count: 1, Duration: 5},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %+v\nwant %+v", records, want)
+	}
+
+	line.Direction = RegionDirectionOut
+	options = CountingOptions{Lines: []Region{line}}
+	records, err = CountTrajectories([]CountingTrajectory{walker}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Type != CountingOut {
+		t.Errorf("out-only line: records = %+v", records)
+	}
+
+	// The line drawn the other way round swaps in and out.
+	reversed := Region{Id: "door", Width: 640, Height: 360, RegionPoints: []Point{{X: 640, Y: 180}, {X: 0, Y: 180}}}
+	records, _ = CountTrajectories([]CountingTrajectory{walker}, CountingOptions{Lines: []Region{reversed}})
+	if len(records) != 2 || records[0].Type != CountingOut || records[1].Type != CountingIn {
+		t.Errorf("reversed line: records = %+v", records)
+	}
+
+	if _, err := CountTrajectories(nil, CountingOptions{Lines: []Region{{Id: "dot", RegionPoints: []Point{{}}}}}); !errors.Is(err, ErrCountingInvalidOptions) {
+		t.Errorf("err = %v, want ErrCountingInvalidOptions", err)
+	}
+}
+
+func TestCountingTrajectoryFromClassify(t *testing.T) {
+	details := ClassifyDetails{
+		Id:          "7",
+		Classified:  "car",
+		FrameWidth:  640,
+		FrameHeight: 360,
+		Traject:     [][]float64{{0, 0, 10, 20, 5}, {10, 0, 20, 20, 10}, {1}},
+	}
+	trajectory, err := CountingTrajectoryFromClassify(details, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CountingPoint{{X: 5, Y: 10, Time: 1}, {X: 15, Y: 10, Time: 2}}
+	if !reflect.DeepEqual(trajectory.Points, want) || trajectory.ObjectName != "car" || trajectory.Width != 640 {
+		t.Errorf("trajectory = %+v", trajectory)
+	}
+
+	details.TrajectCentroids = [][]float64{{1, 2}, {3, 4}}
+	details.Frames = []int64{10, 20}
+	trajectory, _ = CountingTrajectoryFromClassify(details, 10)
+	if want := []CountingPoint{{X: 1, Y: 2, Time: 1}, {X: 3, Y: 4, Time: 2}}; !reflect.DeepEqual(trajectory.Points, want) {
+		t.Errorf("centroid points = %+v", trajectory.Points)
+	}
+
+	if _, err := CountingTrajectoryFromClassify(details, 0); !errors.Is(err, ErrCountingInvalidOptions) {
+		t.Errorf("err = %v, want ErrCountingInvalidOptions", err)
+	}
+}
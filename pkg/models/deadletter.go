@@ -0,0 +1,217 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// DeadLetterKind names the message type a DeadLetter wraps.
+type DeadLetterKind string
+
+const (
+	// DeadLetterPipelineEvent wraps a PipelineEvent from one of the pipeline
+	// stage queues (event, monitor, sequence, analysis, throttler, notification).
+	DeadLetterPipelineEvent DeadLetterKind = "pipelineEvent"
+	// DeadLetterWorkflowRun wraps a WorkflowRun from the workflows tail.
+	DeadLetterWorkflowRun DeadLetterKind = "workflowRun"
+)
+
+var (
+	// ErrDeadLetterEmpty is returned when a dead letter wraps no message.
+	ErrDeadLetterEmpty = errors.New("dead letter wraps no message")
+	// ErrDeadLetterKindMismatch is returned when a dead letter is replayed as a
+	// message type it does not wrap.
+	ErrDeadLetterKindMismatch = errors.New("dead letter wraps a different message kind")
+)
+
+// DeadLetter is what a message looks like once a stage has given up on it: the
+// original message, the stage that failed it, and the history of failed
+// attempts, so an operator can see why it was parked and replay it.
+//
+// Exactly one of PipelineEvent and WorkflowRun is set, as named by Kind. A
+// wrapped WorkflowRun has its Storage and SignedURL cleared so credentials never
+// land on the dead-letter queue; the engine re-attaches them when the replayed
+// run is dispatched again.
+type DeadLetter struct {
+	Kind          DeadLetterKind `json:"kind" bson:"kind"`
+	PipelineEvent *PipelineEvent `json:"pipelineEvent,omitempty" bson:"pipelineEvent,omitempty"`
+	WorkflowRun   *WorkflowRun   `json:"workflowRun,omitempty" bson:"workflowRun,omitempty"`
+
+	// Stage is the stage (or workflow operation) that dead-lettered the message.
+	Stage string `json:"stage" bson:"stage"`
+	// Errors is the error chain of the last failure, outermost first, as
+	// produced by ErrorChain.
+	Errors []string `json:"errors,omitempty" bson:"errors,omitempty"`
+	// Attempts is the failure history, oldest first. Replaying and failing again
+	// appends to it.
+	Attempts []DeadLetterAttempt `json:"attempts,omitempty" bson:"attempts,omitempty"`
+
+	// TraceId continues the distributed trace of the wrapped message.
+	TraceId string `json:"traceId,omitempty" bson:"traceId,omitempty"`
+	// ReplayToken identifies this dead letter so a replay can be made
+	// idempotent. It is kept across repeated failures of the same message.
+	ReplayToken string `json:"replayToken" bson:"replayToken"`
+}
+
+// DeadLetterAttempt is one failed handling of a dead-lettered message.
+type DeadLetterAttempt struct {
+	Stage        string         `json:"stage" bson:"stage"`
+	Action       PipelineAction `json:"action,omitempty" bson:"action,omitempty"`
+	Status       int            `json:"status,omitempty" bson:"status,omitempty"`
+	ReceiveCount int64          `json:"receiveCount,omitempty" bson:"receiveCount,omitempty"`
+	Error        string         `json:"error,omitempty" bson:"error,omitempty"`
+	Timestamp    time.Time      `json:"timestamp" bson:"timestamp"`
+}
+
+// NewPipelineDeadLetter builds the dead letter for a failed handler invocation:
+// the stage, the action and status the handler returned, and the cause. A nil
+// cause is recorded as an attempt without an error chain. The letter holds a
+// copy of event, so later changes to the event do not reach it.
+func NewPipelineDeadLetter(stage string, event PipelineEvent, action PipelineAction, status int, cause error, at time.Time) DeadLetter {
+	event = event.Clone()
+	letter := DeadLetter{
+		Kind:          DeadLetterPipelineEvent,
+		PipelineEvent: &event,
+		Stage:         stage,
+		TraceId:       event.TraceId,
+		ReplayToken:   NewReplayToken(),
+	}
+	letter.Record(stage, action, status, event.ReceiveCount, cause, at)
+	return letter
+}
+
+// NewWorkflowRunDeadLetter builds the dead letter for a workflow run a stage
+// worker or the engine failed to handle. The run's credentials are cleared.
+func NewWorkflowRunDeadLetter(run WorkflowRun, cause error, at time.Time) DeadLetter {
+	run.Storage = nil
+	run.SignedURL = ""
+	letter := DeadLetter{
+		Kind:        DeadLetterWorkflowRun,
+		WorkflowRun: &run,
+		Stage:       run.Operation,
+		TraceId:     run.TraceId,
+		ReplayToken: NewReplayToken(),
+	}
+	letter.Record(run.Operation, PipelineError, 0, 0, cause, at)
+	return letter
+}
+
+// Record appends a failed attempt and makes it the dead letter's current
+// failure.
+func (d *DeadLetter) Record(stage string, action PipelineAction, status int, receiveCount int64, cause error, at time.Time) {
+	attempt := DeadLetterAttempt{
+		Stage:        stage,
+		Action:       action,
+		Status:       status,
+		ReceiveCount: receiveCount,
+		Timestamp:    at.UTC(),
+	}
+	if cause != nil {
+		attempt.Error = cause.Error()
+	}
+	d.Stage = stage
+	d.Errors = ErrorChain(cause)
+	d.Attempts = append(d.Attempts, attempt)
+}
+
+// FirstFailure returns when the message first failed, or the zero time.
+func (d DeadLetter) FirstFailure() time.Time {
+	if len(d.Attempts) == 0 {
+		return time.Time{}
+	}
+	return d.Attempts[0].Timestamp
+}
+
+// LastFailure returns when the message last failed, or the zero time.
+func (d DeadLetter) LastFailure() time.Time {
+	if len(d.Attempts) == 0 {
+		return time.Time{}
+	}
+	return d.Attempts[len(d.Attempts)-1].Timestamp
+}
+
+// ReplayPipelineEvent returns a clean copy of the wrapped event to re-inject on
+// the failing stage's queue: ReceiveCount is reset so the stage gets a fresh
+// retry budget, and TraceId is kept so the replay joins the original trace.
+func (d DeadLetter) ReplayPipelineEvent() (PipelineEvent, error) {
+	if d.Kind != DeadLetterPipelineEvent {
+		return PipelineEvent{}, ErrDeadLetterKindMismatch
+	}
+	if d.PipelineEvent == nil {
+		return PipelineEvent{}, ErrDeadLetterEmpty
+	}
+	event := *d.PipelineEvent
+	event.ReceiveCount = 0
+	event.TraceId = d.TraceId
+	return event, nil
+}
+
+// ReplayWorkflowRun returns a copy of the wrapped run to re-inject on the
+// workflows tail, keeping its TraceId.
+func (d DeadLetter) ReplayWorkflowRun() (WorkflowRun, error) {
+	if d.Kind != DeadLetterWorkflowRun {
+		return WorkflowRun{}, ErrDeadLetterKindMismatch
+	}
+	if d.WorkflowRun == nil {
+		return WorkflowRun{}, ErrDeadLetterEmpty
+	}
+	run := *d.WorkflowRun
+	run.TraceId = d.TraceId
+	return run, nil
+}
+
+// ReplayMessage returns the wire form of the message to re-inject, whichever
+// kind the dead letter wraps.
+func (d DeadLetter) ReplayMessage() (json.RawMessage, error) {
+	switch d.Kind {
+	case DeadLetterPipelineEvent:
+		event, err := d.ReplayPipelineEvent()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(event)
+	case DeadLetterWorkflowRun:
+		run, err := d.ReplayWorkflowRun()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(run)
+	default:
+		return nil, ErrDeadLetterEmpty
+	}
+}
+
+// ErrorChain flattens err into the messages of its chain, outermost first,
+// following both Unwrap() error and Unwrap() []error (errors.Join).
+func ErrorChain(err error) []string {
+	var chain []string
+	var walk func(error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+		chain = append(chain, err.Error())
+		switch unwrapper := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(unwrapper.Unwrap())
+		case interface{ Unwrap() []error }:
+			for _, inner := range unwrapper.Unwrap() {
+				walk(inner)
+			}
+		}
+	}
+	walk(err)
+	return chain
+}
+
+// NewReplayToken returns a random token identifying a dead letter.
+func NewReplayToken() string {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(token[:])
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPipelineDeadLetterRecordsFailureHistory(t *testing.T) {
+	cause := fmt.Errorf("analysis: %w", errors.Join(errors.New("model timeout"), errors.New("fallback unavailable")))
+	first := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := PipelineEvent{TraceId: "trace-1", ReceiveCount: 4, Stages: []string{"event", "monitor"}}
+
+	letter := NewPipelineDeadLetter("analysis", event, PipelineRetry, 503, cause, first)
+	if letter.Kind != DeadLetterPipelineEvent || letter.Stage != "analysis" || letter.TraceId != "trace-1" || letter.ReplayToken == "" {
+		t.Fatalf("letter = %+v", letter)
+	}
+	want := []string{cause.Error(), "model timeout\nfallback unavailable", "model timeout", "fallback unavailable"}
+	if fmt.Sprint(letter.Errors) != fmt.Sprint(want) {
+		t.Errorf("errors = %q, want %q", letter.Errors, want)
+	}
+
+	token := letter.ReplayToken
+	letter.Record("analysis", PipelineError, 500, 1, errors.New("still failing"), first.Add(time.Minute))
+	if len(letter.Attempts) != 2 || letter.ReplayToken != token {
+		t.Fatalf("attempts = %+v, token %q → %q", letter.Attempts, token, letter.ReplayToken)
+	}
+	if !letter.FirstFailure().Equal(first) || !letter.LastFailure().Equal(first.Add(time.Minute)) {
+		t.Errorf("failures = %v .. %v", letter.FirstFailure(), letter.LastFailure())
+	}
+	if len(letter.Errors) != 1 || letter.Errors[0] != "still failing" {
+		t.Errorf("errors after record = %q, want the latest chain", letter.Errors)
+	}
+}
+
+func TestPipelineDeadLetterCopiesEvent(t *testing.T) {
+	projectId := primitive.NewObjectID()
+	wantProjectId := projectId
+	event := PipelineEvent{
+		TraceId:      "trace-1",
+		Stages:       []string{"event", "monitor"},
+		MonitorStage: &MonitorStage{Name: "monitor", ProjectId: &projectId, User: User{Sites: []string{"site-1"}}},
+		Payload:      PipelinePayload{BytesRangeOnTime: []FragmentedBytesRangeOnTime{{Time: "0"}}},
+		Data:         map[string]interface{}{"uri": "a", "nested": map[string]interface{}{"k": "v"}},
+	}
+	letter := NewPipelineDeadLetter("analysis", event, PipelineError, 500, nil, time.Now())
+
+	event.Stages[0] = "changed"
+	event.Stages = append(event.Stages, "sequence")
+	event.MonitorStage.Name = "changed"
+	*event.MonitorStage.ProjectId = primitive.NilObjectID
+	event.MonitorStage.User.Sites[0] = "changed"
+	event.Payload.BytesRangeOnTime[0].Time = "9"
+	event.Data["uri"] = "changed"
+	event.Data["nested"].(map[string]interface{})["k"] = "changed"
+
+	kept := letter.PipelineEvent
+	if !reflect.DeepEqual(kept.Stages, []string{"event", "monitor"}) || kept.MonitorStage.Name != "monitor" || *kept.MonitorStage.ProjectId != wantProjectId ||
+		kept.MonitorStage.User.Sites[0] != "site-1" || kept.Payload.BytesRangeOnTime[0].Time != "0" {
+		t.Errorf("dead-lettered event changed: %+v", kept)
+	}
+	if want := map[string]interface{}{"uri": "a", "nested": map[string]interface{}{"k": "v"}}; !reflect.DeepEqual(kept.Data, want) {
+		t.Errorf("data = %v, want %v", kept.Data, want)
+	}
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	letter := NewPipelineDeadLetter("throttler", PipelineEvent{TraceId: "trace-2", ReceiveCount: 7}, PipelineError, 0, nil, time.Now())
+	raw, err := json.Marshal(letter)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded DeadLetter
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	event, err := decoded.ReplayPipelineEvent()
+	if err != nil || event.ReceiveCount != 0 || event.TraceId != "trace-2" {
+		t.Errorf("replay = (%+v, %v)", event, err)
+	}
+	if _, err := decoded.ReplayWorkflowRun(); !errors.Is(err, ErrDeadLetterKindMismatch) {
+		t.Errorf("replay as run err = %v, want ErrDeadLetterKindMismatch", err)
+	}
+	if _, err := (DeadLetter{Kind: DeadLetterPipelineEvent}).ReplayMessage(); !errors.Is(err, ErrDeadLetterEmpty) {
+		t.Errorf("empty replay err = %v, want ErrDeadLetterEmpty", err)
+	}
+}
+
+func TestWorkflowRunDeadLetterDropsCredentials(t *testing.T) {
+	run := WorkflowRun{
+		Operation: "anpr",
+		TraceId:   "trace-3",
+		Storage:   &WorkflowStorage{Uri: "https://vault", Secret: "sk"},
+		SignedURL: "https://vault/signed?sig=abc",
+	}
+	letter := NewWorkflowRunDeadLetter(run, errors.New("worker crashed"), time.Now())
+	if letter.Stage != "anpr" || letter.WorkflowRun.Storage != nil || letter.WorkflowRun.SignedURL != "" {
+		t.Fatalf("letter = %+v, run = %+v", letter, letter.WorkflowRun)
+	}
+	if run.Storage == nil {
+		t.Error("building the dead letter mutated the caller's run")
+	}
+	raw, err := letter.ReplayMessage()
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	var wire map[string]any
+	_ = json.Unmarshal(raw, &wire)
+	if wire["operation"] != "anpr" || wire["traceId"] != "trace-3" || wire["storage"] != nil {
+		t.Errorf("replayed run = %v", wire)
+	}
+}
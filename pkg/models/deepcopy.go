@@ -0,0 +1,72 @@
+package models
+
+import "reflect"
+
+// Clone returns a deep copy of the event: its stages, payload and Data bag,
+// down to every nested map, slice and pointer, so the copy can be kept (in a
+// dead letter, say) while the original goes on being changed.
+func (pe PipelineEvent) Clone() PipelineEvent {
+	return deepCopy(reflect.ValueOf(pe), map[uintptr]reflect.Value{}).Interface().(PipelineEvent)
+}
+
+// deepCopy copies v, following pointers, maps, slices and interfaces.
+// Unexported struct fields are copied by value (time.Time keeps its location
+// pointer, for instance). copied maps pointers already copied to their copy,
+// so shared and cyclic references stay shared and cyclic.
+func deepCopy(v reflect.Value, copied map[uintptr]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		if c, ok := copied[v.Pointer()]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		copied[v.Pointer()] = c
+		c.Elem().Set(deepCopy(v.Elem(), copied))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), copied))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value(), copied))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i), copied))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
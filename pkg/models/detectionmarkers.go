@@ -0,0 +1,167 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrDetectionMarkersInvalid is returned when markers cannot be derived from a
+// run: it has no frame rate, or the rules are out of range.
+var ErrDetectionMarkersInvalid = errors.New("cannot derive markers from detection run")
+
+// DetectionMarkerRules selects the tracks of a DetectionRun that become
+// markers, and how those markers are labelled.
+type DetectionMarkerRules struct {
+	// ClassIds and Labels select the tracks that qualify; a track matching
+	// either list qualifies. Both empty lets every class through.
+	ClassIds []int    `json:"classIds,omitempty" bson:"classIds,omitempty"`
+	Labels   []string `json:"labels,omitempty" bson:"labels,omitempty"`
+	// MinDuration is the shortest track, in seconds, that becomes a marker.
+	MinDuration float64 `json:"minDuration,omitempty" bson:"minDuration,omitempty"`
+	// MinConfidence is the lowest mean box confidence that becomes a marker. A
+	// track without any confidence only qualifies when this is zero.
+	MinConfidence float64 `json:"minConfidence,omitempty" bson:"minConfidence,omitempty"`
+	// Categories maps a track label to the marker category it is filed under.
+	// Labels missing from the map get no category.
+	Categories map[string]string `json:"categories,omitempty" bson:"categories,omitempty"`
+	// Source and Engine fill MarkerMetadata; Source defaults to the run's
+	// Source.Name.
+	Source string `json:"source,omitempty" bson:"source,omitempty"`
+	Engine string `json:"engine,omitempty" bson:"engine,omitempty"`
+}
+
+// MarkersFromDetectionRun emits one marker per qualifying track of run, in
+// order of start time. Frame numbers are turned into timestamps with
+// Media.Fps from recordingStart (epoch seconds; zero uses the run's
+// RecordingTimestamp): a marker starts at its first frame and ends after its
+// last, rounded out to whole seconds. Deleted frames are ignored.
+//
+// Each marker is pinned to the run's recording through MediaKeys, refers back
+// to its track through Detections, and carries the track's mean confidence and
+// most confident box in its metadata. Markers are returned without an Id; the
+// marker writer assigns one.
+func MarkersFromDetectionRun(run DetectionRun, recordingStart int64, rules DetectionMarkerRules) ([]Marker, error) {
+	fps := run.Media.Fps
+	if fps <= 0 || math.IsNaN(fps) || math.IsInf(fps, 0) {
+		return nil, fmt.Errorf("%w: media fps %v", ErrDetectionMarkersInvalid, fps)
+	}
+	if rules.MinDuration < 0 || rules.MinConfidence < 0 || rules.MinConfidence > 1 {
+		return nil, fmt.Errorf("%w: minDuration %v, minConfidence %v", ErrDetectionMarkersInvalid, rules.MinDuration, rules.MinConfidence)
+	}
+	if recordingStart == 0 {
+		recordingStart = run.RecordingTimestamp
+	}
+	source := rules.Source
+	if source == "" {
+		source = run.Source.Name
+	}
+	filter := DetectionView{ClassIds: rules.ClassIds, Labels: rules.Labels}
+
+	markers := []Marker{}
+	for _, track := range run.Tracks {
+		if len(track.FrameCoordinates) == 0 {
+			track = track.Clone()
+			track.FrameCoordinatesFromTraject()
+		}
+		frames := track.activeFrames()
+		if len(frames) == 0 {
+			continue
+		}
+		label, classId := trackClass(track, frames)
+		if !filter.keepsClass(FaceRedactionTrack{Classified: label, ClassId: classId}, TrackBox{}) {
+			continue
+		}
+		first, last := frames[0], frames[len(frames)-1]
+		if float64(last-first+1)/fps < rules.MinDuration {
+			continue
+		}
+
+		var total float64
+		var scored int
+		best := track.FrameCoordinates[frames[len(frames)/2]]
+		bestConfidence := -1.0
+		for _, frame := range frames {
+			box := track.FrameCoordinates[frame]
+			confidence := boxConfidence(track, box)
+			if confidence > 0 {
+				total += confidence
+				scored++
+			}
+			if confidence > bestConfidence && confidence > 0 {
+				best, bestConfidence = box, confidence
+			}
+		}
+		var confidence *float64
+		if scored > 0 {
+			mean := total / float64(scored)
+			confidence = &mean
+		}
+		if rules.MinConfidence > 0 && (confidence == nil || *confidence < rules.MinConfidence) {
+			continue
+		}
+
+		boundingBox := best.MarkerBox()
+		start := recordingStart + int64(math.Floor(float64(first)/fps))
+		end := recordingStart + int64(math.Ceil(float64(last+1)/fps))
+		marker := Marker{
+			DeviceId:       run.DeviceId,
+			OrganisationId: run.OrganisationId,
+			ProjectId:      run.ProjectId,
+			MediaKeys:      []string{run.Key},
+			StartTimestamp: start,
+			EndTimestamp:   end,
+			Duration:       end - start,
+			Name:           label,
+			Metadata: &MarkerMetadata{
+				Confidence:   confidence,
+				Source:       source,
+				Engine:       rules.Engine,
+				ModelVersion: run.Source.Version,
+				BoundingBox:  &boundingBox,
+			},
+			Detections: []DetectionRef{{RunId: run.Source.RunId, TrackId: track.Id}},
+		}
+		if category := rules.Categories[label]; category != "" {
+			marker.Categories = []MarkerCategory{{Name: category}}
+		}
+		markers = append(markers, marker)
+	}
+
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].StartTimestamp < markers[j].StartTimestamp })
+	return markers, nil
+}
+
+// trackClass returns the track's label and class id, falling back to the
+// most common among its boxes.
+func trackClass(track FaceRedactionTrack, frames []int64) (string, *int) {
+	label, classId := track.Classified, track.ClassId
+	if label != "" && classId != nil {
+		return label, classId
+	}
+	labels := map[string]int{}
+	classIds := map[int]int{}
+	var commonLabel string
+	var commonClassId *int
+	for _, frame := range frames {
+		box := track.FrameCoordinates[frame]
+		if box.Label != "" {
+			if labels[box.Label]++; labels[box.Label] > labels[commonLabel] {
+				commonLabel = box.Label
+			}
+		}
+		if box.ClassId != nil {
+			if classIds[*box.ClassId]++; commonClassId == nil || classIds[*box.ClassId] > classIds[*commonClassId] {
+				commonClassId = box.ClassId
+			}
+		}
+	}
+	if label == "" {
+		label = commonLabel
+	}
+	if classId == nil {
+		classId = commonClassId
+	}
+	return label, classId
+}
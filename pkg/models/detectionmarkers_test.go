@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMarkersFromDetectionRun(t *testing.T) {
+	person, car := 0, 2
+	run := DetectionRun{
+		Key:                "cam/1.mp4",
+		OrganisationId:     "org",
+		DeviceId:           "cam",
+		Source:             DetectionSource{Name: "yolo", Version: "v8", RunId: "run-1"},
+		Media:              DetectionMedia{Fps: 10},
+		RecordingTimestamp: 1000,
+		Tracks: []FaceRedactionTrack{
+			// Frames 25-44: from 1002 to 1005 (after frame 44 ends at 4.5s).
+			trackWithId("walker", &person, map[int64]TrackBox{
+				25: {X1: 0.1, Y1: 0.1, X2: 0.2, Y2: 0.3, Confidence: 0.6},
+				30: {X1: 0.2, Y1: 0.1, X2: 0.3, Y2: 0.3, Confidence: 0.9},
+				44: {X1: 0.3, Y1: 0.1, X2: 0.4, Y2: 0.3, Confidence: 0.6},
+				90: {X1: 0.3, Y1: 0.1, X2: 0.4, Y2: 0.3, Confidence: 0.1},
+			}),
+			// Too short once the deleted frame is ignored.
+			trackWithId("blip", &person, map[int64]TrackBox{
+				0: {X1: 0, Y1: 0, X2: 0.1, Y2: 0.1, Confidence: 0.9},
+				5: {X1: 0, Y1: 0, X2: 0.1, Y2: 0.1, Confidence: 0.9},
+			}),
+			// Falls back to the track confidence of 0.6 and the box labels.
+			trackWithId("parked", nil, map[int64]TrackBox{
+				0:  {X1: 0.5, Y1: 0.5, X2: 0.9, Y2: 0.9, ClassId: &car, Label: "car"},
+				19: {X1: 0.5, Y1: 0.5, X2: 0.9, Y2: 0.9, ClassId: &car, Label: "car"},
+			}),
+		},
+	}
+	run.Tracks[0].Classified = "person"
+	run.Tracks[0].DeletedFrames = []int64{90}
+	run.Tracks[1].DeletedFrames = []int64{5}
+
+	markers, err := MarkersFromDetectionRun(run, 0, DetectionMarkerRules{
+		MinDuration: 1,
+		Categories:  map[string]string{"person": "people"},
+		Engine:      "onnx",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(markers) != 2 {
+		t.Fatalf("markers = %+v", markers)
+	}
+
+	parked, walker := markers[0], markers[1]
+	if parked.Name != "car" || parked.StartTimestamp != 1000 || parked.EndTimestamp != 1002 || parked.Categories != nil {
+		t.Errorf("parked = %+v", parked)
+	}
+	if *parked.Metadata.Confidence != 0.6 {
+		t.Errorf("parked confidence = %v", *parked.Metadata.Confidence)
+	}
+
+	if walker.StartTimestamp != 1002 || walker.EndTimestamp != 1005 || walker.Duration != 3 {
+		t.Errorf("walker runs %d-%d (%d)", walker.StartTimestamp, walker.EndTimestamp, walker.Duration)
+	}
+	if walker.DeviceId != "cam" || walker.OrganisationId != "org" || !reflect.DeepEqual(walker.MediaKeys, []string{"cam/1.mp4"}) {
+		t.Errorf("walker scope = %+v", walker)
+	}
+	if !reflect.DeepEqual(walker.Detections, []DetectionRef{{RunId: "run-1", TrackId: "walker"}}) {
+		t.Errorf("walker detections = %+v", walker.Detections)
+	}
+	if !reflect.DeepEqual(walker.Categories, []MarkerCategory{{Name: "people"}}) {
+		t.Errorf("walker categories = %+v", walker.Categories)
+	}
+	metadata := walker.Metadata
+	if !near(*metadata.Confidence, 0.7) || metadata.Source != "yolo" || metadata.Engine != "onnx" || metadata.ModelVersion != "v8" {
+		t.Errorf("walker metadata = %+v", metadata)
+	}
+	if box := *metadata.BoundingBox; !near(box.X, 0.2) || !near(box.Width, 0.1) || !near(box.Height, 0.2) {
+		t.Errorf("walker box = %+v, want the most confident", box)
+	}
+}
+
+func TestMarkersFromDetectionRunRules(t *testing.T) {
+	person, car := 0, 2
+	run := DetectionRun{
+		Media: DetectionMedia{Fps: 25},
+		Tracks: []FaceRedactionTrack{
+			trackWithId("a", &person, map[int64]TrackBox{0: {X2: 0.1, Y2: 0.1, Confidence: 0.4}}),
+			trackWithId("b", &car, map[int64]TrackBox{0: {X2: 0.1, Y2: 0.1, Confidence: 0.8}}),
+		},
+	}
+	run.Tracks[1].Classified = "car"
+	tests := []struct {
+		rules DetectionMarkerRules
+		want  []string
+	}{
+		{DetectionMarkerRules{}, []string{"a", "b"}},
+		{DetectionMarkerRules{ClassIds: []int{0}}, []string{"a"}},
+		{DetectionMarkerRules{Labels: []string{"car"}}, []string{"b"}},
+		{DetectionMarkerRules{MinConfidence: 0.5}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		markers, err := MarkersFromDetectionRun(run, 500, tt.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, marker := range markers {
+			got = append(got, marker.Detections[0].TrackId)
+			if marker.StartTimestamp != 500 || marker.EndTimestamp != 501 {
+				t.Errorf("%+v: marker runs %d-%d", tt.rules, marker.StartTimestamp, marker.EndTimestamp)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: tracks = %v, want %v", tt.rules, got, tt.want)
+		}
+	}
+
+	run.Media.Fps = 0
+	if _, err := MarkersFromDetectionRun(run, 0, DetectionMarkerRules{}); !errors.Is(err, ErrDetectionMarkersInvalid) {
+		t.Errorf("err = %v, want ErrDetectionMarkersInvalid", err)
+	}
+}
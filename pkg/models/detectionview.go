@@ -0,0 +1,195 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A stored DetectionRun keeps each box's model confidence and class, so the
+// editor can show it at a different sensitivity without running the model
+// again: ApplyView drops what falls below a new threshold or outside a class
+// filter, and suppresses overlapping boxes at a new NMS IoU.
+
+// ErrDetectionViewInvalidOptions is returned for a threshold or IoU outside
+// [0, 1].
+var ErrDetectionViewInvalidOptions = errors.New("invalid detection view options")
+
+// DetectionView selects the boxes of a DetectionRun to keep. Zero values keep
+// everything.
+type DetectionView struct {
+	// ScoreThreshold drops boxes with a lower confidence. A box without a
+	// confidence uses its track's.
+	ScoreThreshold float64 `json:"scoreThreshold,omitempty" bson:"scoreThreshold,omitempty"`
+	// ClassIds and Labels keep only boxes of these classes; a box matching
+	// either list is kept. A box without a class or label uses its track's.
+	ClassIds []int    `json:"classIds,omitempty" bson:"classIds,omitempty"`
+	Labels   []string `json:"labels,omitempty" bson:"labels,omitempty"`
+	// NmsIou suppresses, within each frame, a box overlapping a more confident
+	// box of another track by more than this IoU. Zero disables suppression.
+	NmsIou float64 `json:"nmsIou,omitempty" bson:"nmsIou,omitempty"`
+	// ClassAgnosticNms lets boxes of different classes suppress each other.
+	ClassAgnosticNms bool `json:"classAgnosticNms,omitempty" bson:"classAgnosticNms,omitempty"`
+}
+
+// ApplyView returns a copy of the run with only the boxes options selects; the
+// run itself is not changed. Boxes marked Edited are the user's and survive
+// the threshold and suppression, though not the class filter. Deleted frames
+// are kept as they are. A track left without boxes is dropped.
+//
+// The applied parameters are recorded on the copy: Source.ScoreThreshold and
+// Source.NmsIou become the effective values of the run and the view combined,
+// and View holds options. The copy keeps Source.RunId; give it a new one
+// before storing it next to the original.
+func (r DetectionRun) ApplyView(options DetectionView) (DetectionRun, error) {
+	if options.ScoreThreshold < 0 || options.ScoreThreshold > 1 || math.IsNaN(options.ScoreThreshold) {
+		return DetectionRun{}, fmt.Errorf("%w: scoreThreshold %v", ErrDetectionViewInvalidOptions, options.ScoreThreshold)
+	}
+	if options.NmsIou < 0 || options.NmsIou > 1 || math.IsNaN(options.NmsIou) {
+		return DetectionRun{}, fmt.Errorf("%w: nmsIou %v", ErrDetectionViewInvalidOptions, options.NmsIou)
+	}
+
+	view := r
+	view.Categories = append([]DetectionCategory(nil), r.Categories...)
+	view.Tracks = make([]FaceRedactionTrack, 0, len(r.Tracks))
+	for _, track := range r.Tracks {
+		track = track.Clone()
+		if len(track.FrameCoordinates) == 0 {
+			track.FrameCoordinatesFromTraject()
+		}
+		for frame, box := range track.FrameCoordinates {
+			if track.IsDeleted(frame) {
+				continue
+			}
+			if !options.keepsClass(track, box) || !box.Edited && boxConfidence(track, box) < options.ScoreThreshold {
+				delete(track.FrameCoordinates, frame)
+			}
+		}
+		view.Tracks = append(view.Tracks, track)
+	}
+	if options.NmsIou > 0 {
+		view.suppress(options)
+	}
+
+	tracks := view.Tracks[:0]
+	for _, track := range view.Tracks {
+		if len(track.activeFrames()) == 0 {
+			continue
+		}
+		track.pruneKeypoints()
+		track.TrajectFromFrameCoordinates()
+		tracks = append(tracks, track)
+	}
+	view.Tracks = tracks
+
+	if options.ScoreThreshold > view.Source.ScoreThreshold {
+		view.Source.ScoreThreshold = options.ScoreThreshold
+	}
+	if options.NmsIou > 0 && (view.Source.NmsIou == 0 || options.NmsIou < view.Source.NmsIou) {
+		view.Source.NmsIou = options.NmsIou
+	}
+	options.ClassIds = append([]int(nil), options.ClassIds...)
+	options.Labels = append([]string(nil), options.Labels...)
+	view.View = &options
+	return view, nil
+}
+
+// keepsClass reports whether box passes the class filter.
+func (options DetectionView) keepsClass(track FaceRedactionTrack, box TrackBox) bool {
+	if len(options.ClassIds) == 0 && len(options.Labels) == 0 {
+		return true
+	}
+	if classId := boxClassId(track, box); classId != nil {
+		for _, id := range options.ClassIds {
+			if id == *classId {
+				return true
+			}
+		}
+	}
+	return containsString(options.Labels, boxLabel(track, box))
+}
+
+// suppress runs greedy NMS within each frame across the run's tracks: boxes
+// are visited from most to least confident, Edited boxes first, and a box is
+// removed when it overlaps one already kept by more than NmsIou.
+func (r *DetectionRun) suppress(options DetectionView) {
+	type candidate struct {
+		track int
+		frame int64
+		box   TrackBox
+	}
+	byFrame := map[int64][]candidate{}
+	for i := range r.Tracks {
+		track := &r.Tracks[i]
+		for _, frame := range track.activeFrames() {
+			byFrame[frame] = append(byFrame[frame], candidate{i, frame, track.FrameCoordinates[frame]})
+		}
+	}
+
+	for _, candidates := range byFrame {
+		if len(candidates) < 2 {
+			continue
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if a.box.Edited != b.box.Edited {
+				return a.box.Edited
+			}
+			ca, cb := boxConfidence(r.Tracks[a.track], a.box), boxConfidence(r.Tracks[b.track], b.box)
+			if ca != cb {
+				return ca > cb
+			}
+			return a.track < b.track
+		})
+		var kept []candidate
+		for _, c := range candidates {
+			suppressed := false
+			for _, k := range kept {
+				if !options.ClassAgnosticNms && !r.sameClass(c.track, c.box, k.track, k.box) {
+					continue
+				}
+				if c.box.IoU(k.box) > options.NmsIou {
+					suppressed = true
+					break
+				}
+			}
+			if suppressed && !c.box.Edited {
+				delete(r.Tracks[c.track].FrameCoordinates, c.frame)
+				continue
+			}
+			kept = append(kept, c)
+		}
+	}
+}
+
+// sameClass compares two boxes by class id when both have one, and by label
+// otherwise.
+func (r *DetectionRun) sameClass(i int, a TrackBox, j int, b TrackBox) bool {
+	ca, cb := boxClassId(r.Tracks[i], a), boxClassId(r.Tracks[j], b)
+	if ca != nil && cb != nil {
+		return *ca == *cb
+	}
+	return boxLabel(r.Tracks[i], a) == boxLabel(r.Tracks[j], b)
+}
+
+func boxConfidence(track FaceRedactionTrack, box TrackBox) float64 {
+	if box.Confidence != 0 {
+		return box.Confidence
+	}
+	return track.Confidence
+}
+
+func boxClassId(track FaceRedactionTrack, box TrackBox) *int {
+	if box.ClassId != nil {
+		return box.ClassId
+	}
+	return track.ClassId
+}
+
+func boxLabel(track FaceRedactionTrack, box TrackBox) string {
+	if box.Label != "" {
+		return box.Label
+	}
+	return track.Classified
+}
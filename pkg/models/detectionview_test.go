@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func viewRun() DetectionRun {
+	person, car := 0, 2
+	return DetectionRun{
+		Source: DetectionSource{RunId: "r", ScoreThreshold: 0.2, NmsIou: 0.7},
+		Tracks: []FaceRedactionTrack{
+			trackWithId("a", &person, map[int64]TrackBox{
+				0: {X1: 0, Y1: 0, X2: 0.4, Y2: 0.4, Confidence: 0.9},
+				1: {X1: 0, Y1: 0, X2: 0.4, Y2: 0.4, Confidence: 0.3},
+			}),
+			// Overlaps "a" on frame 0 with an IoU of 0.8.
+			trackWithId("b", &person, map[int64]TrackBox{
+				0: {X1: 0, Y1: 0, X2: 0.4, Y2: 0.32, Confidence: 0.8},
+				2: {X1: 0.5, Y1: 0.5, X2: 0.9, Y2: 0.9, Confidence: 0.25, Edited: true},
+			}),
+			// Same place as "a", another class.
+			trackWithId("c", &car, map[int64]TrackBox{
+				0: {X1: 0, Y1: 0, X2: 0.4, Y2: 0.4},
+			}),
+		},
+	}
+}
+
+func trackWithId(id string, classId *int, boxes map[int64]TrackBox) FaceRedactionTrack {
+	track := trackWith(boxes)
+	track.Id = id
+	track.ClassId = classId
+	track.Confidence = 0.6
+	return track
+}
+
+func viewFrames(run DetectionRun) map[string][]int64 {
+	frames := map[string][]int64{}
+	for _, track := range run.Tracks {
+		frames[track.Id] = track.Frames
+	}
+	return frames
+}
+
+func TestDetectionRunView(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   DetectionView
+		want      map[string][]int64
+		threshold float64
+		iou       float64
+	}{
+		{"everything", DetectionView{}, map[string][]int64{"a": {0, 1}, "b": {0, 2}, "c": {0}}, 0.2, 0.7},
+		// The edited box of "b" survives; "c" has only its track confidence.
+		{"threshold", DetectionView{ScoreThreshold: 0.5}, map[string][]int64{"a": {0}, "b": {0, 2}, "c": {0}}, 0.5, 0.7},
+		{"threshold drops track", DetectionView{ScoreThreshold: 0.65}, map[string][]int64{"a": {0}, "b": {0, 2}}, 0.65, 0.7},
+		{"class id", DetectionView{ClassIds: []int{2}}, map[string][]int64{"c": {0}}, 0.2, 0.7},
+		{"label", DetectionView{Labels: []string{"car"}}, map[string][]int64{"c": {0}}, 0.2, 0.7},
+		{"nms", DetectionView{NmsIou: 0.5}, map[string][]int64{"a": {0, 1}, "b": {2}, "c": {0}}, 0.2, 0.5},
+		{"loose nms", DetectionView{NmsIou: 0.9}, map[string][]int64{"a": {0, 1}, "b": {0, 2}, "c": {0}}, 0.2, 0.7},
+		{"class agnostic nms", DetectionView{NmsIou: 0.5, ClassAgnosticNms: true}, map[string][]int64{"a": {0, 1}, "b": {2}}, 0.2, 0.5},
+	}
+	for _, tt := range tests {
+		run := viewRun()
+		run.Tracks[2].FrameCoordinates[0] = TrackBox{X1: 0, Y1: 0, X2: 0.4, Y2: 0.4, Label: "car"}
+		view, err := run.ApplyView(tt.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := viewFrames(view); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: frames = %v, want %v", tt.name, got, tt.want)
+		}
+		if view.Source.ScoreThreshold != tt.threshold || view.Source.NmsIou != tt.iou || view.View == nil {
+			t.Errorf("%s: provenance = %+v, view %+v", tt.name, view.Source, view.View)
+		}
+		for _, track := range view.Tracks {
+			if len(track.Traject) != len(track.Frames) {
+				t.Errorf("%s: traject of %s not regenerated", tt.name, track.Id)
+			}
+		}
+		if len(run.Tracks) != 3 || len(run.Tracks[0].FrameCoordinates) != 2 || run.View != nil {
+			t.Errorf("%s: original run changed", tt.name)
+		}
+	}
+}
+
+func TestDetectionRunViewKeepsDeletedFrames(t *testing.T) {
+	run := viewRun()
+	run.Tracks[0].DeletedFrames = []int64{1}
+	view, err := run.ApplyView(DetectionView{ScoreThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := view.Tracks[0]; !reflect.DeepEqual(got.Frames, []int64{0, 1}) {
+		t.Errorf("frames = %v, want the deleted frame kept", got.Frames)
+	}
+
+	if _, err := run.ApplyView(DetectionView{ScoreThreshold: 1.5}); !errors.Is(err, ErrDetectionViewInvalidOptions) {
+		t.Errorf("err = %v, want ErrDetectionViewInvalidOptions", err)
+	}
+	if _, err := run.ApplyView(DetectionView{NmsIou: -0.1}); !errors.Is(err, ErrDetectionViewInvalidOptions) {
+		t.Errorf("err = %v, want ErrDetectionViewInvalidOptions", err)
+	}
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Media embeddings are stored in MediaMetadata.Embedding as ints: the float
+// vector a model produced, scaled to unit length and quantised by the
+// EmbeddingFormat's Scale. Two embeddings are only comparable when they come
+// from the same model, version and dimension. The indexes below answer "find
+// recordings that look like this one" in process, always within one
+// organisation and optionally one project.
+
+// DefaultEmbeddingScale quantises a unit vector to the int16 range.
+const DefaultEmbeddingScale = 32767
+
+// ErrEmbeddingInvalid is returned for an incomplete format, a vector of the
+// wrong dimension or without direction, an embedding of another model than the
+// index, or a search without an organisation.
+var ErrEmbeddingInvalid = errors.New("invalid embedding")
+
+// EmbeddingFormat describes how a stored embedding was produced.
+type EmbeddingFormat struct {
+	ModelId      string `json:"modelId" bson:"modelId"`
+	ModelVersion string `json:"modelVersion,omitempty" bson:"modelVersion,omitempty"`
+	Dimension    int    `json:"dimension" bson:"dimension"`
+	// Scale is what a unit vector's components are multiplied by before they
+	// are rounded to ints.
+	Scale float64 `json:"scale" bson:"scale"`
+}
+
+// Validate checks the format can encode and decode.
+func (f EmbeddingFormat) Validate() error {
+	if f.ModelId == "" || f.Dimension <= 0 {
+		return fmt.Errorf("%w: model %q with dimension %d", ErrEmbeddingInvalid, f.ModelId, f.Dimension)
+	}
+	if !(f.Scale > 0) || math.IsInf(f.Scale, 0) {
+		return fmt.Errorf("%w: scale %v", ErrEmbeddingInvalid, f.Scale)
+	}
+	return nil
+}
+
+// Compatible reports whether embeddings of both formats can be compared: the
+// same model, version and dimension. The scale may differ.
+func (f EmbeddingFormat) Compatible(o EmbeddingFormat) bool {
+	return f.ModelId == o.ModelId && f.ModelVersion == o.ModelVersion && f.Dimension == o.Dimension
+}
+
+// Encode scales vector to unit length and quantises it for storage.
+func (f EmbeddingFormat) Encode(vector []float32) ([]int, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	unit, err := f.unit(vector)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int, len(unit))
+	for i, v := range unit {
+		values[i] = int(math.Round(float64(v) * f.Scale))
+	}
+	return values, nil
+}
+
+// Decode turns stored values back into a vector of about unit length.
+func (f EmbeddingFormat) Decode(values []int) ([]float32, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if len(values) != f.Dimension {
+		return nil, fmt.Errorf("%w: %d values for dimension %d", ErrEmbeddingInvalid, len(values), f.Dimension)
+	}
+	vector := make([]float32, len(values))
+	for i, v := range values {
+		vector[i] = float32(float64(v) / f.Scale)
+	}
+	return vector, nil
+}
+
+// unit checks a vector against the format and scales it to unit length.
+func (f EmbeddingFormat) unit(vector []float32) ([]float32, error) {
+	if len(vector) != f.Dimension {
+		return nil, fmt.Errorf("%w: %d values for dimension %d", ErrEmbeddingInvalid, len(vector), f.Dimension)
+	}
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 || math.IsNaN(norm) || math.IsInf(norm, 0) {
+		return nil, fmt.Errorf("%w: vector has no direction", ErrEmbeddingInvalid)
+	}
+	unit := make([]float32, len(vector))
+	for i, v := range vector {
+		unit[i] = float32(float64(v) / norm)
+	}
+	return unit, nil
+}
+
+// EmbeddingEntry is one media's embedding with the scope it belongs to.
+type EmbeddingEntry struct {
+	MediaId        primitive.ObjectID `json:"mediaId" bson:"mediaId"`
+	OrganisationId string             `json:"organisationId" bson:"organisationId"`
+	// ProjectId is the project ResolveProjectId resolves for the media; zero
+	// when it has none.
+	ProjectId primitive.ObjectID `json:"projectId,omitempty" bson:"projectId,omitempty"`
+	Format    EmbeddingFormat    `json:"format" bson:"format"`
+	Vector    []float32          `json:"vector" bson:"vector"`
+}
+
+// EmbeddingEntryFromMedia decodes a media's stored embedding.
+func EmbeddingEntryFromMedia(media Media) (EmbeddingEntry, error) {
+	if media.Metadata == nil || media.Metadata.EmbeddingFormat == nil || len(media.Metadata.Embedding) == 0 {
+		return EmbeddingEntry{}, fmt.Errorf("%w: media %s has no embedding", ErrEmbeddingInvalid, media.Id.Hex())
+	}
+	format := *media.Metadata.EmbeddingFormat
+	vector, err := format.Decode(media.Metadata.Embedding)
+	if err != nil {
+		return EmbeddingEntry{}, err
+	}
+	entry := EmbeddingEntry{MediaId: media.Id, OrganisationId: media.OrganisationId, Format: format, Vector: vector}
+	if organisationId, err := primitive.ObjectIDFromHex(media.OrganisationId); err == nil {
+		entry.ProjectId = ResolveProjectId(organisationId, media.ProjectId)
+	} else if media.ProjectId != nil {
+		entry.ProjectId = *media.ProjectId
+	}
+	return entry, nil
+}
+
+// EmbeddingScope bounds a search. OrganisationId is required; a zero
+// ProjectId searches the whole organisation.
+type EmbeddingScope struct {
+	OrganisationId string             `json:"organisationId" bson:"organisationId"`
+	ProjectId      primitive.ObjectID `json:"projectId,omitempty" bson:"projectId,omitempty"`
+}
+
+// EmbeddingMatch is a search result. Score is the cosine similarity, from -1
+// to 1.
+type EmbeddingMatch struct {
+	MediaId primitive.ObjectID `json:"mediaId" bson:"mediaId"`
+	Score   float64            `json:"score" bson:"score"`
+}
+
+// EmbeddingIndex finds the entries closest to a query vector. Matches are
+// ordered by descending score. Add does not look for an earlier entry of the
+// same media, so each media should be added once.
+type EmbeddingIndex interface {
+	Format() EmbeddingFormat
+	Add(entry EmbeddingEntry) error
+	Search(query []float32, scope EmbeddingScope, k int) ([]EmbeddingMatch, error)
+	Len() int
+}
+
+// SimilarMedia searches index for the k recordings that look most like media,
+// within its organisation and project. The media itself is left out.
+func SimilarMedia(index EmbeddingIndex, media Media, k int) ([]EmbeddingMatch, error) {
+	entry, err := EmbeddingEntryFromMedia(media)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.Format.Compatible(index.Format()) {
+		return nil, fmt.Errorf("%w: media embedded with %s %s, index holds %s %s", ErrEmbeddingInvalid,
+			entry.Format.ModelId, entry.Format.ModelVersion, index.Format().ModelId, index.Format().ModelVersion)
+	}
+	matches, err := index.Search(entry.Vector, EmbeddingScope{OrganisationId: entry.OrganisationId, ProjectId: entry.ProjectId}, k+1)
+	if err != nil {
+		return nil, err
+	}
+	similar := make([]EmbeddingMatch, 0, len(matches))
+	for _, match := range matches {
+		if match.MediaId != media.Id && len(similar) < k {
+			similar = append(similar, match)
+		}
+	}
+	return similar, nil
+}
+
+// embeddingKey groups entries by organisation and project.
+type embeddingKey struct {
+	organisationId string
+	projectId      primitive.ObjectID
+}
+
+// embeddingVector is an indexed entry, scaled to unit length so the cosine is
+// a dot product.
+type embeddingVector struct {
+	mediaId primitive.ObjectID
+	vector  []float32
+}
+
+// prepareEmbedding checks an entry against the index's format.
+func prepareEmbedding(format EmbeddingFormat, entry EmbeddingEntry) (embeddingKey, embeddingVector, error) {
+	if entry.OrganisationId == "" {
+		return embeddingKey{}, embeddingVector{}, fmt.Errorf("%w: media %s has no organisation", ErrEmbeddingInvalid, entry.MediaId.Hex())
+	}
+	if !entry.Format.Compatible(format) {
+		return embeddingKey{}, embeddingVector{}, fmt.Errorf("%w: media %s embedded with %s %s, index holds %s %s", ErrEmbeddingInvalid,
+			entry.MediaId.Hex(), entry.Format.ModelId, entry.Format.ModelVersion, format.ModelId, format.ModelVersion)
+	}
+	unit, err := format.unit(entry.Vector)
+	if err != nil {
+		return embeddingKey{}, embeddingVector{}, err
+	}
+	return embeddingKey{entry.OrganisationId, entry.ProjectId}, embeddingVector{entry.MediaId, unit}, nil
+}
+
+// prepareQuery checks a search and scales its query to unit length.
+func prepareQuery(format EmbeddingFormat, query []float32, scope EmbeddingScope, k int) ([]float32, error) {
+	if scope.OrganisationId == "" {
+		return nil, fmt.Errorf("%w: search without organisation", ErrEmbeddingInvalid)
+	}
+	if k < 0 {
+		return nil, fmt.Errorf("%w: k %d", ErrEmbeddingInvalid, k)
+	}
+	return format.unit(query)
+}
+
+// inScope reports whether entries under key are searched in scope.
+func (key embeddingKey) inScope(scope EmbeddingScope) bool {
+	return key.organisationId == scope.OrganisationId && (scope.ProjectId.IsZero() || key.projectId == scope.ProjectId)
+}
+
+// dot is the dot product of two vectors of the same length.
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// sortMatches orders matches by descending score, then media id, and keeps k.
+func sortMatches(matches []EmbeddingMatch, k int) []EmbeddingMatch {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].MediaId.Hex() < matches[j].MediaId.Hex()
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
+}
+
+// BruteForceIndex compares a query against every entry in scope. It is exact,
+// and fast enough for the few thousand recordings of a project.
+type BruteForceIndex struct {
+	format  EmbeddingFormat
+	entries map[embeddingKey][]embeddingVector
+	count   int
+}
+
+// NewBruteForceIndex returns an empty index for embeddings of format.
+func NewBruteForceIndex(format EmbeddingFormat) (*BruteForceIndex, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	return &BruteForceIndex{format: format, entries: map[embeddingKey][]embeddingVector{}}, nil
+}
+
+// Format is the format the index accepts.
+func (x *BruteForceIndex) Format() EmbeddingFormat {
+	return x.format
+}
+
+// Add indexes an entry.
+func (x *BruteForceIndex) Add(entry EmbeddingEntry) error {
+	key, vector, err := prepareEmbedding(x.format, entry)
+	if err != nil {
+		return err
+	}
+	x.entries[key] = append(x.entries[key], vector)
+	x.count++
+	return nil
+}
+
+// Search returns the k entries in scope most similar to query.
+func (x *BruteForceIndex) Search(query []float32, scope EmbeddingScope, k int) ([]EmbeddingMatch, error) {
+	unit, err := prepareQuery(x.format, query, scope, k)
+	if err != nil {
+		return nil, err
+	}
+	matches := []EmbeddingMatch{}
+	for key, vectors := range x.entries {
+		if !key.inScope(scope) {
+			continue
+		}
+		for _, v := range vectors {
+			matches = append(matches, EmbeddingMatch{MediaId: v.mediaId, Score: dot(unit, v.vector)})
+		}
+	}
+	return sortMatches(matches, k), nil
+}
+
+// Len is the number of indexed entries.
+func (x *BruteForceIndex) Len() int {
+	return x.count
+}
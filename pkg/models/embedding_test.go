@@ -0,0 +1,195 @@
+package models
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var testEmbeddingFormat = EmbeddingFormat{ModelId: "clip-vit-b32", ModelVersion: "1", Dimension: 16, Scale: DefaultEmbeddingScale}
+
+func randomVector(random *rand.Rand, dimension int) []float32 {
+	vector := make([]float32, dimension)
+	for i := range vector {
+		vector[i] = float32(random.NormFloat64())
+	}
+	return vector
+}
+
+func embeddedMedia(t *testing.T, organisationId string, projectId *primitive.ObjectID, vector []float32) Media {
+	t.Helper()
+	values, err := testEmbeddingFormat.Encode(vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := testEmbeddingFormat
+	return Media{
+		Id:             primitive.NewObjectID(),
+		OrganisationId: organisationId,
+		ProjectId:      projectId,
+		Metadata:       &MediaMetadata{Embedding: values, EmbeddingFormat: &format},
+	}
+}
+
+func TestEmbeddingEncodeDecode(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	vector := randomVector(random, 16)
+	values, err := testEmbeddingFormat.Encode(vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range values {
+		if v < -DefaultEmbeddingScale || v > DefaultEmbeddingScale {
+			t.Fatalf("value %d outside the int16 range", v)
+		}
+	}
+	decoded, err := testEmbeddingFormat.Decode(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unit, _ := testEmbeddingFormat.unit(vector)
+	if cosine := dot(unit, decoded); cosine < 0.9999 {
+		t.Errorf("cosine after round trip = %v", cosine)
+	}
+
+	tests := []struct {
+		name   string
+		format EmbeddingFormat
+		vector []float32
+	}{
+		{"no model", EmbeddingFormat{Dimension: 16, Scale: 1}, vector},
+		{"no scale", EmbeddingFormat{ModelId: "m", Dimension: 16}, vector},
+		{"wrong dimension", testEmbeddingFormat, vector[:8]},
+		{"zero vector", testEmbeddingFormat, make([]float32, 16)},
+	}
+	for _, tt := range tests {
+		if _, err := tt.format.Encode(tt.vector); !errors.Is(err, ErrEmbeddingInvalid) {
+			t.Errorf("%s: err = %v, want ErrEmbeddingInvalid", tt.name, err)
+		}
+	}
+	if _, err := testEmbeddingFormat.Decode(values[:3]); !errors.Is(err, ErrEmbeddingInvalid) {
+		t.Errorf("short decode: err = %v", err)
+	}
+}
+
+func TestEmbeddingIndexScope(t *testing.T) {
+	organisation := primitive.NewObjectID()
+	other := primitive.NewObjectID()
+	project := primitive.NewObjectID()
+	base := []float32{1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	near := []float32{1, 0.9, 0.1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	far := []float32{0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+	query := embeddedMedia(t, organisation.Hex(), nil, base)
+	media := []Media{
+		query,
+		embeddedMedia(t, organisation.Hex(), nil, far),
+		embeddedMedia(t, organisation.Hex(), &organisation, near),
+		embeddedMedia(t, organisation.Hex(), &project, base),
+		embeddedMedia(t, other.Hex(), nil, base),
+	}
+
+	hnsw, err := NewHNSWIndex(testEmbeddingFormat, HNSWOptions{Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	brute, _ := NewBruteForceIndex(testEmbeddingFormat)
+	for _, index := range []EmbeddingIndex{brute, hnsw} {
+		for _, m := range media {
+			entry, err := EmbeddingEntryFromMedia(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := index.Add(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if index.Len() != len(media) {
+			t.Errorf("%T: len = %d", index, index.Len())
+		}
+
+		// Unstamped media belong to the organisation's default project, so the
+		// query finds the explicitly stamped default media but not the other
+		// project's copy of itself.
+		similar, err := SimilarMedia(index, query, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(similar) != 2 || similar[0].MediaId != media[2].Id || similar[1].MediaId != media[1].Id {
+			t.Errorf("%T: similar = %+v", index, similar)
+		} else if similar[0].Score < 0.99 || similar[1].Score > 0.01 {
+			t.Errorf("%T: scores = %+v", index, similar)
+		}
+
+		matches, err := index.Search(base, EmbeddingScope{OrganisationId: organisation.Hex()}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 4 {
+			t.Errorf("%T: organisation-wide matches = %+v", index, matches)
+		}
+
+		if _, err := index.Search(base, EmbeddingScope{}, 1); !errors.Is(err, ErrEmbeddingInvalid) {
+			t.Errorf("%T: search without organisation: err = %v", index, err)
+		}
+		entry, _ := EmbeddingEntryFromMedia(query)
+		entry.Format.ModelVersion = "2"
+		if err := index.Add(entry); !errors.Is(err, ErrEmbeddingInvalid) {
+			t.Errorf("%T: add of another model version: err = %v", index, err)
+		}
+	}
+
+	if _, err := EmbeddingEntryFromMedia(Media{}); !errors.Is(err, ErrEmbeddingInvalid) {
+		t.Errorf("media without embedding: err = %v", err)
+	}
+	if _, err := NewHNSWIndex(testEmbeddingFormat, HNSWOptions{M: 1}); !errors.Is(err, ErrEmbeddingInvalid) {
+		t.Errorf("hnsw m 1: err = %v", err)
+	}
+}
+
+func TestHNSWIndexRecall(t *testing.T) {
+	random := rand.New(rand.NewSource(7))
+	scope := EmbeddingScope{OrganisationId: primitive.NewObjectID().Hex()}
+	brute, _ := NewBruteForceIndex(testEmbeddingFormat)
+	hnsw, err := NewHNSWIndex(testEmbeddingFormat, HNSWOptions{M: 8, EfConstruction: 64, EfSearch: 32, Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		entry := EmbeddingEntry{MediaId: primitive.NewObjectID(), OrganisationId: scope.OrganisationId, Format: testEmbeddingFormat, Vector: randomVector(random, 16)}
+		if err := brute.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+		if err := hnsw.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const queries, k = 50, 10
+	found := 0
+	for i := 0; i < queries; i++ {
+		query := randomVector(random, 16)
+		exact, _ := brute.Search(query, scope, k)
+		approximate, err := hnsw.Search(query, scope, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(approximate) != k {
+			t.Fatalf("hnsw returned %d matches", len(approximate))
+		}
+		want := map[primitive.ObjectID]bool{}
+		for _, match := range exact {
+			want[match.MediaId] = true
+		}
+		for _, match := range approximate {
+			if want[match.MediaId] {
+				found++
+			}
+		}
+	}
+	if recall := float64(found) / (queries * k); recall < 0.9 {
+		t.Errorf("recall@%d = %v, want at least 0.9", k, recall)
+	}
+}
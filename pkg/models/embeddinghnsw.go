@@ -0,0 +1,245 @@
+package models
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Default HNSW parameters. They favour recall over memory, which suits
+// per-project graphs of up to a few hundred thousand recordings.
+const (
+	DefaultHNSWM              = 16
+	DefaultHNSWEfConstruction = 200
+	DefaultHNSWEfSearch       = 64
+)
+
+// HNSWOptions tunes an HNSWIndex. Zero values use the defaults.
+type HNSWOptions struct {
+	// M is the number of links a node keeps per layer; twice as many on the
+	// bottom layer.
+	M int `json:"m,omitempty" bson:"m,omitempty"`
+	// EfConstruction and EfSearch are the candidate list sizes while inserting
+	// and searching. Larger is slower and more accurate.
+	EfConstruction int `json:"efConstruction,omitempty" bson:"efConstruction,omitempty"`
+	EfSearch       int `json:"efSearch,omitempty" bson:"efSearch,omitempty"`
+	// Seed fixes the layer assignment, so the same entries added in the same
+	// order build the same graph.
+	Seed int64 `json:"seed,omitempty" bson:"seed,omitempty"`
+}
+
+// HNSWIndex is an approximate index: a hierarchical navigable small world
+// graph per organisation and project. Searches visit a small part of each
+// graph in scope, at the cost of occasionally missing a close match.
+type HNSWIndex struct {
+	format  EmbeddingFormat
+	options HNSWOptions
+	graphs  map[embeddingKey]*hnswGraph
+	random  *rand.Rand
+	count   int
+}
+
+type hnswGraph struct {
+	nodes []hnswNode
+	entry int
+	top   int
+}
+
+type hnswNode struct {
+	embeddingVector
+	// links holds the neighbours of the node on each of its layers.
+	links [][]int
+}
+
+type hnswCandidate struct {
+	node  int
+	score float64
+}
+
+// NewHNSWIndex returns an empty index for embeddings of format.
+func NewHNSWIndex(format EmbeddingFormat, options HNSWOptions) (*HNSWIndex, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	if options.M == 0 {
+		options.M = DefaultHNSWM
+	}
+	if options.EfConstruction == 0 {
+		options.EfConstruction = DefaultHNSWEfConstruction
+	}
+	if options.EfSearch == 0 {
+		options.EfSearch = DefaultHNSWEfSearch
+	}
+	if options.M < 2 || options.EfConstruction < 1 || options.EfSearch < 1 {
+		return nil, fmt.Errorf("%w: hnsw m %d, efConstruction %d, efSearch %d", ErrEmbeddingInvalid, options.M, options.EfConstruction, options.EfSearch)
+	}
+	return &HNSWIndex{
+		format:  format,
+		options: options,
+		graphs:  map[embeddingKey]*hnswGraph{},
+		random:  rand.New(rand.NewSource(options.Seed)),
+	}, nil
+}
+
+// Format is the format the index accepts.
+func (x *HNSWIndex) Format() EmbeddingFormat {
+	return x.format
+}
+
+// Add indexes an entry.
+func (x *HNSWIndex) Add(entry EmbeddingEntry) error {
+	key, vector, err := prepareEmbedding(x.format, entry)
+	if err != nil {
+		return err
+	}
+	graph, ok := x.graphs[key]
+	if !ok {
+		graph = &hnswGraph{}
+		x.graphs[key] = graph
+	}
+	// Layers thin out geometrically: a node reaches layer l with probability
+	// M^-l.
+	level := int(math.Floor(-math.Log(1-x.random.Float64()) / math.Log(float64(x.options.M))))
+	graph.insert(vector, level, x.options)
+	x.count++
+	return nil
+}
+
+// Search returns about the k entries in scope most similar to query.
+func (x *HNSWIndex) Search(query []float32, scope EmbeddingScope, k int) ([]EmbeddingMatch, error) {
+	unit, err := prepareQuery(x.format, query, scope, k)
+	if err != nil {
+		return nil, err
+	}
+	matches := []EmbeddingMatch{}
+	if k == 0 {
+		return matches, nil
+	}
+	for key, graph := range x.graphs {
+		if !key.inScope(scope) {
+			continue
+		}
+		for _, candidate := range graph.search(unit, max(x.options.EfSearch, k)) {
+			matches = append(matches, EmbeddingMatch{MediaId: graph.nodes[candidate.node].mediaId, Score: candidate.score})
+		}
+	}
+	return sortMatches(matches, k), nil
+}
+
+// Len is the number of indexed entries.
+func (x *HNSWIndex) Len() int {
+	return x.count
+}
+
+// insert links a new node into every layer up to level.
+func (g *hnswGraph) insert(vector embeddingVector, level int, options HNSWOptions) {
+	id := len(g.nodes)
+	g.nodes = append(g.nodes, hnswNode{embeddingVector: vector, links: make([][]int, level+1)})
+	if id == 0 {
+		g.entry, g.top = id, level
+		return
+	}
+
+	entry := g.entry
+	for l := g.top; l > level; l-- {
+		entry = g.searchLayer(vector.vector, entry, 1, l)[0].node
+	}
+	for l := min(level, g.top); l >= 0; l-- {
+		candidates := g.searchLayer(vector.vector, entry, options.EfConstruction, l)
+		limit := options.M
+		if l == 0 {
+			limit = 2 * options.M
+		}
+		for _, candidate := range candidates[:min(options.M, len(candidates))] {
+			g.nodes[id].links[l] = append(g.nodes[id].links[l], candidate.node)
+			g.nodes[candidate.node].links[l] = append(g.nodes[candidate.node].links[l], id)
+			if len(g.nodes[candidate.node].links[l]) > limit {
+				g.prune(candidate.node, l, limit)
+			}
+		}
+		entry = candidates[0].node
+	}
+	if level > g.top {
+		g.entry, g.top = id, level
+	}
+}
+
+// prune keeps the limit links of a node on a layer that are most similar to it.
+func (g *hnswGraph) prune(node, layer, limit int) {
+	links := g.nodes[node].links[layer]
+	scored := make([]hnswCandidate, len(links))
+	for i, link := range links {
+		scored[i] = hnswCandidate{link, dot(g.nodes[node].vector, g.nodes[link].vector)}
+	}
+	sort.Slice(scored, func(i, j int) bool { return scored[i].score > scored[j].score })
+	kept := make([]int, limit)
+	for i := range kept {
+		kept[i] = scored[i].node
+	}
+	g.nodes[node].links[layer] = kept
+}
+
+// search descends greedily to the bottom layer and returns the ef best nodes
+// found there.
+func (g *hnswGraph) search(query []float32, ef int) []hnswCandidate {
+	entry := g.entry
+	for l := g.top; l > 0; l-- {
+		entry = g.searchLayer(query, entry, 1, l)[0].node
+	}
+	return g.searchLayer(query, entry, ef, 0)
+}
+
+// searchLayer walks one layer from entry, keeping the ef most similar nodes
+// seen, and returns them most similar first.
+func (g *hnswGraph) searchLayer(query []float32, entry, ef, layer int) []hnswCandidate {
+	start := hnswCandidate{entry, dot(query, g.nodes[entry].vector)}
+	visited := map[int]bool{entry: true}
+	candidates := &hnswHeap{nearest: true, items: []hnswCandidate{start}}
+	results := &hnswHeap{items: []hnswCandidate{start}}
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && current.score < results.items[0].score {
+			break
+		}
+		for _, link := range g.nodes[current.node].links[layer] {
+			if visited[link] {
+				continue
+			}
+			visited[link] = true
+			next := hnswCandidate{link, dot(query, g.nodes[link].vector)}
+			if results.Len() < ef || next.score > results.items[0].score {
+				heap.Push(candidates, next)
+				heap.Push(results, next)
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	sort.Slice(results.items, func(i, j int) bool { return results.items[i].score > results.items[j].score })
+	return results.items
+}
+
+// hnswHeap pops the most similar candidate when nearest is set, and the least
+// similar otherwise.
+type hnswHeap struct {
+	items   []hnswCandidate
+	nearest bool
+}
+
+func (h hnswHeap) Len() int { return len(h.items) }
+func (h hnswHeap) Less(i, j int) bool {
+	if h.nearest {
+		return h.items[i].score > h.items[j].score
+	}
+	return h.items[i].score < h.items[j].score
+}
+func (h hnswHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *hnswHeap) Push(x any)   { h.items = append(h.items, x.(hnswCandidate)) }
+func (h *hnswHeap) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -1,6 +1,256 @@
 package models
 
+import "math"
+
+// The models describe shapes in a few forms: a Point; a Region's RegionPoints,
+// in pixels of the region's Width x Height; a MarkerBox, normalised x, y,
+// width and height; a TrackBox, normalised corners; and a ClassifyDetails box,
+// x, y, w and h in pixels of FrameWidth x FrameHeight. The functions below
+// convert between them and hold the shared geometry. Image coordinates are
+// used throughout: the origin is the top-left corner and y points down.
+
 type Point struct {
 	X float64 `json:"x" bson:"x"`
 	Y float64 `json:"y" bson:"y"`
 }
+
+// ToPixels scales a normalised point to a width x height frame.
+func (p Point) ToPixels(width, height float64) Point {
+	return Point{X: p.X * width, Y: p.Y * height}
+}
+
+// ToNormalized scales a point in a width x height frame to [0, 1].
+func (p Point) ToNormalized(width, height float64) Point {
+	return Point{X: p.X / width, Y: p.Y / height}
+}
+
+// Rotate turns a normalised point clockwise by degrees, a multiple of 90,
+// about the centre of the frame. Other angles leave it unchanged.
+func (p Point) Rotate(degrees int) Point {
+	switch floorMod(int64(degrees), 360) {
+	case 90:
+		return Point{X: 1 - p.Y, Y: p.X}
+	case 180:
+		return Point{X: 1 - p.X, Y: 1 - p.Y}
+	case 270:
+		return Point{X: p.Y, Y: 1 - p.X}
+	}
+	return p
+}
+
+// RotatedSize is the size of a width x height frame turned by degrees.
+func RotatedSize(width, height, degrees int) (int, int) {
+	if floorMod(int64(degrees), 180) == 90 {
+		return height, width
+	}
+	return width, height
+}
+
+// Scaled returns the region's points in a width x height frame. A region
+// without its own size is taken to be drawn at that size already.
+func (r Region) Scaled(width, height float64) []Point {
+	sx, sy := 1.0, 1.0
+	if r.Width > 0 && r.Height > 0 && width > 0 && height > 0 {
+		sx, sy = width/float64(r.Width), height/float64(r.Height)
+	}
+	points := make([]Point, len(r.RegionPoints))
+	for i, p := range r.RegionPoints {
+		points[i] = Point{X: p.X * sx, Y: p.Y * sy}
+	}
+	return points
+}
+
+// Normalized returns the region's points in [0, 1]; Scaled(1, 1).
+func (r Region) Normalized() []Point {
+	return r.Scaled(1, 1)
+}
+
+// TrackBox returns the marker box as corners. Flags and provenance are empty.
+func (b MarkerBox) TrackBox() TrackBox {
+	return TrackBox{X1: b.X, Y1: b.Y, X2: b.X + b.Width, Y2: b.Y + b.Height}
+}
+
+// MarkerBox returns the box as a position and size.
+func (b TrackBox) MarkerBox() MarkerBox {
+	return MarkerBox{X: b.X1, Y: b.Y1, Width: b.X2 - b.X1, Height: b.Y2 - b.Y1}
+}
+
+// TrackBox returns the classified box normalised against its frame, labelled
+// with its class. Without a frame size the box is returned in pixels.
+func (d ClassifyDetails) TrackBox() TrackBox {
+	box := TrackBox{X1: d.X, Y1: d.Y, X2: d.X + d.W, Y2: d.Y + d.H, TrackId: d.Id, Label: d.Classified}
+	if d.FrameWidth > 0 && d.FrameHeight > 0 {
+		box = box.ToNormalized(d.FrameWidth, d.FrameHeight)
+	}
+	return box
+}
+
+// ToPixels scales a normalised box to a width x height frame.
+func (b TrackBox) ToPixels(width, height float64) TrackBox {
+	b.X1, b.X2 = b.X1*width, b.X2*width
+	b.Y1, b.Y2 = b.Y1*height, b.Y2*height
+	return b
+}
+
+// ToNormalized scales a box in a width x height frame to [0, 1].
+func (b TrackBox) ToNormalized(width, height float64) TrackBox {
+	b.X1, b.X2 = b.X1/width, b.X2/width
+	b.Y1, b.Y2 = b.Y1/height, b.Y2/height
+	return b
+}
+
+// Rotate turns a normalised box clockwise by degrees, a multiple of 90, about
+// the centre of the frame. Other angles leave it unchanged.
+func (b TrackBox) Rotate(degrees int) TrackBox {
+	p1, p2 := Point{X: b.X1, Y: b.Y1}.Rotate(degrees), Point{X: b.X2, Y: b.Y2}.Rotate(degrees)
+	b.X1, b.X2 = math.Min(p1.X, p2.X), math.Max(p1.X, p2.X)
+	b.Y1, b.Y2 = math.Min(p1.Y, p2.Y), math.Max(p1.Y, p2.Y)
+	return b
+}
+
+// Center is the middle of the box.
+func (b TrackBox) Center() Point {
+	return Point{X: (b.X1 + b.X2) / 2, Y: (b.Y1 + b.Y2) / 2}
+}
+
+// Area is zero for a box without width or height.
+func (b TrackBox) Area() float64 {
+	return math.Max(b.X2-b.X1, 0) * math.Max(b.Y2-b.Y1, 0)
+}
+
+// Polygon returns the box's corners clockwise from the top left.
+func (b TrackBox) Polygon() []Point {
+	return []Point{{X: b.X1, Y: b.Y1}, {X: b.X2, Y: b.Y1}, {X: b.X2, Y: b.Y2}, {X: b.X1, Y: b.Y2}}
+}
+
+// Intersection is the overlap of two boxes; false when they do not overlap.
+func (b TrackBox) Intersection(o TrackBox) (TrackBox, bool) {
+	overlap := TrackBox{X1: math.Max(b.X1, o.X1), Y1: math.Max(b.Y1, o.Y1), X2: math.Min(b.X2, o.X2), Y2: math.Min(b.Y2, o.Y2)}
+	return overlap, overlap.X2 > overlap.X1 && overlap.Y2 > overlap.Y1
+}
+
+// IoU is the intersection over union of two boxes; zero when either has no
+// area.
+func (b TrackBox) IoU(o TrackBox) float64 {
+	overlap, ok := b.Intersection(o)
+	if !ok {
+		return 0
+	}
+	intersection := overlap.Area()
+	return intersection / (b.Area() + o.Area() - intersection)
+}
+
+// SideOfLine is positive when p lies to the right of the line from a to b,
+// as seen walking from a to b, negative to its left and zero on it. It is the
+// cross product (b - a) x (p - a).
+func SideOfLine(a, b, p Point) float64 {
+	return (b.X-a.X)*(p.Y-a.Y) - (b.Y-a.Y)*(p.X-a.X)
+}
+
+// SegmentsIntersect reports whether segments ab and pq share a point,
+// touching included.
+func SegmentsIntersect(a, b, p, q Point) bool {
+	d1, d2 := SideOfLine(p, q, a), SideOfLine(p, q, b)
+	d3, d4 := SideOfLine(a, b, p), SideOfLine(a, b, q)
+	if (d1 > 0 && d2 < 0 || d1 < 0 && d2 > 0) && (d3 > 0 && d4 < 0 || d3 < 0 && d4 > 0) {
+		return true
+	}
+	within := func(a, b, p Point) bool {
+		return math.Min(a.X, b.X) <= p.X && p.X <= math.Max(a.X, b.X) && math.Min(a.Y, b.Y) <= p.Y && p.Y <= math.Max(a.Y, b.Y)
+	}
+	return d1 == 0 && within(p, q, a) || d2 == 0 && within(p, q, b) ||
+		d3 == 0 && within(a, b, p) || d4 == 0 && within(a, b, q)
+}
+
+// SegmentIntersection returns the point where segments ab and pq cross; false
+// when they do not, or when they are parallel.
+func SegmentIntersection(a, b, p, q Point) (Point, bool) {
+	denominator := (b.X-a.X)*(q.Y-p.Y) - (b.Y-a.Y)*(q.X-p.X)
+	if denominator == 0 || !SegmentsIntersect(a, b, p, q) {
+		return Point{}, false
+	}
+	t := ((p.X-a.X)*(q.Y-p.Y) - (p.Y-a.Y)*(q.X-p.X)) / denominator
+	return Point{X: a.X + t*(b.X-a.X), Y: a.Y + t*(b.Y-a.Y)}, true
+}
+
+// PointInPolygon uses the even-odd rule; a point on the boundary may fall on
+// either side.
+func PointInPolygon(polygon []Point, p Point) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// PolygonArea is the area enclosed by a simple polygon, in either winding.
+func PolygonArea(polygon []Point) float64 {
+	var twice float64
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		twice += polygon[j].X*polygon[i].Y - polygon[i].X*polygon[j].Y
+	}
+	return math.Abs(twice) / 2
+}
+
+// ClipPolygon returns the part of a polygon inside a box (Sutherland-Hodgman);
+// empty when they do not overlap.
+func ClipPolygon(polygon []Point, box TrackBox) []Point {
+	edges := []struct {
+		inside func(Point) bool
+		cross  func(a, b Point) Point
+	}{
+		{func(p Point) bool { return p.X >= box.X1 }, func(a, b Point) Point { return atX(a, b, box.X1) }},
+		{func(p Point) bool { return p.X <= box.X2 }, func(a, b Point) Point { return atX(a, b, box.X2) }},
+		{func(p Point) bool { return p.Y >= box.Y1 }, func(a, b Point) Point { return atY(a, b, box.Y1) }},
+		{func(p Point) bool { return p.Y <= box.Y2 }, func(a, b Point) Point { return atY(a, b, box.Y2) }},
+	}
+	clipped := polygon
+	for _, edge := range edges {
+		input := clipped
+		clipped = nil
+		for i, current := range input {
+			previous := input[(i+len(input)-1)%len(input)]
+			switch {
+			case edge.inside(current):
+				if !edge.inside(previous) {
+					clipped = append(clipped, edge.cross(previous, current))
+				}
+				clipped = append(clipped, current)
+			case edge.inside(previous):
+				clipped = append(clipped, edge.cross(previous, current))
+			}
+		}
+		if len(clipped) == 0 {
+			return []Point{}
+		}
+	}
+	return clipped
+}
+
+// PolygonBoxIntersection is the area a polygon and a box share.
+func PolygonBoxIntersection(polygon []Point, box TrackBox) float64 {
+	return PolygonArea(ClipPolygon(polygon, box))
+}
+
+// PolygonBoxIoU is the intersection over union of a polygon and a box; zero
+// when they do not overlap.
+func PolygonBoxIoU(polygon []Point, box TrackBox) float64 {
+	intersection := PolygonBoxIntersection(polygon, box)
+	if intersection == 0 {
+		return 0
+	}
+	return intersection / (PolygonArea(polygon) + box.Area() - intersection)
+}
+
+// atX is where segment ab meets the vertical line at x.
+func atX(a, b Point, x float64) Point {
+	return Point{X: x, Y: a.Y + (b.Y-a.Y)*(x-a.X)/(b.X-a.X)}
+}
+
+// atY is where segment ab meets the horizontal line at y.
+func atY(a, b Point, y float64) Point {
+	return Point{X: a.X + (b.X-a.X)*(y-a.Y)/(b.Y-a.Y), Y: y}
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBoxConversions(t *testing.T) {
+	marker := MarkerBox{X: 0.1, Y: 0.2, Width: 0.3, Height: 0.4}
+	box := marker.TrackBox()
+	if !near(box.X2, 0.4) || !near(box.Y2, 0.6) {
+		t.Errorf("track box = %+v", box)
+	}
+	if back := box.MarkerBox(); !near(back.Width, 0.3) || !near(back.Height, 0.4) || back.X != 0.1 {
+		t.Errorf("marker box = %+v", back)
+	}
+
+	details := ClassifyDetails{Id: "3", Classified: "car", X: 64, Y: 36, W: 128, H: 72, FrameWidth: 640, FrameHeight: 360}
+	got := details.TrackBox()
+	want := TrackBox{X1: 0.1, Y1: 0.1, X2: 0.3, Y2: 0.3, TrackId: "3", Label: "car"}
+	if !near(got.X1, want.X1) || !near(got.Y2, want.Y2) || got.TrackId != "3" || got.Label != "car" {
+		t.Errorf("classified box = %+v, want %+v", got, want)
+	}
+	if pixels := got.ToPixels(640, 360); !near(pixels.X2, 192) || !near(pixels.Y1, 36) {
+		t.Errorf("pixels = %+v", pixels)
+	}
+
+	region := Region{Width: 1280, Height: 720, RegionPoints: []Point{{X: 640, Y: 360}, {X: 1280, Y: 0}}}
+	if points := region.Scaled(640, 360); !reflect.DeepEqual(points, []Point{{X: 320, Y: 180}, {X: 640, Y: 0}}) {
+		t.Errorf("scaled = %v", points)
+	}
+	if points := region.Normalized(); !reflect.DeepEqual(points, []Point{{X: 0.5, Y: 0.5}, {X: 1, Y: 0}}) {
+		t.Errorf("normalized = %v", points)
+	}
+	if p := (Point{X: 320, Y: 90}).ToNormalized(640, 360); p != (Point{X: 0.5, Y: 0.25}) {
+		t.Errorf("point = %v", p)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	box := TrackBox{X1: 0.1, Y1: 0.2, X2: 0.4, Y2: 0.3, Edited: true}
+	tests := []struct {
+		degrees int
+		want    TrackBox
+	}{
+		{0, box},
+		{90, TrackBox{X1: 0.7, Y1: 0.1, X2: 0.8, Y2: 0.4, Edited: true}},
+		{180, TrackBox{X1: 0.6, Y1: 0.7, X2: 0.9, Y2: 0.8, Edited: true}},
+		{-90, TrackBox{X1: 0.2, Y1: 0.6, X2: 0.3, Y2: 0.9, Edited: true}},
+		{45, box},
+	}
+	for _, tt := range tests {
+		got := box.Rotate(tt.degrees)
+		if !near(got.X1, tt.want.X1) || !near(got.Y1, tt.want.Y1) || !near(got.X2, tt.want.X2) || !near(got.Y2, tt.want.Y2) || !got.Edited {
+			t.Errorf("rotate %d = %+v, want %+v", tt.degrees, got, tt.want)
+		}
+		if back := got.Rotate(-tt.degrees); !near(back.X1, box.X1) || !near(back.Y2, box.Y2) {
+			t.Errorf("rotate %d and back = %+v", tt.degrees, back)
+		}
+	}
+	if w, h := RotatedSize(1920, 1080, 270); w != 1080 || h != 1920 {
+		t.Errorf("rotated size = %dx%d", w, h)
+	}
+	if w, h := RotatedSize(1920, 1080, -180); w != 1920 || h != 1080 {
+		t.Errorf("rotated size = %dx%d", w, h)
+	}
+}
+
+func TestBoxOverlap(t *testing.T) {
+	a := TrackBox{X1: 0, Y1: 0, X2: 0.4, Y2: 0.4}
+	b := TrackBox{X1: 0.2, Y1: 0.2, X2: 0.6, Y2: 0.6}
+	if got := a.IoU(b); !near(got, 0.04/0.28) {
+		t.Errorf("IoU = %v", got)
+	}
+	if got := a.IoU(TrackBox{X1: 0.4, Y1: 0, X2: 0.5, Y2: 0.4}); got != 0 {
+		t.Errorf("touching IoU = %v", got)
+	}
+	if _, ok := a.Intersection(TrackBox{X1: 0.5, Y1: 0.5, X2: 1, Y2: 1}); ok {
+		t.Error("disjoint boxes intersect")
+	}
+	if c := b.Center(); !near(c.X, 0.4) || !near(c.Y, 0.4) {
+		t.Errorf("center = %v", c)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	a, b := Point{X: 0, Y: 0}, Point{X: 10, Y: 0}
+	if side := SideOfLine(a, b, Point{X: 5, Y: 3}); side <= 0 {
+		t.Errorf("point below a left-to-right line: side = %v, want right (positive)", side)
+	}
+	if side := SideOfLine(a, b, Point{X: 5, Y: -3}); side >= 0 {
+		t.Errorf("point above: side = %v, want left (negative)", side)
+	}
+	tests := []struct {
+		p, q    Point
+		crosses bool
+		at      Point
+	}{
+		{Point{X: 5, Y: -5}, Point{X: 5, Y: 5}, true, Point{X: 5, Y: 0}},
+		{Point{X: 10, Y: 0}, Point{X: 12, Y: 3}, true, Point{X: 10, Y: 0}},
+		{Point{X: 11, Y: -5}, Point{X: 11, Y: 5}, false, Point{}},
+		{Point{X: 0, Y: 1}, Point{X: 10, Y: 1}, false, Point{}},
+	}
+	for _, tt := range tests {
+		if got := SegmentsIntersect(a, b, tt.p, tt.q); got != tt.crosses {
+			t.Errorf("%v-%v: intersects = %v", tt.p, tt.q, got)
+		}
+		if at, ok := SegmentIntersection(a, b, tt.p, tt.q); ok != tt.crosses || at != tt.at {
+			t.Errorf("%v-%v: intersection = %v, %v", tt.p, tt.q, at, ok)
+		}
+	}
+}
+
+func TestPolygons(t *testing.T) {
+	// An L shape: the unit square without its top-right quarter.
+	shape := []Point{{X: 0, Y: 0}, {X: 0.5, Y: 0}, {X: 0.5, Y: 0.5}, {X: 1, Y: 0.5}, {X: 1, Y: 1}, {X: 0, Y: 1}}
+	if area := PolygonArea(shape); !near(area, 0.75) {
+		t.Errorf("area = %v", area)
+	}
+	for _, tt := range []struct {
+		p      Point
+		inside bool
+	}{
+		{Point{X: 0.25, Y: 0.25}, true},
+		{Point{X: 0.75, Y: 0.25}, false},
+		{Point{X: 0.75, Y: 0.75}, true},
+		{Point{X: 1.5, Y: 0.75}, false},
+	} {
+		if got := PointInPolygon(shape, tt.p); got != tt.inside {
+			t.Errorf("%v inside = %v", tt.p, got)
+		}
+	}
+
+	box := TrackBox{X1: 0.25, Y1: 0.25, X2: 0.75, Y2: 0.75}
+	// The box loses the quarter of it that lies in the missing corner.
+	if got := PolygonBoxIntersection(shape, box); !near(got, 0.1875) {
+		t.Errorf("intersection = %v", got)
+	}
+	if got := PolygonBoxIoU(shape, box); !near(got, 0.1875/(0.75+0.25-0.1875)) {
+		t.Errorf("IoU = %v", got)
+	}
+	if got := PolygonBoxIoU(shape, TrackBox{X1: 2, Y1: 2, X2: 3, Y2: 3}); got != 0 {
+		t.Errorf("disjoint IoU = %v", got)
+	}
+	if got := ClipPolygon(shape, TrackBox{X1: 0.6, Y1: 0, X2: 1, Y2: 0.4}); len(got) != 0 {
+		t.Errorf("clipped to the missing corner = %v", got)
+	}
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A HeatmapGrid is a density map of where objects were seen, binned into a
+// fixed Columns x Rows grid over the frame. Samples come from media
+// classifications, stored Heatmaps or trajectories, each in pixels of its own
+// frame; they are normalised before binning, so recordings of different
+// resolutions add up on the same grid. Grids for an hour or a day can be
+// merged into longer periods.
+
+// ErrHeatmapInvalidOptions is returned for an empty grid, a negative half
+// life, or grids that cannot be merged.
+var ErrHeatmapInvalidOptions = errors.New("invalid heatmap options")
+
+// HeatmapSample is one sighting, in pixels of a Width x Height frame.
+type HeatmapSample struct {
+	X         float64 `json:"x" bson:"x"`
+	Y         float64 `json:"y" bson:"y"`
+	Width     float64 `json:"width" bson:"width"`
+	Height    float64 `json:"height" bson:"height"`
+	Class     string  `json:"class,omitempty" bson:"class,omitempty"`
+	Timestamp int64   `json:"timestamp" bson:"timestamp"` // epoch seconds
+}
+
+// HeatmapOptions sets the grid and which samples go into it.
+type HeatmapOptions struct {
+	Columns int `json:"columns" bson:"columns"`
+	Rows    int `json:"rows" bson:"rows"`
+	// Classes keeps only samples of these classes; empty keeps all.
+	Classes []string `json:"classes,omitempty" bson:"classes,omitempty"`
+	// HalfLife, in seconds, weighs a sample by 0.5^((Reference - Timestamp) /
+	// HalfLife), so recent activity stands out. Zero weighs every sample 1.
+	HalfLife float64 `json:"halfLife,omitempty" bson:"halfLife,omitempty"`
+	// Reference is the moment decay is measured from, in epoch seconds. Zero
+	// uses the latest sample.
+	Reference int64 `json:"reference,omitempty" bson:"reference,omitempty"`
+}
+
+// HeatmapGrid is the binned density. Cells run row by row from the top-left.
+type HeatmapGrid struct {
+	Columns int       `json:"columns" bson:"columns"`
+	Rows    int       `json:"rows" bson:"rows"`
+	Cells   []float64 `json:"cells" bson:"cells"`
+	// Samples is the number of samples binned.
+	Samples int64 `json:"samples" bson:"samples"`
+	// Start and End are the first and last sample timestamps, epoch seconds.
+	Start int64 `json:"start,omitempty" bson:"start,omitempty"`
+	End   int64 `json:"end,omitempty" bson:"end,omitempty"`
+	// Classes, HalfLife and Reference record the options the grid was built
+	// with.
+	Classes   []string `json:"classes,omitempty" bson:"classes,omitempty"`
+	HalfLife  float64  `json:"halfLife,omitempty" bson:"halfLife,omitempty"`
+	Reference int64    `json:"reference,omitempty" bson:"reference,omitempty"`
+}
+
+// HeatmapSamplesFromMedia reads the per-class centroids of a media's
+// classifications, in the frame size of its metadata, stamped with its start.
+func HeatmapSamplesFromMedia(media Media) []HeatmapSample {
+	samples := []HeatmapSample{}
+	if media.Metadata == nil {
+		return samples
+	}
+	width, height := float64(media.Metadata.Width), float64(media.Metadata.Height)
+	for _, classification := range media.Metadata.Classifications {
+		for _, centroid := range classification.Centroids {
+			samples = append(samples, HeatmapSample{
+				X: centroid[0], Y: centroid[1], Width: width, Height: height,
+				Class: classification.Key, Timestamp: media.StartTimestamp,
+			})
+		}
+	}
+	return samples
+}
+
+// HeatmapSamplesFromHeatmap reads the [x, y] coordinates of a stored Heatmap.
+// They carry no class.
+func HeatmapSamplesFromHeatmap(heatmap Heatmap) []HeatmapSample {
+	samples := []HeatmapSample{}
+	for _, coordinate := range heatmap.Coordinates {
+		if len(coordinate) < 2 {
+			continue
+		}
+		samples = append(samples, HeatmapSample{
+			X: float64(coordinate[0]), Y: float64(coordinate[1]),
+			Width: float64(heatmap.FrameWidth), Height: float64(heatmap.FrameHeight),
+			Timestamp: heatmap.Timestamp,
+		})
+	}
+	return samples
+}
+
+// HeatmapSamplesFromTrajectory reads every point of a trajectory; start is the
+// recording start its point times count from, in epoch seconds.
+func HeatmapSamplesFromTrajectory(trajectory CountingTrajectory, start int64) []HeatmapSample {
+	samples := make([]HeatmapSample, len(trajectory.Points))
+	for i, point := range trajectory.Points {
+		samples[i] = HeatmapSample{
+			X: point.X, Y: point.Y, Width: trajectory.Width, Height: trajectory.Height,
+			Class: trajectory.ObjectName, Timestamp: start + int64(math.Floor(point.Time)),
+		}
+	}
+	return samples
+}
+
+// BuildHeatmap bins samples into a grid. Samples without a frame size, or
+// outside their frame, are skipped.
+func BuildHeatmap(samples []HeatmapSample, options HeatmapOptions) (HeatmapGrid, error) {
+	if options.Columns <= 0 || options.Rows <= 0 {
+		return HeatmapGrid{}, fmt.Errorf("%w: grid %dx%d", ErrHeatmapInvalidOptions, options.Columns, options.Rows)
+	}
+	if options.HalfLife < 0 || math.IsNaN(options.HalfLife) {
+		return HeatmapGrid{}, fmt.Errorf("%w: halfLife %v", ErrHeatmapInvalidOptions, options.HalfLife)
+	}
+	grid := HeatmapGrid{
+		Columns:  options.Columns,
+		Rows:     options.Rows,
+		Cells:    make([]float64, options.Columns*options.Rows),
+		Classes:  append([]string(nil), options.Classes...),
+		HalfLife: options.HalfLife,
+	}
+
+	type binned struct {
+		cell      int
+		timestamp int64
+	}
+	var kept []binned
+	for _, sample := range samples {
+		if len(options.Classes) > 0 && !containsString(options.Classes, sample.Class) {
+			continue
+		}
+		if sample.Width <= 0 || sample.Height <= 0 {
+			continue
+		}
+		p := Point{X: sample.X, Y: sample.Y}.ToNormalized(sample.Width, sample.Height)
+		if !(p.X >= 0 && p.X <= 1 && p.Y >= 0 && p.Y <= 1) {
+			continue
+		}
+		column := min(int(p.X*float64(grid.Columns)), grid.Columns-1)
+		row := min(int(p.Y*float64(grid.Rows)), grid.Rows-1)
+		kept = append(kept, binned{row*grid.Columns + column, sample.Timestamp})
+		if grid.Samples == 0 || sample.Timestamp < grid.Start {
+			grid.Start = sample.Timestamp
+		}
+		if grid.Samples == 0 || sample.Timestamp > grid.End {
+			grid.End = sample.Timestamp
+		}
+		grid.Samples++
+	}
+
+	if grid.HalfLife > 0 {
+		grid.Reference = options.Reference
+		if grid.Reference == 0 {
+			grid.Reference = grid.End
+		}
+	}
+	for _, sample := range kept {
+		grid.Cells[sample.cell] += grid.weight(sample.timestamp)
+	}
+	return grid, nil
+}
+
+// weight is a sample's contribution under the grid's decay.
+func (g HeatmapGrid) weight(timestamp int64) float64 {
+	if g.HalfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(g.Reference-timestamp)/g.HalfLife)
+}
+
+// At is the density of a cell; zero outside the grid.
+func (g HeatmapGrid) At(column, row int) float64 {
+	if column < 0 || column >= g.Columns || row < 0 || row >= g.Rows {
+		return 0
+	}
+	return g.Cells[row*g.Columns+column]
+}
+
+// Max is the density of the busiest cell, to scale colours by.
+func (g HeatmapGrid) Max() float64 {
+	var busiest float64
+	for _, cell := range g.Cells {
+		busiest = math.Max(busiest, cell)
+	}
+	return busiest
+}
+
+// MergeHeatmaps adds grids of the same size, such as hourly grids into a day.
+// Decayed grids must share a half life; they are brought to the latest of
+// their references before adding. The merged grid's classes are those of all
+// grids, or none when any grid took every class.
+func MergeHeatmaps(grids ...HeatmapGrid) (HeatmapGrid, error) {
+	if len(grids) == 0 {
+		return HeatmapGrid{}, fmt.Errorf("%w: nothing to merge", ErrHeatmapInvalidOptions)
+	}
+	merged := HeatmapGrid{
+		Columns:  grids[0].Columns,
+		Rows:     grids[0].Rows,
+		Cells:    make([]float64, len(grids[0].Cells)),
+		Classes:  append([]string(nil), grids[0].Classes...),
+		HalfLife: grids[0].HalfLife,
+	}
+	for _, grid := range grids {
+		if grid.Columns != merged.Columns || grid.Rows != merged.Rows || len(grid.Cells) != len(merged.Cells) {
+			return HeatmapGrid{}, fmt.Errorf("%w: cannot merge a %dx%d grid into %dx%d", ErrHeatmapInvalidOptions, grid.Columns, grid.Rows, merged.Columns, merged.Rows)
+		}
+		if grid.HalfLife != merged.HalfLife {
+			return HeatmapGrid{}, fmt.Errorf("%w: half lives %v and %v differ", ErrHeatmapInvalidOptions, grid.HalfLife, merged.HalfLife)
+		}
+		if len(grid.Classes) == 0 || len(merged.Classes) == 0 {
+			merged.Classes = nil
+		} else {
+			for _, class := range grid.Classes {
+				merged.Classes = appendUniqueName(merged.Classes, class)
+			}
+		}
+		merged.Reference = max(merged.Reference, grid.Reference)
+	}
+
+	for _, grid := range grids {
+		if grid.Samples == 0 {
+			continue
+		}
+		// A grid decayed to an earlier reference has aged since.
+		scale := 1.0
+		if merged.HalfLife > 0 {
+			scale = math.Pow(0.5, float64(merged.Reference-grid.Reference)/merged.HalfLife)
+		}
+		for i, cell := range grid.Cells {
+			merged.Cells[i] += cell * scale
+		}
+		if merged.Samples == 0 || grid.Start < merged.Start {
+			merged.Start = grid.Start
+		}
+		if merged.Samples == 0 || grid.End > merged.End {
+			merged.End = grid.End
+		}
+		merged.Samples += grid.Samples
+	}
+	return merged, nil
+}
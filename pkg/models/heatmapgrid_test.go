@@ -0,0 +1,95 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBuildHeatmap(t *testing.T) {
+	media := Media{
+		StartTimestamp: 1000,
+		Metadata: &MediaMetadata{Width: 1920, Height: 1080, Classifications: []Classification{
+			{Key: "person", Centroids: [][2]float64{{100, 100}, {1900, 1070}}},
+			{Key: "car", Centroids: [][2]float64{{1000, 600}}},
+		}},
+	}
+	// The same top-left spot at a quarter of the resolution, and a point off
+	// the frame.
+	stored := Heatmap{Timestamp: 2000, FrameWidth: 480, FrameHeight: 270, Coordinates: [][]int{{25, 25}, {500, 10}, {1}}}
+	trajectory := CountingTrajectory{ObjectName: "person", Width: 640, Height: 360, Points: []CountingPoint{{X: 600, Y: 10, Time: 0}}}
+
+	samples := append(HeatmapSamplesFromMedia(media), HeatmapSamplesFromHeatmap(stored)...)
+	samples = append(samples, HeatmapSamplesFromTrajectory(trajectory, 3000)...)
+	samples = append(samples, HeatmapSample{X: 1, Y: 1, Class: "person"})
+
+	grid, err := BuildHeatmap(samples, HeatmapOptions{Columns: 4, Rows: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{
+		2, 0, 0, 1,
+		0, 0, 1, 1,
+	}
+	if !reflect.DeepEqual(grid.Cells, want) || grid.Samples != 5 || grid.Start != 1000 || grid.End != 3000 {
+		t.Errorf("grid = %+v, want cells %v", grid, want)
+	}
+	if grid.At(0, 0) != 2 || grid.At(4, 0) != 0 || grid.Max() != 2 {
+		t.Errorf("at/max = %v %v %v", grid.At(0, 0), grid.At(4, 0), grid.Max())
+	}
+
+	people, err := BuildHeatmap(samples, HeatmapOptions{Columns: 4, Rows: 2, Classes: []string{"person"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1, 0, 0, 1, 0, 0, 0, 1}; !reflect.DeepEqual(people.Cells, want) {
+		t.Errorf("person cells = %v, want %v", people.Cells, want)
+	}
+
+	// With a half life of 1000s the sighting at 1000 counts a quarter against
+	// the reference at 3000, the one at 2000 a half.
+	decayed, err := BuildHeatmap(samples, HeatmapOptions{Columns: 4, Rows: 2, HalfLife: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decayed.Reference != 3000 || !near(decayed.At(0, 0), 0.75) || decayed.At(3, 0) != 1 {
+		t.Errorf("decayed = %+v", decayed)
+	}
+
+	for _, options := range []HeatmapOptions{{Columns: 0, Rows: 1}, {Columns: 1, Rows: 1, HalfLife: -1}} {
+		if _, err := BuildHeatmap(nil, options); !errors.Is(err, ErrHeatmapInvalidOptions) {
+			t.Errorf("%+v: err = %v, want ErrHeatmapInvalidOptions", options, err)
+		}
+	}
+}
+
+func TestMergeHeatmaps(t *testing.T) {
+	sample := func(x float64, class string, timestamp int64) HeatmapSample {
+		return HeatmapSample{X: x, Y: 0, Width: 2, Height: 1, Class: class, Timestamp: timestamp}
+	}
+	options := HeatmapOptions{Columns: 2, Rows: 1, HalfLife: 3600, Classes: []string{"person"}}
+	morning, _ := BuildHeatmap([]HeatmapSample{sample(0, "person", 3600)}, options)
+	options.Classes = []string{"car"}
+	noon, _ := BuildHeatmap([]HeatmapSample{sample(1.5, "car", 7200), sample(0, "car", 7200)}, options)
+
+	day, err := MergeHeatmaps(morning, noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The morning grid is an hour older than noon's reference.
+	if !near(day.Cells[0], 1.5) || day.Cells[1] != 1 || day.Reference != 7200 {
+		t.Errorf("day = %+v", day)
+	}
+	if day.Samples != 3 || day.Start != 3600 || day.End != 7200 || !reflect.DeepEqual(day.Classes, []string{"person", "car"}) {
+		t.Errorf("day = %+v", day)
+	}
+
+	plain, _ := BuildHeatmap(nil, HeatmapOptions{Columns: 2, Rows: 1})
+	if _, err := MergeHeatmaps(morning, plain); !errors.Is(err, ErrHeatmapInvalidOptions) {
+		t.Errorf("half life mismatch: err = %v", err)
+	}
+	wide, _ := BuildHeatmap(nil, HeatmapOptions{Columns: 3, Rows: 1})
+	if _, err := MergeHeatmaps(plain, wide); !errors.Is(err, ErrHeatmapInvalidOptions) {
+		t.Errorf("size mismatch: err = %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Pose runs store a TrackKeypoint list per frame of a track, in
+// FrameKeypoints next to the boxes. Keypoints are normalised, rotated,
+// validated and kept or dropped by the same rules as the box of their frame,
+// so code that only reads the boxes sees a pose run as an ordinary detection
+// run.
+
+// Values of TrackKeypoint.Visibility, as in COCO.
+const (
+	// KeypointNotLabeled: the keypoint was not found; X and Y are zero.
+	KeypointNotLabeled = 0
+	// KeypointOccluded: the keypoint is placed but hidden behind something.
+	KeypointOccluded = 1
+	KeypointVisible  = 2
+)
+
+// ErrPoseInvalid is returned for a category whose keypoint names are empty
+// or repeated, or whose skeleton joins a keypoint it does not have.
+var ErrPoseInvalid = errors.New("invalid pose definition")
+
+// Validate checks the category's keypoints and skeleton. A category without
+// keypoint names may still have a skeleton over indexes only.
+func (c DetectionCategory) Validate() error {
+	seen := map[string]bool{}
+	for i, name := range c.Keypoints {
+		if name == "" || seen[name] {
+			return fmt.Errorf("%w: category %d keypoint %d is named %q", ErrPoseInvalid, c.Id, i, name)
+		}
+		seen[name] = true
+	}
+	for _, limb := range c.Skeleton {
+		for _, index := range limb {
+			if index < 0 || len(c.Keypoints) > 0 && index >= len(c.Keypoints) {
+				return fmt.Errorf("%w: category %d skeleton joins keypoint %d", ErrPoseInvalid, c.Id, index)
+			}
+		}
+		if limb[0] == limb[1] {
+			return fmt.Errorf("%w: category %d skeleton joins keypoint %d to itself", ErrPoseInvalid, c.Id, limb[0])
+		}
+	}
+	return nil
+}
+
+// KeypointIndex looks up a keypoint by name.
+func (c DetectionCategory) KeypointIndex(name string) (int, bool) {
+	for i, keypoint := range c.Keypoints {
+		if keypoint == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// pruneKeypoints drops the keypoints of frames that no longer have a box.
+func (t *FaceRedactionTrack) pruneKeypoints() {
+	for frame := range t.FrameKeypoints {
+		if _, ok := t.FrameCoordinates[frame]; !ok {
+			delete(t.FrameKeypoints, frame)
+		}
+	}
+	if len(t.FrameKeypoints) == 0 {
+		t.FrameKeypoints = nil
+	}
+}
+
+// interpolateKeypoints places the keypoints labelled in both a and b at s, from
+// 0 at a to 1 at b. They take the lower visibility and score of the two.
+func interpolateKeypoints(a, b []TrackKeypoint, s float64) []TrackKeypoint {
+	var keypoints []TrackKeypoint
+	for _, from := range a {
+		for _, to := range b {
+			if from.Index != to.Index || from.Visibility == KeypointNotLabeled || to.Visibility == KeypointNotLabeled {
+				continue
+			}
+			keypoints = append(keypoints, TrackKeypoint{
+				Index:      from.Index,
+				Name:       from.Name,
+				X:          from.X + (to.X-from.X)*s,
+				Y:          from.Y + (to.Y-from.Y)*s,
+				Visibility: min(from.Visibility, to.Visibility),
+				Score:      math.Min(from.Score, to.Score),
+			})
+		}
+	}
+	return keypoints
+}
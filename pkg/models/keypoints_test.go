@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectionCategoryValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		category DetectionCategory
+		valid    bool
+	}{
+		{"box only", DetectionCategory{Id: 0, Name: "car"}, true},
+		{"pose", DetectionCategory{Keypoints: []string{"nose", "neck"}, Skeleton: [][2]int{{0, 1}}}, true},
+		{"indexes only", DetectionCategory{Skeleton: [][2]int{{0, 16}}}, true},
+		{"empty name", DetectionCategory{Keypoints: []string{"nose", ""}}, false},
+		{"repeated name", DetectionCategory{Keypoints: []string{"nose", "nose"}}, false},
+		{"unknown keypoint", DetectionCategory{Keypoints: []string{"nose", "neck"}, Skeleton: [][2]int{{0, 2}}}, false},
+		{"negative index", DetectionCategory{Skeleton: [][2]int{{-1, 0}}}, false},
+		{"loop", DetectionCategory{Skeleton: [][2]int{{1, 1}}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.category.Validate()
+		if tt.valid && err != nil || !tt.valid && !errors.Is(err, ErrPoseInvalid) {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+	}
+
+	category := DetectionCategory{Keypoints: []string{"nose", "neck"}}
+	if index, ok := category.KeypointIndex("neck"); !ok || index != 1 {
+		t.Errorf("neck = %d, %v", index, ok)
+	}
+	if _, ok := category.KeypointIndex("tail"); ok {
+		t.Error("tail found")
+	}
+}
+
+func TestTrackKeypoints(t *testing.T) {
+	track := trackWith(map[int64]TrackBox{
+		0: {X1: 0, Y1: 0, X2: 0.2, Y2: 0.2, Confidence: 0.9},
+		4: {X1: 0.4, Y1: 0, X2: 0.6, Y2: 0.2, Confidence: 0.3},
+	})
+	track.FrameKeypoints = map[int64][]TrackKeypoint{
+		0: {
+			{Index: 0, Name: "nose", X: 0.1, Y: 0.1, Visibility: KeypointVisible, Score: 0.8},
+			{Index: 1, Name: "neck", X: 0.1, Y: 0.2, Visibility: KeypointVisible, Score: 0.8},
+		},
+		4: {
+			{Index: 0, Name: "nose", X: 0.5, Y: 0.1, Visibility: KeypointOccluded, Score: 0.6},
+			{Index: 1, Name: "neck", Visibility: KeypointNotLabeled},
+		},
+	}
+
+	clone := track.Clone()
+	clone.FrameKeypoints[0][0].X = 1
+	if track.FrameKeypoints[0][0].X != 0.1 {
+		t.Error("clone shares keypoints with the original")
+	}
+
+	if _, err := track.Interpolate(TrackInterpolation{Method: TrackInterpolateLinear, MaxGap: 3}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the nose is labelled on both sides.
+	got := track.FrameKeypoints[1]
+	if len(got) != 1 || got[0].Index != 0 || !near(got[0].X, 0.2) || got[0].Y != 0.1 || got[0].Visibility != KeypointOccluded || got[0].Score != 0.6 {
+		t.Errorf("frame 1 keypoints = %+v", got)
+	}
+
+	// A view that drops a box drops the keypoints of its frame.
+	run := DetectionRun{Tracks: []FaceRedactionTrack{track}}
+	view, err := run.ApplyView(DetectionView{ScoreThreshold: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keypoints := view.Tracks[0].FrameKeypoints; len(keypoints) != 1 || keypoints[0] == nil {
+		t.Errorf("view keypoints = %+v", keypoints)
+	}
+	if len(run.Tracks[0].FrameKeypoints) != 5 {
+		t.Errorf("view changed the run's keypoints: %+v", run.Tracks[0].FrameKeypoints)
+	}
+}
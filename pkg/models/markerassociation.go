@@ -0,0 +1,134 @@
+package models
+
+import "sort"
+
+// MarkerSummaryLimit is the default bound on Media.MarkerSummary. Once a media
+// holds this many summaries, further markers still extend the flat name arrays
+// but add no summary.
+const MarkerSummaryLimit = 100
+
+// MarkerMediaLink is a media a marker attaches to.
+type MarkerMediaLink struct {
+	// Index is the media's position in the candidates passed in.
+	Index int   `json:"index"`
+	Media Media `json:"media"`
+	// Overlap is how many seconds the marker and media share, without the
+	// tolerance. A marker linked only through the tolerance, or an instant
+	// marker, overlaps for zero seconds.
+	Overlap int64 `json:"overlap"`
+	// Pinned is set when the link came from Marker.MediaKeys rather than time.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// AssociateMarkerMedia returns the candidate media a marker attaches to, in
+// start order. Only media of the marker's organisation and device qualify.
+//
+// When the marker carries MediaKeys, they are authoritative: exactly the media
+// whose VideoFile is listed is linked, whatever its time. Otherwise a media is
+// linked when its time range, widened by tolerance seconds on both sides to
+// absorb fps and clock drift, overlaps the marker's.
+func AssociateMarkerMedia(marker Marker, candidates []Media, tolerance int64) []MarkerMediaLink {
+	if tolerance < 0 {
+		tolerance = 0
+	}
+	start, end := marker.StartTimestamp, markerEnd(marker)
+
+	var links []MarkerMediaLink
+	for i, media := range candidates {
+		if media.OrganisationId != marker.OrganisationId || !mediaOnDevice(media, marker.DeviceId) {
+			continue
+		}
+		mediaStart, mediaEnd := media.StartTimestamp, sequenceMediaEnd(media)
+		link := MarkerMediaLink{Index: i, Media: media, Overlap: max(0, min(end, mediaEnd)-max(start, mediaStart))}
+		if len(marker.MediaKeys) > 0 {
+			if !containsString(marker.MediaKeys, media.VideoFile) {
+				continue
+			}
+			link.Pinned = true
+		} else if start > mediaEnd+tolerance || end < mediaStart-tolerance {
+			continue
+		}
+		links = append(links, link)
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].Media.StartTimestamp < links[j].Media.StartTimestamp
+	})
+	return links
+}
+
+// markerEnd is the marker's end time: EndTimestamp, or the start plus its
+// Duration (seconds).
+func markerEnd(marker Marker) int64 {
+	if marker.EndTimestamp >= marker.StartTimestamp {
+		return marker.EndTimestamp
+	}
+	return marker.StartTimestamp + marker.Duration
+}
+
+// mediaOnDevice reports whether media was recorded by device, matching either
+// the device key or the deprecated device id.
+func mediaOnDevice(media Media, device string) bool {
+	return device != "" && (media.DeviceKey == device || media.DeviceId == device)
+}
+
+// NewMarkerSummary denormalises a marker into the summary stored on the media
+// it overlaps. Names are deduplicated in first-seen order.
+func NewMarkerSummary(marker Marker) MarkerSummary {
+	summary := MarkerSummary{
+		Name:           marker.Name,
+		StartTimestamp: marker.StartTimestamp,
+		EndTimestamp:   markerEnd(marker),
+		Detections:     marker.Detections,
+	}
+	for _, category := range marker.Categories {
+		summary.CategoryNames = appendUniqueName(summary.CategoryNames, category.Name)
+	}
+	for _, event := range marker.Events {
+		summary.EventNames = appendUniqueName(summary.EventNames, event.Name)
+	}
+	for _, tag := range marker.Tags {
+		summary.TagNames = appendUniqueName(summary.TagNames, tag.Name)
+	}
+	return summary
+}
+
+// AddMarkerSummary records a marker occurrence on the media. The flat
+// MarkerNames, EventNames, TagNames and CategoryNames arrays always take the
+// summary's names, deduplicated, so filtering sees every marker. The summary
+// itself is appended, never rewritten, unless the same occurrence (name and
+// time range) is already present or the media holds limit summaries; limit <= 0
+// uses MarkerSummaryLimit. It reports whether the summary was appended.
+func (m *Media) AddMarkerSummary(summary MarkerSummary, limit int) bool {
+	m.MarkerNames = appendUniqueName(m.MarkerNames, summary.Name)
+	for _, name := range summary.EventNames {
+		m.EventNames = appendUniqueName(m.EventNames, name)
+	}
+	for _, name := range summary.TagNames {
+		m.TagNames = appendUniqueName(m.TagNames, name)
+	}
+	for _, name := range summary.CategoryNames {
+		m.CategoryNames = appendUniqueName(m.CategoryNames, name)
+	}
+
+	if limit <= 0 {
+		limit = MarkerSummaryLimit
+	}
+	if len(m.MarkerSummary) >= limit {
+		return false
+	}
+	for _, existing := range m.MarkerSummary {
+		if existing.Name == summary.Name && existing.StartTimestamp == summary.StartTimestamp && existing.EndTimestamp == summary.EndTimestamp {
+			return false
+		}
+	}
+	m.MarkerSummary = append(m.MarkerSummary, summary)
+	return true
+}
+
+// appendUniqueName appends name unless it is empty or already present.
+func appendUniqueName(names []string, name string) []string {
+	if name == "" || containsString(names, name) {
+		return names
+	}
+	return append(names, name)
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssociateMarkerMedia(t *testing.T) {
+	candidates := []Media{
+		{VideoFile: "a.mp4", OrganisationId: "org", DeviceKey: "cam", StartTimestamp: 100, EndTimestamp: 130},
+		{VideoFile: "b.mp4", OrganisationId: "org", DeviceKey: "cam", StartTimestamp: 130, EndTimestamp: 160},
+		{VideoFile: "c.mp4", OrganisationId: "org", DeviceKey: "cam", StartTimestamp: 162, Duration: 10000},
+		{VideoFile: "d.mp4", OrganisationId: "org", DeviceId: "cam", StartTimestamp: 200, EndTimestamp: 230},
+		{VideoFile: "other-device.mp4", OrganisationId: "org", DeviceKey: "cam2", StartTimestamp: 100, EndTimestamp: 160},
+		{VideoFile: "other-org.mp4", OrganisationId: "org2", DeviceKey: "cam", StartTimestamp: 100, EndTimestamp: 160},
+	}
+
+	tests := []struct {
+		name      string
+		marker    Marker
+		tolerance int64
+		want      []string
+		overlaps  []int64
+	}{
+		{
+			name:     "overlap",
+			marker:   Marker{OrganisationId: "org", DeviceId: "cam", StartTimestamp: 120, EndTimestamp: 140},
+			want:     []string{"a.mp4", "b.mp4"},
+			overlaps: []int64{10, 10},
+		},
+		{
+			name:      "tolerance bridges drift",
+			marker:    Marker{OrganisationId: "org", DeviceId: "cam", StartTimestamp: 150, EndTimestamp: 160},
+			tolerance: 2,
+			want:      []string{"b.mp4", "c.mp4"},
+			overlaps:  []int64{10, 0},
+		},
+		{
+			name:     "duration without end",
+			marker:   Marker{OrganisationId: "org", DeviceId: "cam", StartTimestamp: 210, EndTimestamp: 0, Duration: 5},
+			want:     []string{"d.mp4"},
+			overlaps: []int64{5},
+		},
+		{
+			name:     "media keys are authoritative",
+			marker:   Marker{OrganisationId: "org", DeviceId: "cam", StartTimestamp: 120, EndTimestamp: 140, MediaKeys: []string{"d.mp4", "other-org.mp4"}},
+			want:     []string{"d.mp4"},
+			overlaps: []int64{0},
+		},
+		{
+			name:   "no match",
+			marker: Marker{OrganisationId: "org", DeviceId: "cam", StartTimestamp: 300, EndTimestamp: 310},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			links := AssociateMarkerMedia(tt.marker, candidates, tt.tolerance)
+			var keys []string
+			var overlaps []int64
+			for _, link := range links {
+				keys = append(keys, link.Media.VideoFile)
+				overlaps = append(overlaps, link.Overlap)
+				if link.Pinned != (len(tt.marker.MediaKeys) > 0) || candidates[link.Index].VideoFile != link.Media.VideoFile {
+					t.Errorf("link = %+v", link)
+				}
+			}
+			if !reflect.DeepEqual(keys, tt.want) || !reflect.DeepEqual(overlaps, tt.overlaps) {
+				t.Errorf("links = %v %v, want %v %v", keys, overlaps, tt.want, tt.overlaps)
+			}
+		})
+	}
+}
+
+func TestAddMarkerSummary(t *testing.T) {
+	marker := Marker{
+		Name:           "2-HCP-007",
+		StartTimestamp: 100,
+		EndTimestamp:   110,
+		Categories:     []MarkerCategory{{Name: "vehicle"}, {Name: "vehicle"}},
+		Events:         []MarkerEvent{{Name: "entry"}, {Name: ""}},
+		Tags:           []MarkerTag{{Name: "vip"}},
+	}
+	summary := NewMarkerSummary(marker)
+	if !reflect.DeepEqual(summary.CategoryNames, []string{"vehicle"}) || !reflect.DeepEqual(summary.EventNames, []string{"entry"}) {
+		t.Fatalf("summary = %+v", summary)
+	}
+
+	media := Media{MarkerNames: []string{"earlier"}, CategoryNames: []string{"vehicle"}}
+	if !media.AddMarkerSummary(summary, 2) {
+		t.Fatal("first summary not appended")
+	}
+	if media.AddMarkerSummary(summary, 2) {
+		t.Error("same occurrence appended twice")
+	}
+
+	second := NewMarkerSummary(Marker{Name: "2-HCP-007", StartTimestamp: 200, EndTimestamp: 210})
+	third := NewMarkerSummary(Marker{Name: "1-ABC-123", StartTimestamp: 300, EndTimestamp: 310, Tags: []MarkerTag{{Name: "watchlist"}}})
+	if !media.AddMarkerSummary(second, 2) || media.AddMarkerSummary(third, 2) {
+		t.Error("summary list is not bounded at 2")
+	}
+
+	if len(media.MarkerSummary) != 2 || media.MarkerSummary[0].StartTimestamp != 100 || media.MarkerSummary[1].StartTimestamp != 200 {
+		t.Errorf("summaries = %+v", media.MarkerSummary)
+	}
+	// The flat arrays keep every name even once the summary list is full.
+	if want := []string{"earlier", "2-HCP-007", "1-ABC-123"}; !reflect.DeepEqual(media.MarkerNames, want) {
+		t.Errorf("marker names = %v, want %v", media.MarkerNames, want)
+	}
+	if want := []string{"vip", "watchlist"}; !reflect.DeepEqual(media.TagNames, want) {
+		t.Errorf("tag names = %v, want %v", media.TagNames, want)
+	}
+	if !reflect.DeepEqual(media.CategoryNames, []string{"vehicle"}) || !reflect.DeepEqual(media.EventNames, []string{"entry"}) {
+		t.Errorf("category/event names = %v %v", media.CategoryNames, media.EventNames)
+	}
+}
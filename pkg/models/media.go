@@ -126,6 +126,8 @@ type MediaMetadata struct {
 	DominantColors  []string         `json:"dominantColors,omitempty" bson:"dominantColors,omitempty"`
 	Count           int              `json:"count,omitempty" bson:"count,omitempty"`
 	Embedding       []int            `json:"embedding,omitempty" bson:"embedding,omitempty"`
+	// EmbeddingFormat describes how Embedding was produced and quantised.
+	EmbeddingFormat *EmbeddingFormat `json:"embeddingFormat,omitempty" bson:"embeddingFormat,omitempty"`
 }
 
 // MediaAtRuntimeMetadata contains metadata that is generated at runtime, which can include
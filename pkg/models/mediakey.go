@@ -0,0 +1,288 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A recording arrives on the pipeline as a storage key (PipelinePayload.FileName)
+// plus optional structured metadata. Which attributes live where depends on the
+// vault that produced it:
+//
+//   - structured metadata (the current Kerberos Vault): the device, start time,
+//     duration and fps travel in PipelineMetadata, and the key is opaque.
+//   - the legacy Kerberos filename: everything is encoded in the key itself as
+//     <prefix>/<timestamp>_<microseconds>_<device>_<region>_<changes>_<duration>.<ext>
+//
+// Each layout is a MediaKeyParser, and ParseMediaKey tries them in a fixed
+// order so every service decodes the same key to the same attributes.
+// FormatMediaKey is the inverse of the legacy layout, so producers that still
+// mint filename-encoded keys cannot disagree with the parser about the format.
+
+var (
+	// ErrMediaKeyNotApplicable is returned by a parser when the key is not in
+	// its layout, so the next parser is tried. ParseMediaKey never returns it.
+	ErrMediaKeyNotApplicable = errors.New("media key is not in this parser's layout")
+	// ErrMediaKeyUnrecognised is returned when no registered parser accepts the key.
+	ErrMediaKeyUnrecognised = errors.New("media key layout is not recognised")
+	// ErrMediaKeyInvalidPath is returned when the key has no "<prefix>/<file>" path.
+	ErrMediaKeyInvalidPath = errors.New("invalid file path format")
+	// ErrMediaKeyInvalidFileName is returned when the file name has no extension.
+	ErrMediaKeyInvalidFileName = errors.New("invalid video file name format")
+	// ErrMediaKeyInvalidAttributes is returned when a legacy file name does not
+	// hold exactly six underscore-separated attributes.
+	ErrMediaKeyInvalidAttributes = errors.New("invalid attributes format")
+	// ErrMediaKeyInvalidTimestamp is returned for a start timestamp that is not
+	// a non-negative integer (unix seconds).
+	ErrMediaKeyInvalidTimestamp = errors.New("invalid media timestamp")
+	// ErrMediaKeyInvalidDuration is returned for a duration that is not a
+	// non-negative integer.
+	ErrMediaKeyInvalidDuration = errors.New("invalid media duration")
+	// ErrMediaKeyInvalidFPS is returned for a frame rate that is not a
+	// non-negative number.
+	ErrMediaKeyInvalidFPS = errors.New("invalid media fps")
+)
+
+// legacyMediaKeyAttributes is the number of underscore-separated attributes in
+// a legacy Kerberos file name.
+const legacyMediaKeyAttributes = 6
+
+// MediaKeyInfo holds the recording attributes a MediaKeyParser extracts from a
+// key. Timestamps are unix seconds; Duration is in the unit the producer
+// encodes (milliseconds for Kerberos agents).
+type MediaKeyInfo struct {
+	// Key is the full storage key (media.videoFile).
+	Key string `json:"key"`
+	// Parser is the name of the parser that produced this info.
+	Parser string `json:"parser,omitempty"`
+
+	DeviceKey      string  `json:"deviceKey,omitempty"`
+	DeviceName     string  `json:"deviceName,omitempty"`
+	StartTimestamp int64   `json:"startTimestamp,omitempty"`
+	Duration       int     `json:"duration,omitempty"`
+	FPS            float64 `json:"fps,omitempty"`
+
+	// Prefix and Extension are the key's path before the file name (usually the
+	// account name) and its file extension.
+	Prefix    string `json:"prefix,omitempty"`
+	Extension string `json:"extension,omitempty"`
+
+	// MicroSeconds, RegionCoordinates and NumberOfChanges are the opaque legacy
+	// attributes the Kerberos agent encodes between the timestamp and duration.
+	// They are carried verbatim so FormatMediaKey can reproduce the key.
+	MicroSeconds      string `json:"microSeconds,omitempty"`
+	RegionCoordinates string `json:"regionCoordinates,omitempty"`
+	NumberOfChanges   string `json:"numberOfChanges,omitempty"`
+}
+
+// MediaKeyParser decodes one key layout. Parse returns ErrMediaKeyNotApplicable
+// (optionally wrapped) when the key is not in its layout, and any other error
+// when it is but the key is malformed.
+type MediaKeyParser interface {
+	Name() string
+	Parse(key string, metadata PipelineMetadata) (MediaKeyInfo, error)
+}
+
+var mediaKeyParsers = struct {
+	sync.RWMutex
+	custom []MediaKeyParser
+}{}
+
+// RegisterMediaKeyParser adds a parser for a third-party vault layout. Custom
+// parsers are tried after structured metadata (which is authoritative when
+// present) and before the legacy filename layout (which is the fallback and
+// reports why a key is malformed). A parser registered under an existing name
+// replaces it.
+func RegisterMediaKeyParser(parser MediaKeyParser) {
+	mediaKeyParsers.Lock()
+	defer mediaKeyParsers.Unlock()
+	for i, existing := range mediaKeyParsers.custom {
+		if existing.Name() == parser.Name() {
+			mediaKeyParsers.custom[i] = parser
+			return
+		}
+	}
+	mediaKeyParsers.custom = append(mediaKeyParsers.custom, parser)
+}
+
+// MediaKeyParsers returns the parsers in the order ParseMediaKey tries them.
+func MediaKeyParsers() []MediaKeyParser {
+	mediaKeyParsers.RLock()
+	defer mediaKeyParsers.RUnlock()
+	parsers := []MediaKeyParser{StructuredMetadataKeyParser{}}
+	parsers = append(parsers, mediaKeyParsers.custom...)
+	return append(parsers, LegacyKerberosKeyParser{})
+}
+
+// ParseMediaKey decodes a recording key with the first parser that accepts it.
+func ParseMediaKey(key string, metadata PipelineMetadata) (MediaKeyInfo, error) {
+	for _, parser := range MediaKeyParsers() {
+		info, err := parser.Parse(key, metadata)
+		if errors.Is(err, ErrMediaKeyNotApplicable) {
+			continue
+		}
+		if err != nil {
+			return MediaKeyInfo{}, err
+		}
+		info.Key = key
+		info.Parser = parser.Name()
+		return info, nil
+	}
+	return MediaKeyInfo{}, fmt.Errorf("%w: %s", ErrMediaKeyUnrecognised, key)
+}
+
+// StructuredMetadataKeyParser reads the attributes from PipelineMetadata. It
+// applies whenever the metadata names a device; the key itself is opaque.
+type StructuredMetadataKeyParser struct{}
+
+func (StructuredMetadataKeyParser) Name() string { return "metadata" }
+
+func (StructuredMetadataKeyParser) Parse(key string, metadata PipelineMetadata) (MediaKeyInfo, error) {
+	if metadata.DeviceId == "" {
+		return MediaKeyInfo{}, ErrMediaKeyNotApplicable
+	}
+	prefix, _, err := splitMediaKeyPath(key)
+	if err != nil {
+		return MediaKeyInfo{}, err
+	}
+
+	info := MediaKeyInfo{
+		Prefix:            prefix,
+		DeviceKey:         metadata.DeviceId,
+		DeviceName:        metadata.DeviceName,
+		MicroSeconds:      metadata.MicroSeconds,
+		RegionCoordinates: metadata.RegionCoordinates,
+		NumberOfChanges:   metadata.NumberOfChanges,
+	}
+	if info.StartTimestamp, err = parseMediaKeyInt(metadata.Timestamp, false, ErrMediaKeyInvalidTimestamp); err != nil {
+		return MediaKeyInfo{}, err
+	}
+	duration, err := parseMediaKeyInt(metadata.Duration, false, ErrMediaKeyInvalidDuration)
+	if err != nil {
+		return MediaKeyInfo{}, err
+	}
+	info.Duration = int(duration)
+	if metadata.FPS != "" {
+		fps, err := strconv.ParseFloat(metadata.FPS, 64)
+		if err != nil || fps < 0 || math.IsNaN(fps) || math.IsInf(fps, 0) {
+			return MediaKeyInfo{}, fmt.Errorf("%w: %q", ErrMediaKeyInvalidFPS, metadata.FPS)
+		}
+		info.FPS = fps
+	}
+	return info, nil
+}
+
+// LegacyKerberosKeyParser reads the attributes from a legacy Kerberos file name,
+// <prefix>/<timestamp>_<microseconds>_<device>_<region>_<changes>_<duration>.<ext>.
+// It is the fallback layout and therefore always applicable.
+type LegacyKerberosKeyParser struct{}
+
+func (LegacyKerberosKeyParser) Name() string { return "kerberos-legacy" }
+
+func (LegacyKerberosKeyParser) Parse(key string, _ PipelineMetadata) (MediaKeyInfo, error) {
+	prefix, fileName, err := splitMediaKeyPath(key)
+	if err != nil {
+		return MediaKeyInfo{}, err
+	}
+	dot := strings.LastIndex(fileName, ".")
+	if dot < 0 {
+		return MediaKeyInfo{}, fmt.Errorf("%w, expected at least 2 parts separated by '.', got: %s", ErrMediaKeyInvalidFileName, fileName)
+	}
+	attributes := strings.Split(fileName[:dot], "_")
+	if len(attributes) != legacyMediaKeyAttributes {
+		return MediaKeyInfo{}, fmt.Errorf("%w in video file name: %s, expected %d attributes, got: %d", ErrMediaKeyInvalidAttributes, fileName, legacyMediaKeyAttributes, len(attributes))
+	}
+
+	info := MediaKeyInfo{
+		Prefix:            prefix,
+		Extension:         fileName[dot+1:],
+		MicroSeconds:      attributes[1],
+		DeviceKey:         attributes[2],
+		DeviceName:        attributes[2],
+		RegionCoordinates: attributes[3],
+		NumberOfChanges:   attributes[4],
+	}
+	if info.StartTimestamp, err = parseMediaKeyInt(attributes[0], true, ErrMediaKeyInvalidTimestamp); err != nil {
+		return MediaKeyInfo{}, err
+	}
+	duration, err := parseMediaKeyInt(attributes[5], true, ErrMediaKeyInvalidDuration)
+	if err != nil {
+		return MediaKeyInfo{}, err
+	}
+	info.Duration = int(duration)
+	return info, nil
+}
+
+// FormatMediaKey renders info in the legacy Kerberos layout. It is the exact
+// inverse of LegacyKerberosKeyParser: parsing the result yields the same
+// attributes. Unset opaque attributes default to the values a Kerberos agent
+// writes ("0", "0-0-0-0", "0") and the extension defaults to "mp4".
+func FormatMediaKey(info MediaKeyInfo) (string, error) {
+	if info.Prefix == "" {
+		return "", fmt.Errorf("%w: prefix is empty", ErrMediaKeyInvalidPath)
+	}
+	if info.StartTimestamp < 0 {
+		return "", fmt.Errorf("%w: %d", ErrMediaKeyInvalidTimestamp, info.StartTimestamp)
+	}
+	if info.Duration < 0 {
+		return "", fmt.Errorf("%w: %d", ErrMediaKeyInvalidDuration, info.Duration)
+	}
+
+	device := info.DeviceKey
+	if device == "" {
+		device = info.DeviceName
+	}
+	extension := defaultString(info.Extension, "mp4")
+	attributes := []string{
+		strconv.FormatInt(info.StartTimestamp, 10),
+		defaultString(info.MicroSeconds, "0"),
+		device,
+		defaultString(info.RegionCoordinates, "0-0-0-0"),
+		defaultString(info.NumberOfChanges, "0"),
+		strconv.Itoa(info.Duration),
+	}
+	for _, attribute := range attributes {
+		if attribute == "" || strings.ContainsAny(attribute, "_/.") {
+			return "", fmt.Errorf("%w: attribute %q must be non-empty and free of '_', '/' and '.'", ErrMediaKeyInvalidAttributes, attribute)
+		}
+	}
+	if strings.ContainsAny(extension, "/.") {
+		return "", fmt.Errorf("%w: extension %q", ErrMediaKeyInvalidFileName, extension)
+	}
+	return info.Prefix + "/" + strings.Join(attributes, "_") + "." + extension, nil
+}
+
+// splitMediaKeyPath splits a key into the path before the file name and the
+// file name itself.
+func splitMediaKeyPath(key string) (string, string, error) {
+	slash := strings.LastIndex(key, "/")
+	if slash < 0 {
+		return "", "", fmt.Errorf("%w, expected at least 2 parts separated by '/', got: %s", ErrMediaKeyInvalidPath, key)
+	}
+	return key[:slash], key[slash+1:], nil
+}
+
+// parseMediaKeyInt parses a non-negative integer attribute. An empty optional
+// value is "not reported" and parses to zero; anything else that is not a
+// non-negative integer is reported with the given sentinel.
+func parseMediaKeyInt(value string, required bool, sentinel error) (int64, error) {
+	if value == "" && !required {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %q", sentinel, value)
+	}
+	return n, nil
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
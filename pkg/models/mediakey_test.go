@@ -0,0 +1,133 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseMediaKeyTypedErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		metadata PipelineMetadata
+		want     error
+	}{
+		{"no path", "video.mp4", PipelineMetadata{}, ErrMediaKeyInvalidPath},
+		{"no extension", "user/1640000000_0_cam_0-0-0-0_0_5000", PipelineMetadata{}, ErrMediaKeyInvalidFileName},
+		{"too few attributes", "user/1640000000_cam.mp4", PipelineMetadata{}, ErrMediaKeyInvalidAttributes},
+		{"negative legacy timestamp", "user/-1_0_cam_0-0-0-0_0_5000.mp4", PipelineMetadata{}, ErrMediaKeyInvalidTimestamp},
+		{"empty legacy duration", "user/1640000000_0_cam_0-0-0-0_0_.mp4", PipelineMetadata{}, ErrMediaKeyInvalidDuration},
+		{"metadata timestamp", "user/video.mp4", PipelineMetadata{DeviceId: "d", Timestamp: "12abc"}, ErrMediaKeyInvalidTimestamp},
+		{"metadata duration", "user/video.mp4", PipelineMetadata{DeviceId: "d", Duration: "1.5"}, ErrMediaKeyInvalidDuration},
+		{"metadata fps", "user/video.mp4", PipelineMetadata{DeviceId: "d", FPS: "NaN"}, ErrMediaKeyInvalidFPS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMediaKey(tt.key, tt.metadata)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMediaKeyPrefersStructuredMetadata(t *testing.T) {
+	info, err := ParseMediaKey("user/1640000000_0_cam_0-0-0-0_0_5000.mp4", PipelineMetadata{
+		DeviceId:  "device-1",
+		Timestamp: "1706000000",
+		Duration:  "3000",
+		FPS:       "12.5",
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if info.Parser != "metadata" || info.DeviceKey != "device-1" || info.StartTimestamp != 1706000000 || info.Duration != 3000 || info.FPS != 12.5 {
+		t.Errorf("info = %+v, want the metadata attributes", info)
+	}
+}
+
+func TestFormatMediaKeyIsTheInverseOfTheLegacyParser(t *testing.T) {
+	for _, key := range []string{
+		"user/1640000000_6-967003_frontdoor_200-200-400-400_0_769.mp4",
+		"org/site/1706000000_0_cam-2_0-0-0-0_12_60000.mkv",
+	} {
+		info, err := ParseMediaKey(key, PipelineMetadata{})
+		if err != nil {
+			t.Fatalf("parse %q: %v", key, err)
+		}
+		formatted, err := FormatMediaKey(info)
+		if err != nil {
+			t.Fatalf("format %+v: %v", info, err)
+		}
+		if formatted != key {
+			t.Errorf("FormatMediaKey(ParseMediaKey(%q)) = %q", key, formatted)
+		}
+	}
+
+	key, err := FormatMediaKey(MediaKeyInfo{Prefix: "user", DeviceKey: "cam", StartTimestamp: 1640000000, Duration: 5000})
+	if err != nil {
+		t.Fatalf("format with defaults: %v", err)
+	}
+	if key != "user/1640000000_0_cam_0-0-0-0_0_5000.mp4" {
+		t.Errorf("defaulted key = %q", key)
+	}
+	info, err := ParseMediaKey(key, PipelineMetadata{})
+	if err != nil || info.DeviceKey != "cam" || info.StartTimestamp != 1640000000 || info.Duration != 5000 {
+		t.Errorf("parse of formatted key = (%+v, %v)", info, err)
+	}
+}
+
+func TestFormatMediaKeyRejectsAmbiguousAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		info MediaKeyInfo
+		want error
+	}{
+		{"no prefix", MediaKeyInfo{DeviceKey: "cam"}, ErrMediaKeyInvalidPath},
+		{"underscore in device", MediaKeyInfo{Prefix: "user", DeviceKey: "front_door"}, ErrMediaKeyInvalidAttributes},
+		{"no device", MediaKeyInfo{Prefix: "user"}, ErrMediaKeyInvalidAttributes},
+		{"negative duration", MediaKeyInfo{Prefix: "user", DeviceKey: "cam", Duration: -1}, ErrMediaKeyInvalidDuration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FormatMediaKey(tt.info); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// vaultLayoutParser is a third-party layout: <account>/<device>/<timestamp>-<duration>.mp4.
+type vaultLayoutParser struct{}
+
+func (vaultLayoutParser) Name() string { return "test-vault" }
+
+func (vaultLayoutParser) Parse(key string, _ PipelineMetadata) (MediaKeyInfo, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "vault-") {
+		return MediaKeyInfo{}, ErrMediaKeyNotApplicable
+	}
+	return MediaKeyInfo{DeviceKey: parts[1], DeviceName: parts[1]}, nil
+}
+
+func TestRegisteredParserRunsBeforeLegacyFallback(t *testing.T) {
+	RegisterMediaKeyParser(vaultLayoutParser{})
+	parsers := MediaKeyParsers()
+	if parsers[0].Name() != "metadata" || parsers[len(parsers)-1].Name() != "kerberos-legacy" {
+		t.Fatalf("parser order = %v, want metadata first and legacy last", parsers)
+	}
+
+	info, err := ParseMediaKey("vault-acme/cam-9/1706000000-5000.mp4", PipelineMetadata{})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if info.Parser != "test-vault" || info.DeviceKey != "cam-9" {
+		t.Errorf("info = %+v, want the registered layout", info)
+	}
+
+	// Keys outside the custom layout still reach the legacy parser.
+	if _, err := ParseMediaKey("user/1640000000_0_cam_0-0-0-0_0_5000.mp4", PipelineMetadata{}); err != nil {
+		t.Errorf("legacy key after registration: %v", err)
+	}
+}
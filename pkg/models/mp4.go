@@ -1,11 +1,17 @@
 package models
 
+// FragmentedBytesRangeOnTime locates one fragment of a fragmented MP4 on a
+// vault document: Time and Duration in seconds from the start of the
+// recording, Range the inclusive byte range "start-end". pkg/mp4 renders an
+// index in this form.
 type FragmentedBytesRangeOnTime struct {
 	Duration string `json:"duration" bson:"duration"`
 	Time     string `json:"time" bson:"time"`
 	Range    string `json:"range" bson:"range"`
 }
 
+// VideoBytesRangeOnTime is FragmentedBytesRangeOnTime as stored on a media
+// document.
 type VideoBytesRangeOnTime struct {
 	Duration string `json:"duration" bson:"duration"`
 	Time     string `json:"time" bson:"time"`
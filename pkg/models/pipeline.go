@@ -2,9 +2,6 @@ package models
 
 import (
 	"encoding/json"
-	"fmt"
-	"strconv"
-	"strings"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -29,8 +26,21 @@ import (
 //    notification
 //
 // Data flows through each stage sequentially, with relevant information persisted at each step.
+//
+// The wire names of a few fields predate the stage model and are kept for
+// compatibility with queued messages: Stages is "events", Storage is
+// "provider" and Provider is "source". Messages are versioned by
+// SchemaVersion; decoding lifts older shapes to the current one (see
+// UpgradePipelineEvent).
 
 type PipelineEvent struct {
+	// SchemaVersion is the shape of the message, PipelineEventSchemaVersion for
+	// producers using this package (see NewPipelineEvent and
+	// MarshalPipelineEvent). Unversioned messages are treated as the oldest
+	// shape and upgraded on decode; newer ones are decoded as far as they are
+	// understood and keep their version.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+
 	Request   string `json:"request,omitempty"` // ondemand, persist
 	Operation string `json:"operation,omitempty"`
 
@@ -58,96 +68,48 @@ type PipelineEvent struct {
 	FileName  string          `json:"fileName,omitempty"`
 	Payload   PipelinePayload `json:"payload,omitempty"`
 
+	// Data is the legacy free-form bag. Keys that have a typed home in a stage
+	// struct are lifted out of it on decode; producers should not emit it.
 	Data map[string]interface{} `json:"data,omitempty"` // We should get rid of this and use the stage map
 }
 
-func (pe *PipelineEvent) GetMedia() (Media, error) {
-
-	// We need to extract attributes from the event.
-	// 1. The old way, we need to parse the filename (all info is stored in the filename).
-	// 2. (or) Use the metadata attributes.
-
-	media := Media{}
-
-	pathParts := strings.Split(pe.Payload.FileName, "/")
-	if len(pathParts) < 2 {
-		return media, fmt.Errorf("invalid file path format, expected at least 2 parts separated by '/', got: %s", pe.Payload.FileName) // Return empty media if path format is invalid
-	}
-
-	// If we have a DeviceId in the metadata, we can use the structured metadata way.
-	// This is the new and recommended approach.
-	if pe.Payload.Metadata.DeviceId != "" {
-		// Set video file
-		media.VideoFile = pe.Payload.FileName
-
-		// Get device information from metadata
-		media.DeviceName = pe.Payload.Metadata.DeviceName
-		media.DeviceKey = pe.Payload.Metadata.DeviceId
-
-		// Get time information from the recording
-		duration, _ := strconv.ParseInt(pe.Payload.Metadata.Duration, 10, 64)
-		media.Duration = int(duration)
-		media.StartTimestamp, _ = strconv.ParseInt(pe.Payload.Metadata.Timestamp, 10, 64)
-
-		// Information about where the media is stored and provided from
-		media.StorageSolution = pe.Storage
-		media.VideoProvider = pe.Provider
-
-		// Metadata information
-		media.Metadata = &MediaMetadata{}
-		media.Metadata.MotionPixels = 0
-		media.Metadata.FileSize = pe.Payload.FileSize
-		if fps, err := strconv.ParseFloat(pe.Payload.Metadata.FPS, 64); err == nil {
-			media.Metadata.FPS = fps
-		}
-		pe.copyOwnershipToMedia(&media)
-
-		return media, nil
-	}
-
-	// Legacy way, parse from filename the different fields. We used to have only the filename, and used the filename to store all attributes.
-	// As this was not very flexible, we moved to structured metadata, but we still need to support the legacy way if there
-	// is a legacy Vault in place.
+// NewPipelineEvent returns an empty event stamped with
+// PipelineEventSchemaVersion, for producers building events with this
+// package.
+func NewPipelineEvent() PipelineEvent {
+	return PipelineEvent{SchemaVersion: PipelineEventSchemaVersion}
+}
 
-	username := pathParts[0]
-	_ = username // Currently not used, but could be useful in the future.
-	videoFileName := pathParts[1]
-	videoFileNamePieces := strings.Split(videoFileName, ".")
-	if len(videoFileNamePieces) < 2 {
-		return media, fmt.Errorf("invalid video file name format, expected at least 2 parts separated by '.', got: %s", videoFileName) // Return empty media if filename format is invalid
+// GetMedia builds the Media a pipeline event describes. The recording's
+// attributes are decoded from the payload key and metadata by ParseMediaKey
+// (structured metadata first, then any registered vault layout, then the
+// legacy Kerberos filename), so errors can be matched with errors.Is against
+// the ErrMediaKey* sentinels.
+func (pe *PipelineEvent) GetMedia() (Media, error) {
+	info, err := ParseMediaKey(pe.Payload.FileName, pe.Payload.Metadata)
+	if err != nil {
+		return Media{}, err
 	}
 
-	// Extract attributes from the video file name
-	videoFileAttribute := videoFileNamePieces[len(videoFileNamePieces)-2]
-	attributes := strings.Split(videoFileAttribute, "_")
-	if len(attributes) == 6 {
-
-		// Set video file
-		media.VideoFile = pe.Payload.FileName
-
-		// Get device information from the filename, we do not have metadata in the legacy way
-		media.DeviceName = attributes[2]
-		media.DeviceKey = attributes[2]
-
-		// Get time information from the filename
-		duration, _ := strconv.ParseInt(attributes[5], 10, 64)
-		media.Duration = int(duration)
-		media.StartTimestamp, _ = strconv.ParseInt(attributes[0], 10, 64)
+	media := Media{
+		VideoFile:      pe.Payload.FileName,
+		DeviceName:     info.DeviceName,
+		DeviceKey:      info.DeviceKey,
+		Duration:       info.Duration,
+		StartTimestamp: info.StartTimestamp,
 
 		// Information about where the media is stored and provided from
-		media.StorageSolution = pe.Storage
-		media.VideoProvider = pe.Provider
+		StorageSolution: pe.Storage,
+		VideoProvider:   pe.Provider,
 
-		// Metadata information
-		media.Metadata = &MediaMetadata{}
-		media.Metadata.MotionPixels = 0
-		media.Metadata.FileSize = pe.Payload.FileSize
-		pe.copyOwnershipToMedia(&media)
-
-		return media, nil
+		Metadata: &MediaMetadata{
+			FileSize: pe.Payload.FileSize,
+			FPS:      info.FPS,
+		},
 	}
-	return media, fmt.Errorf("invalid attributes format in video file name: %s, expected 6 attributes, got: %d", videoFileName, len(attributes)) // Return empty media if attributes format is invalid
+	pe.copyOwnershipToMedia(&media)
 
+	return media, nil
 }
 
 // copyOwnershipToMedia copies the trusted monitor snapshot into parsed media.
@@ -315,6 +277,11 @@ func (a AnalysisStage) GetName() string { return a.Name }
 type ThrottlerStage struct {
 	Name          string `json:"name,omitempty"`
 	ThrottleLimit int    `json:"throttleLimit,omitempty"` // Add fields relevant to throttler stage
+
+	// Throttles and Suppressions carry the throttle state the stage decided
+	// on (see ThrottlePolicy.Allow and SuppressionPolicy.Decide).
+	Throttles    []ThrottleState    `json:"throttles,omitempty"`
+	Suppressions []SuppressionState `json:"suppressions,omitempty"`
 	// Add more fields as needed
 }
 
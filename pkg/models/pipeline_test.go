@@ -186,7 +186,7 @@ func TestGetMediaFromPipelineEvent_LegacyFormat(t *testing.T) {
 			wantErr:   true,
 		},
 		{
-			name: "invalid timestamp",
+			name: "invalid timestamp is rejected",
 			pipelineEvent: PipelineEvent{
 				Payload: PipelinePayload{
 					FileName: "username/invalid_region_devicename_motion_1234_5000.mp4",
@@ -195,16 +195,8 @@ func TestGetMediaFromPipelineEvent_LegacyFormat(t *testing.T) {
 					},
 				},
 			},
-			wantMedia: Media{
-				VideoFile:       "username/invalid_region_devicename_motion_1234_5000.mp4",
-				StartTimestamp:  0,
-				DeviceName:      "devicename",
-				DeviceKey:       "devicename",
-				Duration:        5000,
-				StorageSolution: "",
-				Metadata:        &MediaMetadata{MotionPixels: 0},
-			},
-			wantErr: false,
+			wantMedia: Media{},
+			wantErr:   true,
 		},
 		{
 			name: "invalid motion pixels",
@@ -228,7 +220,7 @@ func TestGetMediaFromPipelineEvent_LegacyFormat(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name: "invalid duration",
+			name: "invalid duration is rejected",
 			pipelineEvent: PipelineEvent{
 				Payload: PipelinePayload{
 					FileName: "username/1640000000_region_devicename_motion_1234_invalid.mp4",
@@ -237,16 +229,8 @@ func TestGetMediaFromPipelineEvent_LegacyFormat(t *testing.T) {
 					},
 				},
 			},
-			wantMedia: Media{
-				VideoFile:       "username/1640000000_region_devicename_motion_1234_invalid.mp4",
-				StartTimestamp:  1640000000,
-				DeviceName:      "devicename",
-				DeviceKey:       "devicename",
-				Duration:        0,
-				StorageSolution: "",
-				Metadata:        &MediaMetadata{MotionPixels: 0},
-			},
-			wantErr: false,
+			wantMedia: Media{},
+			wantErr:   true,
 		},
 	}
 
@@ -320,7 +304,7 @@ func TestGetMediaFromPipelineEvent_NewFormat(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name: "invalid timestamp in new format",
+			name: "invalid timestamp in new format is rejected",
 			pipelineEvent: PipelineEvent{
 				Payload: PipelinePayload{
 					FileName: "path/to/video.mp4",
@@ -332,18 +316,11 @@ func TestGetMediaFromPipelineEvent_NewFormat(t *testing.T) {
 					},
 				},
 			},
-			wantMedia: Media{
-				VideoFile:       "path/to/video.mp4",
-				StartTimestamp:  0,
-				DeviceName:      "Front Camera",
-				DeviceKey:       "device123",
-				Duration:        3000,
-				StorageSolution: "",
-			},
-			wantErr: false,
+			wantMedia: Media{},
+			wantErr:   true,
 		},
 		{
-			name: "invalid duration in new format",
+			name: "invalid duration in new format is rejected",
 			pipelineEvent: PipelineEvent{
 				Payload: PipelinePayload{
 					FileName: "path/to/video.mp4",
@@ -355,15 +332,8 @@ func TestGetMediaFromPipelineEvent_NewFormat(t *testing.T) {
 					},
 				},
 			},
-			wantMedia: Media{
-				VideoFile:       "path/to/video.mp4",
-				StartTimestamp:  1640000000,
-				DeviceName:      "Front Camera",
-				DeviceKey:       "device123",
-				Duration:        0,
-				StorageSolution: "",
-			},
-			wantErr: false,
+			wantMedia: Media{},
+			wantErr:   true,
 		},
 		{
 			name: "new format with empty device name",
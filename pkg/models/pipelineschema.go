@@ -0,0 +1,207 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PipelineEventSchemaVersion is the current PipelineEvent shape:
+//
+//	0 — unversioned: the payload may be a bare file name, or missing with the
+//	    file name and date flat on the event; stage data lives in Data.
+//	1 — the payload is a PipelinePayload object.
+//	2 — stage data lives in the typed stage structs; Data only carries what
+//	    has no typed home yet.
+const PipelineEventSchemaVersion = 2
+
+// ErrPipelineEventVersion is returned for a message with a negative schema
+// version.
+var ErrPipelineEventVersion = errors.New("unsupported pipeline event schema version")
+
+// pipelineEventUpgrade lifts a decoded message from one schema version to the
+// next. Upgrades must also leave an already-current message untouched, since
+// unversioned producers emit every shape.
+type pipelineEventUpgrade func(message map[string]any) error
+
+// pipelineEventUpgrades[i] lifts version i to version i+1.
+var pipelineEventUpgrades = []pipelineEventUpgrade{
+	upgradePipelineEventFlatPayload,
+	upgradePipelineEventDataBag,
+}
+
+// legacyPipelineDataKey is a Data key with a typed home: the stage struct and
+// its field, both by wire name.
+type legacyPipelineDataKey struct {
+	key   string
+	stage string
+	name  string
+	field string
+}
+
+// pipelineStageTypes returns an empty stage struct for each stage wire name,
+// to check a legacy value fits its typed field before it is moved.
+var pipelineStageTypes = map[string]func() any{
+	"eventStage":        func() any { return &EventStage{} },
+	"monitorStage":      func() any { return &MonitorStage{} },
+	"sequenceStage":     func() any { return &SequenceStage{} },
+	"analysisStage":     func() any { return &AnalysisStage{} },
+	"throttlerStage":    func() any { return &ThrottlerStage{} },
+	"notificationStage": func() any { return &NotificationStage{} },
+}
+
+// legacyPipelineDataKeys are the Data keys older producers wrote stage data
+// under. The monitor ownership snapshot is deliberately absent: it must come
+// from the stored device, never from the free-form bag.
+var legacyPipelineDataKeys = []legacyPipelineDataKey{
+	{key: "eventData", stage: "eventStage", name: "event", field: "eventData"},
+	{key: "user", stage: "monitorStage", name: "monitor", field: "user"},
+	{key: "subscription", stage: "monitorStage", name: "monitor", field: "subscription"},
+	{key: "plans", stage: "monitorStage", name: "monitor", field: "plans"},
+	{key: "highupload", stage: "monitorStage", name: "monitor", field: "highUpload"},
+	{key: "highUpload", stage: "monitorStage", name: "monitor", field: "highUpload"},
+	{key: "activity", stage: "monitorStage", name: "monitor", field: "activity"},
+	{key: "sequenceId", stage: "sequenceStage", name: "sequence", field: "sequenceId"},
+	{key: "analysisResult", stage: "analysisStage", name: "analysis", field: "analysisResult"},
+	{key: "throttleLimit", stage: "throttlerStage", name: "throttler", field: "throttleLimit"},
+	{key: "notificationType", stage: "notificationStage", name: "notification", field: "notificationType"},
+}
+
+// UpgradePipelineEvent lifts a raw PipelineEvent of any older schema version
+// to PipelineEventSchemaVersion and returns it re-encoded. A current message
+// is returned as is, and so is a message from a newer producer: during a
+// rolling deploy an older consumer decodes what it understands of it, and the
+// newer version is kept so the message is not mistaken for a current one.
+func UpgradePipelineEvent(raw []byte) (json.RawMessage, error) {
+	var header struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, err
+	}
+	if header.SchemaVersion < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrPipelineEventVersion, header.SchemaVersion)
+	}
+	if header.SchemaVersion >= PipelineEventSchemaVersion {
+		return raw, nil
+	}
+
+	// Numbers are kept as json.Number so int64 fields survive the round trip.
+	var message map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&message); err != nil {
+		return nil, err
+	}
+	for version := header.SchemaVersion; version < PipelineEventSchemaVersion; version++ {
+		if err := pipelineEventUpgrades[version](message); err != nil {
+			return nil, fmt.Errorf("upgrade pipeline event from version %d: %w", version, err)
+		}
+	}
+	message["schemaVersion"] = PipelineEventSchemaVersion
+	return json.Marshal(message)
+}
+
+// MarshalPipelineEvent encodes an event for the wire, stamped with its schema
+// version. An unversioned event is upgraded first, so keys a producer still
+// sets in Data reach their typed fields before the message claims the current
+// version.
+func MarshalPipelineEvent(event PipelineEvent) ([]byte, error) {
+	encoded, err := json.Marshal(event)
+	if err != nil || event.SchemaVersion != 0 {
+		return encoded, err
+	}
+	return UpgradePipelineEvent(encoded)
+}
+
+// UnmarshalJSON decodes a PipelineEvent of any schema version, upgrading an
+// older one to the current shape.
+func (pe *PipelineEvent) UnmarshalJSON(data []byte) error {
+	upgraded, err := UpgradePipelineEvent(data)
+	if err != nil {
+		return err
+	}
+	type pipelineEvent PipelineEvent
+	var decoded pipelineEvent
+	if err := json.Unmarshal(upgraded, &decoded); err != nil {
+		return err
+	}
+	*pe = PipelineEvent(decoded)
+	return nil
+}
+
+// upgradePipelineEventFlatPayload (0 → 1) turns a bare file-name payload, or
+// a file name and date carried flat on the event, into a payload object.
+func upgradePipelineEventFlatPayload(message map[string]any) error {
+	var payload map[string]any
+	switch value := message["payload"].(type) {
+	case nil:
+		payload = map[string]any{}
+	case string:
+		payload = map[string]any{"key": value}
+	case map[string]any:
+		payload = value
+	default:
+		return fmt.Errorf("payload is a %T", value)
+	}
+
+	if key, _ := payload["key"].(string); key == "" {
+		if fileName, ok := message["fileName"].(string); ok && fileName != "" {
+			payload["key"] = fileName
+		}
+	}
+	if _, ok := payload["timestamp"]; !ok {
+		if date, ok := message["date"].(json.Number); ok && date != "0" {
+			payload["timestamp"] = date
+		}
+	}
+	if len(payload) > 0 {
+		message["payload"] = payload
+	}
+	return nil
+}
+
+// upgradePipelineEventDataBag (1 → 2) moves legacy Data keys into their stage
+// structs. A key whose stage already has a value, or whose value does not
+// decode into its field's type (a hex sequence id, a quoted throttle limit),
+// stays in Data rather than being dropped or failing the whole message.
+func upgradePipelineEventDataBag(message map[string]any) error {
+	data, ok := message["data"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	for _, legacy := range legacyPipelineDataKeys {
+		value, ok := data[legacy.key]
+		if !ok || !fitsPipelineStageField(legacy, value) {
+			continue
+		}
+		stage, ok := message[legacy.stage].(map[string]any)
+		if !ok {
+			if message[legacy.stage] != nil {
+				return fmt.Errorf("%s is a %T", legacy.stage, message[legacy.stage])
+			}
+			stage = map[string]any{"name": legacy.name}
+			message[legacy.stage] = stage
+		}
+		if _, ok := stage[legacy.field]; ok {
+			continue
+		}
+		stage[legacy.field] = value
+		delete(data, legacy.key)
+	}
+	if len(data) == 0 {
+		delete(message, "data")
+	}
+	return nil
+}
+
+// fitsPipelineStageField reports whether value decodes into the typed field a
+// legacy key moves to.
+func fitsPipelineStageField(legacy legacyPipelineDataKey, value any) bool {
+	encoded, err := json.Marshal(map[string]any{legacy.field: value})
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(encoded, pipelineStageTypes[legacy.stage]()) == nil
+}
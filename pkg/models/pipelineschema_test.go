@@ -0,0 +1,201 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// pipelineEventSample is one file of the synthetic corpus: a hand-written
+// message in a legacy shape, the fields its upgraded form must carry, and the
+// top-level fields it must no longer carry. Messages taken off the queues live
+// in the captured corpus instead.
+type pipelineEventSample struct {
+	Description string          `json:"description"`
+	Message     json.RawMessage `json:"message"`
+	Expect      map[string]any  `json:"expect"`
+	Absent      []string        `json:"absent"`
+}
+
+func TestPipelineEventSyntheticCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "pipelineevents", "synthetic", "*.json"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("corpus = (%v, %v)", files, err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sample pipelineEventSample
+			if err := json.Unmarshal(raw, &sample); err != nil {
+				t.Fatalf("decode sample: %v", err)
+			}
+
+			event, got := decodeCorpusMessage(t, sample.Message)
+			assertJSONSubset(t, "", sample.Expect, got)
+			for _, field := range sample.Absent {
+				if _, ok := got[field]; ok {
+					t.Errorf("%s still present: %v", field, got[field])
+				}
+			}
+			if event.SchemaVersion != PipelineEventSchemaVersion {
+				t.Errorf("schema version = %d, want %d", event.SchemaVersion, PipelineEventSchemaVersion)
+			}
+		})
+	}
+}
+
+// TestPipelineEventCapturedCorpus checks messages taken off the queues (see
+// testdata/pipelineevents/captured/README.md). They carry no expectations of
+// their own, so the test checks that the upgrade loses nothing.
+func TestPipelineEventCapturedCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "pipelineevents", "captured", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skip("no captured messages in testdata/pipelineevents/captured")
+	}
+	stageFields := map[string]legacyPipelineDataKey{}
+	for _, legacy := range legacyPipelineDataKeys {
+		stageFields[legacy.key] = legacy
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var original map[string]any
+			if err := json.Unmarshal(raw, &original); err != nil {
+				t.Fatalf("decode capture: %v", err)
+			}
+
+			event, got := decodeCorpusMessage(t, raw)
+			if version, _ := original["schemaVersion"].(float64); int(version) <= PipelineEventSchemaVersion && event.SchemaVersion != PipelineEventSchemaVersion {
+				t.Errorf("schema version = %d, want %d", event.SchemaVersion, PipelineEventSchemaVersion)
+			}
+			data, _ := original["data"].(map[string]any)
+			for key := range data {
+				if _, ok := event.Data[key]; ok {
+					continue
+				}
+				legacy, ok := stageFields[key]
+				stage, _ := got[legacy.stage].(map[string]any)
+				if !ok || stage[legacy.field] == nil {
+					t.Errorf("data key %q was dropped", key)
+				}
+			}
+			if fileName, ok := original["fileName"].(string); ok && fileName != "" && event.Payload.FileName == "" {
+				t.Errorf("fileName %q did not reach the payload", fileName)
+			}
+		})
+	}
+}
+
+// decodeCorpusMessage decodes a corpus message, checks that decoding its
+// re-encoded form gives the same event, and returns the event with its
+// re-encoded form as generic JSON. Re-encoding the typed event checks the
+// upgrade landed in typed fields rather than surviving only as JSON.
+func decodeCorpusMessage(t *testing.T, message []byte) (PipelineEvent, map[string]any) {
+	t.Helper()
+	var event PipelineEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		t.Fatalf("decode message: %v", err)
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encode event: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatal(err)
+	}
+	var again PipelineEvent
+	if err := json.Unmarshal(encoded, &again); err != nil || !reflect.DeepEqual(again, event) {
+		t.Errorf("re-decode = (%+v, %v), want %+v", again, err, event)
+	}
+	return event, got
+}
+
+func assertJSONSubset(t *testing.T, path string, want, got any) {
+	t.Helper()
+	switch want := want.(type) {
+	case map[string]any:
+		gotMap, ok := got.(map[string]any)
+		if !ok {
+			t.Errorf("%s = %v, want an object", path, got)
+			return
+		}
+		for key, value := range want {
+			assertJSONSubset(t, path+"."+key, value, gotMap[key])
+		}
+	default:
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestUpgradePipelineEventKeepsConflictingData(t *testing.T) {
+	raw := []byte(`{"schemaVersion": 1, "sequenceStage": {"name": "sequence", "sequenceId": 7}, "data": {"sequenceId": 8, "throttleLimit": 3}}`)
+	var event PipelineEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.SequenceStage == nil || event.SequenceStage.SequenceId != 7 || event.ThrottlerStage == nil || event.ThrottlerStage.ThrottleLimit != 3 {
+		t.Errorf("event = %+v", event)
+	}
+	if want := map[string]interface{}{"sequenceId": float64(8)}; !reflect.DeepEqual(event.Data, want) {
+		t.Errorf("data = %v, want %v", event.Data, want)
+	}
+}
+
+func TestUpgradePipelineEventNewerVersion(t *testing.T) {
+	raw := []byte(`{"schemaVersion": 99, "traceId": "t", "sequenceStage": {"name": "sequence", "sequenceId": 7}, "future": {"x": 1}}`)
+	var event PipelineEvent
+	if err := json.Unmarshal(raw, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.SchemaVersion != 99 || event.TraceId != "t" || event.SequenceStage == nil || event.SequenceStage.SequenceId != 7 {
+		t.Errorf("event = %+v", event)
+	}
+
+	if _, err := UpgradePipelineEvent([]byte(`{"schemaVersion": -1}`)); !errors.Is(err, ErrPipelineEventVersion) {
+		t.Errorf("err = %v, want ErrPipelineEventVersion", err)
+	}
+	if err := json.Unmarshal([]byte(`{"payload": 12}`), &event); err == nil {
+		t.Error("numeric payload decoded without error")
+	}
+}
+
+func TestMarshalPipelineEvent(t *testing.T) {
+	legacy := PipelineEvent{TraceId: "t", Data: map[string]interface{}{"sequenceId": 42, "uri": "https://vault.example.com"}}
+	encoded, err := MarshalPipelineEvent(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event PipelineEvent
+	if err := json.Unmarshal(encoded, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.SchemaVersion != PipelineEventSchemaVersion || event.SequenceStage == nil || event.SequenceStage.SequenceId != 42 ||
+		!reflect.DeepEqual(event.Data, map[string]interface{}{"uri": "https://vault.example.com"}) {
+		t.Errorf("event = %+v", event)
+	}
+
+	newer := NewPipelineEvent()
+	newer.SchemaVersion = 99
+	encoded, err = MarshalPipelineEvent(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(encoded, &event); err != nil || event.SchemaVersion != 99 {
+		t.Errorf("newer event = (%+v, %v)", event, err)
+	}
+}
@@ -0,0 +1,213 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A redaction plan is the list of pixel rectangles to mask on every frame of
+// the output, worked out from the tracks of a FaceRedaction. The editor
+// preview and the redaction worker both draw from the same plan, so what the
+// user sees is what gets rendered.
+
+// Default mode parameters, in output pixels.
+const (
+	DefaultRedactionKernelSize = 31
+	DefaultRedactionBlockSize  = 16
+)
+
+// ErrRedactionPlanInvalidOptions is returned for an unknown mode, a missing
+// output size, a rotation that is not a multiple of 90 degrees, or a negative
+// or even kernel, block or extension.
+var ErrRedactionPlanInvalidOptions = errors.New("invalid redaction plan options")
+
+// RedactionPlanOptions describes the output a plan is made for.
+type RedactionPlanOptions struct {
+	Mode RedactionMode `json:"mode" bson:"mode"`
+	// Width and Height are the size of the output frames in pixels.
+	Width  int `json:"width" bson:"width"`
+	Height int `json:"height" bson:"height"`
+	// Rotation is the clockwise rotation, in degrees, a player applies to the
+	// output frames. Track boxes are against the rotated picture, so they are
+	// turned back onto the stored frame.
+	Rotation int `json:"rotation,omitempty" bson:"rotation,omitempty"`
+	// KernelSize is the blur kernel for RedactionModeBlur; it must be odd.
+	// Zero uses DefaultRedactionKernelSize.
+	KernelSize int `json:"kernelSize,omitempty" bson:"kernelSize,omitempty"`
+	// BlockSize is the pixel block edge for RedactionModePixelate. Zero uses
+	// DefaultRedactionBlockSize.
+	BlockSize int `json:"blockSize,omitempty" bson:"blockSize,omitempty"`
+	// ExtendBefore and ExtendAfter keep masking a track's first and last box
+	// for this many frames before it starts and after it ends, covering a
+	// subject the detector picked up late or lost early.
+	ExtendBefore int64 `json:"extendBefore,omitempty" bson:"extendBefore,omitempty"`
+	ExtendAfter  int64 `json:"extendAfter,omitempty" bson:"extendAfter,omitempty"`
+	// FrameCount bounds ExtendAfter; zero leaves it unbounded.
+	FrameCount int64 `json:"frameCount,omitempty" bson:"frameCount,omitempty"`
+	// AllTracks plans every track rather than only the Selected ones.
+	AllTracks bool `json:"allTracks,omitempty" bson:"allTracks,omitempty"`
+}
+
+// RedactionRect is a region to mask, in output pixels.
+type RedactionRect struct {
+	TrackId string `json:"trackId" bson:"trackId"`
+	X       int    `json:"x" bson:"x"`
+	Y       int    `json:"y" bson:"y"`
+	Width   int    `json:"width" bson:"width"`
+	Height  int    `json:"height" bson:"height"`
+}
+
+// RedactionFrame lists the regions to mask on one frame.
+type RedactionFrame struct {
+	Frame int64           `json:"frame" bson:"frame"`
+	Rects []RedactionRect `json:"rects" bson:"rects"`
+}
+
+// RedactionSpan is the run-length form of a plan: one rectangle masked on
+// every frame from Start to End inclusive.
+type RedactionSpan struct {
+	Start int64         `json:"start" bson:"start"`
+	End   int64         `json:"end" bson:"end"`
+	Rect  RedactionRect `json:"rect" bson:"rect"`
+}
+
+// RedactionPlan is the per-frame mask list with the resolved mode parameters.
+type RedactionPlan struct {
+	Mode       RedactionMode    `json:"mode" bson:"mode"`
+	Width      int              `json:"width" bson:"width"`
+	Height     int              `json:"height" bson:"height"`
+	KernelSize int              `json:"kernelSize,omitempty" bson:"kernelSize,omitempty"`
+	BlockSize  int              `json:"blockSize,omitempty" bson:"blockSize,omitempty"`
+	Frames     []RedactionFrame `json:"frames" bson:"frames"`
+}
+
+// PlanRedaction expands tracks into a redaction plan. Deleted frames are never
+// masked, not even by an extension. Boxes are scaled to the output, rounded
+// outwards so the mask covers the whole box, and clamped to the frame. Frames
+// are in order, and within a frame rectangles follow the order of tracks.
+func PlanRedaction(tracks []FaceRedactionTrack, options RedactionPlanOptions) (RedactionPlan, error) {
+	plan := RedactionPlan{Mode: options.Mode, Width: options.Width, Height: options.Height, Frames: []RedactionFrame{}}
+	switch options.Mode {
+	case RedactionModeBlur:
+		plan.KernelSize = options.KernelSize
+		if plan.KernelSize == 0 {
+			plan.KernelSize = DefaultRedactionKernelSize
+		}
+		if plan.KernelSize < 0 || plan.KernelSize%2 == 0 {
+			return RedactionPlan{}, fmt.Errorf("%w: kernel size %d is not a positive odd number", ErrRedactionPlanInvalidOptions, options.KernelSize)
+		}
+	case RedactionModePixelate:
+		plan.BlockSize = options.BlockSize
+		if plan.BlockSize == 0 {
+			plan.BlockSize = DefaultRedactionBlockSize
+		}
+		if plan.BlockSize < 0 {
+			return RedactionPlan{}, fmt.Errorf("%w: block size %d", ErrRedactionPlanInvalidOptions, options.BlockSize)
+		}
+	case RedactionModeBlack:
+	default:
+		return RedactionPlan{}, fmt.Errorf("%w: unknown mode %q", ErrRedactionPlanInvalidOptions, options.Mode)
+	}
+	if options.Width <= 0 || options.Height <= 0 {
+		return RedactionPlan{}, fmt.Errorf("%w: output size %dx%d", ErrRedactionPlanInvalidOptions, options.Width, options.Height)
+	}
+	rotation := ((options.Rotation % 360) + 360) % 360
+	if rotation%90 != 0 {
+		return RedactionPlan{}, fmt.Errorf("%w: rotation %d is not a multiple of 90", ErrRedactionPlanInvalidOptions, options.Rotation)
+	}
+	if options.ExtendBefore < 0 || options.ExtendAfter < 0 {
+		return RedactionPlan{}, fmt.Errorf("%w: negative extension", ErrRedactionPlanInvalidOptions)
+	}
+
+	byFrame := map[int64][]RedactionRect{}
+	for _, track := range tracks {
+		if !track.Selected && !options.AllTracks {
+			continue
+		}
+		if len(track.FrameCoordinates) == 0 {
+			track = track.Clone()
+			track.FrameCoordinatesFromTraject()
+		}
+		frames := track.activeFrames()
+		if len(frames) == 0 {
+			continue
+		}
+		add := func(frame int64, box TrackBox) {
+			if track.IsDeleted(frame) {
+				return
+			}
+			if rect, ok := options.rect(track.Id, box, rotation); ok {
+				byFrame[frame] = append(byFrame[frame], rect)
+			}
+		}
+
+		first, last := frames[0], frames[len(frames)-1]
+		for frame := first - options.ExtendBefore; frame < first; frame++ {
+			if frame >= 0 {
+				add(frame, track.FrameCoordinates[first])
+			}
+		}
+		for _, frame := range frames {
+			add(frame, track.FrameCoordinates[frame])
+		}
+		for frame := last + 1; frame <= last+options.ExtendAfter; frame++ {
+			if options.FrameCount > 0 && frame >= options.FrameCount {
+				break
+			}
+			add(frame, track.FrameCoordinates[last])
+		}
+	}
+
+	frames := make([]int64, 0, len(byFrame))
+	for frame := range byFrame {
+		frames = append(frames, frame)
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+	for _, frame := range frames {
+		plan.Frames = append(plan.Frames, RedactionFrame{Frame: frame, Rects: byFrame[frame]})
+	}
+	return plan, nil
+}
+
+// rect turns a normalised box against the rotated picture into a pixel
+// rectangle on the output frame; false when nothing of it is on the frame.
+func (options RedactionPlanOptions) rect(trackId string, box TrackBox, rotation int) (RedactionRect, bool) {
+	box = box.Rotate(-rotation)
+	x1, y1, x2, y2 := box.X1, box.Y1, box.X2, box.Y2
+	// The tolerance keeps float noise such as 0.7*100 = 70.00000000000001 from
+	// growing a mask by a pixel.
+	const eps = 1e-9
+	width, height := float64(options.Width), float64(options.Height)
+	left := int(math.Max(math.Floor(x1*width+eps), 0))
+	top := int(math.Max(math.Floor(y1*height+eps), 0))
+	right := int(math.Min(math.Ceil(x2*width-eps), width))
+	bottom := int(math.Min(math.Ceil(y2*height-eps), height))
+	if right <= left || bottom <= top {
+		return RedactionRect{}, false
+	}
+	return RedactionRect{TrackId: trackId, X: left, Y: top, Width: right - left, Height: bottom - top}, true
+}
+
+// Spans returns the plan in run-length form: each track's rectangle over
+// consecutive frames where it does not change becomes one span. Spans are
+// ordered by start frame, then by the order of the rectangles within it.
+func (p RedactionPlan) Spans() []RedactionSpan {
+	spans := []RedactionSpan{}
+	open := map[string]int{}
+	for _, frame := range p.Frames {
+		next := map[string]int{}
+		for _, rect := range frame.Rects {
+			if i, ok := open[rect.TrackId]; ok && spans[i].Rect == rect && spans[i].End == frame.Frame-1 {
+				spans[i].End = frame.Frame
+				next[rect.TrackId] = i
+				continue
+			}
+			spans = append(spans, RedactionSpan{Start: frame.Frame, End: frame.Frame, Rect: rect})
+			next[rect.TrackId] = len(spans) - 1
+		}
+		open = next
+	}
+	return spans
+}
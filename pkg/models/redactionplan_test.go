@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPlanRedaction(t *testing.T) {
+	face := TrackBox{X1: 0.1, Y1: 0.2, X2: 0.3, Y2: 0.6}
+	selected := trackWith(map[int64]TrackBox{
+		2: face,
+		3: face,
+		4: face,
+		6: {X1: 0.5, Y1: 0.5, X2: 0.7, Y2: 0.9},
+	}, 1)
+	selected.Selected = true
+	other := trackWith(map[int64]TrackBox{0: {X2: 1, Y2: 1}})
+	other.Id = "other"
+
+	plan, err := PlanRedaction([]FaceRedactionTrack{selected, other}, RedactionPlanOptions{
+		Mode:         RedactionModeBlur,
+		Width:        100,
+		Height:       50,
+		ExtendBefore: 2,
+		ExtendAfter:  1,
+		FrameCount:   7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.KernelSize != DefaultRedactionKernelSize || plan.BlockSize != 0 {
+		t.Errorf("parameters = %d/%d", plan.KernelSize, plan.BlockSize)
+	}
+
+	first := RedactionRect{TrackId: "t", X: 10, Y: 10, Width: 20, Height: 20}
+	second := RedactionRect{TrackId: "t", X: 50, Y: 25, Width: 20, Height: 20}
+	// Frame 1 is deleted, so the extension before frame 2 only reaches frame
+	// 0; the recording ends before the extension after frame 6.
+	wantFrames := []RedactionFrame{
+		{Frame: 0, Rects: []RedactionRect{first}},
+		{Frame: 2, Rects: []RedactionRect{first}},
+		{Frame: 3, Rects: []RedactionRect{first}},
+		{Frame: 4, Rects: []RedactionRect{first}},
+		{Frame: 6, Rects: []RedactionRect{second}},
+	}
+	if !reflect.DeepEqual(plan.Frames, wantFrames) {
+		t.Errorf("frames = %+v, want %+v", plan.Frames, wantFrames)
+	}
+	wantSpans := []RedactionSpan{{0, 0, first}, {2, 4, first}, {6, 6, second}}
+	if spans := plan.Spans(); !reflect.DeepEqual(spans, wantSpans) {
+		t.Errorf("spans = %+v, want %+v", spans, wantSpans)
+	}
+
+	all, err := PlanRedaction([]FaceRedactionTrack{selected, other}, RedactionPlanOptions{Mode: RedactionModePixelate, Width: 100, Height: 50, AllTracks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.BlockSize != DefaultRedactionBlockSize || all.Frames[0].Rects[0] != (RedactionRect{TrackId: "other", Width: 100, Height: 50}) {
+		t.Errorf("plan = %+v", all)
+	}
+}
+
+func TestPlanRedactionRotation(t *testing.T) {
+	track := trackWith(map[int64]TrackBox{0: {X1: 0, Y1: 0, X2: 0.2, Y2: 0.1}})
+	tests := []struct {
+		rotation int
+		want     RedactionRect
+	}{
+		{0, RedactionRect{TrackId: "t", X: 0, Y: 0, Width: 20, Height: 5}},
+		// Turned back a quarter clockwise, the top-left corner of the picture is
+		// the bottom-left of the stored frame.
+		{90, RedactionRect{TrackId: "t", X: 0, Y: 40, Width: 10, Height: 10}},
+		{180, RedactionRect{TrackId: "t", X: 80, Y: 45, Width: 20, Height: 5}},
+		{-90, RedactionRect{TrackId: "t", X: 90, Y: 0, Width: 10, Height: 10}},
+	}
+	for _, tt := range tests {
+		plan, err := PlanRedaction([]FaceRedactionTrack{track}, RedactionPlanOptions{Mode: RedactionModeBlack, Width: 100, Height: 50, Rotation: tt.rotation, AllTracks: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := plan.Frames[0].Rects[0]; got != tt.want {
+			t.Errorf("rotation %d: rect = %+v, want %+v", tt.rotation, got, tt.want)
+		}
+	}
+}
+
+func TestPlanRedactionInvalidOptions(t *testing.T) {
+	for name, options := range map[string]RedactionPlanOptions{
+		"mode":     {Mode: "smudge", Width: 10, Height: 10},
+		"size":     {Mode: RedactionModeBlack},
+		"kernel":   {Mode: RedactionModeBlur, Width: 10, Height: 10, KernelSize: 8},
+		"rotation": {Mode: RedactionModeBlack, Width: 10, Height: 10, Rotation: 45},
+		"extend":   {Mode: RedactionModeBlack, Width: 10, Height: 10, ExtendAfter: -1},
+	} {
+		if _, err := PlanRedaction(nil, options); !errors.Is(err, ErrRedactionPlanInvalidOptions) {
+			t.Errorf("%s: err = %v, want ErrRedactionPlanInvalidOptions", name, err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention is spread over several documents: the plan's DayLimit, the
+// account's CustomDayLimit, a case's RetentionDays/ExpiresAt/LegalHold, and the
+// RecordingTimestamp denormalised onto runs so they expire on their
+// recording's clock. RetentionPolicy reads them all in one fixed order and
+// returns a RetentionDecision naming the rule that decided, so the cleanup
+// sweep and the "expires in N days" badge cannot disagree.
+
+// RetentionSubject is the kind of artifact a decision is about.
+type RetentionSubject string
+
+const (
+	RetentionMedia        RetentionSubject = "media"
+	RetentionAnalysis     RetentionSubject = "analysis"
+	RetentionDetectionRun RetentionSubject = "detectionRun"
+	RetentionWorkflowRun  RetentionSubject = "workflowRun"
+	RetentionCase         RetentionSubject = "case"
+)
+
+// RetentionRule names the rule that decided an expiry.
+type RetentionRule string
+
+const (
+	// RetentionRuleLegalHold: the case, or a case pinning the artifact, is
+	// under legal hold; nothing expires.
+	RetentionRuleLegalHold RetentionRule = "legalHold"
+	// RetentionRuleCaseOverride: a user set the case's ExpiresAt by hand.
+	RetentionRuleCaseOverride RetentionRule = "caseOverride"
+	// RetentionRuleCaseRetentionDays: the case's own RetentionDays.
+	RetentionRuleCaseRetentionDays RetentionRule = "caseRetentionDays"
+	// RetentionRuleCaseExpiresAt: the case's materialised ExpiresAt, for
+	// cases without RetentionDays.
+	RetentionRuleCaseExpiresAt RetentionRule = "caseExpiresAt"
+	// RetentionRuleCaseDefault: the policy's default case retention.
+	RetentionRuleCaseDefault RetentionRule = "caseDefault"
+	// RetentionRuleCasePinned: a case referencing the artifact outlives its
+	// account retention, so the artifact follows the case.
+	RetentionRuleCasePinned RetentionRule = "casePinned"
+	// RetentionRuleCustomDayLimit: the account's CustomDayLimit.
+	RetentionRuleCustomDayLimit RetentionRule = "customDayLimit"
+	// RetentionRulePlanDayLimit: the account plan's DayLimit.
+	RetentionRulePlanDayLimit RetentionRule = "planDayLimit"
+	// RetentionRuleUnlimited: no rule bounds the artifact.
+	RetentionRuleUnlimited RetentionRule = "unlimited"
+)
+
+// secondsPerDay is the length of a retention day. Retention counts whole
+// 24-hour days from the anchor, not calendar days.
+const secondsPerDay = 24 * 60 * 60
+
+// RetentionDecision is when an artifact expires and why. Times are unix
+// seconds.
+type RetentionDecision struct {
+	Subject RetentionSubject `json:"subject" bson:"subject"`
+	// Anchor is the start of the retention clock: the recording's start for
+	// media and everything derived from it, the close (or, while open, the
+	// creation date) for a case.
+	Anchor int64 `json:"anchor" bson:"anchor"`
+	// ExpiresAt is nil when the artifact never expires.
+	ExpiresAt *int64        `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	Rule      RetentionRule `json:"rule" bson:"rule"`
+	// Days is the day count the deciding rule applied, when it counts days.
+	Days int `json:"days,omitempty" bson:"days,omitempty"`
+	// CaseId is the case whose rule decided, for case and pinned decisions.
+	CaseId string `json:"caseId,omitempty" bson:"caseId,omitempty"`
+	// Reason explains the decision in a sentence, for logs and audits.
+	Reason string `json:"reason" bson:"reason"`
+}
+
+// Expired reports whether cleanup may purge the artifact at now.
+func (d RetentionDecision) Expired(now time.Time) bool {
+	return d.ExpiresAt != nil && now.Unix() >= *d.ExpiresAt
+}
+
+// DaysLeft is the number of days, rounded up, until the artifact expires; zero
+// once expired. ok is false when it never expires.
+func (d RetentionDecision) DaysLeft(now time.Time) (days int, ok bool) {
+	if d.ExpiresAt == nil {
+		return 0, false
+	}
+	left := *d.ExpiresAt - now.Unix()
+	if left <= 0 {
+		return 0, true
+	}
+	return int((left + secondsPerDay - 1) / secondsPerDay), true
+}
+
+// RetentionPolicy computes expiries. The zero value uses DefaultPlans and keeps
+// cases without a retention of their own forever.
+type RetentionPolicy struct {
+	// Plans resolves User.Plan to its DayLimit. Nil uses DefaultPlans.
+	Plans Plans
+	// DefaultCaseRetentionDays is the workspace default for cases whose
+	// RetentionDays is nil. Nil keeps such cases forever.
+	DefaultCaseRetentionDays *int
+}
+
+// AccountDayLimit returns the retention in days of an account's recordings and
+// the rule it comes from: the account's CustomDayLimit, else its plan's
+// DayLimit. Zero days means unlimited.
+func (p RetentionPolicy) AccountDayLimit(user User) (int, RetentionRule) {
+	if user.CustomDayLimit > 0 {
+		return user.CustomDayLimit, RetentionRuleCustomDayLimit
+	}
+	plans := p.Plans
+	if plans == nil {
+		plans = DefaultPlans
+	}
+	if plan, ok := plans[user.Plan]; ok && plan.DayLimit > 0 {
+		return plan.DayLimit, RetentionRulePlanDayLimit
+	}
+	return 0, RetentionRuleUnlimited
+}
+
+// Case decides a case's expiry. In order: legal hold keeps it forever; a hand
+// edited ExpiresAt stands; RetentionDays counts from the anchor; a
+// materialised ExpiresAt without RetentionDays stands; the policy default
+// counts from the anchor; otherwise the case is kept. The anchor is ClosedAt,
+// or CreationDate while the case is open.
+func (p RetentionPolicy) Case(task Task) RetentionDecision {
+	anchor := task.ClosedAt
+	if anchor == 0 {
+		anchor = task.CreationDate
+	}
+	decision := RetentionDecision{Subject: RetentionCase, Anchor: anchor, CaseId: caseId(task)}
+	switch {
+	case task.LegalHold:
+		decision.Rule = RetentionRuleLegalHold
+		decision.Reason = "case is under legal hold"
+	case task.ExpiresAtOverridden && task.ExpiresAt != nil:
+		decision.Rule = RetentionRuleCaseOverride
+		decision.ExpiresAt = int64Ptr(*task.ExpiresAt)
+		decision.Reason = "case expiry was set by hand"
+	case task.RetentionDays != nil:
+		decision.Rule = RetentionRuleCaseRetentionDays
+		decision.Days = *task.RetentionDays
+		decision.ExpiresAt = int64Ptr(anchor + int64(decision.Days)*secondsPerDay)
+		decision.Reason = fmt.Sprintf("case retention of %d days", decision.Days)
+	case task.ExpiresAt != nil:
+		decision.Rule = RetentionRuleCaseExpiresAt
+		decision.ExpiresAt = int64Ptr(*task.ExpiresAt)
+		decision.Reason = "case expiry as stored"
+	case p.DefaultCaseRetentionDays != nil:
+		decision.Rule = RetentionRuleCaseDefault
+		decision.Days = *p.DefaultCaseRetentionDays
+		decision.ExpiresAt = int64Ptr(anchor + int64(decision.Days)*secondsPerDay)
+		decision.Reason = fmt.Sprintf("default case retention of %d days", decision.Days)
+	default:
+		decision.Rule = RetentionRuleUnlimited
+		decision.Reason = "case has no retention"
+	}
+	return decision
+}
+
+// MaterializeCase writes the case's policy expiry into ExpiresAt, as the write
+// path does on every change. A hand-set ExpiresAt is left alone.
+func (p RetentionPolicy) MaterializeCase(task *Task) {
+	if task.ExpiresAtOverridden {
+		return
+	}
+	task.ExpiresAt = nil
+	decision := p.Case(*task)
+	if decision.Rule != RetentionRuleLegalHold && decision.ExpiresAt != nil {
+		task.ExpiresAt = int64Ptr(*decision.ExpiresAt)
+	}
+}
+
+// Media decides a recording's expiry: the account's day limit counted from
+// the recording's start, extended by any case in cases that references it.
+func (p RetentionPolicy) Media(media Media, user User, cases []Task) RetentionDecision {
+	return p.recording(RetentionMedia, media.StartTimestamp, user, cases)
+}
+
+// Analysis decides an analysis's expiry on its recording's clock.
+func (p RetentionPolicy) Analysis(analysis AnalysisWrapper, user User, cases []Task) RetentionDecision {
+	anchor := analysis.Timestamp
+	if anchor == 0 {
+		anchor = analysis.Start
+	}
+	return p.recording(RetentionAnalysis, anchor, user, cases)
+}
+
+// DetectionRun decides a detection run's expiry on its recording's clock
+// (RecordingTimestamp), falling back to when the run was created.
+func (p RetentionPolicy) DetectionRun(run DetectionRun, user User, cases []Task) RetentionDecision {
+	anchor := run.RecordingTimestamp
+	if anchor == 0 {
+		anchor = run.CreatedAt / 1000
+	}
+	return p.recording(RetentionDetectionRun, anchor, user, cases)
+}
+
+// WorkflowRun decides a workflow run's expiry on its recording's clock
+// (RecordingTimestamp), falling back to when the run started.
+func (p RetentionPolicy) WorkflowRun(run WorkflowRun, user User, cases []Task) RetentionDecision {
+	anchor := run.RecordingTimestamp
+	if anchor == 0 {
+		anchor = run.Start
+	}
+	return p.recording(RetentionWorkflowRun, anchor, user, cases)
+}
+
+// recording decides the expiry of a recording or an artifact derived from
+// it. Cases only ever extend the account retention: the artifact lives as long
+// as the longest-lived case referencing it, and forever while any of them is
+// held or never expires.
+func (p RetentionPolicy) recording(subject RetentionSubject, anchor int64, user User, cases []Task) RetentionDecision {
+	days, rule := p.AccountDayLimit(user)
+	decision := RetentionDecision{Subject: subject, Anchor: anchor, Rule: rule, Days: days}
+	switch rule {
+	case RetentionRuleUnlimited:
+		decision.Reason = "account has no retention limit"
+		return decision
+	case RetentionRuleCustomDayLimit:
+		decision.Reason = fmt.Sprintf("account retention of %d days", days)
+	default:
+		decision.Reason = fmt.Sprintf("plan %q retention of %d days", user.Plan, days)
+	}
+	decision.ExpiresAt = int64Ptr(anchor + int64(days)*secondsPerDay)
+
+	for _, task := range cases {
+		pin := p.Case(task)
+		switch {
+		case decision.ExpiresAt == nil && (decision.Rule == RetentionRuleLegalHold || pin.Rule != RetentionRuleLegalHold):
+			// Already kept forever, and a legal hold explains it best.
+			continue
+		case pin.ExpiresAt != nil && decision.ExpiresAt != nil && *pin.ExpiresAt <= *decision.ExpiresAt:
+			continue
+		case pin.ExpiresAt != nil && decision.ExpiresAt == nil:
+			continue
+		}
+		decision.Rule = RetentionRuleCasePinned
+		if pin.Rule == RetentionRuleLegalHold {
+			decision.Rule = RetentionRuleLegalHold
+		}
+		decision.Days = 0
+		decision.CaseId = pin.CaseId
+		decision.ExpiresAt = pin.ExpiresAt
+		decision.Reason = fmt.Sprintf("pinned by case %s: %s", pin.CaseId, pin.Reason)
+	}
+	return decision
+}
+
+func caseId(task Task) string {
+	if task.Id.IsZero() {
+		return ""
+	}
+	return task.Id.Hex()
+}
+
+func int64Ptr(v int64) *int64 { return &v }
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRetentionCase(t *testing.T) {
+	days := func(n int) *int { return &n }
+	const created = 1700000000
+	policy := RetentionPolicy{DefaultCaseRetentionDays: days(90)}
+
+	tests := []struct {
+		name    string
+		task    Task
+		policy  RetentionPolicy
+		rule    RetentionRule
+		expires *int64
+	}{
+		{
+			name:   "legal hold beats everything",
+			task:   Task{CreationDate: created, LegalHold: true, RetentionDays: days(1), ExpiresAt: int64Ptr(created), ExpiresAtOverridden: true},
+			policy: policy,
+			rule:   RetentionRuleLegalHold,
+		},
+		{
+			name:    "hand-set expiry stands",
+			task:    Task{CreationDate: created, RetentionDays: days(1), ExpiresAt: int64Ptr(created + 500), ExpiresAtOverridden: true},
+			policy:  policy,
+			rule:    RetentionRuleCaseOverride,
+			expires: int64Ptr(created + 500),
+		},
+		{
+			name:    "retention days",
+			task:    Task{CreationDate: created, RetentionDays: days(10), ExpiresAt: int64Ptr(created + 500)},
+			policy:  policy,
+			rule:    RetentionRuleCaseRetentionDays,
+			expires: int64Ptr(created + 10*secondsPerDay),
+		},
+		{
+			name:    "zero days deletes on the next sweep",
+			task:    Task{CreationDate: created, RetentionDays: days(0)},
+			policy:  policy,
+			rule:    RetentionRuleCaseRetentionDays,
+			expires: int64Ptr(created),
+		},
+		{
+			name:    "retention days count from the close",
+			task:    Task{CreationDate: created, ClosedAt: created + 1000, RetentionDays: days(10)},
+			policy:  policy,
+			rule:    RetentionRuleCaseRetentionDays,
+			expires: int64Ptr(created + 1000 + 10*secondsPerDay),
+		},
+		{
+			name:    "stored expiry without retention days",
+			task:    Task{CreationDate: created, ExpiresAt: int64Ptr(created + 500)},
+			policy:  policy,
+			rule:    RetentionRuleCaseExpiresAt,
+			expires: int64Ptr(created + 500),
+		},
+		{
+			name:    "workspace default",
+			task:    Task{CreationDate: created},
+			policy:  policy,
+			rule:    RetentionRuleCaseDefault,
+			expires: int64Ptr(created + 90*secondsPerDay),
+		},
+		{
+			name:    "workspace default counts from the close",
+			task:    Task{CreationDate: created, ClosedAt: created + 1000},
+			policy:  policy,
+			rule:    RetentionRuleCaseDefault,
+			expires: int64Ptr(created + 1000 + 90*secondsPerDay),
+		},
+		{
+			name: "kept without any rule",
+			task: Task{CreationDate: created},
+			rule: RetentionRuleUnlimited,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := tt.policy.Case(tt.task)
+			if decision.Rule != tt.rule || !equalInt64Ptr(decision.ExpiresAt, tt.expires) || decision.Reason == "" {
+				t.Errorf("decision = %+v, want rule %s expiring %v", decision, tt.rule, tt.expires)
+			}
+			anchor := tt.task.CreationDate
+			if tt.task.ClosedAt != 0 {
+				anchor = tt.task.ClosedAt
+			}
+			if decision.Anchor != anchor {
+				t.Errorf("anchor = %d, want %d", decision.Anchor, anchor)
+			}
+		})
+	}
+}
+
+func TestRetentionMaterializeCase(t *testing.T) {
+	days := 5
+	task := Task{CreationDate: 1000, RetentionDays: &days}
+	RetentionPolicy{}.MaterializeCase(&task)
+	if task.ExpiresAt == nil || *task.ExpiresAt != 1000+5*secondsPerDay {
+		t.Errorf("expires at = %v", task.ExpiresAt)
+	}
+
+	task.ExpiresAt, task.ExpiresAtOverridden = int64Ptr(42), true
+	RetentionPolicy{}.MaterializeCase(&task)
+	if *task.ExpiresAt != 42 {
+		t.Errorf("override was rewritten to %d", *task.ExpiresAt)
+	}
+
+	held := Task{CreationDate: 1000, RetentionDays: &days, LegalHold: true, ExpiresAt: int64Ptr(7)}
+	RetentionPolicy{}.MaterializeCase(&held)
+	if held.ExpiresAt != nil {
+		t.Errorf("held case expires at %d", *held.ExpiresAt)
+	}
+}
+
+func TestRetentionRecordings(t *testing.T) {
+	const recorded = 1700000000
+	policy := RetentionPolicy{}
+	basic := User{Plan: "basic"} // DayLimit 3
+	custom := User{Plan: "basic", CustomDayLimit: 14}
+
+	caseDays := 30
+	longCase := Task{Id: primitive.NewObjectID(), CreationDate: recorded + 100, RetentionDays: &caseDays}
+	shortDays := 1
+	shortCase := Task{Id: primitive.NewObjectID(), CreationDate: recorded, RetentionDays: &shortDays}
+	heldCase := Task{Id: primitive.NewObjectID(), CreationDate: recorded, LegalHold: true}
+	openCase := Task{Id: primitive.NewObjectID(), CreationDate: recorded}
+
+	tests := []struct {
+		name     string
+		decision RetentionDecision
+		rule     RetentionRule
+		expires  *int64
+		caseId   string
+	}{
+		{
+			name:     "plan limit",
+			decision: policy.Media(Media{StartTimestamp: recorded}, basic, nil),
+			rule:     RetentionRulePlanDayLimit,
+			expires:  int64Ptr(recorded + 3*secondsPerDay),
+		},
+		{
+			name:     "custom limit wins over plan",
+			decision: policy.Media(Media{StartTimestamp: recorded}, custom, nil),
+			rule:     RetentionRuleCustomDayLimit,
+			expires:  int64Ptr(recorded + 14*secondsPerDay),
+		},
+		{
+			name:     "unknown plan is unlimited",
+			decision: policy.Media(Media{StartTimestamp: recorded}, User{Plan: "nope"}, []Task{shortCase}),
+			rule:     RetentionRuleUnlimited,
+		},
+		{
+			name:     "case pins beyond the plan",
+			decision: policy.Media(Media{StartTimestamp: recorded}, basic, []Task{shortCase, longCase}),
+			rule:     RetentionRuleCasePinned,
+			expires:  int64Ptr(recorded + 100 + 30*secondsPerDay),
+			caseId:   longCase.Id.Hex(),
+		},
+		{
+			name:     "shorter case never shortens",
+			decision: policy.Media(Media{StartTimestamp: recorded}, custom, []Task{shortCase}),
+			rule:     RetentionRuleCustomDayLimit,
+			expires:  int64Ptr(recorded + 14*secondsPerDay),
+		},
+		{
+			name:     "legal hold explains a kept recording",
+			decision: policy.Media(Media{StartTimestamp: recorded}, basic, []Task{openCase, heldCase, longCase}),
+			rule:     RetentionRuleLegalHold,
+			caseId:   heldCase.Id.Hex(),
+		},
+		{
+			name:     "detection run on the recording clock",
+			decision: policy.DetectionRun(DetectionRun{RecordingTimestamp: recorded, CreatedAt: (recorded + 5000) * 1000}, basic, nil),
+			rule:     RetentionRulePlanDayLimit,
+			expires:  int64Ptr(recorded + 3*secondsPerDay),
+		},
+		{
+			name:     "detection run without recording time",
+			decision: policy.DetectionRun(DetectionRun{CreatedAt: (recorded + 5000) * 1000}, basic, nil),
+			rule:     RetentionRulePlanDayLimit,
+			expires:  int64Ptr(recorded + 5000 + 3*secondsPerDay),
+		},
+		{
+			name:     "workflow run pinned by a case",
+			decision: policy.WorkflowRun(WorkflowRun{RecordingTimestamp: recorded, Start: recorded + 60}, basic, []Task{longCase}),
+			rule:     RetentionRuleCasePinned,
+			expires:  int64Ptr(recorded + 100 + 30*secondsPerDay),
+			caseId:   longCase.Id.Hex(),
+		},
+		{
+			name:     "analysis",
+			decision: policy.Analysis(AnalysisWrapper{Timestamp: recorded}, basic, nil),
+			rule:     RetentionRulePlanDayLimit,
+			expires:  int64Ptr(recorded + 3*secondsPerDay),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.decision
+			if d.Rule != tt.rule || !equalInt64Ptr(d.ExpiresAt, tt.expires) || d.CaseId != tt.caseId || d.Reason == "" {
+				t.Errorf("decision = %+v, want rule %s expiring %v by case %q", d, tt.rule, tt.expires, tt.caseId)
+			}
+		})
+	}
+}
+
+func TestRetentionDecisionDaysLeft(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	decision := RetentionDecision{ExpiresAt: int64Ptr(now.Unix() + secondsPerDay + 1)}
+	if days, ok := decision.DaysLeft(now); !ok || days != 2 || decision.Expired(now) {
+		t.Errorf("days left = %d %v", days, ok)
+	}
+	if days, _ := decision.DaysLeft(now.Add(72 * time.Hour)); days != 0 || !decision.Expired(now.Add(72*time.Hour)) {
+		t.Errorf("expired decision has %d days left", days)
+	}
+	if _, ok := (RetentionDecision{}).DaysLeft(now); ok {
+		t.Error("a decision without expiry reports days left")
+	}
+}
+
+func equalInt64Ptr(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
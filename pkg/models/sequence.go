@@ -2,6 +2,9 @@ package models
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Sequence is a burst of activity: the media recorded by one device or site
+// with no inactivity gap between them. The sequence stage produces them with a
+// SequenceBuilder so every service agrees on the boundaries.
 type Sequence struct {
 	Id             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	OrganisationId string             `json:"organisationId" bson:"organisationId,omitempty"`
@@ -0,0 +1,343 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SequenceGrouping selects the stream a SequenceBuilder measures inactivity on.
+type SequenceGrouping string
+
+const (
+	// SequencePerDevice opens one sequence per device: a quiet device closes
+	// its sequence even while other devices on the site keep recording.
+	SequencePerDevice SequenceGrouping = "device"
+	// SequencePerSite opens one sequence per site: recordings from every device
+	// on the site extend the same sequence. Media without a site fall back to
+	// per-device grouping.
+	SequencePerSite SequenceGrouping = "site"
+)
+
+var (
+	// ErrSequenceOutOfOrder is returned when media is added with a start time
+	// before the media added previously.
+	ErrSequenceOutOfOrder = errors.New("media is out of time order")
+	// ErrSequenceInvalidOptions is returned for negative gaps or durations, or
+	// an unknown grouping.
+	ErrSequenceInvalidOptions = errors.New("invalid sequence builder options")
+)
+
+// SequenceBuilderOptions are the grouping rule. Times are in seconds, like
+// Media.StartTimestamp and Sequence.Start/End.
+type SequenceBuilderOptions struct {
+	// Grouping defaults to SequencePerDevice.
+	Grouping SequenceGrouping
+	// InactivityGap closes a sequence when no media of its group started within
+	// this many seconds of the sequence's end. Zero closes on any gap.
+	InactivityGap int64
+	// MaxDuration closes a sequence before media that would stretch it beyond
+	// this many seconds from its start; that media opens the next sequence.
+	// Zero is unbounded.
+	MaxDuration int64
+	// MergeSiteDevices merges per-device sequences on the same site whose time
+	// ranges overlap into one sequence. Unlike SequencePerSite, each device's
+	// boundaries are decided on its own activity first, so a merged sequence can
+	// be longer than MaxDuration.
+	MergeSiteDevices bool
+}
+
+// SequenceBuilder groups a time-ordered stream of Media into Sequences. It is
+// pure: no clock, no I/O, and emission order is fixed, so every service fed the
+// same media produces identical sequence boundaries.
+//
+// Sequences never cross an organisation or project: media is grouped under the
+// project ResolveProjectId resolves for it, and that project is stamped on the
+// sequence.
+type SequenceBuilder struct {
+	options SequenceBuilderOptions
+	open    map[string]*Sequence
+	// pending holds closed per-device sequences waiting for overlapping open
+	// sequences on their site to close (MergeSiteDevices only).
+	pending map[string][]Sequence
+	last    int64
+	started bool
+}
+
+// NewSequenceBuilder returns a builder for options.
+func NewSequenceBuilder(options SequenceBuilderOptions) (*SequenceBuilder, error) {
+	if options.Grouping == "" {
+		options.Grouping = SequencePerDevice
+	}
+	if options.Grouping != SequencePerDevice && options.Grouping != SequencePerSite {
+		return nil, fmt.Errorf("%w: grouping %q", ErrSequenceInvalidOptions, options.Grouping)
+	}
+	if options.InactivityGap < 0 || options.MaxDuration < 0 {
+		return nil, fmt.Errorf("%w: negative gap or duration", ErrSequenceInvalidOptions)
+	}
+	return &SequenceBuilder{
+		options: options,
+		open:    map[string]*Sequence{},
+		pending: map[string][]Sequence{},
+	}, nil
+}
+
+// BuildSequences groups media, which must be in time order, and returns every
+// sequence, closed.
+func BuildSequences(media []Media, options SequenceBuilderOptions) ([]Sequence, error) {
+	builder, err := NewSequenceBuilder(options)
+	if err != nil {
+		return nil, err
+	}
+	var sequences []Sequence
+	for _, m := range media {
+		closed, err := builder.Add(m)
+		if err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, closed...)
+	}
+	return append(sequences, builder.Flush()...), nil
+}
+
+// Add feeds the next media of the stream and returns the sequences its arrival
+// closed: those whose inactivity gap has passed, and the sequence of its own
+// group if the media would exceed MaxDuration.
+func (b *SequenceBuilder) Add(media Media) ([]Sequence, error) {
+	if b.started && media.StartTimestamp < b.last {
+		return nil, fmt.Errorf("%w: %d after %d", ErrSequenceOutOfOrder, media.StartTimestamp, b.last)
+	}
+	b.started = true
+	b.last = media.StartTimestamp
+
+	var closed []Sequence
+	for _, key := range b.openKeys() {
+		if b.open[key].End+b.options.InactivityGap < media.StartTimestamp {
+			closed = append(closed, b.close(key)...)
+		}
+	}
+
+	key := b.groupKey(media)
+	end := sequenceMediaEnd(media)
+	if current, ok := b.open[key]; ok && b.options.MaxDuration > 0 && end-current.Start > b.options.MaxDuration {
+		closed = append(closed, b.close(key)...)
+	}
+
+	current, ok := b.open[key]
+	if !ok {
+		current = newSequenceFor(media)
+		b.open[key] = current
+	}
+	extendSequence(current, media, end)
+
+	return append(closed, b.release()...), nil
+}
+
+// Flush closes every open sequence and returns them with any still pending.
+func (b *SequenceBuilder) Flush() []Sequence {
+	var closed []Sequence
+	for _, key := range b.openKeys() {
+		closed = append(closed, b.close(key)...)
+	}
+	for _, site := range sortedKeys(b.pending) {
+		closed = append(closed, mergeOverlappingSequences(b.pending[site])...)
+		delete(b.pending, site)
+	}
+	sortSequences(closed)
+	return closed
+}
+
+// close removes the open sequence under key. Without MergeSiteDevices it is
+// returned; with it, a sequence that has a site waits in pending for release.
+func (b *SequenceBuilder) close(key string) []Sequence {
+	sequence := *b.open[key]
+	delete(b.open, key)
+	if !b.options.MergeSiteDevices {
+		return []Sequence{sequence}
+	}
+	site := sequenceSiteKey(sequence)
+	if site == "" {
+		return []Sequence{sequence}
+	}
+	b.pending[site] = append(b.pending[site], sequence)
+	return nil
+}
+
+// release emits the pending merged sequences no open sequence on their site
+// overlaps. A later sequence cannot overlap them either: it starts after the
+// current media, which is past their end plus the inactivity gap.
+func (b *SequenceBuilder) release() []Sequence {
+	var released []Sequence
+	for _, site := range sortedKeys(b.pending) {
+		var keep []Sequence
+		for _, merged := range mergeOverlappingSequences(b.pending[site]) {
+			if b.overlapsOpen(site, merged) {
+				keep = append(keep, merged)
+				continue
+			}
+			released = append(released, merged)
+		}
+		if len(keep) == 0 {
+			delete(b.pending, site)
+		} else {
+			b.pending[site] = keep
+		}
+	}
+	sortSequences(released)
+	return released
+}
+
+func (b *SequenceBuilder) overlapsOpen(site string, sequence Sequence) bool {
+	for _, open := range b.open {
+		if sequenceSiteKey(*open) == site && open.Start <= sequence.End && sequence.Start <= open.End {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *SequenceBuilder) openKeys() []string {
+	return sortedKeys(b.open)
+}
+
+// groupKey scopes a media to its organisation and resolved project, then to
+// its site or device.
+func (b *SequenceBuilder) groupKey(media Media) string {
+	scope := media.OrganisationId + "/" + sequenceProjectKey(media)
+	if b.options.Grouping == SequencePerSite && media.SiteId != "" {
+		return scope + "/site/" + media.SiteId
+	}
+	return scope + "/device/" + sequenceDeviceKey(media)
+}
+
+func newSequenceFor(media Media) *Sequence {
+	sequence := &Sequence{
+		OrganisationId: media.OrganisationId,
+		Start:          media.StartTimestamp,
+		End:            media.StartTimestamp,
+	}
+	if organisationId, err := primitive.ObjectIDFromHex(media.OrganisationId); err == nil {
+		projectId := ResolveProjectId(organisationId, media.ProjectId)
+		sequence.ProjectId = &projectId
+	} else if media.ProjectId != nil && !media.ProjectId.IsZero() {
+		projectId := *media.ProjectId
+		sequence.ProjectId = &projectId
+	}
+	return sequence
+}
+
+func extendSequence(sequence *Sequence, media Media, end int64) {
+	sequence.Images = append(sequence.Images, media)
+	if end > sequence.End {
+		sequence.End = end
+	}
+	device := sequenceDeviceKey(media)
+	for _, existing := range sequence.Devices {
+		if existing == device {
+			return
+		}
+	}
+	sequence.Devices = append(sequence.Devices, device)
+}
+
+// mergeOverlappingSequences unions sequences whose [Start, End] ranges
+// overlap, keeping images in time order and devices in order of appearance.
+func mergeOverlappingSequences(sequences []Sequence) []Sequence {
+	sorted := append([]Sequence(nil), sequences...)
+	sortSequences(sorted)
+	var merged []Sequence
+	for _, sequence := range sorted {
+		if n := len(merged); n > 0 && sequence.Start <= merged[n-1].End {
+			last := &merged[n-1]
+			if sequence.End > last.End {
+				last.End = sequence.End
+			}
+			last.Images = append(last.Images, sequence.Images...)
+			for _, device := range sequence.Devices {
+				if !containsString(last.Devices, device) {
+					last.Devices = append(last.Devices, device)
+				}
+			}
+			sort.SliceStable(last.Images, func(i, j int) bool {
+				return last.Images[i].StartTimestamp < last.Images[j].StartTimestamp
+			})
+			continue
+		}
+		sequence.Images = append([]Media(nil), sequence.Images...)
+		sequence.Devices = append([]string(nil), sequence.Devices...)
+		merged = append(merged, sequence)
+	}
+	return merged
+}
+
+func sortSequences(sequences []Sequence) {
+	sort.SliceStable(sequences, func(i, j int) bool {
+		if sequences[i].Start != sequences[j].Start {
+			return sequences[i].Start < sequences[j].Start
+		}
+		return sequenceFirstDevice(sequences[i]) < sequenceFirstDevice(sequences[j])
+	})
+}
+
+// sequenceMediaEnd is the media's end time: EndTimestamp, or the start plus
+// its Duration (milliseconds) rounded up to whole seconds.
+func sequenceMediaEnd(media Media) int64 {
+	if media.EndTimestamp > media.StartTimestamp {
+		return media.EndTimestamp
+	}
+	return media.StartTimestamp + (int64(media.Duration)+999)/1000
+}
+
+func sequenceDeviceKey(media Media) string {
+	if media.DeviceKey != "" {
+		return media.DeviceKey
+	}
+	return media.DeviceId
+}
+
+func sequenceProjectKey(media Media) string {
+	if organisationId, err := primitive.ObjectIDFromHex(media.OrganisationId); err == nil {
+		return ResolveProjectId(organisationId, media.ProjectId).Hex()
+	}
+	if media.ProjectId != nil && !media.ProjectId.IsZero() {
+		return media.ProjectId.Hex()
+	}
+	return ""
+}
+
+// sequenceSiteKey scopes a sequence's site to its organisation and project;
+// empty when its media carry no site.
+func sequenceSiteKey(sequence Sequence) string {
+	if len(sequence.Images) == 0 || sequence.Images[0].SiteId == "" {
+		return ""
+	}
+	media := sequence.Images[0]
+	return media.OrganisationId + "/" + sequenceProjectKey(media) + "/" + media.SiteId
+}
+
+func sequenceFirstDevice(sequence Sequence) string {
+	if len(sequence.Devices) == 0 {
+		return ""
+	}
+	return sequence.Devices[0]
+}
+
+func containsString(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func sequenceMedia(organisationId, site, device string, start int64, durationMs int) Media {
+	return Media{OrganisationId: organisationId, SiteId: site, DeviceKey: device, StartTimestamp: start, Duration: durationMs}
+}
+
+func sequenceBounds(sequences []Sequence) [][2]int64 {
+	var bounds [][2]int64
+	for _, sequence := range sequences {
+		bounds = append(bounds, [2]int64{sequence.Start, sequence.End})
+	}
+	return bounds
+}
+
+func TestBuildSequencesPerDevice(t *testing.T) {
+	org := primitive.NewObjectID().Hex()
+	media := []Media{
+		sequenceMedia(org, "site", "cam-1", 100, 10000),
+		sequenceMedia(org, "site", "cam-2", 105, 10000),
+		sequenceMedia(org, "site", "cam-1", 130, 10000), // within the 30s gap of 110
+		sequenceMedia(org, "site", "cam-1", 200, 10000), // gap exceeded: new sequence
+	}
+	sequences, err := BuildSequences(media, SequenceBuilderOptions{InactivityGap: 30})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	want := [][2]int64{{100, 140}, {105, 115}, {200, 210}}
+	if got := sequenceBounds(sequences); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bounds = %v, want %v", got, want)
+	}
+	if len(sequences[0].Images) != 2 || !reflect.DeepEqual(sequences[0].Devices, []string{"cam-1"}) {
+		t.Errorf("first sequence = %+v", sequences[0])
+	}
+	organisationId, _ := primitive.ObjectIDFromHex(org)
+	if sequences[0].ProjectId == nil || *sequences[0].ProjectId != DefaultProjectId(organisationId) {
+		t.Errorf("project = %v, want the organisation default", sequences[0].ProjectId)
+	}
+}
+
+func TestBuildSequencesPerSiteAndMaxDuration(t *testing.T) {
+	org := primitive.NewObjectID().Hex()
+	media := []Media{
+		sequenceMedia(org, "site", "cam-1", 100, 10000),
+		sequenceMedia(org, "site", "cam-2", 120, 10000),
+		sequenceMedia(org, "site", "cam-1", 140, 10000),
+		sequenceMedia(org, "site", "cam-2", 160, 10000), // would stretch past 60s
+	}
+	sequences, err := BuildSequences(media, SequenceBuilderOptions{Grouping: SequencePerSite, InactivityGap: 30, MaxDuration: 60})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	want := [][2]int64{{100, 150}, {160, 170}}
+	if got := sequenceBounds(sequences); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bounds = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(sequences[0].Devices, []string{"cam-1", "cam-2"}) {
+		t.Errorf("devices = %v", sequences[0].Devices)
+	}
+}
+
+func TestBuildSequencesMergesOverlappingSiteDevices(t *testing.T) {
+	org := primitive.NewObjectID().Hex()
+	media := []Media{
+		sequenceMedia(org, "site", "cam-1", 100, 20000),
+		sequenceMedia(org, "site", "cam-2", 110, 20000),
+		sequenceMedia(org, "other", "cam-3", 115, 5000),
+		sequenceMedia(org, "site", "cam-1", 300, 5000),
+	}
+	sequences, err := BuildSequences(media, SequenceBuilderOptions{InactivityGap: 10, MergeSiteDevices: true})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	want := [][2]int64{{100, 130}, {115, 120}, {300, 305}}
+	if got := sequenceBounds(sequences); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bounds = %v, want %v", got, want)
+	}
+	merged := sequences[0]
+	if !reflect.DeepEqual(merged.Devices, []string{"cam-1", "cam-2"}) || len(merged.Images) != 2 {
+		t.Errorf("merged = %+v", merged)
+	}
+}
+
+func TestSequenceBuilderKeepsProjectsApart(t *testing.T) {
+	org := primitive.NewObjectID().Hex()
+	project := primitive.NewObjectID()
+	other := sequenceMedia(org, "site", "cam-1", 105, 1000)
+	other.ProjectId = &project
+	sequences, err := BuildSequences([]Media{sequenceMedia(org, "site", "cam-1", 100, 1000), other}, SequenceBuilderOptions{InactivityGap: 60})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if len(sequences) != 2 || *sequences[1].ProjectId != project {
+		t.Fatalf("sequences = %+v, want one per project", sequences)
+	}
+}
+
+func TestSequenceBuilderRejectsOutOfOrderMedia(t *testing.T) {
+	builder, err := NewSequenceBuilder(SequenceBuilderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Add(sequenceMedia("org", "", "cam", 200, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Add(sequenceMedia("org", "", "cam", 100, 0)); !errors.Is(err, ErrSequenceOutOfOrder) {
+		t.Errorf("err = %v, want ErrSequenceOutOfOrder", err)
+	}
+	if _, err := NewSequenceBuilder(SequenceBuilderOptions{Grouping: "floor"}); !errors.Is(err, ErrSequenceInvalidOptions) {
+		t.Errorf("err = %v, want ErrSequenceInvalidOptions", err)
+	}
+}
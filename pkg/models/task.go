@@ -129,11 +129,14 @@ type Task struct {
 	// Retention / lifecycle.
 	//
 	// RetentionDays captures the *policy intent*: how many days the
-	// case should be kept after its retention anchor (typically the
+	// case should be kept after its retention anchor (ClosedAt, the
 	// moment the case is closed, falling back to CreationDate when
 	// the case is still open). Nil means "use the workspace/tenant
 	// default policy"; 0 is a valid explicit "delete on next sweep".
 	//
+	// ClosedAt is when the case was closed (unix seconds); zero while
+	// it is open. Reopening a case clears it.
+	//
 	// ExpiresAt is the *materialized* date at which the cleanup
 	// worker is allowed to purge the case (and its attachments,
 	// media, comments, …). It is recomputed from RetentionDays on
@@ -157,6 +160,7 @@ type Task struct {
 	RetentionDays       *int   `json:"retention_days,omitempty"        bson:"retention_days,omitempty"`
 	ExpiresAt           *int64 `json:"expires_at,omitempty"            bson:"expires_at,omitempty"`
 	ExpiresAtOverridden bool   `json:"expires_at_overridden,omitempty" bson:"expires_at_overridden,omitempty"`
+	ClosedAt            int64  `json:"closed_at,omitempty"             bson:"closed_at,omitempty"`
 	LegalHold           bool   `json:"legal_hold,omitempty"            bson:"legal_hold,omitempty"`
 
 	// Related collections
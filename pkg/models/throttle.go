@@ -0,0 +1,214 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The throttling helpers below are pure: every decision takes the stored state
+// and the current time (unix seconds) and returns the next state, which the
+// caller persists on the document it came from (a ThrottlerStage, a User's
+// HighUpload). No clock and no I/O means every throttler replica makes the
+// same decision for the same state.
+
+// ThrottleScope names what a throttle counts against.
+type ThrottleScope string
+
+const (
+	ThrottleScopeUser   ThrottleScope = "user"
+	ThrottleScopeDevice ThrottleScope = "device"
+	ThrottleScopeAlert  ThrottleScope = "alert"
+)
+
+// ThrottleAlgorithm selects how a ThrottlePolicy counts.
+type ThrottleAlgorithm string
+
+const (
+	// ThrottleTokenBucket allows bursts of up to Limit, refilling Limit tokens
+	// evenly over Window.
+	ThrottleTokenBucket ThrottleAlgorithm = "tokenBucket"
+	// ThrottleSlidingWindow allows at most Limit events in any Window.
+	ThrottleSlidingWindow ThrottleAlgorithm = "slidingWindow"
+)
+
+// ErrThrottleInvalidPolicy is returned for a policy without a positive limit
+// and window, or with an unknown algorithm.
+var ErrThrottleInvalidPolicy = errors.New("invalid throttle policy")
+
+// ThrottlePolicy is a rate limit: Limit events per Window seconds.
+type ThrottlePolicy struct {
+	Scope     ThrottleScope     `json:"scope" bson:"scope"`
+	Algorithm ThrottleAlgorithm `json:"algorithm" bson:"algorithm"`
+	Limit     int64             `json:"limit" bson:"limit"`
+	Window    int64             `json:"window" bson:"window"`
+}
+
+// ThrottleState is the stored state of one throttle key. Tokens and UpdatedAt
+// are used by the token bucket, Events by the sliding window.
+type ThrottleState struct {
+	Key       string  `json:"key" bson:"key"`
+	Tokens    float64 `json:"tokens,omitempty" bson:"tokens,omitempty"`
+	UpdatedAt int64   `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+	Events    []int64 `json:"events,omitempty" bson:"events,omitempty"`
+}
+
+// ThrottleDecision is the outcome of one ThrottlePolicy.Allow.
+type ThrottleDecision struct {
+	Allowed bool `json:"allowed"`
+	// Remaining is how many more events are allowed right now.
+	Remaining int64 `json:"remaining"`
+	// RetryAfter is how many seconds until the next event would be allowed;
+	// zero when allowed.
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+}
+
+// ThrottleKey builds the state key for id under scope, e.g. "device:cam-1".
+func ThrottleKey(scope ThrottleScope, id string) string {
+	return string(scope) + ":" + id
+}
+
+// Validate checks the policy can make decisions.
+func (p ThrottlePolicy) Validate() error {
+	if p.Limit <= 0 || p.Window <= 0 {
+		return fmt.Errorf("%w: limit %d per %ds", ErrThrottleInvalidPolicy, p.Limit, p.Window)
+	}
+	if p.Algorithm != ThrottleTokenBucket && p.Algorithm != ThrottleSlidingWindow {
+		return fmt.Errorf("%w: algorithm %q", ErrThrottleInvalidPolicy, p.Algorithm)
+	}
+	return nil
+}
+
+// Allow decides whether one event at now is allowed and returns the state to
+// store. A denied event does not consume capacity. A zero state is a fresh key.
+func (p ThrottlePolicy) Allow(state ThrottleState, now int64) (ThrottleDecision, ThrottleState, error) {
+	if err := p.Validate(); err != nil {
+		return ThrottleDecision{}, state, err
+	}
+	if p.Algorithm == ThrottleTokenBucket {
+		decision, next := p.allowTokenBucket(state, now)
+		return decision, next, nil
+	}
+	decision, next := p.allowSlidingWindow(state, now)
+	return decision, next, nil
+}
+
+func (p ThrottlePolicy) allowTokenBucket(state ThrottleState, now int64) (ThrottleDecision, ThrottleState) {
+	rate := float64(p.Limit) / float64(p.Window) // tokens per second
+	tokens := float64(p.Limit)
+	if state.UpdatedAt != 0 {
+		elapsed := now - state.UpdatedAt
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		tokens = math.Min(float64(p.Limit), state.Tokens+float64(elapsed)*rate)
+	}
+	state.UpdatedAt = now
+
+	if tokens < 1 {
+		state.Tokens = tokens
+		return ThrottleDecision{RetryAfter: int64(math.Ceil((1 - tokens) / rate))}, state
+	}
+	state.Tokens = tokens - 1
+	return ThrottleDecision{Allowed: true, Remaining: int64(state.Tokens)}, state
+}
+
+func (p ThrottlePolicy) allowSlidingWindow(state ThrottleState, now int64) (ThrottleDecision, ThrottleState) {
+	events := make([]int64, 0, len(state.Events)+1)
+	for _, at := range state.Events {
+		if at > now-p.Window {
+			events = append(events, at)
+		}
+	}
+	state.Events = events
+
+	if int64(len(events)) >= p.Limit {
+		// The oldest event in the window has to age out first.
+		return ThrottleDecision{RetryAfter: events[0] + p.Window - now}, state
+	}
+	state.Events = append(state.Events, now)
+	return ThrottleDecision{Allowed: true, Remaining: p.Limit - int64(len(state.Events))}, state
+}
+
+// HighUploadDecision is the outcome of DetectHighUpload.
+type HighUploadDecision struct {
+	// Exceeded reports the device is over its request threshold in the current
+	// window.
+	Exceeded bool `json:"exceeded"`
+	// Notify reports a high-upload notification should be sent: the threshold
+	// was exceeded, alerts are enabled for the device, and none was sent yet in
+	// this window.
+	Notify bool `json:"notify"`
+}
+
+// AppliesTo reports whether high-upload alerts are configured for deviceKey.
+func (h Highupload) AppliesTo(deviceKey string) bool {
+	if !h.Enabled {
+		return false
+	}
+	if h.DevicesAll {
+		return true
+	}
+	for _, device := range h.DevicesList {
+		if device.Key == deviceKey {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectHighUpload counts one upload request at now against state, the
+// device's stored HighUpload counters, and returns the counters to store.
+// Requests are counted in fixed windows of window seconds starting at
+// StartTimestamp; Notification records when an alert was last sent so at most
+// one is sent per window.
+func DetectHighUpload(state HighUpload, settings Highupload, deviceKey string, window int64, now int64) (HighUploadDecision, HighUpload) {
+	if state.StartTimestamp == 0 || window <= 0 || now-state.StartTimestamp >= window || now < state.StartTimestamp {
+		state.StartTimestamp = now
+		state.Requests = 0
+	}
+	state.Requests++
+
+	var decision HighUploadDecision
+	decision.Exceeded = settings.Requests > 0 && state.Requests > int64(settings.Requests)
+	if decision.Exceeded && settings.AppliesTo(deviceKey) && state.Notification < state.StartTimestamp {
+		decision.Notify = true
+		state.Notification = now
+	}
+	return decision, state
+}
+
+// SuppressionPolicy limits repeated notifications for the same key: at most
+// one per Cooldown seconds. Notifications in between are counted so the next
+// one sent can report them.
+type SuppressionPolicy struct {
+	Cooldown int64 `json:"cooldown" bson:"cooldown"`
+}
+
+// SuppressionState is the stored state of one notification key.
+type SuppressionState struct {
+	Key          string `json:"key" bson:"key"`
+	LastNotified int64  `json:"lastNotified,omitempty" bson:"lastNotified,omitempty"`
+	Suppressed   int64  `json:"suppressed,omitempty" bson:"suppressed,omitempty"`
+}
+
+// SuppressionDecision is the outcome of SuppressionPolicy.Decide.
+type SuppressionDecision struct {
+	Notify bool `json:"notify"`
+	// Suppressed is, when Notify, how many notifications were held back since
+	// the previous one was sent.
+	Suppressed int64 `json:"suppressed,omitempty"`
+}
+
+// Decide reports whether a notification at now should be sent and returns the
+// state to store.
+func (p SuppressionPolicy) Decide(state SuppressionState, now int64) (SuppressionDecision, SuppressionState) {
+	if state.LastNotified != 0 && now-state.LastNotified < p.Cooldown {
+		state.Suppressed++
+		return SuppressionDecision{}, state
+	}
+	decision := SuppressionDecision{Notify: true, Suppressed: state.Suppressed}
+	state.LastNotified = now
+	state.Suppressed = 0
+	return decision, state
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestThrottleTokenBucket(t *testing.T) {
+	policy := ThrottlePolicy{Scope: ThrottleScopeDevice, Algorithm: ThrottleTokenBucket, Limit: 2, Window: 60}
+	state := ThrottleState{Key: ThrottleKey(ThrottleScopeDevice, "cam-1")}
+
+	var allowed []bool
+	for _, now := range []int64{1000, 1001, 1002, 1031} {
+		decision, next, err := policy.Allow(state, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if now == 1002 && decision.RetryAfter != 28 {
+			t.Errorf("retry after = %d, want 28", decision.RetryAfter)
+		}
+		allowed = append(allowed, decision.Allowed)
+		state = next
+	}
+	if want := []bool{true, true, false, true}; !reflect.DeepEqual(allowed, want) {
+		t.Errorf("allowed = %v, want %v", allowed, want)
+	}
+	if state.Key != "device:cam-1" {
+		t.Errorf("key = %q", state.Key)
+	}
+}
+
+func TestThrottleSlidingWindowSurvivesRoundTrip(t *testing.T) {
+	policy := ThrottlePolicy{Scope: ThrottleScopeAlert, Algorithm: ThrottleSlidingWindow, Limit: 2, Window: 10}
+	var state ThrottleState
+	var allowed []bool
+	for _, now := range []int64{100, 105, 109, 110, 115} {
+		decision, next, err := policy.Allow(state, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowed = append(allowed, decision.Allowed)
+
+		raw, _ := json.Marshal(next)
+		state = ThrottleState{}
+		if err := json.Unmarshal(raw, &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []bool{true, true, false, true, true}; !reflect.DeepEqual(allowed, want) {
+		t.Errorf("allowed = %v, want %v", allowed, want)
+	}
+	if _, _, err := (ThrottlePolicy{Algorithm: ThrottleSlidingWindow}).Allow(state, 0); !errors.Is(err, ErrThrottleInvalidPolicy) {
+		t.Errorf("err = %v, want ErrThrottleInvalidPolicy", err)
+	}
+}
+
+func TestDetectHighUploadNotifiesOncePerWindow(t *testing.T) {
+	settings := Highupload{Enabled: true, DevicesList: []DeviceKey{{Key: "cam-1"}}, Requests: 2}
+	var state HighUpload
+	var notified []bool
+	for _, now := range []int64{1000, 1010, 1020, 1030, 4600, 4601, 4602} {
+		decision, next := DetectHighUpload(state, settings, "cam-1", 3600, now)
+		notified = append(notified, decision.Notify)
+		state = next
+	}
+	if want := []bool{false, false, true, false, false, false, true}; !reflect.DeepEqual(notified, want) {
+		t.Errorf("notified = %v, want %v", notified, want)
+	}
+	if state.StartTimestamp != 4600 || state.Requests != 3 || state.Notification != 4602 {
+		t.Errorf("state = %+v", state)
+	}
+
+	decision, _ := DetectHighUpload(HighUpload{StartTimestamp: 1000, Requests: 5}, settings, "cam-2", 3600, 1001)
+	if !decision.Exceeded || decision.Notify {
+		t.Errorf("unconfigured device decision = %+v, want exceeded without notify", decision)
+	}
+}
+
+func TestSuppressionPolicyCountsHeldBackNotifications(t *testing.T) {
+	policy := SuppressionPolicy{Cooldown: 300}
+	state := SuppressionState{Key: ThrottleKey(ThrottleScopeUser, "u1")}
+	var sent []SuppressionDecision
+	for _, now := range []int64{1000, 1100, 1200, 1300} {
+		decision, next := policy.Decide(state, now)
+		if decision.Notify {
+			sent = append(sent, decision)
+		}
+		state = next
+	}
+	if want := []SuppressionDecision{{Notify: true}, {Notify: true, Suppressed: 2}}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent = %+v, want %+v", sent, want)
+	}
+}
@@ -0,0 +1,322 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TimelineZoom is the width of the buckets a timeline is cut into.
+type TimelineZoom string
+
+const (
+	TimelineZoomMinute         TimelineZoom = "minute"
+	TimelineZoomFiveMinutes    TimelineZoom = "fiveMinutes"
+	TimelineZoomFifteenMinutes TimelineZoom = "fifteenMinutes"
+	TimelineZoomHour           TimelineZoom = "hour"
+	TimelineZoomSixHours       TimelineZoom = "sixHours"
+	TimelineZoomDay            TimelineZoom = "day"
+)
+
+// timelineZooms is the zoom ladder, narrowest first, with each level's
+// nominal width in seconds. A day is nominal: in a zone with daylight saving
+// a day bucket can be 23 or 25 hours.
+var timelineZooms = []struct {
+	zoom  TimelineZoom
+	width int64
+}{
+	{TimelineZoomMinute, 60},
+	{TimelineZoomFiveMinutes, 5 * 60},
+	{TimelineZoomFifteenMinutes, 15 * 60},
+	{TimelineZoomHour, 3600},
+	{TimelineZoomSixHours, 6 * 3600},
+	{TimelineZoomDay, 24 * 3600},
+}
+
+// ErrTimelineInvalidOptions is returned for an unknown zoom or timezone, a
+// negative gap or cap, or a bucket count without a time range.
+var ErrTimelineInvalidOptions = errors.New("invalid timeline options")
+
+// TimelineBucketOptions control how BucketMedia and BucketMarkers cut a
+// device's media and markers into MediaGroups and MarkerGroups. Times are unix
+// seconds.
+type TimelineBucketOptions struct {
+	// Zoom fixes the bucket width. When empty, BucketCount picks the narrowest
+	// zoom that covers Start..End in at most that many buckets.
+	Zoom        TimelineZoom `json:"zoom,omitempty" bson:"zoom,omitempty"`
+	BucketCount int          `json:"bucketCount,omitempty" bson:"bucketCount,omitempty"`
+
+	// Start and End clip the timeline: items outside are dropped and groups
+	// are cut at the edges. Zero leaves that side open.
+	Start int64 `json:"start,omitempty" bson:"start,omitempty"`
+	End   int64 `json:"end,omitempty" bson:"end,omitempty"`
+
+	// Timezone is the IANA zone bucket boundaries are aligned in, so hour and
+	// day buckets start on the viewer's wall clock. Empty is UTC.
+	Timezone string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+
+	// MaxGap merges items of a bucket into one group while each starts within
+	// MaxGap seconds of the group's end. Zero merges only touching or
+	// overlapping items.
+	MaxGap int64 `json:"maxGap,omitempty" bson:"maxGap,omitempty"`
+
+	// MaxItems caps the items kept on a group to an evenly spread sample that
+	// always includes the first and last item. Count still counts them all.
+	// Zero keeps every item.
+	MaxItems int `json:"maxItems,omitempty" bson:"maxItems,omitempty"`
+}
+
+// timelineBucketer is a validated TimelineBucketOptions.
+type timelineBucketer struct {
+	options  TimelineBucketOptions
+	zoom     TimelineZoom
+	width    int64
+	location *time.Location
+}
+
+func newTimelineBucketer(options TimelineBucketOptions) (*timelineBucketer, error) {
+	if options.MaxGap < 0 || options.MaxItems < 0 || options.BucketCount < 0 {
+		return nil, fmt.Errorf("%w: negative gap, cap or bucket count", ErrTimelineInvalidOptions)
+	}
+	if options.End != 0 && options.End <= options.Start {
+		return nil, fmt.Errorf("%w: empty range %d-%d", ErrTimelineInvalidOptions, options.Start, options.End)
+	}
+	location := time.UTC
+	if options.Timezone != "" {
+		loaded, err := time.LoadLocation(options.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: timezone %q", ErrTimelineInvalidOptions, options.Timezone)
+		}
+		location = loaded
+	}
+
+	b := &timelineBucketer{options: options, location: location}
+	switch {
+	case options.Zoom != "":
+		for _, level := range timelineZooms {
+			if level.zoom == options.Zoom {
+				b.zoom, b.width = level.zoom, level.width
+			}
+		}
+		if b.zoom == "" {
+			return nil, fmt.Errorf("%w: zoom %q", ErrTimelineInvalidOptions, options.Zoom)
+		}
+	case options.BucketCount > 0:
+		if options.Start == 0 || options.End == 0 {
+			return nil, fmt.Errorf("%w: a bucket count needs a start and end", ErrTimelineInvalidOptions)
+		}
+		b.zoom, b.width = ZoomForBucketCount(options.End-options.Start, options.BucketCount)
+	default:
+		return nil, fmt.Errorf("%w: neither zoom nor bucket count set", ErrTimelineInvalidOptions)
+	}
+	return b, nil
+}
+
+// ZoomForBucketCount returns the narrowest zoom, and its nominal width in
+// seconds, that covers span seconds in at most count buckets. Spans too long
+// for count day buckets still get day buckets.
+func ZoomForBucketCount(span int64, count int) (TimelineZoom, int64) {
+	for _, level := range timelineZooms {
+		if count > 0 && level.width*int64(count) >= span {
+			return level.zoom, level.width
+		}
+	}
+	last := timelineZooms[len(timelineZooms)-1]
+	return last.zoom, last.width
+}
+
+// bucketStart returns the start of the bucket holding t. Buckets of a day are
+// aligned on local midnight; narrower buckets on the local wall clock, using
+// the zone offset in force at t.
+func (b *timelineBucketer) bucketStart(t int64) int64 {
+	local := time.Unix(t, 0).In(b.location)
+	if b.zoom == TimelineZoomDay {
+		return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, b.location).Unix()
+	}
+	_, offset := local.Zone()
+	wall := t + int64(offset)
+	return wall - floorMod(wall, b.width) - int64(offset)
+}
+
+// bucketEnd returns the end of the bucket starting at start.
+func (b *timelineBucketer) bucketEnd(start int64) int64 {
+	if b.zoom == TimelineZoomDay {
+		local := time.Unix(start, 0).In(b.location)
+		return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, b.location).Unix()
+	}
+	end := b.bucketStart(start + b.width)
+	if end <= start {
+		// A zone offset change pulled the next boundary back; never
+		// return an empty bucket.
+		end = start + b.width
+	}
+	return end
+}
+
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
+
+// timelineSpan is one item to bucket: its time range and its position in the
+// caller's slice.
+type timelineSpan struct {
+	start, end int64
+	index      int
+}
+
+// timelineGroup is a bucketed group before it is typed: its clipped time
+// range, how many items overlap it and the sampled item positions.
+type timelineGroup struct {
+	start, end int64
+	count      int64
+	items      []int
+}
+
+// group cuts spans into gap-aware groups per bucket. An item overlapping
+// several buckets is counted, and listed, in each of them.
+func (b *timelineBucketer) group(spans []timelineSpan) []timelineGroup {
+	from, to := b.options.Start, b.options.End
+	var clipped []timelineSpan
+	for _, span := range spans {
+		if span.end < span.start {
+			span.end = span.start
+		}
+		if from != 0 && span.end < from || to != 0 && span.start >= to {
+			continue
+		}
+		if from != 0 && span.start < from {
+			span.start = from
+		}
+		if to != 0 && span.end > to {
+			span.end = to
+		}
+		clipped = append(clipped, span)
+	}
+	sort.SliceStable(clipped, func(i, j int) bool { return clipped[i].start < clipped[j].start })
+
+	// Assign each span to every bucket it overlaps; an instant span lands in
+	// the bucket holding it.
+	buckets := map[int64][]timelineSpan{}
+	for _, span := range clipped {
+		for start := b.bucketStart(span.start); ; {
+			end := b.bucketEnd(start)
+			buckets[start] = append(buckets[start], timelineSpan{
+				start: max(span.start, start),
+				end:   min(span.end, end),
+				index: span.index,
+			})
+			if span.end <= end {
+				break
+			}
+			start = end
+		}
+	}
+	starts := make([]int64, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	var groups []timelineGroup
+	for _, start := range starts {
+		var current *timelineGroup
+		for _, span := range buckets[start] {
+			if current != nil && span.start <= current.end+b.options.MaxGap {
+				current.end = max(current.end, span.end)
+				current.count++
+				current.items = append(current.items, span.index)
+				continue
+			}
+			groups = append(groups, timelineGroup{start: span.start, end: span.end, count: 1, items: []int{span.index}})
+			current = &groups[len(groups)-1]
+		}
+	}
+	for i := range groups {
+		groups[i].items = sampleTimelineItems(groups[i].items, b.options.MaxItems)
+	}
+	return groups
+}
+
+// sampleTimelineItems keeps limit items spread evenly over items, first and
+// last included.
+func sampleTimelineItems(items []int, limit int) []int {
+	if limit <= 0 || len(items) <= limit {
+		return items
+	}
+	if limit == 1 {
+		return items[:1]
+	}
+	sample := make([]int, limit)
+	for i := range sample {
+		sample[i] = items[i*(len(items)-1)/(limit-1)]
+	}
+	return sample
+}
+
+// BucketMedia groups a device's media into a timeline. Groups are in time
+// order; within one bucket, media that touch or lie within MaxGap of each other
+// form one group spanning them, clipped to the bucket and to Start..End. Media
+// without an EndTimestamp end after their Duration.
+func BucketMedia(media []Media, options TimelineBucketOptions) ([]MediaGroup, error) {
+	b, err := newTimelineBucketer(options)
+	if err != nil {
+		return nil, err
+	}
+	spans := make([]timelineSpan, len(media))
+	for i, m := range media {
+		spans[i] = timelineSpan{start: m.StartTimestamp, end: sequenceMediaEnd(m), index: i}
+	}
+	groups := b.group(spans)
+	out := make([]MediaGroup, len(groups))
+	for i, g := range groups {
+		out[i] = MediaGroup{StartTimestamp: g.start, EndTimestamp: g.end, Count: g.count, Media: make([]Media, len(g.items))}
+		for j, index := range g.items {
+			out[i].Media[j] = media[index]
+		}
+	}
+	return out, nil
+}
+
+// BucketMarkers groups a device's markers into a timeline, the way BucketMedia
+// groups media. Markers without an EndTimestamp end after their Duration.
+func BucketMarkers(markers []Marker, options TimelineBucketOptions) ([]MarkerGroup, error) {
+	b, err := newTimelineBucketer(options)
+	if err != nil {
+		return nil, err
+	}
+	spans := make([]timelineSpan, len(markers))
+	for i, m := range markers {
+		end := m.EndTimestamp
+		if end < m.StartTimestamp {
+			end = m.StartTimestamp + m.Duration
+		}
+		spans[i] = timelineSpan{start: m.StartTimestamp, end: end, index: i}
+	}
+	groups := b.group(spans)
+	out := make([]MarkerGroup, len(groups))
+	for i, g := range groups {
+		out[i] = MarkerGroup{StartTimestamp: g.start, EndTimestamp: g.end, Count: g.count, Markers: make([]Marker, len(g.items))}
+		for j, index := range g.items {
+			out[i].Markers[j] = markers[index]
+		}
+	}
+	return out, nil
+}
+
+// BuildTimeline buckets a device's media and markers with the same options.
+func BuildTimeline(device Device, media []Media, markers []Marker, options TimelineBucketOptions) (Timeline, error) {
+	mediaGroups, err := BucketMedia(media, options)
+	if err != nil {
+		return Timeline{}, err
+	}
+	markerGroups, err := BucketMarkers(markers, options)
+	if err != nil {
+		return Timeline{}, err
+	}
+	return Timeline{Device: device, Media: &mediaGroups, Markers: &markerGroups}, nil
+}
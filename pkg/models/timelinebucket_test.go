@@ -0,0 +1,185 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func timelineMedia(spans ...[2]int64) []Media {
+	media := make([]Media, len(spans))
+	for i, span := range spans {
+		media[i] = Media{StartTimestamp: span[0], EndTimestamp: span[1], VideoFile: string(rune('a' + i))}
+	}
+	return media
+}
+
+func mediaGroupSpans(groups []MediaGroup) [][3]int64 {
+	spans := make([][3]int64, len(groups))
+	for i, g := range groups {
+		spans[i] = [3]int64{g.StartTimestamp, g.EndTimestamp, g.Count}
+	}
+	return spans
+}
+
+func TestBucketMediaMergesWithinGap(t *testing.T) {
+	// 2024-01-01 00:00:00 UTC.
+	const day = 1704067200
+	media := timelineMedia(
+		[2]int64{day + 0, day + 10},
+		[2]int64{day + 10, day + 20}, // touches the first
+		[2]int64{day + 25, day + 30}, // 5s gap
+		[2]int64{day + 50, day + 55},
+		[2]int64{day + 3590, day + 3610}, // crosses into the next hour
+	)
+
+	tests := []struct {
+		name    string
+		options TimelineBucketOptions
+		want    [][3]int64
+	}{
+		{
+			name:    "touching only",
+			options: TimelineBucketOptions{Zoom: TimelineZoomHour},
+			want: [][3]int64{
+				{day, day + 20, 2}, {day + 25, day + 30, 1}, {day + 50, day + 55, 1},
+				{day + 3590, day + 3600, 1}, {day + 3600, day + 3610, 1},
+			},
+		},
+		{
+			name:    "five second gap",
+			options: TimelineBucketOptions{Zoom: TimelineZoomHour, MaxGap: 5},
+			want: [][3]int64{
+				{day, day + 30, 3}, {day + 50, day + 55, 1},
+				{day + 3590, day + 3600, 1}, {day + 3600, day + 3610, 1},
+			},
+		},
+		{
+			name:    "clipped to range",
+			options: TimelineBucketOptions{Zoom: TimelineZoomHour, MaxGap: 60, Start: day + 15, End: day + 3595},
+			want:    [][3]int64{{day + 15, day + 55, 3}, {day + 3590, day + 3595, 1}},
+		},
+		{
+			name:    "minute buckets",
+			options: TimelineBucketOptions{Zoom: TimelineZoomMinute, MaxGap: 60},
+			want: [][3]int64{
+				{day, day + 55, 4},
+				{day + 3590, day + 3600, 1}, {day + 3600, day + 3610, 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := BucketMedia(media, tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mediaGroupSpans(groups); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketMediaSamplesItems(t *testing.T) {
+	var spans [][2]int64
+	for i := int64(0); i < 10; i++ {
+		spans = append(spans, [2]int64{i * 10, i*10 + 10})
+	}
+	groups, err := BucketMedia(timelineMedia(spans...), TimelineBucketOptions{Zoom: TimelineZoomHour, MaxItems: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Count != 10 {
+		t.Fatalf("groups = %v", mediaGroupSpans(groups))
+	}
+	var keys []string
+	for _, m := range groups[0].Media {
+		keys = append(keys, m.VideoFile)
+	}
+	if want := []string{"a", "e", "j"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("sample = %v, want %v", keys, want)
+	}
+}
+
+func TestBucketMediaAlignsOnTimezone(t *testing.T) {
+	brussels, err := time.LoadLocation("Europe/Brussels")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+
+	// 23:30 and 00:30 Brussels time on the night of the spring DST change.
+	late := time.Date(2024, 3, 30, 23, 30, 0, 0, brussels).Unix()
+	early := time.Date(2024, 3, 31, 0, 30, 0, 0, brussels).Unix()
+	media := timelineMedia([2]int64{late, late + 60}, [2]int64{early, early + 60})
+
+	groups, err := BucketMedia(media, TimelineBucketOptions{Zoom: TimelineZoomDay, Timezone: "Europe/Brussels", MaxGap: 86400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("day groups = %v, want one per local day", mediaGroupSpans(groups))
+	}
+	utc, _ := BucketMedia(media, TimelineBucketOptions{Zoom: TimelineZoomDay, MaxGap: 86400})
+	if len(utc) != 1 {
+		t.Errorf("utc day groups = %v, want both on 30 March UTC", mediaGroupSpans(utc))
+	}
+
+	// The DST day is 23 hours long, so a day bucket ends 23h after it starts.
+	b, _ := newTimelineBucketer(TimelineBucketOptions{Zoom: TimelineZoomDay, Timezone: "Europe/Brussels", MaxGap: 86400})
+	start := b.bucketStart(early)
+	if end := b.bucketEnd(start); end-start != 23*3600 {
+		t.Errorf("DST day is %ds long", end-start)
+	}
+
+	// Hour buckets follow a half-hour zone offset.
+	at := time.Date(2024, 5, 1, 10, 45, 0, 0, kolkata).Unix()
+	b, _ = newTimelineBucketer(TimelineBucketOptions{Zoom: TimelineZoomHour, Timezone: "Asia/Kolkata"})
+	if got, want := b.bucketStart(at), time.Date(2024, 5, 1, 10, 0, 0, 0, kolkata).Unix(); got != want {
+		t.Errorf("hour bucket starts at %d, want %d", got, want)
+	}
+}
+
+func TestBucketMarkersByBucketCount(t *testing.T) {
+	markers := []Marker{
+		{Name: "a", StartTimestamp: 100, EndTimestamp: 110},
+		{Name: "b", StartTimestamp: 500, Duration: 20},
+		{Name: "c", StartTimestamp: 4000, EndTimestamp: 4010},
+	}
+	// Two hours in at most 10 buckets: fifteen-minute buckets.
+	groups, err := BucketMarkers(markers, TimelineBucketOptions{BucketCount: 10, Start: 1, End: 7200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MarkerGroup{
+		{StartTimestamp: 100, EndTimestamp: 110, Count: 1, Markers: markers[:1]},
+		{StartTimestamp: 500, EndTimestamp: 520, Count: 1, Markers: markers[1:2]},
+		{StartTimestamp: 4000, EndTimestamp: 4010, Count: 1, Markers: markers[2:]},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups = %+v, want %+v", groups, want)
+	}
+	if zoom, _ := ZoomForBucketCount(7199, 10); zoom != TimelineZoomFifteenMinutes {
+		t.Errorf("zoom = %s", zoom)
+	}
+}
+
+func TestTimelineBucketOptionsValidation(t *testing.T) {
+	for _, options := range []TimelineBucketOptions{
+		{},
+		{Zoom: "fortnight"},
+		{Zoom: TimelineZoomHour, Timezone: "Mars/Olympus"},
+		{Zoom: TimelineZoomHour, MaxGap: -1},
+		{BucketCount: 10},
+		{Zoom: TimelineZoomHour, Start: 10, End: 5},
+	} {
+		if _, err := BucketMedia(nil, options); !errors.Is(err, ErrTimelineInvalidOptions) {
+			t.Errorf("%+v: err = %v, want ErrTimelineInvalidOptions", options, err)
+		}
+	}
+}
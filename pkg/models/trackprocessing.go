@@ -0,0 +1,387 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A FaceRedactionTrack holds its boxes twice: the sparse FrameCoordinates map
+// the editor writes, and the Frames/Traject arrays older readers use. The
+// utilities below work on FrameCoordinates and regenerate the arrays, so the two
+// stay in step. Frames listed in DeletedFrames are left alone: they are never
+// filled, never read as a neighbour and never modified.
+
+// TrackInterpolationMethod selects how missing frames are filled.
+type TrackInterpolationMethod string
+
+const (
+	TrackInterpolateLinear TrackInterpolationMethod = "linear"
+	// TrackInterpolateSpline fits a cubic Hermite spline through the
+	// neighbouring boxes, which follows accelerating subjects more closely.
+	TrackInterpolateSpline TrackInterpolationMethod = "spline"
+)
+
+// TrackSmoothingMethod selects how box jitter is smoothed.
+type TrackSmoothingMethod string
+
+const (
+	TrackSmoothMovingAverage TrackSmoothingMethod = "movingAverage"
+	TrackSmoothKalman        TrackSmoothingMethod = "kalman"
+)
+
+// ErrTrackInvalidOptions is returned for an unknown method or a negative gap,
+// window, noise or margin.
+var ErrTrackInvalidOptions = errors.New("invalid track processing options")
+
+// TrackInterpolation fills frames missing between two boxes of a track.
+type TrackInterpolation struct {
+	Method TrackInterpolationMethod `json:"method" bson:"method"`
+	// MaxGap is the longest run of missing frames that is filled; longer gaps
+	// are taken as the subject having left the frame.
+	MaxGap int64 `json:"maxGap" bson:"maxGap"`
+}
+
+// TrackSmoothing reduces frame-to-frame jitter. Boxes marked Edited are kept
+// exactly as the user left them, though they still steer their neighbours.
+type TrackSmoothing struct {
+	Method TrackSmoothingMethod `json:"method" bson:"method"`
+	// Window is the moving-average width in frames, centred on each box.
+	Window int64 `json:"window,omitempty" bson:"window,omitempty"`
+	// ProcessNoise and MeasurementNoise tune the Kalman filter: a higher
+	// process noise follows the detections more closely, a higher measurement
+	// noise smooths harder. Zero uses 1e-3 and 1e-2.
+	ProcessNoise     float64 `json:"processNoise,omitempty" bson:"processNoise,omitempty"`
+	MeasurementNoise float64 `json:"measurementNoise,omitempty" bson:"measurementNoise,omitempty"`
+}
+
+// TrackProcessing is the post-processing applied to a track before it is
+// rendered: interpolation, then smoothing, then padding. Nil steps are skipped.
+type TrackProcessing struct {
+	Interpolation *TrackInterpolation `json:"interpolation,omitempty" bson:"interpolation,omitempty"`
+	Smoothing     *TrackSmoothing     `json:"smoothing,omitempty" bson:"smoothing,omitempty"`
+	// Padding grows every box by this fraction of its width and height on
+	// each side, clamped to the frame.
+	Padding float64 `json:"padding,omitempty" bson:"padding,omitempty"`
+}
+
+// ProcessTrack returns a processed copy of track; the original is not changed.
+func ProcessTrack(track FaceRedactionTrack, processing TrackProcessing) (FaceRedactionTrack, error) {
+	processed := track.Clone()
+	if len(processed.FrameCoordinates) == 0 {
+		processed.FrameCoordinatesFromTraject()
+	}
+	if processing.Interpolation != nil {
+		if _, err := processed.Interpolate(*processing.Interpolation); err != nil {
+			return FaceRedactionTrack{}, err
+		}
+	}
+	if processing.Smoothing != nil {
+		if err := processed.Smooth(*processing.Smoothing); err != nil {
+			return FaceRedactionTrack{}, err
+		}
+	}
+	if processing.Padding != 0 {
+		if err := processed.Pad(processing.Padding); err != nil {
+			return FaceRedactionTrack{}, err
+		}
+	}
+	return processed, nil
+}
+
+// Clone returns a copy of the track that shares no slices or maps with it.
+func (t FaceRedactionTrack) Clone() FaceRedactionTrack {
+	clone := t
+	clone.Frames = append([]int64(nil), t.Frames...)
+	clone.DeletedFrames = append([]int64(nil), t.DeletedFrames...)
+	clone.ColorString = append([]string(nil), t.ColorString...)
+	clone.Traject = make([][]float64, len(t.Traject))
+	for i, point := range t.Traject {
+		clone.Traject[i] = append([]float64(nil), point...)
+	}
+	if t.FrameCoordinates != nil {
+		clone.FrameCoordinates = make(map[int64]TrackBox, len(t.FrameCoordinates))
+		for frame, box := range t.FrameCoordinates {
+			clone.FrameCoordinates[frame] = box
+		}
+	}
+	if t.FrameKeypoints != nil {
+		clone.FrameKeypoints = make(map[int64][]TrackKeypoint, len(t.FrameKeypoints))
+		for frame, keypoints := range t.FrameKeypoints {
+			clone.FrameKeypoints[frame] = append([]TrackKeypoint(nil), keypoints...)
+		}
+	}
+	return clone
+}
+
+// IsDeleted reports whether frame is in DeletedFrames.
+func (t *FaceRedactionTrack) IsDeleted(frame int64) bool {
+	for _, deleted := range t.DeletedFrames {
+		if deleted == frame {
+			return true
+		}
+	}
+	return false
+}
+
+// activeFrames returns the frames of FrameCoordinates that are not deleted,
+// in order.
+func (t *FaceRedactionTrack) activeFrames() []int64 {
+	frames := make([]int64, 0, len(t.FrameCoordinates))
+	for frame := range t.FrameCoordinates {
+		if !t.IsDeleted(frame) {
+			frames = append(frames, frame)
+		}
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+	return frames
+}
+
+// TrajectFromFrameCoordinates regenerates Frames and Traject
+// ([x1, y1, x2, y2, frame] rows) from FrameCoordinates, in frame order.
+func (t *FaceRedactionTrack) TrajectFromFrameCoordinates() {
+	frames := make([]int64, 0, len(t.FrameCoordinates))
+	for frame := range t.FrameCoordinates {
+		frames = append(frames, frame)
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i] < frames[j] })
+	t.Frames = frames
+	t.Traject = make([][]float64, len(frames))
+	for i, frame := range frames {
+		box := t.FrameCoordinates[frame]
+		t.Traject[i] = []float64{box.X1, box.Y1, box.X2, box.Y2, float64(frame)}
+	}
+}
+
+// FrameCoordinatesFromTraject regenerates FrameCoordinates from Traject. Boxes
+// already in FrameCoordinates keep their flags and provenance; only their
+// geometry is taken from Traject. Rows shorter than five values are skipped.
+func (t *FaceRedactionTrack) FrameCoordinatesFromTraject() {
+	coordinates := make(map[int64]TrackBox, len(t.Traject))
+	for _, point := range t.Traject {
+		if len(point) < 5 {
+			continue
+		}
+		frame := int64(point[4])
+		box, ok := t.FrameCoordinates[frame]
+		if !ok {
+			box = TrackBox{TrackId: t.Id, Label: t.Classified, ClassId: t.ClassId}
+		}
+		box.X1, box.Y1, box.X2, box.Y2 = point[0], point[1], point[2], point[3]
+		coordinates[frame] = box
+	}
+	t.FrameCoordinates = coordinates
+	t.TrajectFromFrameCoordinates()
+}
+
+// Interpolate fills runs of up to MaxGap missing frames between two boxes. The
+// filled boxes are marked Smoothed and carry the lower confidence of the two
+// boxes around them. Keypoints labelled on both sides are interpolated
+// linearly whatever the method. It returns how many frames it filled.
+func (t *FaceRedactionTrack) Interpolate(options TrackInterpolation) (int, error) {
+	if options.MaxGap < 0 {
+		return 0, fmt.Errorf("%w: negative max gap", ErrTrackInvalidOptions)
+	}
+	if options.Method != TrackInterpolateLinear && options.Method != TrackInterpolateSpline {
+		return 0, fmt.Errorf("%w: interpolation %q", ErrTrackInvalidOptions, options.Method)
+	}
+	keys := t.activeFrames()
+	filled := 0
+	for i := 0; i+1 < len(keys); i++ {
+		from, to := keys[i], keys[i+1]
+		if to-from-1 > options.MaxGap {
+			continue
+		}
+		a, b := t.FrameCoordinates[from], t.FrameCoordinates[to]
+		for frame := from + 1; frame < to; frame++ {
+			if t.IsDeleted(frame) {
+				continue
+			}
+			box := a
+			box.Edited = false
+			box.Smoothed = true
+			box.Confidence = math.Min(a.Confidence, b.Confidence)
+			for c := 0; c < 4; c++ {
+				if options.Method == TrackInterpolateSpline {
+					box = box.withCoord(c, t.splineAt(keys, i, c, frame))
+				} else {
+					s := float64(frame-from) / float64(to-from)
+					box = box.withCoord(c, a.coord(c)+(b.coord(c)-a.coord(c))*s)
+				}
+			}
+			t.FrameCoordinates[frame] = box.clamped()
+			if keypoints := interpolateKeypoints(t.FrameKeypoints[from], t.FrameKeypoints[to], float64(frame-from)/float64(to-from)); len(keypoints) > 0 {
+				t.FrameKeypoints[frame] = keypoints
+			}
+			filled++
+		}
+	}
+	t.TrajectFromFrameCoordinates()
+	return filled, nil
+}
+
+// splineAt evaluates coordinate c at frame, between keys[i] and keys[i+1], on a
+// cubic Hermite spline whose tangents are the finite differences over the
+// neighbouring keys.
+func (t *FaceRedactionTrack) splineAt(keys []int64, i, c int, frame int64) float64 {
+	value := func(k int) float64 { return t.FrameCoordinates[keys[k]].coord(c) }
+	tangent := func(k int) float64 {
+		lo, hi := max(k-1, 0), min(k+1, len(keys)-1)
+		return (value(hi) - value(lo)) / float64(keys[hi]-keys[lo])
+	}
+	span := float64(keys[i+1] - keys[i])
+	s := float64(frame-keys[i]) / span
+	s2, s3 := s*s, s*s*s
+	return (2*s3-3*s2+1)*value(i) + (s3-2*s2+s)*span*tangent(i) +
+		(-2*s3+3*s2)*value(i+1) + (s3-s2)*span*tangent(i+1)
+}
+
+// Smooth reduces jitter over the track's boxes. Edited and deleted boxes are
+// not changed; every other box that moves is marked Smoothed.
+func (t *FaceRedactionTrack) Smooth(options TrackSmoothing) error {
+	if options.Window < 0 || options.ProcessNoise < 0 || options.MeasurementNoise < 0 {
+		return fmt.Errorf("%w: negative window or noise", ErrTrackInvalidOptions)
+	}
+	frames := t.activeFrames()
+	var smoothed [][4]float64
+	switch options.Method {
+	case TrackSmoothMovingAverage:
+		smoothed = t.movingAverage(frames, options.Window)
+	case TrackSmoothKalman:
+		smoothed = t.kalman(frames, options)
+	default:
+		return fmt.Errorf("%w: smoothing %q", ErrTrackInvalidOptions, options.Method)
+	}
+	for i, frame := range frames {
+		box := t.FrameCoordinates[frame]
+		if box.Edited {
+			continue
+		}
+		next := box
+		for c := 0; c < 4; c++ {
+			next = next.withCoord(c, smoothed[i][c])
+		}
+		next = next.clamped()
+		if next.X1 != box.X1 || next.Y1 != box.Y1 || next.X2 != box.X2 || next.Y2 != box.Y2 {
+			next.Smoothed = true
+			t.FrameCoordinates[frame] = next
+		}
+	}
+	t.TrajectFromFrameCoordinates()
+	return nil
+}
+
+// movingAverage averages each box with the boxes within window/2 frames of it.
+func (t *FaceRedactionTrack) movingAverage(frames []int64, window int64) [][4]float64 {
+	half := window / 2
+	out := make([][4]float64, len(frames))
+	lo := 0
+	for i, frame := range frames {
+		for frames[lo] < frame-half {
+			lo++
+		}
+		var sum [4]float64
+		n := 0
+		for j := lo; j < len(frames) && frames[j] <= frame+half; j++ {
+			box := t.FrameCoordinates[frames[j]]
+			for c := 0; c < 4; c++ {
+				sum[c] += box.coord(c)
+			}
+			n++
+		}
+		for c := 0; c < 4; c++ {
+			out[i][c] = sum[c] / float64(n)
+		}
+	}
+	return out
+}
+
+// kalman runs a constant-velocity Kalman filter over each coordinate. An
+// Edited box is taken as an exact measurement, so the filter snaps to it.
+func (t *FaceRedactionTrack) kalman(frames []int64, options TrackSmoothing) [][4]float64 {
+	q, r := options.ProcessNoise, options.MeasurementNoise
+	if q == 0 {
+		q = 1e-3
+	}
+	if r == 0 {
+		r = 1e-2
+	}
+	out := make([][4]float64, len(frames))
+	for c := 0; c < 4; c++ {
+		var x, v float64          // position and velocity
+		var p00, p01, p11 float64 // covariance
+		for i, frame := range frames {
+			box := t.FrameCoordinates[frame]
+			z := box.coord(c)
+			if i == 0 {
+				x, v, p00, p01, p11 = z, 0, r, 0, 1
+				out[i][c] = x
+				continue
+			}
+			dt := float64(frame - frames[i-1])
+			// Predict.
+			x += v * dt
+			p00 += dt*(2*p01+dt*p11) + q
+			p01 += dt * p11
+			p11 += q
+			// Update.
+			noise := r
+			if box.Edited {
+				noise = 0
+			}
+			s := p00 + noise
+			k0, k1 := p00/s, p01/s
+			innovation := z - x
+			x += k0 * innovation
+			v += k1 * innovation
+			p00, p01, p11 = (1-k0)*p00, (1-k0)*p01, p11-k1*p01
+			out[i][c] = x
+		}
+	}
+	return out
+}
+
+// Pad grows every box that is not deleted by margin times its width and height
+// on each side, clamped to the frame.
+func (t *FaceRedactionTrack) Pad(margin float64) error {
+	if margin < 0 {
+		return fmt.Errorf("%w: negative margin", ErrTrackInvalidOptions)
+	}
+	for _, frame := range t.activeFrames() {
+		box := t.FrameCoordinates[frame]
+		dx, dy := (box.X2-box.X1)*margin, (box.Y2-box.Y1)*margin
+		box.X1, box.Y1, box.X2, box.Y2 = box.X1-dx, box.Y1-dy, box.X2+dx, box.Y2+dy
+		t.FrameCoordinates[frame] = box.clamped()
+	}
+	t.TrajectFromFrameCoordinates()
+	return nil
+}
+
+// coord returns X1, Y1, X2 or Y2 by index.
+func (b TrackBox) coord(c int) float64 {
+	return [4]float64{b.X1, b.Y1, b.X2, b.Y2}[c]
+}
+
+func (b TrackBox) withCoord(c int, v float64) TrackBox {
+	switch c {
+	case 0:
+		b.X1 = v
+	case 1:
+		b.Y1 = v
+	case 2:
+		b.X2 = v
+	case 3:
+		b.Y2 = v
+	}
+	return b
+}
+
+// clamped keeps the box within the normalised frame and its corners ordered.
+func (b TrackBox) clamped() TrackBox {
+	b.X1, b.X2 = math.Min(b.X1, b.X2), math.Max(b.X1, b.X2)
+	b.Y1, b.Y2 = math.Min(b.Y1, b.Y2), math.Max(b.Y1, b.Y2)
+	b.X1, b.Y1 = math.Max(b.X1, 0), math.Max(b.Y1, 0)
+	b.X2, b.Y2 = math.Min(b.X2, 1), math.Min(b.Y2, 1)
+	return b
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func trackWith(boxes map[int64]TrackBox, deleted ...int64) FaceRedactionTrack {
+	track := FaceRedactionTrack{Id: "t", FrameCoordinates: boxes, DeletedFrames: deleted}
+	track.TrajectFromFrameCoordinates()
+	return track
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestTrajectRoundTrip(t *testing.T) {
+	track := trackWith(map[int64]TrackBox{
+		4: {X1: 0.4, Y1: 0.4, X2: 0.5, Y2: 0.5, Edited: true},
+		1: {X1: 0.1, Y1: 0.1, X2: 0.2, Y2: 0.2},
+	})
+	if !reflect.DeepEqual(track.Frames, []int64{1, 4}) || !reflect.DeepEqual(track.Traject[1], []float64{0.4, 0.4, 0.5, 0.5, 4}) {
+		t.Fatalf("frames = %v, traject = %v", track.Frames, track.Traject)
+	}
+
+	track.Traject[1][0] = 0.45
+	track.Traject = append(track.Traject, []float64{0.6, 0.6, 0.7, 0.7, 6}, []float64{1, 2})
+	track.FrameCoordinatesFromTraject()
+	if got := track.FrameCoordinates[4]; got.X1 != 0.45 || !got.Edited {
+		t.Errorf("frame 4 = %+v, want new geometry with flags kept", got)
+	}
+	if got := track.FrameCoordinates[6]; got.TrackId != "t" || got.X2 != 0.7 {
+		t.Errorf("frame 6 = %+v", got)
+	}
+	if len(track.FrameCoordinates) != 3 || len(track.Traject) != 3 {
+		t.Errorf("short traject row was not skipped: %v", track.Traject)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	boxes := func() map[int64]TrackBox {
+		return map[int64]TrackBox{
+			0:  {X1: 0, Y1: 0, X2: 0.2, Y2: 0.2, Confidence: 0.9},
+			4:  {X1: 0.4, Y1: 0, X2: 0.6, Y2: 0.2, Confidence: 0.5, Edited: true},
+			8:  {X1: 0.8, Y1: 0, X2: 1, Y2: 0.2},
+			20: {X1: 0, Y1: 0.5, X2: 0.2, Y2: 0.7},
+		}
+	}
+
+	track := trackWith(boxes(), 2)
+	filled, err := track.Interpolate(TrackInterpolation{Method: TrackInterpolateLinear, MaxGap: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames 1 and 3 between 0 and 4 (2 is deleted), 5-7 between 4 and 8; the
+	// 11-frame gap to 20 is too long.
+	if filled != 5 || len(track.Frames) != 9 {
+		t.Fatalf("filled %d, frames %v", filled, track.Frames)
+	}
+	if _, ok := track.FrameCoordinates[2]; ok {
+		t.Error("deleted frame was filled")
+	}
+	got := track.FrameCoordinates[1]
+	if !near(got.X1, 0.1) || !near(got.X2, 0.3) || !got.Smoothed || got.Edited || got.Confidence != 0.5 {
+		t.Errorf("frame 1 = %+v", got)
+	}
+	if got := track.FrameCoordinates[6]; !near(got.X1, 0.6) {
+		t.Errorf("frame 6 = %+v", got)
+	}
+
+	// Where the boxes around a gap move evenly, the spline matches the line.
+	spline := trackWith(boxes())
+	if _, err := spline.Interpolate(TrackInterpolation{Method: TrackInterpolateSpline, MaxGap: 3}); err != nil {
+		t.Fatal(err)
+	}
+	for frame := int64(1); frame < 4; frame++ {
+		if got := spline.FrameCoordinates[frame]; !near(got.X1, float64(frame)/10) {
+			t.Errorf("spline frame %d = %+v", frame, got)
+		}
+	}
+
+	if _, err := track.Interpolate(TrackInterpolation{Method: "cubic"}); !errors.Is(err, ErrTrackInvalidOptions) {
+		t.Errorf("err = %v, want ErrTrackInvalidOptions", err)
+	}
+}
+
+func TestSmoothKeepsEditedBoxes(t *testing.T) {
+	jitter := map[int64]TrackBox{}
+	for frame := int64(0); frame < 20; frame++ {
+		offset := 0.02
+		if frame%2 == 1 {
+			offset = -0.02
+		}
+		jitter[frame] = TrackBox{X1: 0.4 + offset, Y1: 0.4, X2: 0.6 + offset, Y2: 0.6}
+	}
+	jitter[10] = TrackBox{X1: 0.1, Y1: 0.1, X2: 0.2, Y2: 0.2, Edited: true}
+	jitter[11] = TrackBox{X1: 0.9, Y1: 0.9, X2: 1, Y2: 1}
+
+	for _, options := range []TrackSmoothing{
+		{Method: TrackSmoothMovingAverage, Window: 3},
+		{Method: TrackSmoothKalman},
+	} {
+		track := trackWith(jitter, 11)
+		if err := track.Smooth(options); err != nil {
+			t.Fatal(err)
+		}
+		if got := track.FrameCoordinates[10]; got != jitter[10] {
+			t.Errorf("%s: edited box changed to %+v", options.Method, got)
+		}
+		if got := track.FrameCoordinates[11]; got != jitter[11] {
+			t.Errorf("%s: deleted box changed to %+v", options.Method, got)
+		}
+		// Before the edit, smoothing pulls the alternating boxes towards their
+		// mean.
+		got := track.FrameCoordinates[6]
+		if !got.Smoothed || math.Abs(got.X1-0.4) >= 0.02 {
+			t.Errorf("%s: frame 6 = %+v", options.Method, got)
+		}
+		if track.Traject[6][0] != got.X1 {
+			t.Errorf("%s: traject not regenerated", options.Method)
+		}
+	}
+}
+
+func TestPadAndProcessTrack(t *testing.T) {
+	original := trackWith(map[int64]TrackBox{
+		0: {X1: 0.4, Y1: 0.4, X2: 0.6, Y2: 0.5},
+		2: {X1: 0, Y1: 0.9, X2: 0.2, Y2: 1},
+	})
+	processed, err := ProcessTrack(original, TrackProcessing{
+		Interpolation: &TrackInterpolation{Method: TrackInterpolateLinear, MaxGap: 1},
+		Padding:       0.5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := processed.FrameCoordinates[0]; !near(got.X1, 0.3) || !near(got.Y1, 0.35) || !near(got.X2, 0.7) || !near(got.Y2, 0.55) {
+		t.Errorf("frame 0 = %+v", got)
+	}
+	if got := processed.FrameCoordinates[2]; got.X1 != 0 || got.Y2 != 1 || !near(got.X2, 0.3) {
+		t.Errorf("frame 2 = %+v, want clamped to the frame", got)
+	}
+	if len(processed.Frames) != 3 || len(original.Frames) != 2 || original.FrameCoordinates[0].X1 != 0.4 {
+		t.Errorf("original changed or interpolation missing: %v / %v", original.Frames, processed.Frames)
+	}
+	if _, err := ProcessTrack(original, TrackProcessing{Padding: -1}); !errors.Is(err, ErrTrackInvalidOptions) {
+		t.Errorf("err = %v, want ErrTrackInvalidOptions", err)
+	}
+}
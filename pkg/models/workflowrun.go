@@ -234,8 +234,10 @@ type WorkflowRun struct {
 	// Payload is the self-describing block envelope a delegated-ingest worker
 	// hands back for the platform to persist: one or more typed blocks (e.g. a
 	// "detection" block carrying a PostDetectionsRequest, optionally followed by
-	// "marker" blocks). It is the channel the shared ingest core reads from,
-	// distinct from Results:
+	// "marker" blocks). The envelope's wire shape, block registry and
+	// encode/decode/grouping helpers live in the api package (api.PayloadEnvelope,
+	// api.DecodePayload, api.GroupPayloadResults). It is the channel the shared
+	// ingest core reads from, distinct from Results:
 	//
 	//   - Results is the multi-operation, decoded routing/state ledger the
 	//     condition matcher reads and the run persists.
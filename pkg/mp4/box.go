@@ -0,0 +1,158 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// maxLeafBoxSize bounds how much of a single box is read into memory. The
+// boxes the index reads are headers and sample tables; a larger one is a
+// corrupt size field, not a real file.
+const maxLeafBoxSize = 64 << 20
+
+// box is one ISO-BMFF box located in the file: Offset is the first byte of its
+// header, Size covers header and payload.
+type box struct {
+	Type       string
+	Offset     int64
+	Size       int64
+	HeaderSize int64
+}
+
+func (b box) payloadOffset() int64 { return b.Offset + b.HeaderSize }
+func (b box) payloadSize() int64   { return b.Size - b.HeaderSize }
+func (b box) end() int64           { return b.Offset + b.Size }
+
+// readBoxes lists the boxes laid out between start and end.
+func readBoxes(r io.ReaderAt, start, end int64) ([]box, error) {
+	var boxes []box
+	for offset := start; offset < end; {
+		if end-offset < 8 {
+			return nil, fmt.Errorf("%w: %d trailing bytes at offset %d", ErrInvalidBox, end-offset, offset)
+		}
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("%w: read box header at offset %d: %v", ErrInvalidBox, offset, err)
+		}
+		b := box{
+			Type:       string(header[4:8]),
+			Offset:     offset,
+			Size:       int64(binary.BigEndian.Uint32(header[:4])),
+			HeaderSize: 8,
+		}
+		switch b.Size {
+		case 0: // extends to the end of the enclosing space
+			b.Size = end - offset
+		case 1: // 64-bit largesize follows the type
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("%w: read %s largesize at offset %d: %v", ErrInvalidBox, b.Type, offset, err)
+			}
+			b.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+			b.HeaderSize = 16
+		}
+		if b.Size < b.HeaderSize || b.end() > end {
+			return nil, fmt.Errorf("%w: %s at offset %d has size %d", ErrInvalidBox, b.Type, offset, b.Size)
+		}
+		boxes = append(boxes, b)
+		offset = b.end()
+	}
+	return boxes, nil
+}
+
+// children lists the boxes inside a container box.
+func children(r io.ReaderAt, parent box) ([]box, error) {
+	return readBoxes(r, parent.payloadOffset(), parent.end())
+}
+
+// find returns the first box of type typ in boxes.
+func find(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.Type == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// payload reads a leaf box's payload.
+func payload(r io.ReaderAt, b box) (*reader, error) {
+	size := b.payloadSize()
+	if size > maxLeafBoxSize {
+		return nil, fmt.Errorf("%w: %s of %d bytes", ErrInvalidBox, b.Type, size)
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, b.payloadOffset()); err != nil {
+		return nil, fmt.Errorf("%w: read %s at offset %d: %v", ErrInvalidBox, b.Type, b.Offset, err)
+	}
+	return &reader{typ: b.Type, data: data}, nil
+}
+
+// reader decodes big-endian fields from a box payload. The first read past
+// the payload records an error and every later read returns zero, so a parser
+// reads its fields and checks err once.
+type reader struct {
+	typ  string
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("%w: %s payload is %d bytes, need %d", ErrInvalidBox, r.typ, len(r.data), r.pos+n)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) skip(n int) { r.take(n) }
+
+func (r *reader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// fullBox reads the version and 24-bit flags of a FullBox header.
+func (r *reader) fullBox() (version uint8, flags uint32) {
+	word := r.u32()
+	return uint8(word >> 24), word & 0xffffff
+}
+
+// uintV reads a 64-bit field for version 1 boxes and a 32-bit one otherwise.
+func (r *reader) uintV(version uint8) uint64 {
+	if version == 1 {
+		return r.u64()
+	}
+	return uint64(r.u32())
+}
+
+func (r *reader) remaining() int { return len(r.data) - r.pos }
@@ -0,0 +1,221 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+)
+
+// tfhd and trun flags (ISO/IEC 14496-12 §8.8.7, §8.8.8).
+const (
+	tfhdBaseDataOffset         = 0x000001
+	tfhdSampleDescriptionIndex = 0x000002
+	tfhdDefaultSampleDuration  = 0x000008
+	tfhdDefaultSampleSize      = 0x000010
+	tfhdDefaultSampleFlags     = 0x000020
+
+	trunDataOffset       = 0x000001
+	trunFirstSampleFlags = 0x000004
+	trunSampleDuration   = 0x000100
+	trunSampleSize       = 0x000200
+	trunSampleFlags      = 0x000400
+	trunSampleCTSOffset  = 0x000800
+)
+
+// maxTrunSamples bounds the sample count of one trun box: over 4 hours of 60
+// fps video. A trun without per-sample fields costs no bytes per sample, so
+// its count is otherwise unchecked.
+const maxTrunSamples = 1 << 20
+
+// parsedFragment is a Fragment plus whether its start came from a tfdt box;
+// without one it continues where the previous fragment ended.
+type parsedFragment struct {
+	Fragment
+	hasTime bool
+}
+
+// parseFragment reads the traf of track t in a moof box. The fragment's byte
+// range runs from the moof to the end of the first mdat in rest, the boxes
+// that follow it. ok is false when the moof carries no run for t.
+func parseFragment(r io.ReaderAt, moof box, rest []box, t *track) (parsedFragment, bool, error) {
+	boxes, err := children(r, moof)
+	if err != nil {
+		return parsedFragment{}, false, err
+	}
+
+	var fragment parsedFragment
+	if mfhd, ok := find(boxes, "mfhd"); ok {
+		p, err := payload(r, mfhd)
+		if err != nil {
+			return parsedFragment{}, false, err
+		}
+		p.fullBox()
+		fragment.Sequence = p.u32()
+		if p.err != nil {
+			return parsedFragment{}, false, p.err
+		}
+	}
+
+	found := false
+	for _, b := range boxes {
+		if b.Type != "traf" {
+			continue
+		}
+		ok, err := parseTrackFragment(r, b, t, &fragment)
+		if err != nil {
+			return parsedFragment{}, false, err
+		}
+		found = found || ok
+	}
+	if !found {
+		return parsedFragment{}, false, nil
+	}
+
+	end := moof.end()
+	for _, b := range rest {
+		if b.Type == "moof" {
+			break
+		}
+		if b.Type == "mdat" {
+			end = b.end()
+			break
+		}
+	}
+	fragment.Range = ByteRange{Start: moof.Offset, End: end - 1}
+	return fragment, true, nil
+}
+
+// parseTrackFragment adds the samples of a traf box to fragment when it
+// belongs to track t.
+func parseTrackFragment(r io.ReaderAt, traf box, t *track, fragment *parsedFragment) (bool, error) {
+	boxes, err := children(r, traf)
+	if err != nil {
+		return false, err
+	}
+	tfhd, ok := find(boxes, "tfhd")
+	if !ok {
+		return false, fmt.Errorf("%w: traf at offset %d has no tfhd", ErrInvalidBox, traf.Offset)
+	}
+	p, err := payload(r, tfhd)
+	if err != nil {
+		return false, err
+	}
+	_, flags := p.fullBox()
+	if p.u32() != t.id {
+		return false, p.err
+	}
+	if flags&tfhdBaseDataOffset != 0 {
+		p.u64()
+	}
+	if flags&tfhdSampleDescriptionIndex != 0 {
+		p.u32()
+	}
+	defaultDuration := t.defaultSampleDuration
+	if flags&tfhdDefaultSampleDuration != 0 {
+		defaultDuration = p.u32()
+	}
+	if p.err != nil {
+		return false, p.err
+	}
+
+	if tfdt, ok := find(boxes, "tfdt"); ok {
+		p, err := payload(r, tfdt)
+		if err != nil {
+			return false, err
+		}
+		version, _ := p.fullBox()
+		fragment.StartTime = p.uintV(version)
+		fragment.hasTime = true
+		if p.err != nil {
+			return false, p.err
+		}
+	}
+
+	for _, b := range boxes {
+		if b.Type != "trun" {
+			continue
+		}
+		p, err := payload(r, b)
+		if err != nil {
+			return false, err
+		}
+		_, flags := p.fullBox()
+		count := p.u32()
+		if flags&trunDataOffset != 0 {
+			p.u32()
+		}
+		if flags&trunFirstSampleFlags != 0 {
+			p.u32()
+		}
+		perSample := 0
+		for _, flag := range []uint32{trunSampleDuration, trunSampleSize, trunSampleFlags, trunSampleCTSOffset} {
+			if flags&flag != 0 {
+				perSample += 4
+			}
+		}
+		if count > maxTrunSamples || int64(count)*int64(perSample) > int64(p.remaining()) {
+			return false, fmt.Errorf("%w: trun claims %d samples", ErrInvalidBox, count)
+		}
+		if perSample == 0 {
+			// Every sample has the default duration.
+			fragment.Duration += uint64(count) * uint64(defaultDuration)
+			fragment.Samples += int(count)
+			continue
+		}
+		for i := uint32(0); i < count; i++ {
+			duration := defaultDuration
+			if flags&trunSampleDuration != 0 {
+				duration = p.u32()
+			}
+			p.skip(perSample - boolInt(flags&trunSampleDuration != 0)*4)
+			fragment.Duration += uint64(duration)
+		}
+		if p.err != nil {
+			return false, p.err
+		}
+		fragment.Samples += int(count)
+	}
+	return true, nil
+}
+
+// parseSegmentIndex reads the references of a sidx box. Reference offsets are
+// relative to the first byte after the sidx box.
+func parseSegmentIndex(r io.ReaderAt, sidx box) ([]SegmentReference, error) {
+	p, err := payload(r, sidx)
+	if err != nil {
+		return nil, err
+	}
+	version, _ := p.fullBox()
+	p.u32() // reference_ID
+	timescale := p.u32()
+	start := p.uintV(version)
+	offset := sidx.end() + int64(p.uintV(version))
+	p.u16() // reserved
+	count := p.u16()
+	if int(count)*12 > p.remaining() {
+		return nil, fmt.Errorf("%w: sidx claims %d references", ErrInvalidBox, count)
+	}
+
+	references := make([]SegmentReference, 0, count)
+	for i := uint16(0); i < count; i++ {
+		size := int64(p.u32() & 0x7fffffff) // top bit is reference_type
+		duration := uint64(p.u32())
+		sap := p.u32()
+		references = append(references, SegmentReference{
+			StartTime:     start,
+			Duration:      duration,
+			Timescale:     timescale,
+			Range:         ByteRange{Start: offset, End: offset + size - 1},
+			StartsWithSAP: sap>>31 == 1,
+		})
+		start += duration
+		offset += size
+	}
+	return references, p.err
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
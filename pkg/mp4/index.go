@@ -0,0 +1,210 @@
+// Package mp4 reads the index of an ISO-BMFF (MP4) recording — fragmented or
+// not — without decoding any media. Parse walks the box structure of an
+// io.ReaderAt (moov/mvhd/tkhd/mdhd/stsd/stts, moof/traf/tfhd/tfdt/trun and
+// sidx) and returns a typed Index of the video track: its timescale, frame
+// rate, codec and resolution and, for a fragmented file, the start time,
+// duration and byte range of every fragment.
+//
+// The Index renders into the string-typed fields the vault and media documents
+// carry (models.FragmentedBytesRangeOnTime, models.VideoBytesRangeOnTime and
+// models.VaultMediaMetadata), so a producer can replace its own parser without
+// changing the stored format. Going the other way, FragmentIndex types a stored
+// table back so a player or exporter can turn a time interval into HTTP Range
+// requests, and PlanClip stitches an interval across several recordings.
+// HLSPlaylist and DASHManifest serve recordings as byte-range streams.
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrInvalidBox is returned for a box whose size or payload does not fit
+	// the file.
+	ErrInvalidBox = errors.New("invalid mp4 box")
+	// ErrNoMovie is returned when the file has no moov box.
+	ErrNoMovie = errors.New("mp4 has no moov box")
+	// ErrNoTrack is returned when the movie has no track to index.
+	ErrNoTrack = errors.New("mp4 has no track")
+)
+
+// ByteRange is an inclusive range of file offsets, as in an HTTP Range header.
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Length is the number of bytes in the range.
+func (b ByteRange) Length() int64 { return b.End - b.Start + 1 }
+
+// String formats the range as "start-end".
+func (b ByteRange) String() string { return fmt.Sprintf("%d-%d", b.Start, b.End) }
+
+// Fragment is one movie fragment (moof and the mdat after it) of the indexed
+// track. Times are in the track's Timescale.
+type Fragment struct {
+	Sequence  uint32    `json:"sequence"`
+	StartTime uint64    `json:"startTime"`
+	Duration  uint64    `json:"duration"`
+	Samples   int       `json:"samples"`
+	Range     ByteRange `json:"range"`
+}
+
+// SegmentReference is one entry of a segment index (sidx) box. Times are in
+// the sidx Timescale.
+type SegmentReference struct {
+	StartTime     uint64    `json:"startTime"`
+	Duration      uint64    `json:"duration"`
+	Timescale     uint32    `json:"timescale"`
+	Range         ByteRange `json:"range"`
+	StartsWithSAP bool      `json:"startsWithSap"`
+}
+
+// Index is what Parse learns about the video track of a recording, or its
+// first track when it has no video.
+type Index struct {
+	TrackId        uint32 `json:"trackId"`
+	MovieTimescale uint32 `json:"movieTimescale"`
+	// Timescale is the track's media timescale (ticks per second); Duration,
+	// fragment times and durations are in it.
+	Timescale uint32  `json:"timescale"`
+	Duration  uint64  `json:"duration"`
+	FPS       float64 `json:"fps"`
+	// Codec is the RFC 6381 codec string when the sample entry carries the
+	// configuration for it (avc1.64001f), else the sample entry type (hvc1).
+	Codec  string `json:"codec"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+
+	Fragmented bool `json:"fragmented"`
+	// Init is the initialisation segment: everything before the first
+	// fragment (ftyp and moov). It is zero for a non-fragmented file.
+	Init      ByteRange  `json:"init"`
+	Fragments []Fragment `json:"fragments,omitempty"`
+	// Segments are the references of the first sidx box, when there is one,
+	// and SegmentIndex is that box's byte range.
+	Segments     []SegmentReference `json:"segments,omitempty"`
+	SegmentIndex *ByteRange         `json:"segmentIndex,omitempty"`
+}
+
+// Seconds converts a duration in the index's Timescale to seconds.
+func (ix *Index) Seconds(ticks uint64) float64 {
+	if ix.Timescale == 0 {
+		return 0
+	}
+	return float64(ticks) / float64(ix.Timescale)
+}
+
+// DurationSeconds is the indexed track's duration in seconds.
+func (ix *Index) DurationSeconds() float64 { return ix.Seconds(ix.Duration) }
+
+// track is what a trak box says about one track.
+type track struct {
+	id         uint32
+	handler    string
+	timescale  uint32
+	duration   uint64
+	codec      string
+	width      int
+	height     int
+	samples    uint64 // from stts, for non-fragmented files
+	sampleTime uint64 // total stts duration
+	// defaultSampleDuration comes from the track's trex box.
+	defaultSampleDuration uint32
+}
+
+// Parse indexes the recording of size bytes read from r.
+func Parse(r io.ReaderAt, size int64) (*Index, error) {
+	top, err := readBoxes(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	moov, ok := find(top, "moov")
+	if !ok {
+		return nil, ErrNoMovie
+	}
+
+	ix := &Index{}
+	tracks, err := parseMovie(r, moov, ix)
+	if err != nil {
+		return nil, err
+	}
+	video := pickTrack(tracks)
+	if video == nil {
+		return nil, ErrNoTrack
+	}
+	ix.TrackId = video.id
+	ix.Timescale = video.timescale
+	ix.Codec = video.codec
+	ix.Width, ix.Height = video.width, video.height
+	ix.Duration = video.duration
+
+	var samples int
+	for i, b := range top {
+		switch b.Type {
+		case "sidx":
+			if ix.Segments != nil {
+				continue
+			}
+			if ix.Segments, err = parseSegmentIndex(r, b); err != nil {
+				return nil, err
+			}
+			ix.SegmentIndex = &ByteRange{Start: b.Offset, End: b.end() - 1}
+		case "moof":
+			fragment, ok, err := parseFragment(r, b, top[i+1:], video)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if !ix.Fragmented {
+				ix.Fragmented = true
+				ix.Init = ByteRange{Start: 0, End: b.Offset - 1}
+			}
+			if n := len(ix.Fragments); n > 0 && !fragment.hasTime {
+				previous := ix.Fragments[n-1]
+				fragment.StartTime = previous.StartTime + previous.Duration
+			}
+			ix.Fragments = append(ix.Fragments, fragment.Fragment)
+			samples += fragment.Samples
+		}
+	}
+
+	if ix.Fragmented {
+		// A fragmented movie usually leaves mdhd's duration at zero; the
+		// fragments are the authority.
+		first, last := ix.Fragments[0], ix.Fragments[len(ix.Fragments)-1]
+		span := last.StartTime + last.Duration - first.StartTime
+		if span > ix.Duration {
+			ix.Duration = span
+		}
+		ix.FPS = rate(uint64(samples), span, ix.Timescale)
+	} else {
+		ix.FPS = rate(video.samples, video.sampleTime, ix.Timescale)
+	}
+	return ix, nil
+}
+
+// rate is samples per second over ticks of timescale.
+func rate(samples, ticks uint64, timescale uint32) float64 {
+	if samples == 0 || ticks == 0 || timescale == 0 {
+		return 0
+	}
+	return float64(samples) * float64(timescale) / float64(ticks)
+}
+
+// pickTrack prefers the first video track, then the first track.
+func pickTrack(tracks []*track) *track {
+	for _, t := range tracks {
+		if t.handler == "vide" {
+			return t
+		}
+	}
+	if len(tracks) > 0 {
+		return tracks[0]
+	}
+	return nil
+}
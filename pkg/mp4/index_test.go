@@ -0,0 +1,131 @@
+package mp4
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+func parseFixture(t *testing.T, name string) (*Index, int64) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("parse %s: %v", name, err)
+	}
+	return ix, int64(len(data))
+}
+
+func TestParseFragmented(t *testing.T) {
+	ix, size := parseFixture(t, "fragmented.mp4")
+
+	if !ix.Fragmented || ix.TrackId != 1 || ix.Timescale != 90000 || ix.MovieTimescale != 1000 {
+		t.Fatalf("index = %+v", ix)
+	}
+	if ix.Codec != "avc1.64001f" || ix.Width != 640 || ix.Height != 360 {
+		t.Errorf("codec/resolution = %s %dx%d", ix.Codec, ix.Width, ix.Height)
+	}
+	if ix.Duration != 153000 || ix.FPS != 48*90000.0/153000 {
+		t.Errorf("duration = %d, fps = %v", ix.Duration, ix.FPS)
+	}
+	if len(ix.Fragments) != 3 {
+		t.Fatalf("fragments = %+v", ix.Fragments)
+	}
+
+	starts := []uint64{ix.Fragments[0].StartTime, ix.Fragments[1].StartTime, ix.Fragments[2].StartTime}
+	durations := []uint64{ix.Fragments[0].Duration, ix.Fragments[1].Duration, ix.Fragments[2].Duration}
+	if !reflect.DeepEqual(starts, []uint64{0, 90000, 135000}) || !reflect.DeepEqual(durations, []uint64{90000, 45000, 18000}) {
+		t.Errorf("starts = %v, durations = %v", starts, durations)
+	}
+
+	// Fragments tile the file after the init segment and the sidx.
+	if ix.Init.Start != 0 || ix.Fragments[len(ix.Fragments)-1].Range.End != size-1 {
+		t.Errorf("init = %v, last fragment = %v, size %d", ix.Init, ix.Fragments[2].Range, size)
+	}
+	for i := 1; i < len(ix.Fragments); i++ {
+		if ix.Fragments[i].Range.Start != ix.Fragments[i-1].Range.End+1 {
+			t.Errorf("fragment %d starts at %d, previous ends at %d", i, ix.Fragments[i].Range.Start, ix.Fragments[i-1].Range.End)
+		}
+	}
+
+	// The sidx references the same byte ranges the moofs were found at.
+	if len(ix.Segments) != 3 {
+		t.Fatalf("segments = %+v", ix.Segments)
+	}
+	for i, segment := range ix.Segments {
+		if segment.Range != ix.Fragments[i].Range || segment.Duration != ix.Fragments[i].Duration || !segment.StartsWithSAP {
+			t.Errorf("segment %d = %+v, fragment %+v", i, segment, ix.Fragments[i])
+		}
+	}
+}
+
+func TestRenderIntoStoredFields(t *testing.T) {
+	ix, _ := parseFixture(t, "fragmented.mp4")
+
+	metadata := ix.VaultMediaMetadata()
+	if !metadata.IsFragmented || metadata.Timescale != 90000 || metadata.Duration != 153000 || metadata.FPS != ix.FPS {
+		t.Errorf("metadata = %+v", metadata)
+	}
+	want := []models.FragmentedBytesRangeOnTime{
+		{Time: "0", Duration: "1", Range: ix.Fragments[0].Range.String()},
+		{Time: "1", Duration: "0.5", Range: ix.Fragments[1].Range.String()},
+		{Time: "1.5", Duration: "0.2", Range: ix.Fragments[2].Range.String()},
+	}
+	if !reflect.DeepEqual(metadata.BytesRangeOnTime, want) {
+		t.Errorf("bytes range on time = %+v, want %+v", metadata.BytesRangeOnTime, want)
+	}
+	if got := ix.VideoBytesRangeOnTime(); len(got) != 3 || got[2].Time != "1.5" || got[2].Range != want[2].Range {
+		t.Errorf("video bytes range on time = %+v", got)
+	}
+	if wantRanges := ix.Init.String() + "," + want[0].Range + "," + want[1].Range + "," + want[2].Range; metadata.BytesRanges != wantRanges {
+		t.Errorf("bytes ranges = %q, want %q", metadata.BytesRanges, wantRanges)
+	}
+
+	var media models.MediaMetadata
+	ix.MediaMetadata(&media)
+	if media.Resolution != "640x360" || media.Codec != "avc1.64001f" || media.Container != "mp4" {
+		t.Errorf("media metadata = %+v", media)
+	}
+}
+
+func TestParseProgressive(t *testing.T) {
+	ix, _ := parseFixture(t, "progressive.mp4")
+	if ix.Fragmented || len(ix.Fragments) != 0 || ix.BytesRanges() != "" {
+		t.Errorf("progressive index = %+v", ix)
+	}
+	if ix.Timescale != 45000 || ix.Duration != 90000 || ix.FPS != 30 || ix.DurationSeconds() != 2 {
+		t.Errorf("timing = %d/%d at %v fps", ix.Duration, ix.Timescale, ix.FPS)
+	}
+}
+
+func TestParseRejectsBrokenFiles(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "fragmented.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := data[:len(data)-10]
+	if _, err := Parse(bytes.NewReader(truncated), int64(len(truncated))); !errors.Is(err, ErrInvalidBox) {
+		t.Errorf("truncated err = %v, want ErrInvalidBox", err)
+	}
+	// A trun without per-sample fields can claim billions of samples for a
+	// few bytes; counting them one by one took seconds per box.
+	hostile, err := os.ReadFile(filepath.Join("testdata", "trun_sample_count.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(bytes.NewReader(hostile), int64(len(hostile))); !errors.Is(err, ErrInvalidBox) {
+		t.Errorf("trun sample count err = %v, want ErrInvalidBox", err)
+	}
+	ftyp := data[:28]
+	if _, err := Parse(bytes.NewReader(ftyp), int64(len(ftyp))); !errors.Is(err, ErrNoMovie) {
+		t.Errorf("no moov err = %v, want ErrNoMovie", err)
+	}
+}
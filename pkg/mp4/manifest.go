@@ -0,0 +1,365 @@
+package mp4
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// ErrNoFragments is returned when a manifest is asked for recordings without
+// any fragment to serve.
+var ErrNoFragments = errors.New("no fragments to serve")
+
+// dashTimescale is the timescale DASH segment timelines are written in:
+// milliseconds, the precision of the stored fragment tables.
+const dashTimescale = 1000
+
+// The on-demand profile requires every representation to be a SegmentBase;
+// a manifest with any SegmentList falls back to the main profile.
+const (
+	dashOnDemandProfile = "urn:mpeg:dash:profile:isoff-on-demand:2011"
+	dashMainProfile     = "urn:mpeg:dash:profile:isoff-main:2011"
+)
+
+// Recording is one fragmented MP4 file served as part of a stream.
+type Recording struct {
+	// Url is where the file is fetched from; every segment is a byte range of it.
+	Url string
+	// StartTimestamp is the recording's start (unix seconds, as
+	// Media.StartTimestamp). Zero omits program date times.
+	StartTimestamp int64
+	Fragments      *FragmentIndex
+	// SegmentIndex is the byte range of the file's sidx box, when known. It
+	// lets DASH describe the file with SegmentBase.
+	SegmentIndex *ByteRange
+}
+
+// RecordingFromCollection prepares a vault fragment collection for serving.
+func RecordingFromCollection(collection models.VaultMediaFragmentCollection) (Recording, error) {
+	fragments, err := parseFragmentsWithInit(collection.BytesRangeOnTime, collection.BytesRanges)
+	if err != nil {
+		return Recording{}, fmt.Errorf("%s: %w", collection.Key, err)
+	}
+	return Recording{Url: collection.Url, StartTimestamp: collection.Timestamp, Fragments: fragments}, nil
+}
+
+// RecordingFromMedia prepares a media document's recording, fetched from url,
+// for serving.
+func RecordingFromMedia(media models.Media, url string) (Recording, error) {
+	fragments, err := ParseVideoFragmentTable(media.VideoBytesRangeOnTime)
+	if err != nil {
+		return Recording{}, err
+	}
+	return Recording{Url: url, StartTimestamp: media.StartTimestamp, Fragments: fragments}, nil
+}
+
+// RecordingFromIndex prepares a parsed file, fetched from url, for serving.
+func RecordingFromIndex(ix *Index, url string, startTimestamp int64) Recording {
+	fragments := &FragmentIndex{}
+	if ix.Fragmented {
+		init := ix.Init
+		// The sidx sits between moov and the first moof; it is not part of
+		// the initialisation segment.
+		if ix.SegmentIndex != nil && init.End >= ix.SegmentIndex.Start {
+			init.End = ix.SegmentIndex.Start - 1
+		}
+		fragments.Init = &init
+	}
+	if len(ix.Fragments) > 0 {
+		origin := ix.Fragments[0].StartTime
+		for _, fragment := range ix.Fragments {
+			fragments.Entries = append(fragments.Entries, FragmentEntry{
+				Start:    ix.Seconds(fragment.StartTime - origin),
+				Duration: ix.Seconds(fragment.Duration),
+				Range:    fragment.Range,
+			})
+		}
+	}
+	return Recording{Url: url, StartTimestamp: startTimestamp, Fragments: fragments, SegmentIndex: ix.SegmentIndex}
+}
+
+// initRange is the recording's initialisation segment: Init when known,
+// otherwise every byte before the first fragment. ok is false when the first
+// fragment starts the file.
+func (r Recording) initRange() (ByteRange, bool) {
+	if r.Fragments.Init != nil {
+		return *r.Fragments.Init, true
+	}
+	if first := r.Fragments.Entries[0].Range.Start; first > 0 {
+		return ByteRange{Start: 0, End: first - 1}, true
+	}
+	return ByteRange{}, false
+}
+
+func checkRecordings(recordings []Recording) error {
+	if len(recordings) == 0 {
+		return ErrNoFragments
+	}
+	for i, recording := range recordings {
+		if recording.Fragments == nil || len(recording.Fragments.Entries) == 0 {
+			return fmt.Errorf("%w: recording %d (%s)", ErrNoFragments, i, recording.Url)
+		}
+	}
+	return nil
+}
+
+// fragmentGap is how far apart, in seconds, two fragments of a file may be
+// and still count as contiguous: the stored tables round to milliseconds.
+const fragmentGap = 0.001
+
+// HLSPlaylist renders recordings as one VOD media playlist: each fragment is
+// an EXT-X-BYTERANGE segment of its file, each file's init segment an
+// EXT-X-MAP, and a discontinuity separates consecutive files, as it does a gap
+// between fragments of one file. Files with a StartTimestamp carry an
+// EXT-X-PROGRAM-DATE-TIME after every discontinuity, so a player's clock
+// skips the gap.
+func HLSPlaylist(recordings []Recording) (string, error) {
+	if err := checkRecordings(recordings); err != nil {
+		return "", err
+	}
+	target := 0.0
+	for _, recording := range recordings {
+		for _, entry := range recording.Fragments.Entries {
+			target = math.Max(target, entry.Duration)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for i, recording := range recordings {
+		if i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if init, ok := recording.initRange(); ok {
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q,BYTERANGE=\"%d@%d\"\n", recording.Url, init.Length(), init.Start)
+		}
+		if recording.StartTimestamp > 0 {
+			fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", programDateTime(recording.StartTimestamp, recording.Fragments.Entries[0].Start))
+		}
+		for j, entry := range recording.Fragments.Entries {
+			if j > 0 && entry.Start-recording.Fragments.Entries[j-1].End() > fragmentGap {
+				b.WriteString("#EXT-X-DISCONTINUITY\n")
+				if recording.StartTimestamp > 0 {
+					fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", programDateTime(recording.StartTimestamp, entry.Start))
+				}
+			}
+			fmt.Fprintf(&b, "#EXTINF:%s,\n", strconv.FormatFloat(entry.Duration, 'f', 3, 64))
+			fmt.Fprintf(&b, "#EXT-X-BYTERANGE:%d@%d\n", entry.Range.Length(), entry.Range.Start)
+			b.WriteString(recording.Url + "\n")
+		}
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.String(), nil
+}
+
+func programDateTime(startTimestamp int64, offset float64) string {
+	at := time.Unix(startTimestamp, 0).Add(time.Duration(math.Round(offset * float64(time.Second))))
+	return at.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// StreamInfo describes the video the recordings carry, for the DASH
+// representation. Every field is optional.
+type StreamInfo struct {
+	Codec  string
+	Width  int
+	Height int
+}
+
+// StreamInfoFromIndex takes the stream description from a parsed file.
+func StreamInfoFromIndex(ix *Index) StreamInfo {
+	return StreamInfo{Codec: ix.Codec, Width: ix.Width, Height: ix.Height}
+}
+
+type mpd struct {
+	XMLName                   xml.Name    `xml:"MPD"`
+	Xmlns                     string      `xml:"xmlns,attr"`
+	Profiles                  string      `xml:"profiles,attr"`
+	Type                      string      `xml:"type,attr"`
+	MediaPresentationDuration string      `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string      `xml:"minBufferTime,attr"`
+	AvailabilityStartTime     string      `xml:"availabilityStartTime,attr,omitempty"`
+	Periods                   []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	Id            string           `xml:"id,attr"`
+	Start         string           `xml:"start,attr"`
+	Duration      string           `xml:"duration,attr"`
+	AdaptationSet mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ContentType      string            `xml:"contentType,attr"`
+	MimeType         string            `xml:"mimeType,attr"`
+	SegmentAlignment bool              `xml:"segmentAlignment,attr"`
+	Representation   mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	Id          string          `xml:"id,attr"`
+	Codecs      string          `xml:"codecs,attr,omitempty"`
+	Width       int             `xml:"width,attr,omitempty"`
+	Height      int             `xml:"height,attr,omitempty"`
+	Bandwidth   int64           `xml:"bandwidth,attr"`
+	BaseURL     string          `xml:"BaseURL"`
+	SegmentBase *mpdSegmentBase `xml:"SegmentBase"`
+	SegmentList *mpdSegmentList `xml:"SegmentList"`
+}
+
+type mpdURL struct {
+	Range string `xml:"range,attr"`
+}
+
+type mpdSegmentBase struct {
+	IndexRange     string `xml:"indexRange,attr"`
+	Initialization mpdURL `xml:"Initialization"`
+}
+
+type mpdSegmentList struct {
+	Timescale       int             `xml:"timescale,attr"`
+	Initialization  *mpdURL         `xml:"Initialization"`
+	SegmentTimeline []mpdTimelineS  `xml:"SegmentTimeline>S"`
+	SegmentURLs     []mpdSegmentURL `xml:"SegmentURL"`
+}
+
+type mpdTimelineS struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+type mpdSegmentURL struct {
+	MediaRange string `xml:"mediaRange,attr"`
+}
+
+// DASHManifest renders recordings as a static MPD with one Period per file,
+// so a discontinuity between files is a period boundary. A file whose sidx
+// range is known is described by SegmentBase; otherwise by a SegmentList with
+// a SegmentTimeline in milliseconds. The first file's StartTimestamp, when
+// set, anchors availabilityStartTime, and every file with a StartTimestamp
+// starts its Period that far after it, so the time between recordings stays
+// on the timeline. A file without one, or one that would overlap the previous
+// Period, starts where the previous Period ends.
+func DASHManifest(recordings []Recording, info StreamInfo) (string, error) {
+	if err := checkRecordings(recordings); err != nil {
+		return "", err
+	}
+
+	manifest := mpd{
+		Xmlns:         "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:      dashOnDemandProfile,
+		Type:          "static",
+		MinBufferTime: "PT2S",
+	}
+	if recordings[0].StartTimestamp > 0 {
+		manifest.AvailabilityStartTime = programDateTime(recordings[0].StartTimestamp, 0)
+	}
+
+	var offset float64
+	for i, recording := range recordings {
+		entries := recording.Fragments.Entries
+		duration := entries[len(entries)-1].End() - entries[0].Start
+		start := offset
+		if anchor := recordings[0].StartTimestamp; anchor > 0 && recording.StartTimestamp > 0 {
+			start = math.Max(offset, float64(recording.StartTimestamp-anchor)+entries[0].Start)
+		}
+
+		var size int64
+		for _, entry := range entries {
+			size += entry.Range.Length()
+		}
+		representation := mpdRepresentation{
+			Id:        strconv.Itoa(i),
+			Codecs:    info.Codec,
+			Width:     info.Width,
+			Height:    info.Height,
+			Bandwidth: bandwidth(size, duration),
+			BaseURL:   recording.Url,
+		}
+		init, hasInit := recording.initRange()
+		if recording.SegmentIndex != nil && hasInit {
+			representation.SegmentBase = &mpdSegmentBase{
+				IndexRange:     recording.SegmentIndex.String(),
+				Initialization: mpdURL{Range: init.String()},
+			}
+		} else {
+			list := &mpdSegmentList{Timescale: dashTimescale, SegmentTimeline: segmentTimeline(entries)}
+			if hasInit {
+				list.Initialization = &mpdURL{Range: init.String()}
+			}
+			for _, entry := range entries {
+				list.SegmentURLs = append(list.SegmentURLs, mpdSegmentURL{MediaRange: entry.Range.String()})
+			}
+			representation.SegmentList = list
+			manifest.Profiles = dashMainProfile
+		}
+
+		manifest.Periods = append(manifest.Periods, mpdPeriod{
+			Id:       strconv.Itoa(i),
+			Start:    isoDuration(start),
+			Duration: isoDuration(duration),
+			AdaptationSet: mpdAdaptationSet{
+				ContentType:      "video",
+				MimeType:         "video/mp4",
+				SegmentAlignment: true,
+				Representation:   representation,
+			},
+		})
+		offset = start + duration
+	}
+	manifest.MediaPresentationDuration = isoDuration(offset)
+
+	out, err := xml.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(out) + "\n", nil
+}
+
+// segmentTimeline writes fragment durations in milliseconds, folding runs of
+// equal durations into one S element with a repeat count. The first element
+// carries the start time, as does any that follows a gap.
+func segmentTimeline(entries []FragmentEntry) []mpdTimelineS {
+	var timeline []mpdTimelineS
+	var next int64
+	for i, entry := range entries {
+		start := int64(math.Round((entry.Start - entries[0].Start) * dashTimescale))
+		duration := int64(math.Round(entry.Duration * dashTimescale))
+		if n := len(timeline); n > 0 && start == next && timeline[n-1].D == duration {
+			timeline[n-1].R++
+			next += duration
+			continue
+		}
+		element := mpdTimelineS{D: duration}
+		if i == 0 || start != next {
+			element.T = &start
+		}
+		timeline = append(timeline, element)
+		next = start + duration
+	}
+	return timeline
+}
+
+// bandwidth is the average bits per second of size bytes over seconds.
+func bandwidth(size int64, seconds float64) int64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(size) * 8 / seconds))
+}
+
+// isoDuration formats seconds as an ISO 8601 duration, "PT12.5S".
+func isoDuration(seconds float64) string {
+	return "PT" + strconv.FormatFloat(math.Round(seconds*dashTimescale)/dashTimescale, 'f', -1, 64) + "S"
+}
@@ -0,0 +1,166 @@
+package mp4
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
+
+// manifestCollections are two consecutive recordings of a camera; the second
+// has no initialisation range stored and a gap in its fragment table.
+func manifestCollections() []models.VaultMediaFragmentCollection {
+	return []models.VaultMediaFragmentCollection{
+		{
+			Key:         "cam1/1700000000.mp4",
+			Url:         "https://vault.example.com/cam1/1700000000.mp4",
+			Timestamp:   1700000000,
+			BytesRanges: "0-799,800-1799,1800-2799,2800-3299",
+			BytesRangeOnTime: []models.FragmentedBytesRangeOnTime{
+				{Time: "0", Duration: "2", Range: "800-1799"},
+				{Time: "2", Duration: "2", Range: "1800-2799"},
+				{Time: "4", Duration: "1.5", Range: "2800-3299"},
+			},
+		},
+		{
+			Key:       "cam1/1700000010.mp4",
+			Url:       "https://vault.example.com/cam1/1700000010.mp4",
+			Timestamp: 1700000010,
+			BytesRangeOnTime: []models.FragmentedBytesRangeOnTime{
+				{Time: "0", Duration: "2", Range: "900-1899"},
+				{Time: "3", Duration: "2", Range: "1900-2899"},
+			},
+		},
+	}
+}
+
+func manifestRecordings(t *testing.T) []Recording {
+	t.Helper()
+	var recordings []Recording
+	for _, collection := range manifestCollections() {
+		recording, err := RecordingFromCollection(collection)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recordings = append(recordings, recording)
+	}
+	return recordings
+}
+
+func TestHLSPlaylist(t *testing.T) {
+	playlist, err := HLSPlaylist(manifestRecordings(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "collections.m3u8", playlist)
+
+	again, _ := HLSPlaylist(manifestRecordings(t))
+	if again != playlist {
+		t.Error("playlist is not deterministic")
+	}
+}
+
+func TestDASHManifest(t *testing.T) {
+	manifest, err := DASHManifest(manifestRecordings(t), StreamInfo{Codec: "avc1.64001f", Width: 1280, Height: 720})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "collections.mpd", manifest)
+}
+
+func TestManifestsFromIndex(t *testing.T) {
+	ix, _ := parseFixture(t, "fragmented.mp4")
+	recording := RecordingFromIndex(ix, "fragmented.mp4", 1700000000)
+	if recording.Fragments.Init.End >= ix.SegmentIndex.Start {
+		t.Errorf("init %v includes the sidx at %v", recording.Fragments.Init, ix.SegmentIndex)
+	}
+
+	playlist, err := HLSPlaylist([]Recording{recording})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "fragmented.m3u8", playlist)
+
+	manifest, err := DASHManifest([]Recording{recording}, StreamInfoFromIndex(ix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "fragmented.mpd", manifest)
+}
+
+func TestManifestsFromMedia(t *testing.T) {
+	media := models.Media{
+		StartTimestamp: 1700000000,
+		VideoBytesRangeOnTime: []models.VideoBytesRangeOnTime{
+			{Time: "0", Duration: "1", Range: "0-99"},
+			{Time: "1", Duration: "1", Range: "100-199"},
+		},
+	}
+	recording, err := RecordingFromMedia(media, "media.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	playlist, err := HLSPlaylist([]Recording{recording})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first fragment starts the file, so there is no init segment to map.
+	want := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-PROGRAM-DATE-TIME:2023-11-14T22:13:20.000Z\n" +
+		"#EXTINF:1.000,\n#EXT-X-BYTERANGE:100@0\nmedia.mp4\n" +
+		"#EXTINF:1.000,\n#EXT-X-BYTERANGE:100@100\nmedia.mp4\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist != want {
+		t.Errorf("playlist =\n%s\nwant\n%s", playlist, want)
+	}
+}
+
+func TestDASHPeriodStarts(t *testing.T) {
+	recordings := manifestRecordings(t)
+	// Without a timestamp the second file has no place on the clock, so it
+	// follows the first; one that claims to start inside the first does too.
+	for _, timestamp := range []int64{0, 1700000003} {
+		recordings[1].StartTimestamp = timestamp
+		manifest, err := DASHManifest(recordings, StreamInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(manifest, `<Period id="1" start="PT5.5S" duration="PT5S">`) || !strings.Contains(manifest, `mediaPresentationDuration="PT10.5S"`) {
+			t.Errorf("timestamp %d: manifest =\n%s", timestamp, manifest)
+		}
+	}
+}
+
+func TestManifestsRejectEmptyRecordings(t *testing.T) {
+	if _, err := HLSPlaylist(nil); !errors.Is(err, ErrNoFragments) {
+		t.Errorf("HLS err = %v, want ErrNoFragments", err)
+	}
+	empty := Recording{Url: "empty.mp4", Fragments: &FragmentIndex{}}
+	if _, err := DASHManifest([]Recording{empty}, StreamInfo{}); !errors.Is(err, ErrNoFragments) {
+		t.Errorf("DASH err = %v, want ErrNoFragments", err)
+	}
+}
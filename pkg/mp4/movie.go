@@ -0,0 +1,237 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+)
+
+// parseMovie reads mvhd into ix and returns the tracks of a moov box.
+func parseMovie(r io.ReaderAt, moov box, ix *Index) ([]*track, error) {
+	boxes, err := children(r, moov)
+	if err != nil {
+		return nil, err
+	}
+	if mvhd, ok := find(boxes, "mvhd"); ok {
+		p, err := payload(r, mvhd)
+		if err != nil {
+			return nil, err
+		}
+		version, _ := p.fullBox()
+		p.uintV(version) // creation_time
+		p.uintV(version) // modification_time
+		ix.MovieTimescale = p.u32()
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+
+	var tracks []*track
+	for _, b := range boxes {
+		if b.Type != "trak" {
+			continue
+		}
+		t, err := parseTrack(r, b)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, t)
+	}
+
+	if mvex, ok := find(boxes, "mvex"); ok {
+		if err := parseMovieExtends(r, mvex, tracks); err != nil {
+			return nil, err
+		}
+	}
+	return tracks, nil
+}
+
+func parseTrack(r io.ReaderAt, trak box) (*track, error) {
+	t := &track{}
+	boxes, err := children(r, trak)
+	if err != nil {
+		return nil, err
+	}
+	if tkhd, ok := find(boxes, "tkhd"); ok {
+		p, err := payload(r, tkhd)
+		if err != nil {
+			return nil, err
+		}
+		version, _ := p.fullBox()
+		p.uintV(version) // creation_time
+		p.uintV(version) // modification_time
+		t.id = p.u32()
+		p.skip(4)        // reserved
+		p.uintV(version) // duration, in the movie timescale
+		p.skip(8 + 2 + 2 + 2 + 2 + 36)
+		// width and height are 16.16 fixed point.
+		t.width = int(p.u32() >> 16)
+		t.height = int(p.u32() >> 16)
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+
+	mdia, ok := find(boxes, "mdia")
+	if !ok {
+		return t, nil
+	}
+	if boxes, err = children(r, mdia); err != nil {
+		return nil, err
+	}
+	if mdhd, ok := find(boxes, "mdhd"); ok {
+		p, err := payload(r, mdhd)
+		if err != nil {
+			return nil, err
+		}
+		version, _ := p.fullBox()
+		p.uintV(version) // creation_time
+		p.uintV(version) // modification_time
+		t.timescale = p.u32()
+		t.duration = p.uintV(version)
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+	if hdlr, ok := find(boxes, "hdlr"); ok {
+		p, err := payload(r, hdlr)
+		if err != nil {
+			return nil, err
+		}
+		p.fullBox()
+		p.skip(4) // pre_defined
+		t.handler = string(p.take(4))
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+
+	minf, ok := find(boxes, "minf")
+	if !ok {
+		return t, nil
+	}
+	if boxes, err = children(r, minf); err != nil {
+		return nil, err
+	}
+	stbl, ok := find(boxes, "stbl")
+	if !ok {
+		return t, nil
+	}
+	if boxes, err = children(r, stbl); err != nil {
+		return nil, err
+	}
+	if stsd, ok := find(boxes, "stsd"); ok {
+		if err := parseSampleDescription(r, stsd, t); err != nil {
+			return nil, err
+		}
+	}
+	if stts, ok := find(boxes, "stts"); ok {
+		p, err := payload(r, stts)
+		if err != nil {
+			return nil, err
+		}
+		p.fullBox()
+		entries := p.u32()
+		if int64(entries)*8 > int64(p.remaining()) {
+			return nil, fmt.Errorf("%w: stts claims %d entries", ErrInvalidBox, entries)
+		}
+		for i := uint32(0); i < entries; i++ {
+			count, delta := p.u32(), p.u32()
+			t.samples += uint64(count)
+			t.sampleTime += uint64(count) * uint64(delta)
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+	}
+	return t, nil
+}
+
+// videoSampleEntries are the sample entry types read as visual sample entries.
+var videoSampleEntries = map[string]bool{
+	"avc1": true, "avc3": true, "hvc1": true, "hev1": true,
+	"mp4v": true, "av01": true, "vp08": true, "vp09": true,
+}
+
+// parseSampleDescription reads the codec, and the resolution when tkhd left
+// it unset, from the first sample entry.
+func parseSampleDescription(r io.ReaderAt, stsd box, t *track) error {
+	p, err := payload(r, stsd)
+	if err != nil {
+		return err
+	}
+	p.fullBox()
+	if p.u32() == 0 || p.err != nil {
+		return p.err
+	}
+	entryStart := p.pos
+	entrySize := int(p.u32())
+	entryType := string(p.take(4))
+	if p.err != nil {
+		return p.err
+	}
+	t.codec = entryType
+	if !videoSampleEntries[entryType] {
+		return nil
+	}
+
+	// SampleEntry: reserved(6) data_reference_index(2); VisualSampleEntry:
+	// pre_defined/reserved(16) width(2) height(2) ... up to 78 bytes.
+	p.skip(6 + 2 + 16)
+	width, height := int(p.u16()), int(p.u16())
+	p.skip(50)
+	if p.err != nil {
+		return p.err
+	}
+	if t.width == 0 && t.height == 0 {
+		t.width, t.height = width, height
+	}
+
+	// The codec configuration box follows the fixed fields.
+	end := entryStart + entrySize
+	if end > len(p.data) {
+		return fmt.Errorf("%w: stsd entry of %d bytes", ErrInvalidBox, entrySize)
+	}
+	for p.pos+8 <= end {
+		size := int(p.u32())
+		typ := string(p.take(4))
+		if size < 8 || p.pos-8+size > end {
+			break
+		}
+		body := p.take(size - 8)
+		if typ == "avcC" && len(body) >= 4 {
+			// configurationVersion, profile, compatibility, level.
+			t.codec = fmt.Sprintf("%s.%02x%02x%02x", entryType, body[1], body[2], body[3])
+		}
+	}
+	return nil
+}
+
+// parseMovieExtends reads the per-track fragment defaults from trex boxes.
+func parseMovieExtends(r io.ReaderAt, mvex box, tracks []*track) error {
+	boxes, err := children(r, mvex)
+	if err != nil {
+		return err
+	}
+	for _, b := range boxes {
+		if b.Type != "trex" {
+			continue
+		}
+		p, err := payload(r, b)
+		if err != nil {
+			return err
+		}
+		p.fullBox()
+		id := p.u32()
+		p.u32() // default_sample_description_index
+		duration := p.u32()
+		if p.err != nil {
+			return p.err
+		}
+		for _, t := range tracks {
+			if t.id == id {
+				t.defaultSampleDuration = duration
+			}
+		}
+	}
+	return nil
+}
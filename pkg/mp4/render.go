@@ -0,0 +1,103 @@
+package mp4
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+// The stored byte-range-on-time format, which the methods below produce:
+//
+//	Time      fragment start in seconds from the start of the recording
+//	Duration  fragment duration in seconds
+//	Range     inclusive byte range "start-end"
+//
+// Seconds are written in the shortest form that reads back exactly ("2",
+// "0.5"). BytesRanges is the comma-separated list of the initialisation
+// segment's range followed by every fragment's.
+
+// FormatSeconds formats seconds the way the stored time fields expect.
+func FormatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
+// fragmentTimes returns each fragment's start (relative to the first) and
+// duration in seconds, formatted.
+func (ix *Index) fragmentTimes() (times, durations []string) {
+	if len(ix.Fragments) == 0 {
+		return nil, nil
+	}
+	origin := ix.Fragments[0].StartTime
+	for _, fragment := range ix.Fragments {
+		times = append(times, FormatSeconds(ix.Seconds(fragment.StartTime-origin)))
+		durations = append(durations, FormatSeconds(ix.Seconds(fragment.Duration)))
+	}
+	return times, durations
+}
+
+// BytesRangeOnTime renders the fragments as stored on vault documents.
+func (ix *Index) BytesRangeOnTime() []models.FragmentedBytesRangeOnTime {
+	times, durations := ix.fragmentTimes()
+	ranges := make([]models.FragmentedBytesRangeOnTime, 0, len(ix.Fragments))
+	for i, fragment := range ix.Fragments {
+		ranges = append(ranges, models.FragmentedBytesRangeOnTime{
+			Duration: durations[i],
+			Time:     times[i],
+			Range:    fragment.Range.String(),
+		})
+	}
+	return ranges
+}
+
+// VideoBytesRangeOnTime renders the fragments as stored on media documents.
+func (ix *Index) VideoBytesRangeOnTime() []models.VideoBytesRangeOnTime {
+	times, durations := ix.fragmentTimes()
+	ranges := make([]models.VideoBytesRangeOnTime, 0, len(ix.Fragments))
+	for i, fragment := range ix.Fragments {
+		ranges = append(ranges, models.VideoBytesRangeOnTime{
+			Duration: durations[i],
+			Time:     times[i],
+			Range:    fragment.Range.String(),
+		})
+	}
+	return ranges
+}
+
+// BytesRanges renders the initialisation segment and fragment ranges as a
+// comma-separated list; empty for a non-fragmented file.
+func (ix *Index) BytesRanges() string {
+	if !ix.Fragmented {
+		return ""
+	}
+	ranges := []string{ix.Init.String()}
+	for _, fragment := range ix.Fragments {
+		ranges = append(ranges, fragment.Range.String())
+	}
+	return strings.Join(ranges, ",")
+}
+
+// VaultMediaMetadata renders the index into the vault media metadata.
+func (ix *Index) VaultMediaMetadata() models.VaultMediaMetadata {
+	return models.VaultMediaMetadata{
+		BytesRanges:      ix.BytesRanges(),
+		BytesRangeOnTime: ix.BytesRangeOnTime(),
+		IsFragmented:     ix.Fragmented,
+		Duration:         ix.Duration,
+		Timescale:        ix.Timescale,
+		FPS:              ix.FPS,
+	}
+}
+
+// MediaMetadata fills the container fields of a media document's metadata
+// from the index.
+func (ix *Index) MediaMetadata(metadata *models.MediaMetadata) {
+	metadata.Container = "mp4"
+	metadata.Codec = ix.Codec
+	metadata.Width = ix.Width
+	metadata.Height = ix.Height
+	if ix.Width > 0 && ix.Height > 0 {
+		metadata.Resolution = strconv.Itoa(ix.Width) + "x" + strconv.Itoa(ix.Height)
+	}
+	metadata.FPS = ix.FPS
+}
@@ -0,0 +1,299 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+var (
+	// ErrFragmentMalformed is returned for a stored entry whose time,
+	// duration or range does not parse.
+	ErrFragmentMalformed = errors.New("malformed fragment entry")
+	// ErrFragmentOutOfOrder is returned when an entry starts, in time or in
+	// bytes, before the entry it follows.
+	ErrFragmentOutOfOrder = errors.New("fragment entries are out of order")
+	// ErrFragmentOverlap is returned when an entry starts, in time or in
+	// bytes, before the entry it follows has ended.
+	ErrFragmentOverlap = errors.New("fragment entries overlap")
+	// ErrFragmentOutOfRange is returned for a lookup outside the indexed time.
+	ErrFragmentOutOfRange = errors.New("time is outside the fragment index")
+)
+
+// fragmentEpsilon absorbs the rounding of times stored as decimal strings
+// when checking that one fragment starts where the previous ended.
+const fragmentEpsilon = 1e-6
+
+// FragmentEntry is one parsed row of a stored byte-range-on-time table.
+// Times are in seconds from the start of the recording.
+type FragmentEntry struct {
+	Start    float64   `json:"start"`
+	Duration float64   `json:"duration"`
+	Range    ByteRange `json:"range"`
+}
+
+// End is when the fragment ends.
+func (e FragmentEntry) End() float64 { return e.Start + e.Duration }
+
+// FragmentIndex is a validated, typed byte-range-on-time table: entries in
+// time and byte order, none overlapping. When Init is set, every selection
+// starts with it so the bytes are playable on their own.
+type FragmentIndex struct {
+	Init    *ByteRange      `json:"init,omitempty"`
+	Entries []FragmentEntry `json:"entries"`
+}
+
+// Selection is the part of a recording a lookup resolved to: the fragments
+// covering the requested time, snapped outward to fragment boundaries, and
+// their bytes as merged ranges.
+type Selection struct {
+	From      float64         `json:"from"`
+	To        float64         `json:"to"`
+	Fragments []FragmentEntry `json:"fragments"`
+	Ranges    []ByteRange     `json:"ranges"`
+}
+
+// Header renders the selection's ranges as an HTTP Range header value.
+func (s Selection) Header() string { return RangeHeader(s.Ranges) }
+
+// RangeHeader renders ranges as an HTTP Range header value, "bytes=0-99,200-299".
+func RangeHeader(ranges []ByteRange) string {
+	if len(ranges) == 0 {
+		return ""
+	}
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = r.String()
+	}
+	return "bytes=" + strings.Join(parts, ",")
+}
+
+// ParseByteRange parses an inclusive "start-end" range.
+func ParseByteRange(value string) (ByteRange, error) {
+	startText, endText, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return ByteRange{}, fmt.Errorf("%w: range %q", ErrFragmentMalformed, value)
+	}
+	start, err := strconv.ParseInt(startText, 10, 64)
+	if err != nil {
+		return ByteRange{}, fmt.Errorf("%w: range %q", ErrFragmentMalformed, value)
+	}
+	end, err := strconv.ParseInt(endText, 10, 64)
+	if err != nil || start < 0 || end < start {
+		return ByteRange{}, fmt.Errorf("%w: range %q", ErrFragmentMalformed, value)
+	}
+	return ByteRange{Start: start, End: end}, nil
+}
+
+// ParseFragmentTable types and validates a vault byte-range-on-time table.
+func ParseFragmentTable(table []models.FragmentedBytesRangeOnTime) (*FragmentIndex, error) {
+	rows := make([][3]string, len(table))
+	for i, row := range table {
+		rows[i] = [3]string{row.Time, row.Duration, row.Range}
+	}
+	return parseFragmentRows(rows)
+}
+
+// ParseVideoFragmentTable types and validates a media byte-range-on-time table.
+func ParseVideoFragmentTable(table []models.VideoBytesRangeOnTime) (*FragmentIndex, error) {
+	rows := make([][3]string, len(table))
+	for i, row := range table {
+		rows[i] = [3]string{row.Time, row.Duration, row.Range}
+	}
+	return parseFragmentRows(rows)
+}
+
+// ParseVaultFragments types the fragment table of vault metadata. The first
+// range of BytesRanges, when it lies before the first fragment, is taken as
+// the initialisation segment.
+func ParseVaultFragments(metadata models.VaultMediaMetadata) (*FragmentIndex, error) {
+	return parseFragmentsWithInit(metadata.BytesRangeOnTime, metadata.BytesRanges)
+}
+
+func parseFragmentsWithInit(table []models.FragmentedBytesRangeOnTime, bytesRanges string) (*FragmentIndex, error) {
+	ix, err := ParseFragmentTable(table)
+	if err != nil {
+		return nil, err
+	}
+	first, _, _ := strings.Cut(bytesRanges, ",")
+	if first == "" || len(ix.Entries) == 0 {
+		return ix, nil
+	}
+	init, err := ParseByteRange(first)
+	if err != nil {
+		return nil, err
+	}
+	if init.End < ix.Entries[0].Range.Start {
+		ix.Init = &init
+	}
+	return ix, nil
+}
+
+func parseFragmentRows(rows [][3]string) (*FragmentIndex, error) {
+	ix := &FragmentIndex{Entries: make([]FragmentEntry, 0, len(rows))}
+	for i, row := range rows {
+		start, err := strconv.ParseFloat(strings.TrimSpace(row[0]), 64)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("%w: entry %d time %q", ErrFragmentMalformed, i, row[0])
+		}
+		duration, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("%w: entry %d duration %q", ErrFragmentMalformed, i, row[1])
+		}
+		byteRange, err := ParseByteRange(row[2])
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		entry := FragmentEntry{Start: start, Duration: duration, Range: byteRange}
+
+		if i > 0 {
+			previous := ix.Entries[i-1]
+			switch {
+			case entry.Start < previous.Start || entry.Range.Start < previous.Range.Start:
+				return nil, fmt.Errorf("%w: entry %d starts before entry %d", ErrFragmentOutOfOrder, i, i-1)
+			case entry.Start < previous.End()-fragmentEpsilon || entry.Range.Start <= previous.Range.End:
+				return nil, fmt.Errorf("%w: entry %d starts before entry %d ends", ErrFragmentOverlap, i, i-1)
+			}
+		}
+		ix.Entries = append(ix.Entries, entry)
+	}
+	return ix, nil
+}
+
+// Duration is the end of the last fragment.
+func (ix *FragmentIndex) Duration() float64 {
+	if len(ix.Entries) == 0 {
+		return 0
+	}
+	return ix.Entries[len(ix.Entries)-1].End()
+}
+
+// At returns the index of the fragment playing at offset seconds. A gap
+// between fragments resolves to the fragment after it.
+func (ix *FragmentIndex) At(offset float64) (int, error) {
+	i := sort.Search(len(ix.Entries), func(i int) bool { return ix.Entries[i].End() > offset })
+	if offset < 0 || i == len(ix.Entries) {
+		return 0, fmt.Errorf("%w: %gs of %gs", ErrFragmentOutOfRange, offset, ix.Duration())
+	}
+	return i, nil
+}
+
+// Seek returns the selection for playback from offset to the end.
+func (ix *FragmentIndex) Seek(offset float64) (Selection, error) {
+	return ix.Interval(offset, ix.Duration())
+}
+
+// Interval returns the selection covering from..to seconds: every fragment
+// overlapping the interval, with their byte ranges — preceded by Init — merged
+// where contiguous.
+func (ix *FragmentIndex) Interval(from, to float64) (Selection, error) {
+	if to <= from {
+		return Selection{}, fmt.Errorf("%w: empty interval %g-%g", ErrFragmentOutOfRange, from, to)
+	}
+	first, err := ix.At(from)
+	if err != nil {
+		return Selection{}, err
+	}
+	last := sort.Search(len(ix.Entries), func(i int) bool { return ix.Entries[i].Start >= to }) - 1
+	if last < first {
+		return Selection{}, fmt.Errorf("%w: no fragment in %g-%g", ErrFragmentOutOfRange, from, to)
+	}
+
+	fragments := ix.Entries[first : last+1]
+	var ranges []ByteRange
+	if ix.Init != nil {
+		ranges = append(ranges, *ix.Init)
+	}
+	for _, fragment := range fragments {
+		ranges = appendMerged(ranges, fragment.Range)
+	}
+	return Selection{
+		From:      fragments[0].Start,
+		To:        fragments[len(fragments)-1].End(),
+		Fragments: append([]FragmentEntry(nil), fragments...),
+		Ranges:    ranges,
+	}, nil
+}
+
+// appendMerged appends r, extending the last range instead when r follows it
+// directly.
+func appendMerged(ranges []ByteRange, r ByteRange) []ByteRange {
+	if n := len(ranges); n > 0 && ranges[n-1].End+1 == r.Start {
+		ranges[n-1].End = r.End
+		return ranges
+	}
+	return append(ranges, r)
+}
+
+// ClipPart is the slice of one media file a clip needs.
+type ClipPart struct {
+	Key       string    `json:"key"`
+	FileName  string    `json:"filename"`
+	Url       string    `json:"url"`
+	Selection Selection `json:"selection"`
+}
+
+// ClipPlan is a clip stitched across media files, in time order. From and To
+// are absolute, in the collections' clock, after snapping.
+type ClipPlan struct {
+	From  float64    `json:"from"`
+	To    float64    `json:"to"`
+	Parts []ClipPart `json:"parts"`
+}
+
+// PlanClip stitches the from..to interval, on the collections' absolute clock
+// (VaultMediaFragmentCollection.Start/End), across the media files that cover
+// it. Files are taken in Start order and must not overlap in time.
+func PlanClip(collections []models.VaultMediaFragmentCollection, from, to float64) (ClipPlan, error) {
+	if to <= from {
+		return ClipPlan{}, fmt.Errorf("%w: empty interval %g-%g", ErrFragmentOutOfRange, from, to)
+	}
+	sorted := append([]models.VaultMediaFragmentCollection(nil), collections...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var plan ClipPlan
+	for i, collection := range sorted {
+		if i > 0 && collection.Start < sorted[i-1].End-fragmentEpsilon {
+			return ClipPlan{}, fmt.Errorf("%w: %s starts before %s ends", ErrFragmentOverlap, collection.Key, sorted[i-1].Key)
+		}
+		if collection.End <= from || collection.Start >= to {
+			continue
+		}
+
+		ix, err := parseFragmentsWithInit(collection.BytesRangeOnTime, collection.BytesRanges)
+		if err != nil {
+			return ClipPlan{}, fmt.Errorf("%s: %w", collection.Key, err)
+		}
+		localFrom := max(from, collection.Start) - collection.Start
+		localTo := min(to, collection.End) - collection.Start
+		if localTo > ix.Duration() {
+			localTo = ix.Duration()
+		}
+		if localTo <= localFrom {
+			continue
+		}
+		selection, err := ix.Interval(localFrom, localTo)
+		if err != nil {
+			return ClipPlan{}, fmt.Errorf("%s: %w", collection.Key, err)
+		}
+
+		if len(plan.Parts) == 0 {
+			plan.From = collection.Start + selection.From
+		}
+		plan.To = collection.Start + selection.To
+		plan.Parts = append(plan.Parts, ClipPart{
+			Key:       collection.Key,
+			FileName:  collection.FileName,
+			Url:       collection.Url,
+			Selection: selection,
+		})
+	}
+	if len(plan.Parts) == 0 {
+		return ClipPlan{}, fmt.Errorf("%w: no media covers %g-%g", ErrFragmentOutOfRange, from, to)
+	}
+	return plan, nil
+}
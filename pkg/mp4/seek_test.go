@@ -0,0 +1,130 @@
+package mp4
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/uug-ai/models/pkg/models"
+)
+
+func fragmentTable() []models.FragmentedBytesRangeOnTime {
+	return []models.FragmentedBytesRangeOnTime{
+		{Time: "0", Duration: "2", Range: "100-199"},
+		{Time: "2", Duration: "2", Range: "200-299"},
+		{Time: "4", Duration: "2", Range: "300-399"},
+		{Time: "6", Duration: "2", Range: "500-599"}, // bytes gap before
+	}
+}
+
+func TestFragmentIndexInterval(t *testing.T) {
+	ix, err := ParseFragmentTable(fragmentTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.Init = &ByteRange{Start: 0, End: 99}
+
+	selection, err := ix.Interval(3, 6.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selection.From != 2 || selection.To != 8 || len(selection.Fragments) != 3 {
+		t.Errorf("selection = %+v, want 2s-8s over three fragments", selection)
+	}
+	if got := selection.Header(); got != "bytes=0-99,200-399,500-599" {
+		t.Errorf("header = %q", got)
+	}
+
+	// An interval ending exactly on a boundary does not pull in the next fragment.
+	selection, err = ix.Interval(0, 4)
+	if err != nil || selection.Header() != "bytes=0-299" {
+		t.Errorf("interval 0-4 = (%q, %v)", selection.Header(), err)
+	}
+
+	if i, err := ix.At(5.99); err != nil || i != 2 {
+		t.Errorf("At(5.99) = (%d, %v), want 2", i, err)
+	}
+	if _, err := ix.At(8); !errors.Is(err, ErrFragmentOutOfRange) {
+		t.Errorf("At(8) err = %v, want ErrFragmentOutOfRange", err)
+	}
+}
+
+func TestFragmentIndexValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		table []models.VideoBytesRangeOnTime
+		want  error
+	}{
+		{"malformed time", []models.VideoBytesRangeOnTime{{Time: "0:12", Duration: "1", Range: "0-1"}}, ErrFragmentMalformed},
+		{"malformed range", []models.VideoBytesRangeOnTime{{Time: "0", Duration: "1", Range: "9-1"}}, ErrFragmentMalformed},
+		{"time out of order", []models.VideoBytesRangeOnTime{{Time: "2", Duration: "1", Range: "0-9"}, {Time: "1", Duration: "1", Range: "10-19"}}, ErrFragmentOutOfOrder},
+		{"bytes out of order", []models.VideoBytesRangeOnTime{{Time: "0", Duration: "1", Range: "10-19"}, {Time: "1", Duration: "1", Range: "0-9"}}, ErrFragmentOutOfOrder},
+		{"time overlap", []models.VideoBytesRangeOnTime{{Time: "0", Duration: "2", Range: "0-9"}, {Time: "1", Duration: "1", Range: "10-19"}}, ErrFragmentOverlap},
+		{"bytes overlap", []models.VideoBytesRangeOnTime{{Time: "0", Duration: "1", Range: "0-9"}, {Time: "1", Duration: "1", Range: "9-19"}}, ErrFragmentOverlap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseVideoFragmentTable(tt.table); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVaultFragmentsRoundTripsTheIndex(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "fragmented.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := ParseVaultFragments(parsed.VaultMediaMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Init == nil || *ix.Init != parsed.Init || len(ix.Entries) != len(parsed.Fragments) {
+		t.Fatalf("index = %+v", ix)
+	}
+	selection, err := ix.Seek(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The whole recording is one contiguous range from the first byte.
+	if want := []ByteRange{{Start: 0, End: int64(len(data)) - 1}}; !reflect.DeepEqual(selection.Ranges, want) {
+		t.Errorf("ranges = %v, want %v", selection.Ranges, want)
+	}
+}
+
+func TestPlanClipStitchesMediaFiles(t *testing.T) {
+	collections := []models.VaultMediaFragmentCollection{
+		{Key: "b", Start: 1008, End: 1016, BytesRanges: "0-99,100-199", BytesRangeOnTime: fragmentTable()},
+		{Key: "a", Start: 1000, End: 1008, BytesRanges: "0-99,100-199", BytesRangeOnTime: fragmentTable()},
+		{Key: "c", Start: 1020, End: 1028, BytesRangeOnTime: fragmentTable()},
+	}
+	plan, err := PlanClip(collections, 1005, 1010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.From != 1004 || plan.To != 1010 || len(plan.Parts) != 2 {
+		t.Fatalf("plan = %+v", plan)
+	}
+	if plan.Parts[0].Key != "a" || plan.Parts[0].Selection.Header() != "bytes=0-99,300-399,500-599" {
+		t.Errorf("part a = %+v", plan.Parts[0])
+	}
+	if plan.Parts[1].Key != "b" || plan.Parts[1].Selection.Header() != "bytes=0-199" {
+		t.Errorf("part b = %+v", plan.Parts[1])
+	}
+
+	if _, err := PlanClip(collections, 1017, 1019); !errors.Is(err, ErrFragmentOutOfRange) {
+		t.Errorf("uncovered err = %v, want ErrFragmentOutOfRange", err)
+	}
+	overlapping := append(collections, models.VaultMediaFragmentCollection{Key: "d", Start: 1006, End: 1007})
+	if _, err := PlanClip(overlapping, 1000, 1010); !errors.Is(err, ErrFragmentOverlap) {
+		t.Errorf("overlap err = %v, want ErrFragmentOverlap", err)
+	}
+}
@@ -0,0 +1,158 @@
+//go:build ignore
+
+// generate writes the MP4 fixtures of this directory. The fixtures contain
+// valid box structures with placeholder sample data, which is all the index
+// reader looks at.
+//
+//	go run testdata/generate.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+)
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func join(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+func box(typ string, body ...[]byte) []byte {
+	payload := join(body...)
+	return join(u32(uint32(8+len(payload))), []byte(typ), payload)
+}
+
+func fullBox(typ string, version uint8, flags uint32, body ...[]byte) []byte {
+	return box(typ, append([][]byte{u32(uint32(version)<<24 | flags)}, body...)...)
+}
+
+func ftyp() []byte {
+	return box("ftyp", []byte("isom"), u32(0x200), []byte("isomiso6mp41"))
+}
+
+func mvhd(timescale uint32) []byte {
+	return fullBox("mvhd", 0, 0, u32(0), u32(0), u32(timescale), u32(0), u32(0x00010000), u16(0x0100), make([]byte, 10), make([]byte, 36), make([]byte, 24), u32(2))
+}
+
+func tkhd(id uint32, width, height uint16) []byte {
+	return fullBox("tkhd", 0, 3, u32(0), u32(0), u32(id), u32(0), u32(0), make([]byte, 8), u16(0), u16(0), u16(0), u16(0), make([]byte, 36), u32(uint32(width)<<16), u32(uint32(height)<<16))
+}
+
+func mdhd(timescale, duration uint32) []byte {
+	return fullBox("mdhd", 0, 0, u32(0), u32(0), u32(timescale), u32(duration), u16(0x55c4), u16(0))
+}
+
+func hdlr(handler string) []byte {
+	return fullBox("hdlr", 0, 0, u32(0), []byte(handler), make([]byte, 12), []byte("VideoHandler\x00"))
+}
+
+func avc1(width, height uint16) []byte {
+	avcC := box("avcC", []byte{1, 0x64, 0x00, 0x1f, 0xff, 0xe0, 0x00})
+	return box("avc1", make([]byte, 6), u16(1), make([]byte, 16), u16(width), u16(height), u32(0x00480000), u32(0x00480000), u32(0), u16(1), make([]byte, 32), u16(0x18), u16(0xffff), avcC)
+}
+
+func stbl(width, height uint16, stts []byte) []byte {
+	return box("stbl",
+		fullBox("stsd", 0, 0, u32(1), avc1(width, height)),
+		stts,
+		fullBox("stsc", 0, 0, u32(0)),
+		fullBox("stsz", 0, 0, u32(0), u32(0)),
+		fullBox("stco", 0, 0, u32(0)),
+	)
+}
+
+func trak(id, timescale, duration uint32, stts []byte) []byte {
+	return box("trak",
+		tkhd(id, 640, 360),
+		box("mdia",
+			mdhd(timescale, duration),
+			hdlr("vide"),
+			box("minf", stbl(640, 360, stts)),
+		),
+	)
+}
+
+// fragment returns a moof+mdat pair of samples samples. When durations is nil
+// the samples use the trex default duration; otherwise each carries its own.
+func fragment(sequence uint32, decodeTime uint64, samples int, durations []uint32) []byte {
+	flags := uint32(0x000001 | 0x000200) // data offset, sample sizes
+	if durations != nil {
+		flags |= 0x000100
+	}
+	var entries [][]byte
+	for i := 0; i < samples; i++ {
+		if durations != nil {
+			entries = append(entries, u32(durations[i]))
+		}
+		entries = append(entries, u32(4))
+	}
+	build := func(dataOffset uint32) []byte {
+		return box("moof",
+			fullBox("mfhd", 0, 0, u32(sequence)),
+			box("traf",
+				fullBox("tfhd", 0, 0x020000, u32(1)),
+				fullBox("tfdt", 1, 0, u64(decodeTime)),
+				fullBox("trun", 0, flags, append([][]byte{u32(uint32(samples)), u32(dataOffset)}, entries...)...),
+			),
+		)
+	}
+	moof := build(0)
+	moof = build(uint32(len(moof) + 8))
+	return join(moof, box("mdat", make([]byte, 4*samples)))
+}
+
+func fragmented() []byte {
+	const timescale = 90000
+	moov := box("moov",
+		mvhd(1000),
+		trak(1, timescale, 0, fullBox("stts", 0, 0, u32(0))),
+		box("mvex", fullBox("trex", 0, 0, u32(1), u32(1), u32(3000), u32(0), u32(0))),
+	)
+	fragments := [][]byte{
+		fragment(1, 0, 30, nil), // 1s at the trex default of 3000
+		fragment(2, 90000, 15, nil),
+		fragment(3, 135000, 3, []uint32{3000, 6000, 9000}),
+	}
+	var references [][]byte
+	for i, f := range fragments {
+		durations := []uint32{90000, 45000, 18000}
+		references = append(references, u32(uint32(len(f))), u32(durations[i]), u32(1<<31))
+	}
+	sidx := fullBox("sidx", 0, 0, append([][]byte{u32(1), u32(timescale), u32(0), u32(0), u16(0), u16(uint16(len(fragments)))}, references...)...)
+	return join(append([][]byte{ftyp(), moov, sidx}, fragments...)...)
+}
+
+func progressive() []byte {
+	// 60 samples of 1500 ticks at 45000: 2 seconds at 30 fps.
+	moov := box("moov",
+		mvhd(1000),
+		trak(1, 45000, 90000, fullBox("stts", 0, 0, u32(1), u32(60), u32(1500))),
+	)
+	return join(ftyp(), box("mdat", make([]byte, 64)), moov)
+}
+
+// trunSampleCount is a hostile upload: trun boxes without per-sample fields
+// claiming 2^32-1 samples each, which cost nothing to store and used to cost
+// seconds each to count.
+func trunSampleCount() []byte {
+	trun := fullBox("trun", 0, 0, u32(0xffffffff))
+	return join(
+		box("moov", box("trak")),
+		box("moof", box("traf", fullBox("tfhd", 0, 0, u32(0)), trun, trun, trun)),
+	)
+}
+
+func main() {
+	for name, data := range map[string][]byte{
+		"fragmented.mp4":        fragmented(),
+		"progressive.mp4":       progressive(),
+		"trun_sample_count.mp4": trunSampleCount(),
+	} {
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Package pipeline executes the MessageHandler contract in process. The
+// pipeline is a chain of named stages (event → monitor → sequence → analysis →
+// throttler → notification by default, see models.PipelineEvent); each stage
+// consumes PipelineEvents from its own queue, runs its handler, and acts on the
+// PipelineAction the handler returns:
+//
+//	forward — the stage is appended to PipelineEvent.Stages and the event is
+//	          sent to the next stage's queue (or Output after the last stage).
+//	cancel  — processing stops; the event is dropped.
+//	retry   — the event is sent back to the stage's own queue until the stage's
+//	          retry limit is exhausted, after which it is dead-lettered.
+//	error   — the event is dead-lettered.
+//
+// A handler that panics is treated as returning error. Every handler call is
+// timed and reported through the runner's PrometheusHandler. The runner sits
+// on a queue.Transport, so a service can integration-test its stage against
+// queue.MemoryTransport without a broker.
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uug-ai/models/pkg/models"
+	"github.com/uug-ai/models/pkg/queue"
+)
+
+// DefaultMaxRetries is the retry limit applied to a stage that sets none.
+const DefaultMaxRetries = 3
+
+// DefaultDeadLetterQueue is the queue dead letters are published to when a
+// runner has no DeadLetter sink of its own.
+const DefaultDeadLetterQueue = "dead-letter"
+
+// receiveBackoff is how long a stage waits after a failed Receive before
+// trying again.
+const receiveBackoff = 100 * time.Millisecond
+
+// DefaultStages is the documented order of the pipeline stages.
+var DefaultStages = []string{"event", "monitor", "sequence", "analysis", "throttler", "notification"}
+
+var (
+	// ErrRetriesExhausted is the dead-letter cause for an event whose stage kept
+	// asking for a retry past its limit.
+	ErrRetriesExhausted = errors.New("pipeline retries exhausted")
+	// ErrHandlerFailed is the dead-letter cause for an event whose handler
+	// returned PipelineError.
+	ErrHandlerFailed = errors.New("pipeline handler failed")
+	// ErrHandlerPanicked is the dead-letter cause for an event whose handler
+	// panicked.
+	ErrHandlerPanicked = errors.New("pipeline handler panicked")
+	// ErrUnknownAction is the dead-letter cause for a handler that returned an
+	// action outside the PipelineAction enum.
+	ErrUnknownAction = errors.New("pipeline handler returned an unknown action")
+	// ErrMalformedEvent is the dead-letter cause for a delivery that is not a
+	// PipelineEvent.
+	ErrMalformedEvent = errors.New("delivery is not a pipeline event")
+)
+
+// Stage is one link of the chain.
+type Stage struct {
+	// Name identifies the stage; it is appended to PipelineEvent.Stages.
+	Name string
+	// Handler processes the stage's events.
+	Handler models.MessageHandler
+	// Queue is the queue the stage consumes. Defaults to Name.
+	Queue string
+	// MaxRetries, when set, overrides the runner's retry limit for this stage;
+	// zero dead-letters the first retry.
+	MaxRetries *int
+}
+
+// DeadLetterSink receives events that could not be processed, wrapped in a
+// models.DeadLetter naming the stage that gave up on them and why.
+type DeadLetterSink interface {
+	DeadLetter(ctx context.Context, letter models.DeadLetter) error
+}
+
+// DeadLetterFunc adapts a plain function to DeadLetterSink.
+type DeadLetterFunc func(ctx context.Context, letter models.DeadLetter) error
+
+// DeadLetter calls f(ctx, letter).
+func (f DeadLetterFunc) DeadLetter(ctx context.Context, letter models.DeadLetter) error {
+	return f(ctx, letter)
+}
+
+// QueueDeadLetterSink publishes dead letters, as JSON, to a queue.
+type QueueDeadLetterSink struct {
+	Transport queue.Transport
+	Queue     string
+}
+
+// DeadLetter publishes letter to the sink's queue.
+func (s QueueDeadLetterSink) DeadLetter(ctx context.Context, letter models.DeadLetter) error {
+	body, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	return s.Transport.Send(ctx, s.Queue, body)
+}
+
+// Runner chains stage handlers over a transport.
+type Runner struct {
+	Stages    []Stage
+	Transport queue.Transport
+
+	// Output, when set, is the queue events are sent to after the last stage
+	// forwards them. Empty drops them once the chain completes.
+	Output string
+	// MaxRetries is the default number of retries per stage; DefaultMaxRetries
+	// when zero.
+	MaxRetries int
+	// DeadLetter receives exhausted and failed events. When nil they are
+	// published to DefaultDeadLetterQueue on the runner's transport.
+	DeadLetter DeadLetterSink
+	// OnError, when set, is called for every error that did not stop the
+	// runner: a failed Receive, or a delivery whose outcome could not be sent
+	// or dead-lettered, or that could not be acked. Such a delivery is
+	// requeued and the stage keeps consuming.
+	OnError func(stage string, err error)
+	// Metrics, when set, receives the processing time of every handler call.
+	Metrics models.PrometheusHandler
+	// Args are passed to every handler after the event.
+	Args []any
+}
+
+// NewRunner returns a runner for stages over transport, dead-lettering to
+// DefaultDeadLetterQueue on the same transport.
+func NewRunner(transport queue.Transport, stages ...Stage) *Runner {
+	return &Runner{
+		Stages:     stages,
+		Transport:  transport,
+		MaxRetries: DefaultMaxRetries,
+		DeadLetter: QueueDeadLetterSink{Transport: transport, Queue: DefaultDeadLetterQueue},
+	}
+}
+
+// Run consumes every stage's queue concurrently until ctx is done or the
+// transport is closed. An error on one delivery is reported to OnError and
+// does not stop the runner, so Run always returns nil.
+func (r *Runner) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	done := make([]chan struct{}, len(r.Stages))
+	for i := range r.Stages {
+		done[i] = make(chan struct{})
+	}
+	for i := range r.Stages {
+		var upstream chan struct{}
+		if i > 0 {
+			upstream = done[i-1]
+		}
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			defer close(done[index])
+			r.consume(ctx, index, upstream)
+		}(i)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Publish sends event to the first stage's queue.
+func (r *Runner) Publish(ctx context.Context, event models.PipelineEvent) error {
+	if len(r.Stages) == 0 {
+		return errors.New("pipeline has no stages")
+	}
+	return r.send(ctx, r.Stages[0].queue(), event)
+}
+
+// consume processes a stage's queue. A closed transport only stops the stage
+// once the stage feeding it (upstream, nil for the first) has stopped, since
+// until then more events may still arrive. Any other error is reported and
+// the stage carries on.
+func (r *Runner) consume(ctx context.Context, index int, upstream <-chan struct{}) {
+	stage := r.Stages[index]
+	for {
+		delivery, err := r.Transport.Receive(ctx, stage.queue())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, queue.ErrTransportClosed) {
+				r.reportError(stage.Name, err)
+				select {
+				case <-time.After(receiveBackoff):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			if upstream == nil {
+				return
+			}
+			select {
+			case <-upstream:
+				upstream = nil
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		if err := r.process(ctx, index, delivery.Body); err != nil {
+			r.reportError(stage.Name, err)
+			if err := delivery.Nack(true); err != nil {
+				r.reportError(stage.Name, err)
+			}
+			continue
+		}
+		if err := delivery.Ack(); err != nil {
+			r.reportError(stage.Name, err)
+		}
+	}
+}
+
+func (r *Runner) reportError(stage string, err error) {
+	if r.OnError != nil {
+		r.OnError(stage, err)
+	}
+}
+
+// process runs one delivery through a stage and routes the outcome. It only
+// returns an error when the outcome could not be delivered; the message is
+// then requeued.
+func (r *Runner) process(ctx context.Context, index int, body []byte) error {
+	stage := r.Stages[index]
+
+	var event models.PipelineEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return r.deadLetter(ctx, stage.Name, event, models.PipelineError, 0, fmt.Errorf("%w: %v", ErrMalformedEvent, err))
+	}
+	event.ReceiveCount++
+
+	action, next, status, cause := r.invoke(stage, event)
+	next.ReceiveCount = event.ReceiveCount
+
+	switch action {
+	case models.PipelineForward:
+		next.Stages = append(next.Stages, stage.Name)
+		// ReceiveCount counts deliveries within a stage, so the next stage
+		// starts its own retry budget.
+		next.ReceiveCount = 0
+		if index+1 < len(r.Stages) {
+			return r.send(ctx, r.Stages[index+1].queue(), next)
+		}
+		if r.Output != "" {
+			return r.send(ctx, r.Output, next)
+		}
+		return nil
+	case models.PipelineCancel:
+		return nil
+	case models.PipelineRetry:
+		if int(next.ReceiveCount) > r.maxRetries(stage) {
+			return r.deadLetter(ctx, stage.Name, next, action, status, fmt.Errorf("%w: stage %q after %d attempts (status %d)", ErrRetriesExhausted, stage.Name, next.ReceiveCount, status))
+		}
+		return r.send(ctx, stage.queue(), next)
+	case models.PipelineError:
+		if cause == nil {
+			cause = fmt.Errorf("%w: stage %q (status %d)", ErrHandlerFailed, stage.Name, status)
+		}
+		return r.deadLetter(ctx, stage.Name, next, action, status, cause)
+	default:
+		return r.deadLetter(ctx, stage.Name, next, action, status, fmt.Errorf("%w: %q from stage %q", ErrUnknownAction, action, stage.Name))
+	}
+}
+
+// invoke calls the stage handler, timing it and converting a panic into a
+// PipelineError with its cause.
+func (r *Runner) invoke(stage Stage, event models.PipelineEvent) (action models.PipelineAction, next models.PipelineEvent, status int, cause error) {
+	start := time.Now()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			action, next, status = models.PipelineError, event, 0
+			cause = fmt.Errorf("%w: stage %q: %v", ErrHandlerPanicked, stage.Name, recovered)
+		}
+		if r.Metrics != nil {
+			r.Metrics(models.PipelineMetrics{ProcessingTime: time.Since(start).Seconds()})
+		}
+	}()
+	action, next, status = stage.Handler(event, r.Args...)
+	return action, next, status, nil
+}
+
+func (r *Runner) deadLetter(ctx context.Context, stage string, event models.PipelineEvent, action models.PipelineAction, status int, cause error) error {
+	sink := r.DeadLetter
+	if sink == nil {
+		sink = QueueDeadLetterSink{Transport: r.Transport, Queue: DefaultDeadLetterQueue}
+	}
+	return sink.DeadLetter(ctx, models.NewPipelineDeadLetter(stage, event, action, status, cause, time.Now()))
+}
+
+// send encodes event with models.MarshalPipelineEvent, so every event the
+// runner emits carries its schema version, and sends it to queueName.
+func (r *Runner) send(ctx context.Context, queueName string, event models.PipelineEvent) error {
+	body, err := models.MarshalPipelineEvent(event)
+	if err != nil {
+		return err
+	}
+	return r.Transport.Send(ctx, queueName, body)
+}
+
+func (r *Runner) maxRetries(stage Stage) int {
+	if stage.MaxRetries != nil {
+		return max(*stage.MaxRetries, 0)
+	}
+	if r.MaxRetries > 0 {
+		return r.MaxRetries
+	}
+	return DefaultMaxRetries
+}
+
+func (s Stage) queue() string {
+	if s.Queue != "" {
+		return s.Queue
+	}
+	return s.Name
+}
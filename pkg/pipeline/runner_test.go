@@ -0,0 +1,249 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uug-ai/models/pkg/models"
+	"github.com/uug-ai/models/pkg/queue"
+)
+
+type deadLetters struct {
+	mu      sync.Mutex
+	letters []models.DeadLetter
+}
+
+func (d *deadLetters) DeadLetter(ctx context.Context, letter models.DeadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.letters = append(d.letters, letter)
+	return nil
+}
+
+// hasCause reports whether err is part of the letter's recorded error chain.
+func hasCause(letter models.DeadLetter, err error) bool {
+	for _, message := range letter.Errors {
+		if message == err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+func forward(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+	return models.PipelineForward, event, 0
+}
+
+func runUntilDrained(t *testing.T, runner *Runner, transport *queue.MemoryTransport) {
+	t.Helper()
+	transport.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := runner.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func TestRunnerForwardsThroughEveryStage(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	var stages []Stage
+	for _, name := range DefaultStages {
+		stages = append(stages, Stage{Name: name, Handler: forward})
+	}
+	runner := NewRunner(transport, stages...)
+	runner.Output = "done"
+	var calls int
+	var mu sync.Mutex
+	runner.Metrics = func(metrics models.PipelineMetrics) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+	}
+
+	if err := runner.Publish(context.Background(), models.PipelineEvent{TraceId: "trace-1"}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	runUntilDrained(t, runner, transport)
+
+	delivery, err := transport.Receive(context.Background(), "done")
+	if err != nil {
+		t.Fatalf("receive output: %v", err)
+	}
+	var out models.PipelineEvent
+	if err := json.Unmarshal(delivery.Body, &out); err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if len(out.Stages) != len(DefaultStages) || out.Stages[0] != "event" || out.Stages[5] != "notification" {
+		t.Errorf("stages = %v, want %v", out.Stages, DefaultStages)
+	}
+	if out.TraceId != "trace-1" {
+		t.Errorf("trace id = %q", out.TraceId)
+	}
+	if calls != len(DefaultStages) {
+		t.Errorf("metrics reported %d times, want %d", calls, len(DefaultStages))
+	}
+}
+
+func TestRunnerCancelStopsTheChain(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	reached := false
+	runner := NewRunner(transport,
+		Stage{Name: "monitor", Handler: func(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+			return models.PipelineCancel, event, 0
+		}},
+		Stage{Name: "sequence", Handler: func(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+			reached = true
+			return models.PipelineForward, event, 0
+		}},
+	)
+	_ = runner.Publish(context.Background(), models.PipelineEvent{})
+	runUntilDrained(t, runner, transport)
+	if reached {
+		t.Error("cancelled event reached the next stage")
+	}
+}
+
+func TestRunnerRetriesThenDeadLetters(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	sink := &deadLetters{}
+	var counts []int64
+	retries := 2
+	runner := NewRunner(transport, Stage{Name: "analysis", MaxRetries: &retries, Handler: func(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+		counts = append(counts, event.ReceiveCount)
+		return models.PipelineRetry, event, 503
+	}})
+	runner.DeadLetter = sink
+	_ = runner.Publish(context.Background(), models.PipelineEvent{TraceId: "trace-2"})
+	runUntilDrained(t, runner, transport)
+
+	if len(counts) != 3 || counts[0] != 1 || counts[2] != 3 {
+		t.Errorf("receive counts = %v, want [1 2 3]", counts)
+	}
+	if len(sink.letters) != 1 || !hasCause(sink.letters[0], ErrRetriesExhausted) {
+		t.Fatalf("dead letters = %+v, want one ErrRetriesExhausted", sink.letters)
+	}
+	letter := sink.letters[0]
+	if letter.Stage != "analysis" || letter.TraceId != "trace-2" || letter.ReplayToken == "" {
+		t.Errorf("dead letter = %+v", letter)
+	}
+	if len(letter.Attempts) != 1 || letter.Attempts[0].Status != 503 || letter.Attempts[0].ReceiveCount != 3 {
+		t.Errorf("attempts = %+v", letter.Attempts)
+	}
+	replay, err := letter.ReplayPipelineEvent()
+	if err != nil || replay.ReceiveCount != 0 || replay.TraceId != "trace-2" {
+		t.Errorf("replay = (%+v, %v)", replay, err)
+	}
+}
+
+func TestRunnerStageWithoutRetries(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	sink := &deadLetters{}
+	calls := 0
+	retries := 0
+	runner := NewRunner(transport, Stage{Name: "notification", MaxRetries: &retries, Handler: func(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+		calls++
+		return models.PipelineRetry, event, 429
+	}})
+	runner.DeadLetter = sink
+	_ = runner.Publish(context.Background(), models.PipelineEvent{TraceId: "trace-3"})
+	runUntilDrained(t, runner, transport)
+
+	if calls != 1 {
+		t.Errorf("handler calls = %d, want 1", calls)
+	}
+	if len(sink.letters) != 1 || !hasCause(sink.letters[0], ErrRetriesExhausted) {
+		t.Errorf("dead letters = %+v, want one ErrRetriesExhausted", sink.letters)
+	}
+}
+
+func TestRunnerDeadLettersErrorsAndPanics(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	sink := &deadLetters{}
+	runner := NewRunner(transport, Stage{Name: "throttler", Handler: func(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+		if event.TraceId == "panic" {
+			panic("nil map")
+		}
+		return models.PipelineError, event, 500
+	}})
+	runner.DeadLetter = sink
+	_ = runner.Publish(context.Background(), models.PipelineEvent{TraceId: "error"})
+	_ = runner.Publish(context.Background(), models.PipelineEvent{TraceId: "panic"})
+	_ = transport.Send(context.Background(), "throttler", []byte("{"))
+	runUntilDrained(t, runner, transport)
+
+	want := []error{ErrHandlerFailed, ErrHandlerPanicked, ErrMalformedEvent}
+	if len(sink.letters) != len(want) {
+		t.Fatalf("dead letters = %+v, want %d", sink.letters, len(want))
+	}
+	for i, err := range want {
+		if !hasCause(sink.letters[i], err) {
+			t.Errorf("dead letter %d errors = %v, want %v", i, sink.letters[i].Errors, err)
+		}
+	}
+}
+
+// flakyTransport fails the first Send to a queue.
+type flakyTransport struct {
+	*queue.MemoryTransport
+	mu     sync.Mutex
+	failed map[string]bool
+}
+
+func (f *flakyTransport) Send(ctx context.Context, name string, body []byte) error {
+	f.mu.Lock()
+	fail := !f.failed[name]
+	f.failed[name] = true
+	f.mu.Unlock()
+	if fail {
+		return errors.New("broker unavailable")
+	}
+	return f.MemoryTransport.Send(ctx, name, body)
+}
+
+func TestRunnerSurvivesFailedSends(t *testing.T) {
+	memory := queue.NewMemoryTransport()
+	// The first publish goes straight to the memory transport; the forward
+	// to "done" and the first dead letter each fail once.
+	transport := &flakyTransport{MemoryTransport: memory, failed: map[string]bool{"event": true}}
+	runner := NewRunner(transport, Stage{Name: "event", Handler: func(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+		if event.TraceId == "bad" {
+			return models.PipelineError, event, 500
+		}
+		return models.PipelineForward, event, 200
+	}})
+	runner.Output = "done"
+	var errs []string
+	runner.OnError = func(stage string, err error) { errs = append(errs, stage+": "+err.Error()) }
+	_ = runner.Publish(context.Background(), models.PipelineEvent{TraceId: "good"})
+	_ = runner.Publish(context.Background(), models.PipelineEvent{TraceId: "bad"})
+	runUntilDrained(t, runner, memory)
+
+	if memory.Len("done") != 1 || memory.Len(DefaultDeadLetterQueue) != 1 {
+		t.Errorf("done %d, dead letters %d, want 1 and 1", memory.Len("done"), memory.Len(DefaultDeadLetterQueue))
+	}
+	if len(errs) != 2 {
+		t.Errorf("reported errors = %v, want 2", errs)
+	}
+}
+
+func TestRunnerDefaultDeadLetterQueue(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	runner := &Runner{Transport: transport, Stages: []Stage{{Name: "monitor", Handler: func(event models.PipelineEvent, args ...any) (models.PipelineAction, models.PipelineEvent, int) {
+		return models.PipelineError, event, 500
+	}}}}
+	_ = runner.Publish(context.Background(), models.PipelineEvent{TraceId: "lost"})
+	runUntilDrained(t, runner, transport)
+
+	delivery, err := transport.Receive(context.Background(), DefaultDeadLetterQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letter models.DeadLetter
+	if err := json.Unmarshal(delivery.Body, &letter); err != nil || letter.TraceId != "lost" || !hasCause(letter, ErrHandlerFailed) {
+		t.Errorf("dead letter = (%+v, %v)", letter, err)
+	}
+}
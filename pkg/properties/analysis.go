@@ -109,6 +109,8 @@ const (
 	DetectionCategoryId = "id"
 	DetectionCategoryName = "name"
 	DetectionCategoryAlias = "alias"
+	DetectionCategoryKeypoints = "keypoints"
+	DetectionCategorySkeleton = "skeleton"
 )
 
 // DetectionMedia property field names (BSON)
@@ -138,6 +140,7 @@ const (
 	DetectionRunCreatedAt = "createdAt"
 	DetectionRunUpdatedAt = "updatedAt"
 	DetectionRunRecordingTimestamp = "recordingTimestamp"
+	DetectionRunView = "view"
 )
 
 // DetectionSource property field names (BSON)
@@ -179,6 +182,7 @@ const (
 	FaceRedactionTrackConfidence = "confidence"
 	FaceRedactionTrackClassId = "classId"
 	FaceRedactionTrackShape = "shape"
+	FaceRedactionTrackFrameKeypoints = "frameKeypoints"
 )
 
 // Sprite property field names (BSON)
@@ -215,3 +219,13 @@ const (
 	TrackBoxClassId = "classId"
 	TrackBoxLabel = "label"
 )
+
+// TrackKeypoint property field names (BSON)
+const (
+	TrackKeypointIndex = "index"
+	TrackKeypointName = "name"
+	TrackKeypointX = "x"
+	TrackKeypointY = "y"
+	TrackKeypointVisibility = "visibility"
+	TrackKeypointScore = "score"
+)
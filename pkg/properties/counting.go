@@ -0,0 +1,31 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// CountingOptions property field names (BSON)
+const (
+	CountingOptionsLines = "lines"
+	CountingOptionsRegions = "regions"
+	CountingOptionsStartTimestamp = "startTimestamp"
+	CountingOptionsDeviceId = "deviceId"
+	CountingOptionsAlertId = "alertId"
+	CountingOptionsAlertName = "alertName"
+	CountingOptionsMinDwell = "minDwell"
+)
+
+// CountingPoint property field names (BSON)
+const (
+	CountingPointX = "x"
+	CountingPointY = "y"
+	CountingPointTime = "time"
+)
+
+// CountingTrajectory property field names (BSON)
+const (
+	CountingTrajectoryObjectId = "objectId"
+	CountingTrajectoryObjectName = "objectName"
+	CountingTrajectoryWidth = "width"
+	CountingTrajectoryHeight = "height"
+	CountingTrajectoryPoints = "points"
+)
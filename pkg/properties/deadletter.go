@@ -0,0 +1,26 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// DeadLetter property field names (BSON)
+const (
+	DeadLetterKind = "kind"
+	DeadLetterPipelineEvent = "pipelineEvent"
+	DeadLetterWorkflowRun = "workflowRun"
+	DeadLetterStage = "stage"
+	DeadLetterErrors = "errors"
+	DeadLetterAttempts = "attempts"
+	DeadLetterTraceId = "traceId"
+	DeadLetterReplayToken = "replayToken"
+)
+
+// DeadLetterAttempt property field names (BSON)
+const (
+	DeadLetterAttemptStage = "stage"
+	DeadLetterAttemptAction = "action"
+	DeadLetterAttemptStatus = "status"
+	DeadLetterAttemptReceiveCount = "receiveCount"
+	DeadLetterAttemptError = "error"
+	DeadLetterAttemptTimestamp = "timestamp"
+)
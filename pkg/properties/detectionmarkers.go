@@ -0,0 +1,15 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// DetectionMarkerRules property field names (BSON)
+const (
+	DetectionMarkerRulesClassIds = "classIds"
+	DetectionMarkerRulesLabels = "labels"
+	DetectionMarkerRulesMinDuration = "minDuration"
+	DetectionMarkerRulesMinConfidence = "minConfidence"
+	DetectionMarkerRulesCategories = "categories"
+	DetectionMarkerRulesSource = "source"
+	DetectionMarkerRulesEngine = "engine"
+)
@@ -0,0 +1,13 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// DetectionView property field names (BSON)
+const (
+	DetectionViewScoreThreshold = "scoreThreshold"
+	DetectionViewClassIds = "classIds"
+	DetectionViewLabels = "labels"
+	DetectionViewNmsIou = "nmsIou"
+	DetectionViewClassAgnosticNms = "classAgnosticNms"
+)
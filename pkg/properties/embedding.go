@@ -0,0 +1,33 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// EmbeddingEntry property field names (BSON)
+const (
+	EmbeddingEntryMediaId = "mediaId"
+	EmbeddingEntryOrganisationId = "organisationId"
+	EmbeddingEntryProjectId = "projectId"
+	EmbeddingEntryFormat = "format"
+	EmbeddingEntryVector = "vector"
+)
+
+// EmbeddingFormat property field names (BSON)
+const (
+	EmbeddingFormatModelId = "modelId"
+	EmbeddingFormatModelVersion = "modelVersion"
+	EmbeddingFormatDimension = "dimension"
+	EmbeddingFormatScale = "scale"
+)
+
+// EmbeddingMatch property field names (BSON)
+const (
+	EmbeddingMatchMediaId = "mediaId"
+	EmbeddingMatchScore = "score"
+)
+
+// EmbeddingScope property field names (BSON)
+const (
+	EmbeddingScopeOrganisationId = "organisationId"
+	EmbeddingScopeProjectId = "projectId"
+)
@@ -0,0 +1,12 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// HNSWOptions property field names (BSON)
+const (
+	HNSWOptionsM = "m"
+	HNSWOptionsEfConstruction = "efConstruction"
+	HNSWOptionsEfSearch = "efSearch"
+	HNSWOptionsSeed = "seed"
+)
@@ -0,0 +1,36 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// HeatmapGrid property field names (BSON)
+const (
+	HeatmapGridColumns = "columns"
+	HeatmapGridRows = "rows"
+	HeatmapGridCells = "cells"
+	HeatmapGridSamples = "samples"
+	HeatmapGridStart = "start"
+	HeatmapGridEnd = "end"
+	HeatmapGridClasses = "classes"
+	HeatmapGridHalfLife = "halfLife"
+	HeatmapGridReference = "reference"
+)
+
+// HeatmapOptions property field names (BSON)
+const (
+	HeatmapOptionsColumns = "columns"
+	HeatmapOptionsRows = "rows"
+	HeatmapOptionsClasses = "classes"
+	HeatmapOptionsHalfLife = "halfLife"
+	HeatmapOptionsReference = "reference"
+)
+
+// HeatmapSample property field names (BSON)
+const (
+	HeatmapSampleX = "x"
+	HeatmapSampleY = "y"
+	HeatmapSampleWidth = "width"
+	HeatmapSampleHeight = "height"
+	HeatmapSampleClass = "class"
+	HeatmapSampleTimestamp = "timestamp"
+)
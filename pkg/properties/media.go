@@ -114,6 +114,7 @@ const (
 	MediaMetadataDominantColors = "dominantColors"
 	MediaMetadataCount = "count"
 	MediaMetadataEmbedding = "embedding"
+	MediaMetadataEmbeddingFormat = "embeddingFormat"
 )
 
 // Region property field names (BSON)
@@ -0,0 +1,50 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// RedactionFrame property field names (BSON)
+const (
+	RedactionFrameFrame = "frame"
+	RedactionFrameRects = "rects"
+)
+
+// RedactionPlan property field names (BSON)
+const (
+	RedactionPlanMode = "mode"
+	RedactionPlanWidth = "width"
+	RedactionPlanHeight = "height"
+	RedactionPlanKernelSize = "kernelSize"
+	RedactionPlanBlockSize = "blockSize"
+	RedactionPlanFrames = "frames"
+)
+
+// RedactionPlanOptions property field names (BSON)
+const (
+	RedactionPlanOptionsMode = "mode"
+	RedactionPlanOptionsWidth = "width"
+	RedactionPlanOptionsHeight = "height"
+	RedactionPlanOptionsRotation = "rotation"
+	RedactionPlanOptionsKernelSize = "kernelSize"
+	RedactionPlanOptionsBlockSize = "blockSize"
+	RedactionPlanOptionsExtendBefore = "extendBefore"
+	RedactionPlanOptionsExtendAfter = "extendAfter"
+	RedactionPlanOptionsFrameCount = "frameCount"
+	RedactionPlanOptionsAllTracks = "allTracks"
+)
+
+// RedactionRect property field names (BSON)
+const (
+	RedactionRectTrackId = "trackId"
+	RedactionRectX = "x"
+	RedactionRectY = "y"
+	RedactionRectWidth = "width"
+	RedactionRectHeight = "height"
+)
+
+// RedactionSpan property field names (BSON)
+const (
+	RedactionSpanStart = "start"
+	RedactionSpanEnd = "end"
+	RedactionSpanRect = "rect"
+)
@@ -0,0 +1,15 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// RetentionDecision property field names (BSON)
+const (
+	RetentionDecisionSubject = "subject"
+	RetentionDecisionAnchor = "anchor"
+	RetentionDecisionExpiresAt = "expiresAt"
+	RetentionDecisionRule = "rule"
+	RetentionDecisionDays = "days"
+	RetentionDecisionCaseId = "caseId"
+	RetentionDecisionReason = "reason"
+)
@@ -98,6 +98,7 @@ const (
 	TaskRetentionDays = "retention_days"
 	TaskExpiresAt = "expires_at"
 	TaskExpiresAtOverridden = "expires_at_overridden"
+	TaskClosedAt = "closed_at"
 	TaskLegalHold = "legal_hold"
 	TaskComments = "comments"
 	TaskLabels = "labels"
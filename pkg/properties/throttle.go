@@ -0,0 +1,32 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// SuppressionPolicy property field names (BSON)
+const (
+	SuppressionPolicyCooldown = "cooldown"
+)
+
+// SuppressionState property field names (BSON)
+const (
+	SuppressionStateKey = "key"
+	SuppressionStateLastNotified = "lastNotified"
+	SuppressionStateSuppressed = "suppressed"
+)
+
+// ThrottlePolicy property field names (BSON)
+const (
+	ThrottlePolicyScope = "scope"
+	ThrottlePolicyAlgorithm = "algorithm"
+	ThrottlePolicyLimit = "limit"
+	ThrottlePolicyWindow = "window"
+)
+
+// ThrottleState property field names (BSON)
+const (
+	ThrottleStateKey = "key"
+	ThrottleStateTokens = "tokens"
+	ThrottleStateUpdatedAt = "updatedAt"
+	ThrottleStateEvents = "events"
+)
@@ -0,0 +1,15 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// TimelineBucketOptions property field names (BSON)
+const (
+	TimelineBucketOptionsZoom = "zoom"
+	TimelineBucketOptionsBucketCount = "bucketCount"
+	TimelineBucketOptionsStart = "start"
+	TimelineBucketOptionsEnd = "end"
+	TimelineBucketOptionsTimezone = "timezone"
+	TimelineBucketOptionsMaxGap = "maxGap"
+	TimelineBucketOptionsMaxItems = "maxItems"
+)
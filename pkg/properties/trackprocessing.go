@@ -0,0 +1,25 @@
+// Code generated by generate-properties. DO NOT EDIT.
+// Source: pkg/models/*.go
+
+package properties
+
+// TrackInterpolation property field names (BSON)
+const (
+	TrackInterpolationMethod = "method"
+	TrackInterpolationMaxGap = "maxGap"
+)
+
+// TrackProcessing property field names (BSON)
+const (
+	TrackProcessingInterpolation = "interpolation"
+	TrackProcessingSmoothing = "smoothing"
+	TrackProcessingPadding = "padding"
+)
+
+// TrackSmoothing property field names (BSON)
+const (
+	TrackSmoothingMethod = "method"
+	TrackSmoothingWindow = "window"
+	TrackSmoothingProcessNoise = "processNoise"
+	TrackSmoothingMeasurementNoise = "measurementNoise"
+)
@@ -0,0 +1,126 @@
+// Package queue is the message transport abstraction shared by the in-process
+// runners in this module (the workflow stage worker SDK and the pipeline
+// runner). A service adapts its broker (RabbitMQ, SQS, ...) to Transport;
+// MemoryTransport implements it in process so a stage can be exercised end to
+// end in a test without a broker.
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrTransportClosed is returned by Receive once a transport has been closed
+// and has nothing left to deliver.
+var ErrTransportClosed = errors.New("transport is closed")
+
+// Delivery is one message received from a queue. Ack settles it; Nack rejects
+// it, optionally returning it to the queue.
+type Delivery struct {
+	Body []byte
+	Ack  func() error
+	Nack func(requeue bool) error
+}
+
+// Transport is the queue abstraction the runners sit on.
+type Transport interface {
+	// Receive blocks until a message is available on queue, ctx is done, or the
+	// transport is closed (ErrTransportClosed).
+	Receive(ctx context.Context, queue string) (Delivery, error)
+	// Send publishes body to queue.
+	Send(ctx context.Context, queue string, body []byte) error
+}
+
+// MemoryTransport is an in-process Transport backed by unbounded FIFO queues.
+// It is meant for tests: publish a message on the first queue, run the
+// consumer, and read what it produced from the downstream queue.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	queues   map[string][][]byte
+	inFlight int
+	closed   bool
+}
+
+// NewMemoryTransport returns an empty in-memory transport.
+func NewMemoryTransport() *MemoryTransport {
+	t := &MemoryTransport{queues: map[string][][]byte{}}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// Send appends body to queue.
+func (t *MemoryTransport) Send(ctx context.Context, queue string, body []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queues[queue] = append(t.queues[queue], append([]byte(nil), body...))
+	t.cond.Broadcast()
+	return nil
+}
+
+// Receive pops the oldest message from queue, waiting for one if it is empty.
+// The message stays in flight until it is acked or nacked; a nack with
+// requeue=true puts it back at the front of the queue.
+func (t *MemoryTransport) Receive(ctx context.Context, queue string) (Delivery, error) {
+	stop := context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.cond.Broadcast()
+	})
+	defer stop()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.queues[queue]) == 0 {
+		if t.closed && t.inFlight == 0 {
+			return Delivery{}, ErrTransportClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return Delivery{}, err
+		}
+		t.cond.Wait()
+	}
+	body := t.queues[queue][0]
+	t.queues[queue] = t.queues[queue][1:]
+	t.inFlight++
+
+	var once sync.Once
+	settle := func(requeue bool) error {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if requeue {
+				t.queues[queue] = append([][]byte{body}, t.queues[queue]...)
+			}
+			t.inFlight--
+			t.cond.Broadcast()
+		})
+		return nil
+	}
+	return Delivery{
+		Body: body,
+		Ack:  func() error { return settle(false) },
+		Nack: settle,
+	}, nil
+}
+
+// Len reports how many messages are waiting on queue.
+func (t *MemoryTransport) Len(queue string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.queues[queue])
+}
+
+// Close marks the transport as having no further input. A Receive returns
+// ErrTransportClosed once its queue is empty and no delivery is still in
+// flight anywhere on the transport — so a consumer that forwards a message
+// before acking it can never strand it — which lets a chain of consumers
+// process everything queued and then stop. Send keeps working so the output
+// of those messages can still be read.
+func (t *MemoryTransport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.cond.Broadcast()
+}
@@ -0,0 +1,64 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransportReceiveHonoursContext(t *testing.T) {
+	transport := NewMemoryTransport()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := transport.Receive(ctx, "empty"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestMemoryTransportNackRequeuesAtFront(t *testing.T) {
+	transport := NewMemoryTransport()
+	ctx := context.Background()
+	_ = transport.Send(ctx, "q", []byte("a"))
+	_ = transport.Send(ctx, "q", []byte("b"))
+
+	delivery, _ := transport.Receive(ctx, "q")
+	if err := delivery.Nack(true); err != nil {
+		t.Fatalf("nack: %v", err)
+	}
+	again, _ := transport.Receive(ctx, "q")
+	if string(again.Body) != "a" {
+		t.Errorf("requeued message = %q, want it redelivered first", again.Body)
+	}
+}
+
+// A closed transport keeps delivering while a message is in flight, because
+// its consumer may still forward it to another queue before acking.
+func TestMemoryTransportCloseWaitsForInFlightDeliveries(t *testing.T) {
+	transport := NewMemoryTransport()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = transport.Send(ctx, "first", []byte("m"))
+	delivery, _ := transport.Receive(ctx, "first")
+	transport.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		next, err := transport.Receive(ctx, "second")
+		if err != nil {
+			received <- nil
+			return
+		}
+		_ = next.Ack()
+		received <- next.Body
+	}()
+
+	_ = transport.Send(ctx, "second", delivery.Body)
+	_ = delivery.Ack()
+	if body := <-received; string(body) != "m" {
+		t.Fatalf("forwarded message = %q, want m", body)
+	}
+	if _, err := transport.Receive(ctx, "second"); !errors.Is(err, ErrTransportClosed) {
+		t.Errorf("err = %v, want ErrTransportClosed once drained", err)
+	}
+}
@@ -0,0 +1,24 @@
+package stageworker
+
+import "github.com/uug-ai/models/pkg/queue"
+
+// The transport now lives in pkg/queue, shared with the pipeline runner. These
+// names keep the API stage workers were written against.
+
+// ErrTransportClosed is returned by Receive once a transport has been closed
+// and the queue it reads is drained.
+var ErrTransportClosed = queue.ErrTransportClosed
+
+// Delivery is one message received from a queue.
+type Delivery = queue.Delivery
+
+// Transport is the queue abstraction the Runner sits on.
+type Transport = queue.Transport
+
+// MemoryTransport is an in-process Transport for tests.
+type MemoryTransport = queue.MemoryTransport
+
+// NewMemoryTransport returns an empty in-memory transport.
+func NewMemoryTransport() *MemoryTransport {
+	return queue.NewMemoryTransport()
+}
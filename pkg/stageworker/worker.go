@@ -0,0 +1,200 @@
+// Package stageworker is a small SDK for writing a custom workflow stage. It
+// implements the worker side of the engine ⇄ stage contract documented on
+// models.WorkflowRun, so a stage only has to implement StageWorker:
+//
+//	engine ──WorkflowRun{operation:<stage>, storage}──▶ Runner ──▶ StageWorker.Handle
+//	engine ◀──WorkflowRun{operation:<stage>, payload|results}── Runner
+//
+// The Runner decodes each dispatch, hands it to the worker, and replies with
+// the same envelope: RunId, Key, TraceId and User echoed so the engine can
+// locate and scope the run, Storage and SignedURL stripped so credentials never
+// travel back, and the result in exactly one channel. It sits on a pluggable
+// queue.Transport; queue.MemoryTransport lets a stage be exercised end to end
+// in a test without a broker.
+package stageworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/uug-ai/models/pkg/models"
+	"github.com/uug-ai/models/pkg/queue"
+)
+
+// WorkflowsQueue is the queue the engine consumes stage results from.
+const WorkflowsQueue = "workflows"
+
+var (
+	// ErrAmbiguousResult is returned when a StageResult sets both Payload and
+	// Results: a worker is either delegated-ingest or self-persisting, never both.
+	ErrAmbiguousResult = errors.New("stage result sets both payload and results")
+	// ErrUnexpectedOperation is returned when a dispatch names a different
+	// operation than the runner serves.
+	ErrUnexpectedOperation = errors.New("dispatch operation does not match the stage")
+	// ErrMalformedDispatch is returned when a delivery is not a WorkflowRun.
+	ErrMalformedDispatch = errors.New("dispatch is not a workflow run")
+)
+
+// StageResult is what a stage hands back for one dispatch. Exactly one channel
+// is used:
+//
+//   - Payload — a delegated-ingest worker's block envelope (see
+//     api.EncodePayload); the engine ingests it and mirrors it into Results.
+//   - Results — a self-persisting worker's routing values; the runner places
+//     them under Results[operation].
+//
+// A zero StageResult is a valid, empty self-persisting result: the reply still
+// carries Results[operation] so the engine resolves the operation and the run
+// can finalise.
+type StageResult struct {
+	Payload json.RawMessage
+	Results map[string]interface{}
+}
+
+// StageWorker is the interface a custom stage implements. Handle receives the
+// decoded dispatch, including the Storage credentials to fetch the media, and
+// returns its result. Returning an error leaves the run's operation unresolved.
+type StageWorker interface {
+	Handle(ctx context.Context, run models.WorkflowRun) (StageResult, error)
+}
+
+// HandlerFunc adapts a plain function to StageWorker.
+type HandlerFunc func(ctx context.Context, run models.WorkflowRun) (StageResult, error)
+
+// Handle calls f(ctx, run).
+func (f HandlerFunc) Handle(ctx context.Context, run models.WorkflowRun) (StageResult, error) {
+	return f(ctx, run)
+}
+
+// Runner consumes dispatches for one stage operation and replies to the engine.
+type Runner struct {
+	// Operation is the stage operation this runner serves (e.g. "anpr").
+	Operation string
+	// Queue is the queue dispatches are received from. Defaults to Operation.
+	Queue string
+	// ReplyQueue is the queue results are sent to. Defaults to WorkflowsQueue.
+	ReplyQueue string
+
+	Worker    StageWorker
+	Transport queue.Transport
+
+	// OnError, when set, is called for every dispatch that did not produce a
+	// reply (a malformed dispatch, a worker error, or a failed send), with the
+	// run as far as it was decoded.
+	OnError func(run models.WorkflowRun, err error)
+}
+
+// NewRunner returns a Runner for operation with the default queues.
+func NewRunner(operation string, worker StageWorker, transport queue.Transport) *Runner {
+	return &Runner{
+		Operation:  operation,
+		Queue:      operation,
+		ReplyQueue: WorkflowsQueue,
+		Worker:     worker,
+		Transport:  transport,
+	}
+}
+
+// Run receives and processes dispatches until ctx is done or the transport is
+// closed. Each delivery is acknowledged once its reply is sent; a delivery
+// that cannot be processed is rejected without requeue so a poison message
+// never loops.
+func (r *Runner) Run(ctx context.Context) error {
+	for {
+		delivery, err := r.Transport.Receive(ctx, r.queue())
+		if err != nil {
+			if errors.Is(err, queue.ErrTransportClosed) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		run, reply, err := r.Process(ctx, delivery.Body)
+		if err == nil {
+			err = r.Transport.Send(ctx, r.replyQueue(), reply)
+		}
+		if err != nil {
+			if r.OnError != nil {
+				r.OnError(run, err)
+			}
+			if nackErr := delivery.Nack(false); nackErr != nil {
+				return nackErr
+			}
+			continue
+		}
+		if err := delivery.Ack(); err != nil {
+			return err
+		}
+	}
+}
+
+// Process handles a single raw dispatch and returns the encoded reply. It is
+// what Run calls per delivery, exposed so a worker can be driven directly.
+func (r *Runner) Process(ctx context.Context, body []byte) (models.WorkflowRun, []byte, error) {
+	var run models.WorkflowRun
+	if err := json.Unmarshal(body, &run); err != nil {
+		return run, nil, fmt.Errorf("%w: %v", ErrMalformedDispatch, err)
+	}
+	if run.Operation != r.Operation {
+		return run, nil, fmt.Errorf("%w: got %q, want %q", ErrUnexpectedOperation, run.Operation, r.Operation)
+	}
+
+	result, err := r.Worker.Handle(ctx, run)
+	if err != nil {
+		return run, nil, err
+	}
+	reply, err := Reply(run, result)
+	if err != nil {
+		return run, nil, err
+	}
+	encoded, err := json.Marshal(reply)
+	if err != nil {
+		return run, nil, err
+	}
+	return run, encoded, nil
+}
+
+// Reply builds the worker→engine result for a dispatch. It returns the same
+// envelope — so RunId, Key, TraceId and User are echoed — with the credential
+// carriers (Storage, SignedURL) cleared and the result placed in exactly one
+// channel: Payload for a delegated-ingest result, or Results[operation] for a
+// self-persisting one. Upstream Results are not echoed back; the engine already
+// holds them.
+func Reply(dispatch models.WorkflowRun, result StageResult) (models.WorkflowRun, error) {
+	if len(result.Payload) > 0 && result.Results != nil {
+		return models.WorkflowRun{}, ErrAmbiguousResult
+	}
+
+	reply := dispatch
+	reply.Storage = nil
+	reply.SignedURL = ""
+	reply.Payload = nil
+	reply.Results = nil
+
+	if len(result.Payload) > 0 {
+		reply.Payload = result.Payload
+		return reply, nil
+	}
+	values := result.Results
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	reply.Results = map[string]interface{}{dispatch.Operation: values}
+	return reply, nil
+}
+
+func (r *Runner) queue() string {
+	if r.Queue != "" {
+		return r.Queue
+	}
+	return r.Operation
+}
+
+func (r *Runner) replyQueue() string {
+	if r.ReplyQueue != "" {
+		return r.ReplyQueue
+	}
+	return WorkflowsQueue
+}
@@ -0,0 +1,169 @@
+package stageworker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/uug-ai/models/pkg/api"
+	"github.com/uug-ai/models/pkg/models"
+	"github.com/uug-ai/models/pkg/queue"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func dispatch(t *testing.T, operation string) (models.WorkflowRun, []byte) {
+	t.Helper()
+	projectId := primitive.NewObjectID()
+	run := models.WorkflowRun{
+		Operation: operation,
+		Id:        primitive.NewObjectID(),
+		Key:       "user/1752482068_x_cam_y_0_10000.mp4",
+		TraceId:   "trace-1",
+		User:      models.WorkflowUser{OrganisationId: "org-1", ProjectId: &projectId},
+		Device:    models.WorkflowDevice{DeviceKey: "cam"},
+		Results:   map[string]interface{}{"classify": map[string]interface{}{"properties": []interface{}{"car"}}},
+		Storage:   &models.WorkflowStorage{Uri: "https://vault", AccessKey: "ak", Secret: "sk"},
+		SignedURL: "https://vault/signed?sig=abc",
+	}
+	body, err := json.Marshal(run)
+	if err != nil {
+		t.Fatalf("marshal dispatch: %v", err)
+	}
+	return run, body
+}
+
+func runUntilDrained(t *testing.T, runner *Runner, transport *queue.MemoryTransport) {
+	t.Helper()
+	transport.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := runner.Run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func receiveReply(t *testing.T, transport *queue.MemoryTransport) map[string]any {
+	t.Helper()
+	delivery, err := transport.Receive(context.Background(), WorkflowsQueue)
+	if err != nil {
+		t.Fatalf("receive reply: %v", err)
+	}
+	var wire map[string]any
+	if err := json.Unmarshal(delivery.Body, &wire); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	return wire
+}
+
+func TestRunnerEchoesIdentityAndStripsCredentials(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	sent, body := dispatch(t, "anpr")
+	if err := transport.Send(context.Background(), "anpr", body); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	var seen models.WorkflowRun
+	runner := NewRunner("anpr", HandlerFunc(func(ctx context.Context, run models.WorkflowRun) (StageResult, error) {
+		seen = run
+		return StageResult{Results: map[string]interface{}{"plates": []string{"2-HCP-007"}}}, nil
+	}), transport)
+	runUntilDrained(t, runner, transport)
+
+	if seen.Storage == nil || seen.Storage.Secret != "sk" {
+		t.Fatalf("worker did not receive the dispatch storage: %+v", seen.Storage)
+	}
+
+	wire := receiveReply(t, transport)
+	if wire["runId"] != sent.Id.Hex() || wire["key"] != sent.Key || wire["traceId"] != "trace-1" || wire["operation"] != "anpr" {
+		t.Errorf("reply identity not echoed: %v", wire)
+	}
+	if user, _ := wire["user"].(map[string]any); user["organisationId"] != "org-1" || user["projectId"] != sent.User.ProjectId.Hex() {
+		t.Errorf("reply user not echoed: %v", wire["user"])
+	}
+	for _, field := range []string{"storage", "signedUrl", "payload"} {
+		if _, ok := wire[field]; ok {
+			t.Errorf("reply carried %q: %v", field, wire[field])
+		}
+	}
+	results, _ := wire["results"].(map[string]any)
+	if len(results) != 1 || results["anpr"] == nil {
+		t.Errorf("reply results = %v, want only results.anpr", results)
+	}
+}
+
+func TestRunnerRepliesWithPayloadChannel(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	_, body := dispatch(t, "detect")
+	_ = transport.Send(context.Background(), "detect", body)
+
+	runner := NewRunner("detect", HandlerFunc(func(ctx context.Context, run models.WorkflowRun) (StageResult, error) {
+		block, err := api.NewPayloadBlock(api.PayloadBlockMarker, models.Marker{Name: "person"})
+		if err != nil {
+			return StageResult{}, err
+		}
+		payload, err := api.EncodePayload(block)
+		return StageResult{Payload: payload}, err
+	}), transport)
+	runUntilDrained(t, runner, transport)
+
+	wire := receiveReply(t, transport)
+	if _, ok := wire["results"]; ok {
+		t.Errorf("payload reply also carried results: %v", wire["results"])
+	}
+	raw, _ := json.Marshal(wire["payload"])
+	blocks, err := api.DecodePayload(raw, api.PayloadDecodeStrict)
+	if err != nil || len(blocks) != 1 {
+		t.Fatalf("reply payload = (%v, %v), want one block", blocks, err)
+	}
+}
+
+func TestRunnerRejectsFailedDispatches(t *testing.T) {
+	transport := queue.NewMemoryTransport()
+	_, anpr := dispatch(t, "anpr")
+	_, other := dispatch(t, "other")
+	ctx := context.Background()
+	_ = transport.Send(ctx, "anpr", []byte("{"))
+	_ = transport.Send(ctx, "anpr", other)
+	_ = transport.Send(ctx, "anpr", anpr)
+	_ = transport.Send(ctx, "anpr", anpr)
+
+	var failures []error
+	calls := 0
+	runner := NewRunner("anpr", HandlerFunc(func(ctx context.Context, run models.WorkflowRun) (StageResult, error) {
+		calls++
+		if calls == 1 {
+			return StageResult{}, errors.New("model unavailable")
+		}
+		return StageResult{Payload: json.RawMessage(`{"blocks":[]}`), Results: map[string]interface{}{}}, nil
+	}), transport)
+	runner.OnError = func(run models.WorkflowRun, err error) { failures = append(failures, err) }
+	runUntilDrained(t, runner, transport)
+
+	if len(failures) != 4 {
+		t.Fatalf("failures = %v, want 4", failures)
+	}
+	if !errors.Is(failures[0], ErrMalformedDispatch) {
+		t.Errorf("failure 0 = %v, want ErrMalformedDispatch", failures[0])
+	}
+	if !errors.Is(failures[1], ErrUnexpectedOperation) {
+		t.Errorf("failure 1 = %v, want ErrUnexpectedOperation", failures[1])
+	}
+	if !errors.Is(failures[3], ErrAmbiguousResult) {
+		t.Errorf("failure 3 = %v, want ErrAmbiguousResult", failures[3])
+	}
+	if n := transport.Len(WorkflowsQueue); n != 0 {
+		t.Errorf("%d replies sent for failed dispatches, want 0", n)
+	}
+}
+
+func TestReplyWithEmptyResultStillResolvesOperation(t *testing.T) {
+	reply, err := Reply(models.WorkflowRun{Operation: "noop", RunId: "r"}, StageResult{})
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if _, ok := reply.Results["noop"]; !ok || len(reply.Results) != 1 {
+		t.Errorf("results = %v, want an empty results.noop", reply.Results)
+	}
+}